@@ -3,6 +3,8 @@ package handlers
 import (
 	"net/http"
 
+	"goclone/internal/auth/sessionstore"
+
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
@@ -34,7 +36,9 @@ func Logout(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "No session."})
 		return
 	}
-	session.Delete("id")
+	// the store drops the session instead of keeping an empty one around, and expires the cookie
+	session.Clear()
+	sessionstore.End(session)
 	if err := session.Save(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save session"})
 		return
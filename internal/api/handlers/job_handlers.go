@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"goclone/internal/auth/rbac"
+	"goclone/internal/jobs"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// keepaliveInterval keeps proxies from closing event streams of jobs that are quiet for a while
+const keepaliveInterval = 15 * time.Second
+
+// JobHandlers lets users follow the clones they started
+type JobHandlers struct {
+	manager  *jobs.Manager
+	enforcer *rbac.Enforcer
+	tracer   trace.Tracer
+}
+
+func NewJobHandlers(manager *jobs.Manager, enforcer *rbac.Enforcer) *JobHandlers {
+	return &JobHandlers{
+		manager:  manager,
+		enforcer: enforcer,
+		tracer:   otel.Tracer("goclone"),
+	}
+}
+
+// getJob returns the job if the user may see it. Users only see their own jobs, unless they can view every pod.
+func (h *JobHandlers) getJob(c *gin.Context, id string) (jobs.Job, error) {
+	job, err := h.manager.Get(id)
+	if err == nil && !strings.EqualFold(job.Owner, GetUser(c)) && !h.enforcer.Has(c, rbac.PodsViewAll) {
+		// not telling apart jobs that do not exist from those of other users
+		err = jobs.ErrNotFound
+	}
+	return job, err
+}
+
+// GetJob returns a job with the status of each of its steps
+func (h *JobHandlers) GetJob(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "GET /api/v1/jobs")
+	defer span.End()
+
+	id := c.Param("id")
+	span.SetAttributes(attribute.String("job", id))
+
+	job, err := h.getJob(c, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
+
+// ListJobs lists the user's jobs, newest first
+func (h *JobHandlers) ListJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"jobs": h.manager.List(GetUser(c))})
+}
+
+// StreamJob sends the job's events as Server-Sent Events until the job finishes. Clients reconnecting
+// with Last-Event-ID get the events they missed, a finished job's stream replays its whole log.
+func (h *JobHandlers) StreamJob(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "GET /api/v1/jobs/events")
+	defer span.End()
+
+	id := c.Param("id")
+	span.SetAttributes(attribute.String("job", id))
+
+	if _, err := h.getJob(c, id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	after, _ := strconv.Atoi(c.GetHeader("Last-Event-ID"))
+	past, live, cancel, err := h.manager.Subscribe(id, after)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	for _, event := range past {
+		renderEvent(c, event)
+	}
+	c.Writer.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-live:
+			if !ok {
+				return false
+			}
+			renderEvent(c, event)
+			return true
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
+
+func renderEvent(c *gin.Context, event jobs.Event) {
+	c.Render(-1, sse.Event{
+		Id:    strconv.Itoa(event.ID),
+		Event: event.Type,
+		Data:  event,
+	})
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"goclone/internal/leases"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LeaseHandlers shows users when their pods expire and lets them extend the leases
+type LeaseHandlers struct {
+	manager *leases.Manager
+	tracer  trace.Tracer
+}
+
+func NewLeaseHandlers(manager *leases.Manager) *LeaseHandlers {
+	return &LeaseHandlers{
+		manager: manager,
+		tracer:  otel.Tracer("goclone"),
+	}
+}
+
+// ListLeases returns the leases of the caller's pods
+func (h *LeaseHandlers) ListLeases(c *gin.Context) {
+	list, err := h.manager.List(GetUser(c))
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting leases"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"leases": list})
+}
+
+// AdminListLeases returns the leases of every pod
+func (h *LeaseHandlers) AdminListLeases(c *gin.Context) {
+	list, err := h.manager.List("")
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting leases"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"leases": list})
+}
+
+// ExtendLease pushes back when one of the caller's pods expires. Without hours the configured extension is used.
+func (h *LeaseHandlers) ExtendLease(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/pod/lease/extend")
+	defer span.End()
+
+	var form struct {
+		Hours int `json:"hours"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&form); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	podID := c.Param("podId")
+	span.SetAttributes(attribute.String("pod", podID))
+
+	lease, err := h.manager.Extend(GetUser(c), podID, c.Query("server"), time.Duration(form.Hours)*time.Hour)
+	switch {
+	case err == leases.ErrLeaseNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err == leases.ErrExtensionLimit, err == leases.ErrAmbiguousPod, err == leases.ErrLeaseExpired:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"lease": lease})
+	}
+}
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"goclone/internal/auth/lockout"
+	"goclone/internal/auth/mfa"
+	"goclone/internal/auth/rbac"
+	"goclone/internal/auth/sessionstore"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// mfaSessionKey records the user whose code the session was verified with
+const mfaSessionKey = "mfa"
+
+func mfaVerified(c *gin.Context) bool {
+	verified, _ := sessions.Default(c).Get(mfaSessionKey).(string)
+	return verified != "" && verified == GetUser(c)
+}
+
+// MFARequired holds back sessions that still have to enter a code, because the user enabled MFA
+// or has a role that requires it. API tokens are let through, MFA is only part of the cookie login.
+// A nil manager lets everything through.
+func MFARequired(manager *mfa.Manager, enforcer *rbac.Enforcer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if manager == nil || GetToken(c) != nil || mfaVerified(c) {
+			c.Next()
+			return
+		}
+
+		enrolled, required, err := mfaState(c, manager, enforcer)
+		if err != nil {
+			fmt.Println(err)
+			c.String(http.StatusInternalServerError, "Internal Server Error")
+			c.Abort()
+			return
+		}
+
+		switch {
+		case enrolled:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "MFA code required", "mfa": "verify"})
+			c.Abort()
+		case required:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "MFA enrollment required", "mfa": "enroll"})
+			c.Abort()
+		default:
+			c.Next()
+		}
+	}
+}
+
+func mfaState(c *gin.Context, manager *mfa.Manager, enforcer *rbac.Enforcer) (enrolled, required bool, err error) {
+	enrolled, err = manager.Enrolled(GetUser(c))
+	if err != nil {
+		return false, false, err
+	}
+	roles, err := enforcer.Roles(c)
+	if err != nil {
+		return false, false, err
+	}
+	return enrolled, manager.Required(roles), nil
+}
+
+// MFAHandlers let users enroll an authenticator app and finish logins with its codes.
+// guard may be nil; otherwise wrong codes count as failed logins.
+type MFAHandlers struct {
+	manager  *mfa.Manager
+	enforcer *rbac.Enforcer
+	guard    *lockout.Guard
+	tracer   trace.Tracer
+}
+
+func NewMFAHandlers(manager *mfa.Manager, enforcer *rbac.Enforcer, guard *lockout.Guard) *MFAHandlers {
+	return &MFAHandlers{
+		manager:  manager,
+		enforcer: enforcer,
+		guard:    guard,
+		tracer:   otel.Tracer("goclone"),
+	}
+}
+
+type mfaCodeForm struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// Status tells the frontend whether the session still needs a code or an enrollment
+func (h *MFAHandlers) Status(c *gin.Context) {
+	enrolled, required, err := mfaState(c, h.manager, h.enforcer)
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting MFA status"})
+		return
+	}
+
+	recoveryCodes, err := h.manager.RecoveryCodesLeft(GetUser(c))
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting MFA status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enrolled":      enrolled,
+		"required":      required,
+		"verified":      mfaVerified(c),
+		"recoveryCodes": recoveryCodes,
+	})
+}
+
+// Enroll starts an enrollment, returning the secret both as text and as a QR code
+func (h *MFAHandlers) Enroll(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/mfa/enroll")
+	defer span.End()
+
+	username := GetUser(c)
+	span.SetAttributes(attribute.String("username", username))
+
+	key, err := h.manager.Enroll(username)
+	if err == mfa.ErrAlreadyEnrolled {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error enrolling"})
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+// Confirm finishes the enrollment with a first code. The session counts as verified from then on.
+func (h *MFAHandlers) Confirm(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/mfa/confirm")
+	defer span.End()
+
+	var form mfaCodeForm
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return
+	}
+
+	username := GetUser(c)
+	codes, err := h.manager.Confirm(username, form.Code)
+	if err == mfa.ErrNotEnrolled || err == mfa.ErrAlreadyEnrolled || err == mfa.ErrInvalidCode {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error confirming enrollment"})
+		return
+	}
+
+	if !h.markVerified(c, username) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "MFA enabled", "recoveryCodes": codes})
+}
+
+// Verify is the second step of a login, taking a code from the authenticator app or a recovery code
+func (h *MFAHandlers) Verify(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/login/mfa")
+	defer span.End()
+
+	var form mfaCodeForm
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return
+	}
+
+	username := GetUser(c)
+	span.SetAttributes(attribute.String("username", username))
+
+	if h.guard != nil {
+		if wait := h.guard.BeginMFA(username, c.ClientIP()); wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed logins, try again later"})
+			return
+		}
+	}
+
+	err := h.manager.Verify(username, form.Code)
+	if h.guard != nil {
+		if err == nil || err == mfa.ErrInvalidCode {
+			h.guard.FinishMFA(username, c.ClientIP(), err == nil)
+		} else {
+			h.guard.CancelMFA(username)
+		}
+	}
+	if err == mfa.ErrNotEnrolled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err == mfa.ErrInvalidCode {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error verifying code"})
+		return
+	}
+
+	if !h.markVerified(c, username) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged in"})
+}
+
+// RegenerateRecoveryCodes replaces the caller's recovery codes. The session has to be verified.
+func (h *MFAHandlers) RegenerateRecoveryCodes(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/mfa/recovery-codes")
+	defer span.End()
+
+	if !mfaVerified(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Enter an MFA code first"})
+		return
+	}
+
+	codes, err := h.manager.RegenerateRecoveryCodes(GetUser(c))
+	if err == mfa.ErrNotEnrolled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating recovery codes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// Disable turns off MFA for the caller, who has to confirm with a current code
+func (h *MFAHandlers) Disable(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "DELETE /api/v1/mfa")
+	defer span.End()
+
+	var form mfaCodeForm
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return
+	}
+	if !mfaVerified(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Enter an MFA code first"})
+		return
+	}
+
+	username := GetUser(c)
+	err := h.manager.Verify(username, form.Code)
+	if err == mfa.ErrNotEnrolled || err == mfa.ErrInvalidCode {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err == nil {
+		err = h.manager.Disable(username)
+	}
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error disabling MFA"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "MFA disabled"})
+}
+
+// AdminReset removes a user's enrollment, for users who lost their device and their recovery codes
+func (h *MFAHandlers) AdminReset(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "DELETE /api/v1/admin/mfa")
+	defer span.End()
+
+	username := c.Param("username")
+	span.SetAttributes(attribute.String("username", username))
+
+	err := h.manager.Disable(username)
+	if err == mfa.ErrNotEnrolled {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resetting MFA"})
+		return
+	}
+	fmt.Printf("MFA of %s reset by %s\n", username, GetUser(c))
+	c.JSON(http.StatusOK, gin.H{"message": "MFA reset"})
+}
+
+func (h *MFAHandlers) markVerified(c *gin.Context, username string) bool {
+	session := sessions.Default(c)
+	sessionstore.Renew(session)
+	session.Set(mfaSessionKey, username)
+	if err := session.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving session"})
+		return false
+	}
+	return true
+}
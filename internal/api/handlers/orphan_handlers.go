@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+
+	"goclone/internal/orphans"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OrphanHandlers shows admins the resources failed clones and manual deletes left behind
+type OrphanHandlers struct {
+	collector *orphans.Collector
+	tracer    trace.Tracer
+}
+
+func NewOrphanHandlers(collector *orphans.Collector) *OrphanHandlers {
+	return &OrphanHandlers{
+		collector: collector,
+		tracer:    otel.Tracer("goclone"),
+	}
+}
+
+// ListOrphans returns what the last scan found
+func (h *OrphanHandlers) ListOrphans(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"report": h.collector.Report()})
+}
+
+// ScanOrphans scans right away instead of waiting for the next scan
+func (h *OrphanHandlers) ScanOrphans(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/admin/orphans/scan")
+	defer span.End()
+
+	c.JSON(http.StatusOK, gin.H{"report": h.collector.Scan(ctx)})
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"goclone/internal/auth"
+	"goclone/internal/auth/lockout"
+	"goclone/internal/auth/reset"
+	"goclone/internal/auth/sessionstore"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PasswordHandlers change passwords through the auth manager. resets and sessionStore may be nil,
+// which disables reset links and leaves existing sessions alone after a reset. guard may be nil;
+// otherwise wrong current passwords count as failed logins.
+type PasswordHandlers struct {
+	authManager  auth.AuthManager
+	resets       *reset.Manager
+	sessionStore *sessionstore.Store
+	guard        *lockout.Guard
+	tracer       trace.Tracer
+}
+
+func NewPasswordHandlers(authManager auth.AuthManager, resets *reset.Manager, sessionStore *sessionstore.Store, guard *lockout.Guard) *PasswordHandlers {
+	return &PasswordHandlers{
+		authManager:  authManager,
+		resets:       resets,
+		sessionStore: sessionStore,
+		guard:        guard,
+		tracer:       otel.Tracer("goclone"),
+	}
+}
+
+// ChangePassword lets a logged in user change their password by giving the current one.
+// Their other sessions are ended so a stolen session does not outlive the old password.
+func (h *PasswordHandlers) ChangePassword(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/password/change")
+	defer span.End()
+
+	var form struct {
+		OldPassword string `json:"oldPassword"`
+		NewPassword string `json:"newPassword"`
+	}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pm, ok := h.authManager.(auth.PasswordManager)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Password changes are not supported"})
+		return
+	}
+
+	username := GetUser(c)
+	span.SetAttributes(attribute.String("username", username))
+
+	if h.guard != nil {
+		if wait := h.guard.Begin(username, c.ClientIP()); wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed logins, try again later"})
+			return
+		}
+	}
+
+	err := pm.ChangePassword(username, form.OldPassword, form.NewPassword)
+	if h.guard != nil {
+		if err == nil || err == auth.ErrInvalidCredentials {
+			h.guard.Finish(username, c.ClientIP(), err == nil)
+		} else {
+			h.guard.Cancel(username)
+		}
+	}
+	switch err {
+	case nil:
+	case auth.ErrInvalidCredentials:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
+		return
+	case auth.ErrWeakPassword:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case auth.ErrUnknownUser:
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Password changes are not supported for this account"})
+		return
+	default:
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error changing password"})
+		return
+	}
+
+	h.revokeSessions(username, sessions.Default(c).ID())
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
+
+// AdminResetPassword sets another user's password and ends all of their sessions
+func (h *PasswordHandlers) AdminResetPassword(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/admin/user/password/reset")
+	defer span.End()
+
+	var form struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	span.SetAttributes(attribute.String("username", form.Username))
+
+	pm, ok := h.authManager.(auth.PasswordManager)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Password resets are not supported"})
+		return
+	}
+
+	err := pm.ResetPassword(form.Username, form.Password)
+	switch err {
+	case nil:
+	case auth.ErrUnknownUser:
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	case auth.ErrWeakPassword:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	default:
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resetting password"})
+		return
+	}
+
+	h.revokeSessions(form.Username, "")
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset"})
+}
+
+// ForgotPassword sends a reset link to the user's email address. The response is the same whether
+// or not the user exists, and the email goes out after it, so it cannot be used to find out which accounts there are.
+func (h *PasswordHandlers) ForgotPassword(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/password/forgot")
+	defer span.End()
+
+	var form struct {
+		Username string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&form); err != nil || form.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username not provided"})
+		return
+	}
+	span.SetAttributes(attribute.String("username", form.Username))
+
+	err := h.resets.Request(form.Username)
+	if err != nil && err != auth.ErrUnknownUser {
+		fmt.Println(err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the account has an email address, a reset link has been sent"})
+}
+
+// ResetPassword sets a new password using the token from a reset link
+func (h *PasswordHandlers) ResetPassword(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/password/reset")
+	defer span.End()
+
+	var form struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	username, err := h.resets.Redeem(form.Token, form.Password)
+	switch err {
+	case nil:
+	case reset.ErrInvalidToken, auth.ErrWeakPassword:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	default:
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resetting password"})
+		return
+	}
+
+	span.SetAttributes(attribute.String("username", username))
+	h.revokeSessions(username, "")
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset"})
+}
+
+func (h *PasswordHandlers) revokeSessions(username, keepID string) {
+	if h.sessionStore == nil {
+		return
+	}
+	if _, err := h.sessionStore.RevokeUserExcept(username, keepID); err != nil {
+		fmt.Printf("Failed to revoke sessions of %s: %v\n", username, err)
+	}
+}
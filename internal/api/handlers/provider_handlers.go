@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"goclone/internal/auth/rbac"
+	"goclone/internal/jobs"
+	"goclone/internal/leases"
+	"goclone/internal/providers"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+)
+
+// ProviderHandlers exposes a providers.Provider over HTTP. Clones run as jobs on jobManager,
+// or within the request when it is nil. Cloned pods get a lease from leaseManager unless it is nil.
+type ProviderHandlers struct {
+	provider     providers.Provider
+	enforcer     *rbac.Enforcer
+	jobManager   *jobs.Manager
+	leaseManager *leases.Manager
+	tracer       trace.Tracer
+}
+
+func NewProviderHandlers(provider providers.Provider, enforcer *rbac.Enforcer, jobManager *jobs.Manager, leaseManager *leases.Manager) *ProviderHandlers {
+	return &ProviderHandlers{
+		provider:     provider,
+		enforcer:     enforcer,
+		jobManager:   jobManager,
+		leaseManager: leaseManager,
+		tracer:       otel.Tracer("goclone"),
+	}
+}
+
+// providerError maps typed provider errors to a status code, falling back to status for everything else.
+// What a failed clone could not tear down is listed apart from the error it failed with.
+func providerError(c *gin.Context, err error, status int) {
+	body := gin.H{"error": err.Error()}
+	var cloneErr *providers.CloneError
+	if errors.As(err, &cloneErr) && len(cloneErr.Cleanup) > 0 {
+		body["cleanupErrors"] = cloneErr.CleanupErrors()
+	}
+
+	var notFound *providers.NotFoundError
+	switch {
+	case errors.As(err, &notFound):
+		status = http.StatusNotFound
+	case errors.Is(err, providers.ErrNoPortGroups):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, providers.ErrPodLimit), errors.Is(err, providers.ErrTooManyVMs):
+		status = http.StatusBadRequest
+	}
+	c.JSON(status, body)
+}
+
+// runClone submits clone as a job and answers with its ID, or without a job manager clones right away
+func (h *ProviderHandlers) runClone(c *gin.Context, ctx context.Context, kind, owner, target string, clone func(ctx context.Context) error) {
+	if h.jobManager == nil {
+		err := clone(ctx)
+		if err != nil {
+			providerError(c, err, http.StatusBadRequest)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Pod deployed successfully!"})
+		return
+	}
+
+	job, err := h.jobManager.Submit(kind, owner, target, clone)
+	if err == jobs.ErrQueueFull || err == jobs.ErrClosed {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting clone"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Clone started", "jobId": job.ID})
+}
+
+// leased wraps clone to give the pod it creates a lease. name is what the pod is named after, and
+// ttl is worked out now because the caller's roles are not known once the clone runs as a job.
+func (h *ProviderHandlers) leased(owner, name string, ttl time.Duration, clone func(ctx context.Context) error) func(ctx context.Context) error {
+	if h.leaseManager == nil {
+		return clone
+	}
+	return func(ctx context.Context) error {
+		return h.leaseManager.Track(ctx, owner, name, ttl, clone)
+	}
+}
+
+// leaseTTL returns how long a pod the caller clones from template lives
+func (h *ProviderHandlers) leaseTTL(c *gin.Context, template string) time.Duration {
+	if h.leaseManager == nil {
+		return 0
+	}
+	roles, err := h.enforcer.Roles(c)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return h.leaseManager.TTL(template, roles)
+}
+
+func bulkResponse(c *gin.Context, result providers.BulkResult, err error, failedMsg, okMsg string) {
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "failed": result.Failed})
+		return
+	}
+	if len(result.Failed) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": failedMsg, "failed": result.Failed})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": okMsg})
+}
+
+func (h *ProviderHandlers) GetPods(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "GET /api/v1/view/pods")
+	defer span.End()
+
+	username := GetUser(c)
+	span.SetAttributes(attribute.String("username", username))
+
+	pods, err := h.provider.ListPods(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error getting pods"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pods": pods})
+}
+
+// AdminGetPods lists the pods of every user
+func (h *ProviderHandlers) AdminGetPods(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "GET /api/v1/admin/view/pods")
+	defer span.End()
+
+	pods, err := h.provider.ListAllPods(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error getting pods"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pods": pods})
+}
+
+func (h *ProviderHandlers) DeletePod(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "DELETE /api/v1/pod/delete")
+	defer span.End()
+
+	req := providers.DeletePodRequest{
+		PodID:      c.Param("podId"),
+		ServerGUID: c.Query("server"),
+		Owner:      GetUser(c),
+	}
+	span.SetAttributes(attribute.String("deleted-pod", req.PodID))
+
+	pods, err := h.provider.ListPods(ctx, req.Owner)
+	if err != nil {
+		providerError(c, err, http.StatusInternalServerError)
+		return
+	}
+	if !ownsPod(pods, req.PodID, req.ServerGUID) {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+		return
+	}
+
+	err = h.provider.DeletePod(ctx, req)
+	if err != nil {
+		providerError(c, err, http.StatusBadRequest)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Pod deleted successfully!"})
+}
+
+// ownsPod reports whether podID is among pods, on serverGUID when one is given
+func ownsPod(pods []providers.Pod, podID, serverGUID string) bool {
+	for _, pod := range pods {
+		if pod.Name == podID && (serverGUID == "" || pod.ServerGUID == serverGUID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *ProviderHandlers) GetPresetTemplates(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "GET /api/v1/view/templates")
+	defer span.End()
+
+	showHidden := h.enforcer.Has(c, rbac.TemplatesViewHidden)
+	templates, err := h.provider.ListPresetTemplates(ctx, showHidden)
+	if err != nil {
+		providerError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+func (h *ProviderHandlers) GetCustomTemplates(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "GET /api/v1/view/template/vms")
+	defer span.End()
+
+	templates, err := h.provider.ListCustomTemplates(ctx)
+	if err != nil {
+		providerError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+func (h *ProviderHandlers) CloneFromTemplate(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/pod/clone/template")
+	defer span.End()
+
+	var form struct {
+		Template string `json:"template"`
+	}
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	username := GetUser(c)
+	span.SetAttributes(attribute.String("username", username))
+	span.SetAttributes(attribute.String("template", form.Template))
+
+	fmt.Printf("User %s is cloning template %s\n", username, form.Template)
+	req := providers.TemplateCloneRequest{Template: form.Template, Username: username}
+	ttl := h.leaseTTL(c, form.Template)
+	h.runClone(c, ctx, "template", username, form.Template, h.leased(username, form.Template, ttl, func(ctx context.Context) error {
+		return h.provider.CloneTemplate(ctx, req)
+	}))
+}
+
+func (h *ProviderHandlers) CloneCustomPod(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/pod/clone/custom")
+	defer span.End()
+
+	var form struct {
+		Name       string   `json:"name"`
+		Nat        bool     `json:"nat"`
+		Vmstoclone []string `json:"vmstoclone"`
+		Provider   string   `json:"provider"`
+	}
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req := providers.CustomCloneRequest{
+		Name:     form.Name,
+		VMs:      form.Vmstoclone,
+		Natted:   form.Nat,
+		Username: GetUser(c),
+		Provider: form.Provider,
+	}
+
+	span.SetAttributes(attribute.String("username", req.Username))
+	span.SetAttributes(attribute.String("pod-name", req.Name))
+	span.SetAttributes(attribute.Bool("nat", req.Natted))
+	span.SetAttributes(attribute.StringSlice("vms-to-clone", req.VMs))
+
+	err = req.Validate()
+	if err != nil {
+		providerError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	fmt.Printf("User %s is cloning custom pod %s\n", req.Username, req.Name)
+	ttl := h.leaseTTL(c, "")
+	h.runClone(c, ctx, "custom", req.Username, req.Name, h.leased(req.Username, req.Name, ttl, func(ctx context.Context) error {
+		return h.provider.CloneCustom(ctx, req)
+	}))
+}
+
+func (h *ProviderHandlers) RefreshTemplates(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/admin/templates/refresh")
+	defer span.End()
+
+	err := h.provider.RefreshTemplates(ctx)
+	if err != nil {
+		providerError(c, err, http.StatusBadRequest)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Templates refreshed successfully!"})
+}
+
+func (h *ProviderHandlers) BulkClonePods(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/admin/pod/clone/bulk")
+	defer span.End()
+
+	var form struct {
+		Template string   `json:"template"`
+		Names    []string `json:"names"`
+	}
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fmt.Printf("User %s is cloning %d pods\n", GetUser(c), len(form.Names))
+	// the roles of the users cloned for are not known, so their pods get the template's TTL
+	var ttl time.Duration
+	if h.leaseManager != nil {
+		ttl = h.leaseManager.TTL(form.Template, nil)
+	}
+	eg := errgroup.Group{}
+	for _, name := range form.Names {
+		if name == "" {
+			continue
+		}
+		clone := h.leased(name, form.Template, ttl, func(ctx context.Context) error {
+			return h.provider.CloneTemplate(ctx, providers.TemplateCloneRequest{Template: form.Template, Username: name})
+		})
+		eg.Go(func() error {
+			return clone(ctx)
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		providerError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Pods deployed successfully!"})
+}
+
+func (h *ProviderHandlers) BulkDeletePods(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "DELETE /api/v1/admin/pod/delete/bulk")
+	defer span.End()
+
+	var form struct {
+		Filters []string `json:"filters"`
+	}
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	span.SetAttributes(attribute.StringSlice("filters", form.Filters))
+
+	result, err := h.provider.BulkDelete(ctx, providers.PodFilter(form.Filters))
+	bulkResponse(c, result, err, "Failed to delete pods", "Pods deleted successfully!")
+}
+
+func (h *ProviderHandlers) BulkRevertPods(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/admin/pod/revert/bulk")
+	defer span.End()
+
+	var form struct {
+		Filters  []string `json:"filters"`
+		Snapshot string   `json:"snapshot"`
+	}
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.provider.BulkRevert(ctx, providers.PodFilter(form.Filters), form.Snapshot)
+	bulkResponse(c, result, err, "Failed to revert pods", "Pods reverted successfully!")
+}
+
+func (h *ProviderHandlers) BulkPowerPods(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/admin/pod/power/bulk")
+	defer span.End()
+
+	var form struct {
+		Filters []string `json:"filters"`
+		Power   bool     `json:"power"`
+	}
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.provider.BulkPower(ctx, providers.PodFilter(form.Filters), form.Power)
+	bulkResponse(c, result, err, "Failed to power pods", "Pods powered successfully!")
+}
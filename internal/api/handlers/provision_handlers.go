@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"goclone/internal/auth"
+	"goclone/internal/auth/provision"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ProvisionHandlers create accounts in bulk from class rosters
+type ProvisionHandlers struct {
+	authManager auth.AuthManager
+	tracer      trace.Tracer
+}
+
+func NewProvisionHandlers(authManager auth.AuthManager) *ProvisionHandlers {
+	return &ProvisionHandlers{
+		authManager: authManager,
+		tracer:      otel.Tracer("goclone"),
+	}
+}
+
+// ProvisionRoster reads a CSV roster from the roster form file and creates an account with a random
+// password for every row. With the dry_run query parameter it only reports what it would do.
+// Rows are independent, so one bad row does not stop the others; the report has the outcome of each.
+func (h *ProvisionHandlers) ProvisionRoster(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/admin/user/provision")
+	defer span.End()
+
+	provisioner, ok := h.authManager.(auth.Provisioner)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "The auth backend cannot provision users"})
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	span.SetAttributes(attribute.Bool("dry_run", dryRun))
+
+	// the upload is parsed within the router's MaxMultipartMemory
+	header, err := c.FormFile("roster")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Roster file not provided"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error reading roster"})
+		return
+	}
+	defer file.Close()
+
+	rows, err := provision.ParseRoster(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	span.SetAttributes(attribute.Int("rows", len(rows)))
+
+	results := provision.Run(provisioner, rows, dryRun)
+
+	summary := map[provision.Status]int{}
+	for _, result := range results {
+		summary[result.Status]++
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dryRun": dryRun, "summary": summary, "results": results})
+}
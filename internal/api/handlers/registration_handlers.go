@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"goclone/internal/auth"
+	"goclone/internal/auth/registration"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RegistrationHandlers serve registration through the gate, and let admins manage invites and pending registrations
+type RegistrationHandlers struct {
+	gate   *registration.Gate
+	tracer trace.Tracer
+}
+
+func NewRegistrationHandlers(gate *registration.Gate) *RegistrationHandlers {
+	return &RegistrationHandlers{
+		gate:   gate,
+		tracer: otel.Tracer("goclone"),
+	}
+}
+
+func (h *RegistrationHandlers) Register(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/register")
+	defer span.End()
+
+	var userInfo map[string]interface{}
+	if err := c.BindJSON(&userInfo); err != nil {
+		c.String(http.StatusBadRequest, "Bad Request")
+		return
+	}
+	if backend := c.Query("backend"); backend != "" {
+		userInfo["backend"] = backend
+	}
+
+	if err := registration.Validate(userInfo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	username, _ := userInfo["username"].(string)
+	span.SetAttributes(attribute.String("username", username))
+
+	pending, err := h.gate.Register(userInfo, c.ClientIP())
+	switch err {
+	case nil:
+	case registration.ErrInviteRequired, registration.ErrInvalidInvite:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case registration.ErrPendingExists:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	default:
+		fmt.Println(err)
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	if pending {
+		c.JSON(http.StatusAccepted, gin.H{"message": "Registration is waiting for approval"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User registered"})
+}
+
+// GetMode tells the frontend whether to ask for an invite code
+func (h *RegistrationHandlers) GetMode(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"mode": h.gate.Mode()})
+}
+
+func (h *RegistrationHandlers) CreateInvite(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/admin/invites")
+	defer span.End()
+
+	var form struct {
+		MaxUses int    `json:"maxUses"`
+		Days    int    `json:"days"`
+		Group   string `json:"group"`
+		Role    string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if form.Days == 0 {
+		form.Days = 7
+	}
+
+	invite, err := h.gate.CreateInvite(GetUser(c), form.MaxUses, time.Duration(form.Days)*24*time.Hour, form.Group, form.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	span.SetAttributes(attribute.String("group", invite.Group))
+
+	c.JSON(http.StatusOK, gin.H{"invite": invite})
+}
+
+func (h *RegistrationHandlers) ListInvites(c *gin.Context) {
+	invites, err := h.gate.ListInvites()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting invites"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"invites": invites})
+}
+
+func (h *RegistrationHandlers) DeleteInvite(c *gin.Context) {
+	err := h.gate.DeleteInvite(c.Param("code"))
+	if err == registration.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting invite"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Invite deleted"})
+}
+
+func (h *RegistrationHandlers) ListPending(c *gin.Context) {
+	list, err := h.gate.ListPending()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting registrations"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"registrations": list})
+}
+
+// ApproveRegistration creates the pending user. When the backend refuses, the error is returned
+// and the registration stays pending.
+func (h *RegistrationHandlers) ApproveRegistration(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/admin/registrations/approve")
+	defer span.End()
+
+	pending, err := h.gate.Approve(c.Param("id"))
+	if err == registration.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Registration not found"})
+		return
+	}
+	if err == auth.ErrWeakPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error approving registration: %v", err)})
+		return
+	}
+	span.SetAttributes(attribute.String("username", pending.Username))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Registration approved", "registration": pending})
+}
+
+func (h *RegistrationHandlers) RejectRegistration(c *gin.Context) {
+	err := h.gate.Reject(c.Param("id"))
+	if err == registration.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Registration not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error rejecting registration"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Registration rejected"})
+}
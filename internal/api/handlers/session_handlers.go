@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+
+	"goclone/internal/auth/sessionstore"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SessionHandlers lets admins see who is logged in and end their sessions
+type SessionHandlers struct {
+	store  *sessionstore.Store
+	tracer trace.Tracer
+}
+
+func NewSessionHandlers(store *sessionstore.Store) *SessionHandlers {
+	return &SessionHandlers{
+		store:  store,
+		tracer: otel.Tracer("goclone"),
+	}
+}
+
+// ListSessions lists every active session, or those of the user given in the user query parameter
+func (h *SessionHandlers) ListSessions(c *gin.Context) {
+	list, err := h.store.List(c.Query("user"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": list})
+}
+
+func (h *SessionHandlers) RevokeSession(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "DELETE /api/v1/admin/sessions")
+	defer span.End()
+
+	err := h.store.Revoke(c.Param("id"))
+	if err == sessionstore.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error revoking session"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+func (h *SessionHandlers) RevokeUserSessions(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "DELETE /api/v1/admin/sessions/user")
+	defer span.End()
+
+	username := c.Param("username")
+	span.SetAttributes(attribute.String("username", username))
+
+	revoked, err := h.store.RevokeUser(username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error revoking sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Sessions revoked", "revoked": revoked})
+}
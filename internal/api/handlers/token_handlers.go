@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"goclone/internal/auth"
+	"goclone/internal/auth/rbac"
+	"goclone/internal/auth/tokens"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tokenContextKey = "apiToken"
+
+// TokenAuth lets requests authenticate with an "Authorization: Bearer" API token instead of a session cookie.
+// The request gets a session built from the token that is never saved, so a token never turns into a cookie.
+func TokenAuth(store *tokens.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok {
+			c.Next()
+			return
+		}
+
+		if store == nil {
+			c.String(http.StatusUnauthorized, "Unauthorized")
+			c.Abort()
+			return
+		}
+
+		token, err := store.Validate(strings.TrimSpace(raw))
+		if err == tokens.ErrInvalidToken {
+			c.String(http.StatusUnauthorized, "Unauthorized")
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Internal Server Error")
+			c.Abort()
+			return
+		}
+
+		c.Set(sessions.DefaultKey, newTokenSession(token))
+		c.Set(tokenContextKey, token)
+		c.Next()
+	}
+}
+
+// GetToken returns the API token the request was made with, or nil for cookie sessions
+func GetToken(c *gin.Context) *tokens.Token {
+	token, ok := c.Get(tokenContextKey)
+	if !ok {
+		return nil
+	}
+	return token.(*tokens.Token)
+}
+
+// RequireScope rejects token requests whose scope does not cover the route. Cookie sessions are not limited.
+func RequireScope(scope tokens.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := GetToken(c)
+		if token != nil && !token.Scope.Allows(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Token scope %s does not allow this request", token.Scope)})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// SessionOnly rejects token requests, so a leaked token cannot be used to mint new ones
+func SessionOnly(c *gin.Context) {
+	if GetToken(c) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Tokens can only be managed from a logged in session"})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// tokenSession stands in for the cookie session on token requests and is never persisted
+type tokenSession struct {
+	id     string
+	values map[interface{}]interface{}
+}
+
+func newTokenSession(token *tokens.Token) *tokenSession {
+	values := map[interface{}]interface{}{
+		"id": token.Username,
+	}
+	if token.Backend != "" {
+		values[auth.SessionBackendKey] = token.Backend
+	}
+	return &tokenSession{id: "token-" + token.ID, values: values}
+}
+
+func (s *tokenSession) ID() string                         { return s.id }
+func (s *tokenSession) Get(key interface{}) interface{}    { return s.values[key] }
+func (s *tokenSession) Set(key interface{}, v interface{}) { s.values[key] = v }
+func (s *tokenSession) Delete(key interface{})             { delete(s.values, key) }
+func (s *tokenSession) Clear()                             { s.values = map[interface{}]interface{}{} }
+func (s *tokenSession) AddFlash(interface{}, ...string)    {}
+func (s *tokenSession) Flashes(...string) []interface{}    { return nil }
+func (s *tokenSession) Options(sessions.Options)           {}
+func (s *tokenSession) Save() error                        { return nil }
+
+// TokenHandlers lets users manage their own API tokens and admins manage everyone's
+type TokenHandlers struct {
+	store    *tokens.Store
+	enforcer *rbac.Enforcer
+	tracer   trace.Tracer
+}
+
+func NewTokenHandlers(store *tokens.Store, enforcer *rbac.Enforcer) *TokenHandlers {
+	return &TokenHandlers{
+		store:    store,
+		enforcer: enforcer,
+		tracer:   otel.Tracer("goclone"),
+	}
+}
+
+func (h *TokenHandlers) CreateToken(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/tokens")
+	defer span.End()
+
+	var form struct {
+		Name  string `json:"name"`
+		Scope string `json:"scope"`
+		Days  int    `json:"days"`
+	}
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scope, err := tokens.ParseScope(form.Scope)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// admin tokens are for anyone who can use at least one admin route, the routes still check their permission
+	if scope == tokens.ScopeAdmin && !h.enforcer.Privileged(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can create admin tokens"})
+		return
+	}
+
+	backend, _ := sessions.Default(c).Get(auth.SessionBackendKey).(string)
+	owner := tokens.Owner{Username: GetUser(c), Backend: backend}
+	span.SetAttributes(attribute.String("username", owner.Username))
+	span.SetAttributes(attribute.String("scope", string(scope)))
+
+	raw, token, err := h.store.Create(owner, form.Name, scope, time.Duration(form.Days)*24*time.Hour)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": raw, "info": token})
+}
+
+func (h *TokenHandlers) ListTokens(c *gin.Context) {
+	list, err := h.store.List(GetUser(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": list})
+}
+
+func (h *TokenHandlers) RevokeToken(c *gin.Context) {
+	h.revoke(c, GetUser(c))
+}
+
+// AdminListTokens lists every token, or those of the user given in the user query parameter
+func (h *TokenHandlers) AdminListTokens(c *gin.Context) {
+	list, err := h.store.List(c.Query("user"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": list})
+}
+
+func (h *TokenHandlers) AdminRevokeToken(c *gin.Context) {
+	h.revoke(c, "")
+}
+
+func (h *TokenHandlers) revoke(c *gin.Context, owner string) {
+	_, span := h.tracer.Start(c.Request.Context(), "DELETE /api/v1/tokens")
+	defer span.End()
+
+	id := c.Param("id")
+	span.SetAttributes(attribute.String("token", id))
+
+	err := h.store.Revoke(id, owner)
+	if err == tokens.ErrTokenNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error revoking token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+}
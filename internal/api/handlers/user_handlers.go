@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"goclone/internal/auth"
+	"goclone/internal/auth/rbac"
+	"goclone/internal/auth/sessionstore"
+	"goclone/internal/auth/tokens"
+	"goclone/internal/providers"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// UserHandlers let admins browse, disable and delete accounts. sessionStore and tokenStore may be nil,
+// in which case a disabled or deleted user's existing sessions and tokens are left to expire.
+type UserHandlers struct {
+	authManager  auth.AuthManager
+	provider     providers.Provider
+	sessionStore *sessionstore.Store
+	tokenStore   *tokens.Store
+	enforcer     *rbac.Enforcer
+	tracer       trace.Tracer
+}
+
+func NewUserHandlers(authManager auth.AuthManager, provider providers.Provider, sessionStore *sessionstore.Store, tokenStore *tokens.Store, enforcer *rbac.Enforcer) *UserHandlers {
+	return &UserHandlers{
+		authManager:  authManager,
+		provider:     provider,
+		sessionStore: sessionStore,
+		tokenStore:   tokenStore,
+		enforcer:     enforcer,
+		tracer:       otel.Tracer("goclone"),
+	}
+}
+
+func (h *UserHandlers) directory(c *gin.Context) (auth.UserDirectory, bool) {
+	directory, ok := h.authManager.(auth.UserDirectory)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "The auth backend cannot manage users"})
+	}
+	return directory, ok
+}
+
+func (h *UserHandlers) ListUsers(c *gin.Context) {
+	_, span := h.tracer.Start(c.Request.Context(), "GET /api/v1/admin/users")
+	defer span.End()
+
+	directory, ok := h.directory(c)
+	if !ok {
+		return
+	}
+
+	users, err := directory.ListUsers()
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting users"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// GetUser shows a user's attributes and group membership along with the pods they own
+func (h *UserHandlers) GetUser(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "GET /api/v1/admin/user/view")
+	defer span.End()
+
+	username := c.Param("username")
+	span.SetAttributes(attribute.String("username", username))
+
+	directory, ok := h.directory(c)
+	if !ok {
+		return
+	}
+
+	info, err := directory.GetUserInfo(username)
+	if err == auth.ErrUnknownUser {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting user"})
+		return
+	}
+
+	pods, err := h.provider.ListPods(ctx, info.Username)
+	if err != nil {
+		providerError(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": info, "pods": pods})
+}
+
+func (h *UserHandlers) DisableUser(c *gin.Context) {
+	h.setDisabled(c, true)
+}
+
+func (h *UserHandlers) EnableUser(c *gin.Context) {
+	h.setDisabled(c, false)
+}
+
+// setDisabled disables or enables an account. Disabling also logs the user out everywhere,
+// since their sessions and tokens would otherwise outlive the account.
+func (h *UserHandlers) setDisabled(c *gin.Context, disabled bool) {
+	action := "enable"
+	if disabled {
+		action = "disable"
+	}
+	_, span := h.tracer.Start(c.Request.Context(), "POST /api/v1/admin/user/"+action)
+	defer span.End()
+
+	username := c.Param("username")
+	span.SetAttributes(attribute.String("username", username))
+
+	directory, ok := h.directory(c)
+	if !ok {
+		return
+	}
+
+	err := directory.SetUserDisabled(username, disabled)
+	if err == auth.ErrUnknownUser {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating user"})
+		return
+	}
+
+	if disabled {
+		h.logOut(username)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User " + action + "d"})
+}
+
+// DeleteUser deletes an account. With the destroy_pods query parameter the user's pods are
+// destroyed first; if any of them fail the account is kept so the request can be retried.
+func (h *UserHandlers) DeleteUser(c *gin.Context) {
+	ctx, span := h.tracer.Start(c.Request.Context(), "DELETE /api/v1/admin/user/delete")
+	defer span.End()
+
+	username := c.Param("username")
+	destroyPods, _ := strconv.ParseBool(c.Query("destroy_pods"))
+	span.SetAttributes(attribute.String("username", username), attribute.Bool("destroy_pods", destroyPods))
+
+	directory, ok := h.directory(c)
+	if !ok {
+		return
+	}
+
+	info, err := directory.GetUserInfo(username)
+	if err == auth.ErrUnknownUser {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting user"})
+		return
+	}
+
+	destroyed := []string{}
+	if destroyPods {
+		pods, err := h.provider.ListPods(ctx, info.Username)
+		if err != nil {
+			providerError(c, err, http.StatusInternalServerError)
+			return
+		}
+
+		failed := gin.H{}
+		for _, pod := range pods {
+			err := h.provider.DeletePod(ctx, providers.DeletePodRequest{
+				PodID:      pod.Name,
+				ServerGUID: pod.ServerGUID,
+				Owner:      info.Username,
+			})
+			if err != nil {
+				failed[pod.Name] = err.Error()
+				continue
+			}
+			destroyed = append(destroyed, pod.Name)
+		}
+		if len(failed) > 0 {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error destroying pods, user was not deleted", "destroyed": destroyed, "failed": failed})
+			return
+		}
+	}
+
+	err = directory.DeleteUser(info.Username)
+	if err == auth.ErrUnknownUser {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting user"})
+		return
+	}
+
+	h.logOut(info.Username)
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted", "destroyed": destroyed})
+}
+
+// logOut ends the user's sessions, revokes their API tokens and forgets their cached roles
+func (h *UserHandlers) logOut(username string) {
+	if h.sessionStore != nil {
+		if _, err := h.sessionStore.RevokeUser(username); err != nil {
+			fmt.Println(err)
+		}
+	}
+	if h.tokenStore != nil {
+		if err := h.tokenStore.RevokeUser(username); err != nil {
+			fmt.Println(err)
+		}
+	}
+	h.enforcer.Invalidate(username)
+}
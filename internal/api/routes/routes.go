@@ -3,50 +3,183 @@ package routes
 import (
     "goclone/internal/api/handlers"
     "goclone/internal/auth"
+    "goclone/internal/auth/lockout"
+    "goclone/internal/auth/mfa"
+    "goclone/internal/auth/rbac"
+    "goclone/internal/auth/registration"
+    "goclone/internal/auth/reset"
+    "goclone/internal/auth/sessionstore"
+    "goclone/internal/auth/tokens"
+    "goclone/internal/jobs"
+    "goclone/internal/leases"
+    "goclone/internal/orphans"
     "goclone/internal/providers"
 
     "github.com/gin-gonic/gin"
 )
 
-func AddRoutes(router *gin.Engine, authManager auth.AuthManager, virtProvider providers.Provider) {
+// AddRoutes registers the API. tokenStore may be nil, in which case API tokens are refused.
+// sessionStore may be nil when sessions are kept somewhere that cannot list them, which leaves out the session admin routes.
+// resets may be nil, which leaves out the forgotten password routes.
+// gate may be nil for open registration straight through the auth manager.
+// guard may be nil to allow unlimited login attempts.
+// mfaManager may be nil, which turns off MFA and leaves out its routes.
+// jobManager may be nil to clone within the request, which leaves out the job routes.
+// collector may be nil when leaked resources are not looked for, which leaves out the orphan routes.
+// leaseManager may be nil to keep pods until they are deleted, which leaves out the lease routes.
+func AddRoutes(router *gin.Engine, authManager auth.AuthManager, enforcer *rbac.Enforcer, virtProvider providers.Provider, tokenStore *tokens.Store, sessionStore *sessionstore.Store, resets *reset.Manager, gate *registration.Gate, guard *lockout.Guard, mfaManager *mfa.Manager, jobManager *jobs.Manager, collector *orphans.Collector, leaseManager *leases.Manager) {
+    passwordHandlers := handlers.NewPasswordHandlers(authManager, resets, sessionStore, guard)
+
     public := router.Group("/api/v1")
-    addPublicRoutes(public, authManager)
+    addPublicRoutes(public, authManager, guard)
+    registrationHandlers := handlers.NewRegistrationHandlers(gate)
+    if gate != nil {
+        public.POST("/register", registrationHandlers.Register)
+        public.GET("/register/mode", registrationHandlers.GetMode)
+    } else {
+        public.POST("/register", authManager.RegisterUser)
+    }
+    if resets != nil {
+        public.POST("/password/forgot", passwordHandlers.ForgotPassword)
+        public.POST("/password/reset", passwordHandlers.ResetPassword)
+    }
+
+    tokenAuth := handlers.TokenAuth(tokenStore)
+    tokenHandlers := handlers.NewTokenHandlers(tokenStore, enforcer)
+    mfaRequired := handlers.MFARequired(mfaManager, enforcer)
+
+    // logged in, but maybe still waiting for an MFA code
+    pending := router.Group("/api/v1")
+    pending.Use(tokenAuth, handlers.AuthRequired)
+    pending.GET("/logout", handlers.RequireScope(tokens.ScopeRead), handlers.Logout)
+    mfaHandlers := handlers.NewMFAHandlers(mfaManager, enforcer, guard)
+    if mfaManager != nil {
+        pending.POST("/login/mfa", handlers.SessionOnly, mfaHandlers.Verify)
+        addMFARoutes(pending.Group("/mfa", handlers.SessionOnly), mfaHandlers)
+    }
 
     private := router.Group("/api/v1")
-    private.Use(handlers.AuthRequired)
-    addPrivateRoutes(private, virtProvider)
+    private.Use(tokenAuth, handlers.AuthRequired, mfaRequired)
+    providerHandlers := handlers.NewProviderHandlers(virtProvider, enforcer, jobManager, leaseManager)
+    addPrivateRoutes(private, providerHandlers)
+    leaseHandlers := handlers.NewLeaseHandlers(leaseManager)
+    if leaseManager != nil {
+        private.GET("/view/leases", handlers.RequireScope(tokens.ScopeRead), leaseHandlers.ListLeases)
+        private.POST("/pod/lease/extend/:podId", handlers.RequireScope(tokens.ScopePods), leaseHandlers.ExtendLease)
+    }
+    if jobManager != nil {
+        jobHandlers := handlers.NewJobHandlers(jobManager, enforcer)
+        private.GET("/jobs", handlers.RequireScope(tokens.ScopeRead), jobHandlers.ListJobs)
+        private.GET("/jobs/:id", handlers.RequireScope(tokens.ScopeRead), jobHandlers.GetJob)
+        private.GET("/jobs/:id/events", handlers.RequireScope(tokens.ScopeRead), jobHandlers.StreamJob)
+    }
+    private.GET("/view/roles", handlers.RequireScope(tokens.ScopeRead), enforcer.GetRoles)
+    private.POST("/password/change", handlers.SessionOnly, passwordHandlers.ChangePassword)
+    if tokenStore != nil {
+        addTokenRoutes(private.Group("/tokens", handlers.SessionOnly), tokenHandlers)
+    }
 
     admin := router.Group("/api/v1/admin")
-    admin.Use(handlers.AuthRequired, authManager.IsAdmin)
-    addAdminRoutes(admin, virtProvider)
+    admin.Use(tokenAuth, handlers.AuthRequired, mfaRequired, handlers.RequireScope(tokens.ScopeAdmin))
+    addAdminRoutes(admin, enforcer, providerHandlers)
+    admin.POST("/user/password/reset", enforcer.Require(rbac.UsersManage), passwordHandlers.AdminResetPassword)
+    admin.POST("/user/provision", enforcer.Require(rbac.UsersManage), handlers.NewProvisionHandlers(authManager).ProvisionRoster)
+    addUserRoutes(admin, enforcer, handlers.NewUserHandlers(authManager, virtProvider, sessionStore, tokenStore, enforcer))
+    if gate != nil {
+        addRegistrationRoutes(admin, enforcer, registrationHandlers)
+    }
+    if tokenStore != nil {
+        admin.GET("/tokens", enforcer.Require(rbac.TokensManage), tokenHandlers.AdminListTokens)
+        admin.DELETE("/tokens/:id", enforcer.Require(rbac.TokensManage), tokenHandlers.AdminRevokeToken)
+    }
+    if mfaManager != nil {
+        admin.DELETE("/mfa/:username", enforcer.Require(rbac.UsersManage), mfaHandlers.AdminReset)
+    }
+    if guard != nil {
+        lockoutHandlers := handlers.NewLockoutHandlers(guard)
+        admin.GET("/lockouts", enforcer.Require(rbac.UsersManage), lockoutHandlers.ListLockouts)
+        admin.DELETE("/lockouts/user/:username", enforcer.Require(rbac.UsersManage), lockoutHandlers.UnlockUser)
+        admin.DELETE("/lockouts/ip/:ip", enforcer.Require(rbac.UsersManage), lockoutHandlers.UnlockIP)
+    }
+    if leaseManager != nil {
+        admin.GET("/view/leases", enforcer.Require(rbac.PodsViewAll), leaseHandlers.AdminListLeases)
+    }
+    if collector != nil {
+        orphanHandlers := handlers.NewOrphanHandlers(collector)
+        admin.GET("/orphans", enforcer.Require(rbac.OrphansManage), orphanHandlers.ListOrphans)
+        admin.POST("/orphans/scan", enforcer.Require(rbac.OrphansManage), orphanHandlers.ScanOrphans)
+    }
+    if sessionStore != nil {
+        sessionHandlers := handlers.NewSessionHandlers(sessionStore)
+        admin.GET("/sessions", enforcer.Require(rbac.SessionsManage), sessionHandlers.ListSessions)
+        admin.DELETE("/sessions/:id", enforcer.Require(rbac.SessionsManage), sessionHandlers.RevokeSession)
+        admin.DELETE("/sessions/user/:username", enforcer.Require(rbac.SessionsManage), sessionHandlers.RevokeUserSessions)
+    }
 }
 
-func addPublicRoutes(g *gin.RouterGroup, authManager auth.AuthManager) {
-    g.POST("/login", authManager.Login)
-    g.POST("/register", authManager.RegisterUser)
+func addPublicRoutes(g *gin.RouterGroup, authManager auth.AuthManager, guard *lockout.Guard) {
+    g.POST("/login", handlers.LoginGuard(guard), authManager.Login)
+    if cb, ok := authManager.(auth.CallbackHandler); ok {
+        g.GET("/login", authManager.Login)
+        g.GET("/login/callback", cb.Callback)
+    }
     g.GET("/health", handlers.HealthCheck)
 }
 
-func addPrivateRoutes(g *gin.RouterGroup, virtProvider providers.Provider) {
-    g.GET("/logout", handlers.Logout)
+func addPrivateRoutes(g *gin.RouterGroup, h *handlers.ProviderHandlers) {
+    read := handlers.RequireScope(tokens.ScopeRead)
+    pods := handlers.RequireScope(tokens.ScopePods)
 
-    g.GET("/view/pods", virtProvider.GetPodsHandler)
+    g.GET("/view/pods", read, h.GetPods)
 
     // system
-    g.GET("/view/templates/preset", virtProvider.GetPresetTemplatesHandler)
-    g.GET("/view/templates/custom", virtProvider.GetTemplateVMsHandler)
+    g.GET("/view/templates/preset", read, h.GetPresetTemplates)
+    g.GET("/view/templates/custom", read, h.GetCustomTemplates)
 
     // clone
-    g.POST("/pod/clone/custom", virtProvider.CloneCustomPodHandler)
-    g.POST("/pod/clone/template", virtProvider.CloneFromTemplateHandler)
-    g.DELETE("/pod/delete/:podId", virtProvider.DeletePodHandler)
-}
-
-func addAdminRoutes(g *gin.RouterGroup, virtProvider providers.Provider) {
-    g.GET("/view/pods", virtProvider.GetPodsHandler)
-    g.POST("/pod/clone/bulk", virtProvider.BulkClonePodsHandler)
-	g.DELETE("/pod/delete/bulk", virtProvider.BulkDeletePodsHandler)
-	g.POST("/templates/refresh", virtProvider.RefreshTemplatesHandler)
-	g.POST("/pod/revert/bulk", virtProvider.BulkRevertPodHandler)
-	g.POST("/pod/power/bulk", virtProvider.BulkPowerPodHandler)
+    g.POST("/pod/clone/custom", pods, h.CloneCustomPod)
+    g.POST("/pod/clone/template", pods, h.CloneFromTemplate)
+    g.DELETE("/pod/delete/:podId", pods, h.DeletePod)
+}
+
+func addMFARoutes(g *gin.RouterGroup, h *handlers.MFAHandlers) {
+    g.GET("", h.Status)
+    g.POST("/enroll", h.Enroll)
+    g.POST("/confirm", h.Confirm)
+    g.POST("/recovery-codes", h.RegenerateRecoveryCodes)
+    g.DELETE("", h.Disable)
+}
+
+func addTokenRoutes(g *gin.RouterGroup, h *handlers.TokenHandlers) {
+    g.GET("", h.ListTokens)
+    g.POST("", h.CreateToken)
+    g.DELETE("/:id", h.RevokeToken)
+}
+
+func addRegistrationRoutes(g *gin.RouterGroup, enforcer *rbac.Enforcer, h *handlers.RegistrationHandlers) {
+    manage := enforcer.Require(rbac.UsersManage)
+    g.GET("/invites", manage, h.ListInvites)
+    g.POST("/invites", manage, h.CreateInvite)
+    g.DELETE("/invites/:code", manage, h.DeleteInvite)
+    g.GET("/registrations", manage, h.ListPending)
+    g.POST("/registrations/:id/approve", manage, h.ApproveRegistration)
+    g.POST("/registrations/:id/reject", manage, h.RejectRegistration)
+}
+
+func addUserRoutes(g *gin.RouterGroup, enforcer *rbac.Enforcer, h *handlers.UserHandlers) {
+    manage := enforcer.Require(rbac.UsersManage)
+    g.GET("/users", manage, h.ListUsers)
+    g.GET("/user/view/:username", manage, h.GetUser)
+    g.POST("/user/disable/:username", manage, h.DisableUser)
+    g.POST("/user/enable/:username", manage, h.EnableUser)
+    g.DELETE("/user/delete/:username", manage, h.DeleteUser)
+}
+
+func addAdminRoutes(g *gin.RouterGroup, enforcer *rbac.Enforcer, h *handlers.ProviderHandlers) {
+    g.GET("/view/pods", enforcer.Require(rbac.PodsViewAll), h.AdminGetPods)
+    g.POST("/pod/clone/bulk", enforcer.Require(rbac.PodsBulkClone), h.BulkClonePods)
+    g.DELETE("/pod/delete/bulk", enforcer.Require(rbac.PodsBulkDelete), h.BulkDeletePods)
+    g.POST("/templates/refresh", enforcer.Require(rbac.TemplatesRefresh), h.RefreshTemplates)
+    g.POST("/pod/revert/bulk", enforcer.Require(rbac.PodsBulkRevert), h.BulkRevertPods)
+    g.POST("/pod/power/bulk", enforcer.Require(rbac.PodsBulkPower), h.BulkPowerPods)
 }
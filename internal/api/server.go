@@ -5,16 +5,30 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
 
 	"goclone/internal/api/routes"
 	"goclone/internal/auth"
 	"goclone/internal/auth/ldap"
+	"goclone/internal/auth/local"
+	"goclone/internal/auth/lockout"
+	"goclone/internal/auth/mfa"
+	"goclone/internal/auth/oidc"
+	"goclone/internal/auth/rbac"
+	"goclone/internal/auth/registration"
+	"goclone/internal/auth/reset"
+	"goclone/internal/auth/sessionstore"
+	"goclone/internal/auth/tokens"
 	"goclone/internal/config"
+	"goclone/internal/jobs"
+	"goclone/internal/leases"
+	"goclone/internal/orphans"
 	"goclone/internal/providers"
+	"goclone/internal/providers/fake"
+	"goclone/internal/providers/proxmox"
 	"goclone/internal/providers/vsphere"
 
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -50,10 +64,15 @@ func StartAPI(conf *config.Config) {
 	router := gin.Default()
 	router.Use(CORSMiddleware(conf.Core.ExternalURL))
 	router.MaxMultipartMemory = 8 << 20 // 8Mib
-	initCookies(router)
+	sessionStore := initSessions(router, conf.Auth.Sessions)
+
+	enforcer, err := rbac.NewEnforcer(conf.Auth, authManager)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
 	// add routes
-	routes.AddRoutes(router, authManager, virtProvider)
+	routes.AddRoutes(router, authManager, enforcer, virtProvider, SetupTokenStore(conf), sessionStore, SetupPasswordReset(conf, authManager), SetupRegistration(conf, authManager), SetupLockout(conf), SetupMFA(conf), SetupJobs(conf), SetupOrphans(conf, virtProvider), SetupLeases(conf, virtProvider, authManager))
 
 	log.Fatalln(router.Run(conf.Core.ListeningAddress))
 }
@@ -80,27 +99,254 @@ func getUUID() string {
 	return uuid.NewString()
 }
 
-// initCookies use gin-contrib/sessions{/cookie} to initalize a cookie store.
-// It generates a random secret for the cookie store -- not ideal for continuity or invalidating previous cookies, but it's secure and it works
-func initCookies(router *gin.Engine) {
-	router.Use(sessions.Sessions("kamino", cookie.NewStore([]byte("kamino")))) // change to secret
+// initSessions keeps sessions on the server behind a signed "kamino" cookie, so they expire and can be revoked
+func initSessions(router *gin.Engine, conf config.Sessions) *sessionstore.Store {
+	store, err := sessionstore.NewStore(conf)
+	if err != nil {
+		log.Fatalln(errors.Wrap(err, "failed to open session store"))
+	}
+	router.Use(sessions.Sessions("kamino", store))
+	return store
 }
 
 func SetupAuthManager(conf *config.Config) auth.AuthManager {
+    if len(conf.Auth.Chain) > 0 {
+        var entries []auth.ChainEntry
+        for _, name := range conf.Auth.Chain {
+            manager, err := newAuthBackend(conf, name)
+            if err != nil {
+                log.Fatalln(err)
+            }
+            entries = append(entries, auth.ChainEntry{Name: name, Manager: manager})
+        }
+        authManager, err := auth.NewChainManager(entries)
+        if err != nil {
+            log.Fatalln(err)
+        }
+        fmt.Printf("Auth Chain Enabled: %s\n", strings.Join(conf.Auth.Chain, ", "))
+        return authManager
+    }
+
     var authManager auth.AuthManager
-    if (conf.Auth.Ldap != config.LdapProvider{}) {
-        authManager = ldap.NewLdapManager(conf.Auth.Ldap, conf.Core.Tracer)
-        fmt.Println("LDAP Auth Enabled")
+    for _, name := range []string{"ldap", "oidc", "local"} {
+        if !authBackendConfigured(conf, name) {
+            continue
+        }
+        manager, err := newAuthBackend(conf, name)
+        if err != nil {
+            log.Fatalln(err)
+        }
+        authManager = manager
+        break
     }
     return authManager
 }
 
-func SetupVirtProvider(conf *config.Config, authManager *auth.AuthManager) providers.Provider {
-    var virtProvider providers.Provider
-    if (conf.Provider.VCenter != config.VCenter{}) {
-        virtProvider = vsphere.NewVSphereProvider(conf, authManager)
-        fmt.Println("vSphere Provider Enabled")
+func authBackendConfigured(conf *config.Config, name string) bool {
+    switch name {
+    case "ldap":
+        return conf.Auth.Ldap != config.LdapProvider{}
+    case "oidc":
+        return conf.Auth.Oidc.IssuerURL != ""
+    case "local":
+        return conf.Auth.Local.DBPath != ""
+    }
+    return false
+}
+
+func newAuthBackend(conf *config.Config, name string) (auth.AuthManager, error) {
+    if !authBackendConfigured(conf, name) {
+        return nil, fmt.Errorf("Auth backend %s is not configured", name)
+    }
+
+    switch name {
+    case "ldap":
+        fmt.Println("LDAP Auth Enabled")
+        return ldap.NewLdapManager(conf.Auth.Ldap, conf.Core.Tracer)
+    case "oidc":
+        fmt.Println("OIDC Auth Enabled")
+        return oidc.NewOidcManager(conf.Auth.Oidc, conf.Core.Tracer)
+    default:
+        fmt.Println("Local Auth Enabled")
+        return local.NewLocalManager(conf.Auth.Local, conf.Core.Tracer)
+    }
+}
+
+// SetupTokenStore opens the API token database, returning nil when tokens are not configured
+func SetupTokenStore(conf *config.Config) *tokens.Store {
+    if conf.Auth.Tokens.DBPath == "" {
+        return nil
+    }
+
+    store, err := tokens.NewStore(conf.Auth.Tokens)
+    if err != nil {
+        log.Fatalln(err)
+    }
+    fmt.Println("API Tokens Enabled")
+    return store
+}
+
+// SetupPasswordReset enables reset links sent by email, returning nil unless password_reset is enabled.
+// Without an SMTP host the links are printed, which is only meant for trying the flow out.
+func SetupPasswordReset(conf *config.Config, authManager auth.AuthManager) *reset.Manager {
+    if !conf.Auth.PasswordReset.Enabled {
+        return nil
+    }
+
+    resetConf := conf.Auth.PasswordReset
+    if resetConf.ResetURL == "" {
+        resetConf.ResetURL = strings.TrimSuffix(conf.Core.ExternalURL, "/") + "/reset-password"
+    }
+
+    var notifier reset.Notifier = reset.LogNotifier{}
+    if resetConf.SMTP.Host != "" {
+        notifier = reset.NewSMTPNotifier(resetConf.SMTP)
+    }
+
+    resets, err := reset.NewManager(resetConf, authManager, notifier)
+    if err != nil {
+        log.Fatalln(err)
+    }
+    fmt.Println("Password Reset Enabled")
+    return resets
+}
+
+// SetupRegistration puts registration behind invite codes or approval, returning nil for open registration
+func SetupRegistration(conf *config.Config, authManager auth.AuthManager) *registration.Gate {
+    mode := conf.Auth.Registration.Mode
+    if mode == "" || mode == string(registration.ModeOpen) {
+        return nil
+    }
+
+    gate, err := registration.NewGate(conf.Auth.Registration, conf.Auth.Roles, authManager)
+    if err != nil {
+        log.Fatalln(err)
+    }
+    fmt.Printf("Registration Mode: %s\n", gate.Mode())
+    return gate
+}
+
+// SetupLockout starts tracking failed logins, returning nil when lockout is disabled
+func SetupLockout(conf *config.Config) *lockout.Guard {
+    if conf.Auth.Lockout.Disabled {
+        return nil
+    }
+
+    guard, err := lockout.NewGuard(conf.Auth.Lockout)
+    if err != nil {
+        log.Fatalln(err)
+    }
+    fmt.Println("Login Lockout Enabled")
+    return guard
+}
+
+// SetupMFA opens the MFA enrollment database, returning nil when MFA is not configured
+func SetupMFA(conf *config.Config) *mfa.Manager {
+    if conf.Auth.MFA.DBPath == "" {
+        return nil
+    }
+
+    manager, err := mfa.NewManager(conf.Auth.MFA)
+    if err != nil {
+        log.Fatalln(err)
+    }
+    fmt.Println("MFA Enabled")
+    return manager
+}
+
+// SetupJobs starts the workers clones run on, returning nil when clones run within the request
+func SetupJobs(conf *config.Config) *jobs.Manager {
+    if conf.Jobs.Disabled {
+        return nil
+    }
+
+    manager := jobs.NewManager(conf.Jobs)
+    fmt.Println("Clone Jobs Enabled")
+    return manager
+}
+
+// SetupOrphans starts scanning the providers for leaked resources, returning nil when the scans are disabled
+func SetupOrphans(conf *config.Config, virtProvider providers.Provider) *orphans.Collector {
+    provider, ok := virtProvider.(providers.OrphanCollector)
+    if conf.Orphans.Disabled || !ok {
+        return nil
+    }
+
+    collector := orphans.NewCollector(conf.Orphans, provider)
+    if conf.Orphans.AutoCleanup {
+        fmt.Println("Orphan Collector Enabled with Auto Cleanup")
+    } else {
+        fmt.Println("Orphan Collector Enabled")
     }
-    return virtProvider
+    return collector
 }
 
+// SetupLeases opens the lease database and starts expiring pods, returning nil when leases are not configured.
+// Without an SMTP host the expiry warnings are printed.
+func SetupLeases(conf *config.Config, virtProvider providers.Provider, authManager auth.AuthManager) *leases.Manager {
+    if conf.Leases.DBPath == "" {
+        return nil
+    }
+
+    var notifier leases.Notifier = reset.LogNotifier{}
+    if conf.Leases.SMTP.Host != "" {
+        notifier = reset.NewSMTPNotifier(conf.Leases.SMTP)
+    }
+    emails, _ := authManager.(auth.EmailResolver)
+
+    manager, err := leases.NewManager(conf.Leases, virtProvider, emails, notifier)
+    if err != nil {
+        log.Fatalln(err)
+    }
+    fmt.Println("Pod Leases Enabled")
+    return manager
+}
+
+func SetupVirtProvider(conf *config.Config, authManager *auth.AuthManager) providers.Provider {
+    // vcsim keeps its inventory in package state, so a second simulated vCenter would share the first one's
+    simulators := 0
+    for _, providerConf := range conf.AllProviders() {
+        if providerConf.Kind() == "vsphere" && providerConf.VCenter.Simulator {
+            simulators++
+        }
+    }
+    if simulators > 1 {
+        log.Fatalln(fmt.Errorf("Invalid provider config: only one vcenter may set simulator, %d do", simulators))
+    }
+
+    registry := providers.NewRegistry()
+    for _, providerConf := range conf.AllProviders() {
+        // each provider reads its own block through conf.Provider
+        pConf := *conf
+        pConf.Provider = providerConf
+
+        var backend providers.Backend
+        switch providerConf.Kind() {
+        case "fake":
+            backend = fake.NewFakeProvider(&pConf, authManager)
+        case "vsphere":
+            if providerConf.VCenter.Simulator {
+                // the simulator runs for the lifetime of the process
+                if _, err := vsphere.StartSimulator(&pConf); err != nil {
+                    log.Fatalln(errors.Wrap(err, "Failed to start vCenter simulator"))
+                }
+            }
+            backend = vsphere.NewVSphereProvider(&pConf, authManager)
+        case "proxmox":
+            backend = proxmox.NewProxmoxProvider(&pConf, authManager)
+        default:
+            continue
+        }
+
+        err := registry.Register(providerConf.DisplayName(), backend)
+        if err != nil {
+            log.Fatalln(errors.Wrap(err, "Failed to register provider"))
+        }
+        fmt.Printf("%s Provider %s Enabled\n", providerConf.Kind(), providerConf.DisplayName())
+    }
+
+    if registry.Len() == 0 {
+        return nil
+    }
+    return registry
+}
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"errors"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -9,3 +11,96 @@ type AuthManager interface {
     RegisterUser(c *gin.Context)
     IsAdmin(c *gin.Context)
 }
+
+// CallbackHandler is implemented by managers that finish logins on a redirect back from an external identity provider.
+// Those managers take the login over GET so the browser can be redirected.
+type CallbackHandler interface {
+    Callback(c *gin.Context)
+}
+
+// PasswordAuthenticator is implemented by managers that can check a username and password without a redirect.
+// Authenticate returns ErrUnknownUser when the backend has no such user and ErrInvalidCredentials when the password is wrong.
+type PasswordAuthenticator interface {
+    Authenticate(username, password string) (isAdmin bool, err error)
+}
+
+// Registrar is implemented by managers that can create users through RegisterUser.
+// RegisterUserReq creates the user from the same fields RegisterUser reads from the request body.
+type Registrar interface {
+    RegistrationEnabled() bool
+    RegisterUserReq(userInfo map[string]interface{}) error
+}
+
+// GroupAssigner is implemented by managers that can add an existing user to a group
+type GroupAssigner interface {
+    AddUserToGroup(username, group string) error
+}
+
+// GroupResolver is implemented by managers that can look up the groups a user currently belongs to
+type GroupResolver interface {
+    UserGroups(username string) ([]string, error)
+}
+
+// AdminResolver is implemented by managers that can look up whether a user is currently an admin
+type AdminResolver interface {
+    IsAdminReq(username string) (bool, error)
+}
+
+// PasswordManager is implemented by managers that store passwords and can change them.
+// Both methods return ErrUnknownUser when the backend has no such user and ErrWeakPassword when the
+// new password fails ValidatePassword. ChangePassword returns ErrInvalidCredentials when the old password is wrong.
+type PasswordManager interface {
+    ChangePassword(username, oldPassword, newPassword string) error
+    ResetPassword(username, newPassword string) error
+}
+
+// EmailResolver is implemented by managers that know a user's email address.
+// It returns ErrUnknownUser when the backend has no such user and an empty address when none is on record.
+type EmailResolver interface {
+    UserEmail(username string) (string, error)
+}
+
+// Provisioner is implemented by managers that admins can create ready to use accounts in
+type Provisioner interface {
+    UserExists(username string) (bool, error)
+    ProvisionUser(user NewUser) error
+}
+
+// NewUser is an account created by an admin rather than registered by the user.
+// Group is optional and added on top of any group every user is put in.
+type NewUser struct {
+    Username string
+    Name     string
+    Email    string
+    Password string
+    Group    string
+}
+
+// UserDirectory is implemented by managers that let admins browse and manage their accounts.
+// Methods taking a username return ErrUnknownUser when the backend has no such user.
+type UserDirectory interface {
+    ListUsers() ([]UserInfo, error)
+    GetUserInfo(username string) (*UserInfo, error)
+    SetUserDisabled(username string, disabled bool) error
+    DeleteUser(username string) error
+}
+
+// UserInfo describes an account for admins. Backend is filled in by a chain with the backend holding the account.
+type UserInfo struct {
+    Username  string   `json:"username"`
+    Name      string   `json:"name,omitempty"`
+    FirstName string   `json:"firstName,omitempty"`
+    LastName  string   `json:"lastName,omitempty"`
+    Email     string   `json:"email,omitempty"`
+    Groups    []string `json:"groups"`
+    Disabled  bool     `json:"disabled"`
+    IsAdmin   bool     `json:"isAdmin"`
+    DN        string   `json:"dn,omitempty"`
+    Backend   string   `json:"backend,omitempty"`
+}
+
+var (
+    ErrUnknownUser        = errors.New("Unknown user")
+    ErrInvalidCredentials = errors.New("Invalid username or password")
+    ErrWeakPassword       = errors.New("Password must be at least 8 characters long and contain at least one letter and one number")
+)
@@ -0,0 +1,409 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"goclone/internal/auth/sessionstore"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// SessionBackendKey is the session key recording which backend of a chain authenticated the user
+const SessionBackendKey = "backend"
+
+type ChainEntry struct {
+	Name    string
+	Manager AuthManager
+}
+
+// ChainManager tries several auth backends in order. Password logins go to the first PasswordAuthenticator
+// that knows the user, logins without credentials go to the first CallbackHandler.
+// IsAdmin and RegisterUser are handled by the backend recorded in the session or named in the request.
+type ChainManager struct {
+	entries []ChainEntry
+}
+
+// chainWithCallback is returned when a backend in the chain finishes logins on a redirect, so the
+// callback route is only registered when something can serve it
+type chainWithCallback struct {
+	*ChainManager
+	callback ChainEntry
+}
+
+func NewChainManager(entries []ChainEntry) (AuthManager, error) {
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("Auth chain is empty")
+	}
+
+	seen := map[string]bool{}
+	var callback *ChainEntry
+	for i, entry := range entries {
+		if seen[entry.Name] {
+			return nil, fmt.Errorf("Auth backend %s is listed twice", entry.Name)
+		}
+		seen[entry.Name] = true
+
+		if _, ok := entry.Manager.(CallbackHandler); ok && callback == nil {
+			callback = &entries[i]
+		}
+	}
+
+	cm := &ChainManager{entries: entries}
+	if callback != nil {
+		return &chainWithCallback{ChainManager: cm, callback: *callback}, nil
+	}
+	return cm, nil
+}
+
+// SessionBackend returns the manager that authenticated the session, looking through a chain to the backend it used
+func SessionBackend(c *gin.Context, manager AuthManager) (AuthManager, bool) {
+	var chain *ChainManager
+	switch m := manager.(type) {
+	case *ChainManager:
+		chain = m
+	case *chainWithCallback:
+		chain = m.ChainManager
+	default:
+		return manager, true
+	}
+
+	name, _ := sessions.Default(c).Get(SessionBackendKey).(string)
+	return chain.backend(name)
+}
+
+func (cm *ChainManager) backend(name string) (AuthManager, bool) {
+	for _, entry := range cm.entries {
+		if entry.Name == name {
+			return entry.Manager, true
+		}
+	}
+	return nil, false
+}
+
+func (cm *ChainManager) Login(c *gin.Context) {
+	var loginInfo map[string]interface{}
+	if err := c.BindJSON(&loginInfo); err != nil {
+		c.String(http.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	username, ok := loginInfo["username"].(string)
+	if !ok {
+		c.String(http.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	password, ok := loginInfo["password"].(string)
+	if !ok {
+		c.String(http.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	name, isAdmin, err := cm.Authenticate(username, password)
+	if err == ErrUnknownUser || err == ErrInvalidCredentials {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	session := sessions.Default(c)
+	sessionstore.Renew(session)
+	session.Set("id", username)
+	session.Set("isAdmin", isAdmin)
+	session.Set(SessionBackendKey, name)
+	if err := session.Save(); err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged in"})
+}
+
+// Authenticate returns the name of the first backend that knows the user, provided the password matches.
+// A wrong password stops the chain so an account cannot be shadowed by the same name further down.
+// A backend that fails with an error is skipped, but the error is reported if no later backend knows the user.
+func (cm *ChainManager) Authenticate(username, password string) (string, bool, error) {
+	var lastErr error
+	for _, entry := range cm.entries {
+		authenticator, ok := entry.Manager.(PasswordAuthenticator)
+		if !ok {
+			continue
+		}
+
+		isAdmin, err := authenticator.Authenticate(username, password)
+		switch err {
+		case nil:
+			return entry.Name, isAdmin, nil
+		case ErrInvalidCredentials:
+			return "", false, err
+		case ErrUnknownUser:
+		default:
+			lastErr = fmt.Errorf("Auth backend %s: %v", entry.Name, err)
+		}
+	}
+
+	if lastErr != nil {
+		return "", false, lastErr
+	}
+	return "", false, ErrUnknownUser
+}
+
+// ChangePassword changes the password in the first backend that knows the user, the same one Authenticate logs them in with
+func (cm *ChainManager) ChangePassword(username, oldPassword, newPassword string) error {
+	return cm.forUser(func(entry ChainEntry) (bool, error) {
+		pm, ok := entry.Manager.(PasswordManager)
+		if !ok {
+			return false, nil
+		}
+		return true, pm.ChangePassword(username, oldPassword, newPassword)
+	})
+}
+
+// ResetPassword resets the password in the first backend that knows the user
+func (cm *ChainManager) ResetPassword(username, newPassword string) error {
+	return cm.forUser(func(entry ChainEntry) (bool, error) {
+		pm, ok := entry.Manager.(PasswordManager)
+		if !ok {
+			return false, nil
+		}
+		return true, pm.ResetPassword(username, newPassword)
+	})
+}
+
+// UserEmail returns the address held by the first backend that knows the user
+func (cm *ChainManager) UserEmail(username string) (string, error) {
+	var email string
+	err := cm.forUser(func(entry ChainEntry) (bool, error) {
+		resolver, ok := entry.Manager.(EmailResolver)
+		if !ok {
+			return false, nil
+		}
+		var err error
+		email, err = resolver.UserEmail(username)
+		return true, err
+	})
+	return email, err
+}
+
+// forUser calls fn on each backend until one that supports the operation knows the user,
+// skipping failing backends the same way Authenticate does
+func (cm *ChainManager) forUser(fn func(entry ChainEntry) (supported bool, err error)) error {
+	var lastErr error
+	for _, entry := range cm.entries {
+		supported, err := fn(entry)
+		if !supported || err == ErrUnknownUser {
+			continue
+		}
+		if err == nil || err == ErrInvalidCredentials || err == ErrWeakPassword {
+			return err
+		}
+		lastErr = fmt.Errorf("Auth backend %s: %v", entry.Name, err)
+	}
+
+	if lastErr != nil {
+		return lastErr
+	}
+	return ErrUnknownUser
+}
+
+// RegisterUser registers with the backend named by the backend query parameter,
+// or the first backend in the chain that accepts registrations
+func (cm *ChainManager) RegisterUser(c *gin.Context) {
+	manager, ok := cm.registrar(c.Query("backend"))
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Registration is not available"})
+		return
+	}
+
+	manager.RegisterUser(c)
+}
+
+// RegistrationEnabled reports whether any backend in the chain accepts registrations
+func (cm *ChainManager) RegistrationEnabled() bool {
+	_, ok := cm.registrar("")
+	return ok
+}
+
+// RegisterUserReq registers with the backend named by the backend field, or the first that accepts registrations
+func (cm *ChainManager) RegisterUserReq(userInfo map[string]interface{}) error {
+	name, _ := userInfo["backend"].(string)
+	manager, ok := cm.registrar(name)
+	if !ok {
+		return fmt.Errorf("Registration is not available")
+	}
+	return manager.(Registrar).RegisterUserReq(userInfo)
+}
+
+// AddUserToGroup adds the user to a group in the first backend that knows them
+func (cm *ChainManager) AddUserToGroup(username, group string) error {
+	return cm.forUser(func(entry ChainEntry) (bool, error) {
+		assigner, ok := entry.Manager.(GroupAssigner)
+		if !ok {
+			return false, nil
+		}
+		return true, assigner.AddUserToGroup(username, group)
+	})
+}
+
+// UserExists reports whether any backend in the chain knows the user, so provisioning never shadows an account
+func (cm *ChainManager) UserExists(username string) (bool, error) {
+	for _, entry := range cm.entries {
+		provisioner, ok := entry.Manager.(Provisioner)
+		if !ok {
+			continue
+		}
+		exists, err := provisioner.UserExists(username)
+		if err != nil {
+			return false, fmt.Errorf("Auth backend %s: %v", entry.Name, err)
+		}
+		if exists {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ProvisionUser creates the account in the first backend that can provision users
+func (cm *ChainManager) ProvisionUser(user NewUser) error {
+	for _, entry := range cm.entries {
+		if provisioner, ok := entry.Manager.(Provisioner); ok {
+			return provisioner.ProvisionUser(user)
+		}
+	}
+	return fmt.Errorf("No auth backend can provision users")
+}
+
+// ListUsers lists the accounts of every backend that keeps a user directory
+func (cm *ChainManager) ListUsers() ([]UserInfo, error) {
+	users := []UserInfo{}
+	for _, entry := range cm.entries {
+		directory, ok := entry.Manager.(UserDirectory)
+		if !ok {
+			continue
+		}
+		list, err := directory.ListUsers()
+		if err != nil {
+			return nil, fmt.Errorf("Auth backend %s: %v", entry.Name, err)
+		}
+		for _, user := range list {
+			user.Backend = entry.Name
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// GetUserInfo describes the user as held by the first backend that knows them
+func (cm *ChainManager) GetUserInfo(username string) (*UserInfo, error) {
+	var info *UserInfo
+	err := cm.forUser(func(entry ChainEntry) (bool, error) {
+		directory, ok := entry.Manager.(UserDirectory)
+		if !ok {
+			return false, nil
+		}
+		var err error
+		info, err = directory.GetUserInfo(username)
+		if err == nil {
+			info.Backend = entry.Name
+		}
+		return true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// SetUserDisabled disables or enables the account in the first backend that knows the user
+func (cm *ChainManager) SetUserDisabled(username string, disabled bool) error {
+	return cm.forUser(func(entry ChainEntry) (bool, error) {
+		directory, ok := entry.Manager.(UserDirectory)
+		if !ok {
+			return false, nil
+		}
+		return true, directory.SetUserDisabled(username, disabled)
+	})
+}
+
+// DeleteUser deletes the account from the first backend that knows the user
+func (cm *ChainManager) DeleteUser(username string) error {
+	return cm.forUser(func(entry ChainEntry) (bool, error) {
+		directory, ok := entry.Manager.(UserDirectory)
+		if !ok {
+			return false, nil
+		}
+		return true, directory.DeleteUser(username)
+	})
+}
+
+// registrar returns the named backend, or the first that accepts registrations when name is empty
+func (cm *ChainManager) registrar(name string) (AuthManager, bool) {
+	if name == "" {
+		for _, entry := range cm.entries {
+			if registrar, ok := entry.Manager.(Registrar); ok && registrar.RegistrationEnabled() {
+				name = entry.Name
+				break
+			}
+		}
+	}
+
+	manager, ok := cm.backend(name)
+	registrar, supported := manager.(Registrar)
+	if !ok || !supported || !registrar.RegistrationEnabled() {
+		return nil, false
+	}
+	return manager, true
+}
+
+// IsAdmin defers to the backend that authenticated the session
+func (cm *ChainManager) IsAdmin(c *gin.Context) {
+	session := sessions.Default(c)
+	name, _ := session.Get(SessionBackendKey).(string)
+	manager, ok := cm.backend(name)
+	if session.Get("id") == nil || !ok {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		c.Abort()
+		return
+	}
+
+	manager.IsAdmin(c)
+}
+
+// Login sends requests carrying a username and password down the chain, anything else starts a redirect login
+func (cc *chainWithCallback) Login(c *gin.Context) {
+	if c.Request.Method == http.MethodGet {
+		cc.callback.Manager.Login(c)
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Bad Request")
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var loginInfo map[string]interface{}
+	json.Unmarshal(body, &loginInfo)
+	if _, ok := loginInfo["username"]; !ok {
+		cc.callback.Manager.Login(c)
+		return
+	}
+
+	cc.ChainManager.Login(c)
+}
+
+// Callback records the redirect backend before handing over, the backend saves the session once the user is known
+func (cc *chainWithCallback) Callback(c *gin.Context) {
+	sessions.Default(c).Set(SessionBackendKey, cc.callback.Name)
+	cc.callback.Manager.(CallbackHandler).Callback(c)
+}
@@ -0,0 +1,156 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goclone/internal/auth"
+	"goclone/internal/auth/local"
+	"goclone/internal/config"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func newLocal(t *testing.T, conf config.LocalProvider) *local.LocalManager {
+	conf.DBPath = filepath.Join(t.TempDir(), "users.db")
+	lm, err := local.NewLocalManager(conf, noop.NewTracerProvider().Tracer("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lm.Close() })
+	return lm
+}
+
+// redirectManager stands in for a backend that logs users in on a redirect
+type redirectManager struct{}
+
+func (redirectManager) Login(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"redirect": "https://idp.example.com"})
+}
+
+func (redirectManager) Callback(c *gin.Context) {
+	session := sessions.Default(c)
+	session.Set("id", "sso-user")
+	session.Set("isAdmin", false)
+	session.Save()
+	c.Status(http.StatusOK)
+}
+
+func (redirectManager) RegisterUser(c *gin.Context) {
+	c.Status(http.StatusNotImplemented)
+}
+
+func (redirectManager) IsAdmin(c *gin.Context) {
+	c.String(http.StatusForbidden, "Forbidden")
+	c.Abort()
+}
+
+func TestChainManager(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	staff := newLocal(t, config.LocalProvider{BootstrapAdmin: "instructor", BootstrapPassword: "password1", DisableRegistration: true})
+	guests := newLocal(t, config.LocalProvider{})
+	if err := guests.CreateUser("guest", "password2", false); err != nil {
+		t.Fatal(err)
+	}
+	// the same name in both backends resolves to the first one in the chain
+	if err := guests.CreateUser("instructor", "password3", false); err != nil {
+		t.Fatal(err)
+	}
+
+	manager, err := auth.NewChainManager([]auth.ChainEntry{
+		{Name: "staff", Manager: staff},
+		{Name: "guests", Manager: guests},
+		{Name: "sso", Manager: redirectManager{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, ok := manager.(auth.CallbackHandler)
+	if !ok {
+		t.Fatal("chain with a redirect backend should handle callbacks")
+	}
+
+	router := gin.New()
+	router.Use(sessions.Sessions("kamino", cookie.NewStore([]byte("kamino"))))
+	router.POST("/login", manager.Login)
+	router.GET("/login/callback", callback.Callback)
+	router.POST("/register", manager.RegisterUser)
+	router.GET("/admin", manager.IsAdmin, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.GET("/whoami", func(c *gin.Context) {
+		c.String(http.StatusOK, "%v", sessions.Default(c).Get(auth.SessionBackendKey))
+	})
+
+	do := func(method, path, body, cookie string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Cookie", cookie)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	login := func(username, password string) (int, string) {
+		w := do(http.MethodPost, "/login", `{"username":"`+username+`","password":"`+password+`"}`, "")
+		return w.Code, w.Header().Get("Set-Cookie")
+	}
+
+	code, instructor := login("instructor", "password1")
+	if code != http.StatusOK {
+		t.Fatalf("instructor login failed: %d", code)
+	}
+	if w := do(http.MethodGet, "/whoami", "", instructor); w.Body.String() != "staff" {
+		t.Errorf("instructor should be authenticated by staff, got %s", w.Body.String())
+	}
+	if w := do(http.MethodGet, "/admin", "", instructor); w.Code != http.StatusOK {
+		t.Errorf("staff admin should pass IsAdmin, got %d", w.Code)
+	}
+
+	if code, _ := login("instructor", "password3"); code != http.StatusUnauthorized {
+		t.Errorf("shadowed guest account should not log in, got %d", code)
+	}
+
+	code, guest := login("guest", "password2")
+	if code != http.StatusOK {
+		t.Fatalf("guest login failed: %d", code)
+	}
+	if w := do(http.MethodGet, "/whoami", "", guest); w.Body.String() != "guests" {
+		t.Errorf("guest should be authenticated by guests, got %s", w.Body.String())
+	}
+	if w := do(http.MethodGet, "/admin", "", guest); w.Code != http.StatusForbidden {
+		t.Errorf("guest should not pass IsAdmin, got %d", w.Code)
+	}
+
+	if code, _ := login("nobody", "password1"); code != http.StatusUnauthorized {
+		t.Errorf("unknown user should be rejected, got %d", code)
+	}
+
+	// logins without credentials start the redirect flow, and its callback is recorded against that backend
+	if w := do(http.MethodPost, "/login", `{}`, ""); !strings.Contains(w.Body.String(), "redirect") {
+		t.Errorf("expected redirect login, got %d %s", w.Code, w.Body.String())
+	}
+	w := do(http.MethodGet, "/login/callback", "", "")
+	if w := do(http.MethodGet, "/whoami", "", w.Header().Get("Set-Cookie")); w.Body.String() != "sso" {
+		t.Errorf("callback should record the sso backend, got %s", w.Body.String())
+	}
+
+	// staff has registration disabled, so new users land in guests
+	if w := do(http.MethodPost, "/register", `{"username":"newbie","password":"password4"}`, ""); w.Code != http.StatusOK {
+		t.Fatalf("registration failed: %d %s", w.Code, w.Body.String())
+	}
+	if exists, _ := guests.UserExists("newbie"); !exists {
+		t.Error("new user should be registered with guests")
+	}
+	if w := do(http.MethodPost, "/register?backend=staff", `{"username":"sneaky","password":"password4"}`, ""); w.Code != http.StatusForbidden {
+		t.Errorf("registration on staff should be refused, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/register?backend=sso", `{"username":"sneaky","password":"password4"}`, ""); w.Code != http.StatusForbidden {
+		t.Errorf("registration on sso should be refused, got %d", w.Code)
+	}
+}
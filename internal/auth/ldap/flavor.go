@@ -0,0 +1,218 @@
+package ldap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const (
+	flavorActiveDirectory = "ad"
+	flavorOpenLDAP        = "openldap"
+	flavor389DS           = "389ds"
+
+	groupSchemaGroupOfNames = "groupOfNames"
+	groupSchemaPosixGroup   = "posixGroup"
+
+	// permanentLock is the pwdAccountLockedTime value the OpenLDAP password policy overlay treats as locked until unlocked
+	permanentLock = "000001010000Z"
+)
+
+// directorySchema is what differs between the directory servers goclone supports
+type directorySchema struct {
+	flavor string
+
+	// userClass finds users, new users get userObjectClasses
+	userClass         string
+	userObjectClasses []string
+	// loginAttribute holds the name users log in with
+	loginAttribute string
+	// rdnAttribute names new user entries, unless the FieldMap has a UserIdentifier
+	rdnAttribute string
+
+	groupClass string
+	// memberAttribute lists a group's members, by DN or, with memberByUID, by login name
+	memberAttribute string
+	memberByUID     bool
+}
+
+func newDirectorySchema(flavor, groupSchema string) (directorySchema, error) {
+	switch strings.ToLower(flavor) {
+	case "", flavorActiveDirectory:
+		if groupSchema != "" && groupSchema != "group" {
+			return directorySchema{}, fmt.Errorf("Active Directory only supports the group schema")
+		}
+		return directorySchema{
+			flavor:            flavorActiveDirectory,
+			userClass:         "user",
+			userObjectClasses: []string{"top", "person", "organizationalPerson", "user"},
+			loginAttribute:    "sAMAccountName",
+			rdnAttribute:      "cn",
+			groupClass:        "group",
+			memberAttribute:   "member",
+		}, nil
+	case flavorOpenLDAP, flavor389DS:
+	default:
+		return directorySchema{}, fmt.Errorf("Unknown LDAP flavor %q", flavor)
+	}
+
+	schema := directorySchema{
+		flavor:            strings.ToLower(flavor),
+		userClass:         "inetOrgPerson",
+		userObjectClasses: []string{"top", "person", "organizationalPerson", "inetOrgPerson"},
+		loginAttribute:    "uid",
+		rdnAttribute:      "uid",
+	}
+	switch groupSchema {
+	case "", groupSchemaGroupOfNames:
+		schema.groupClass = groupSchemaGroupOfNames
+		schema.memberAttribute = "member"
+	case groupSchemaPosixGroup:
+		schema.groupClass = groupSchemaPosixGroup
+		schema.memberAttribute = "memberUid"
+		schema.memberByUID = true
+	default:
+		return directorySchema{}, fmt.Errorf("Unknown LDAP group schema %q", groupSchema)
+	}
+	return schema, nil
+}
+
+func (s directorySchema) activeDirectory() bool {
+	return s.flavor == flavorActiveDirectory
+}
+
+// disabledAttribute is where the flavor keeps whether an account is disabled
+func (s directorySchema) disabledAttribute() string {
+	switch s.flavor {
+	case flavorActiveDirectory:
+		return "userAccountControl"
+	case flavor389DS:
+		return "nsAccountLock"
+	default:
+		return "pwdAccountLockedTime"
+	}
+}
+
+func (s directorySchema) disabled(entry *ldap.Entry) bool {
+	value := entry.GetAttributeValue(s.disabledAttribute())
+	switch s.flavor {
+	case flavorActiveDirectory:
+		flags, _ := strconv.Atoi(value)
+		return flags&uacAccountDisable != 0
+	case flavor389DS:
+		return strings.EqualFold(value, "true")
+	default:
+		return value != ""
+	}
+}
+
+// disable returns the change that disables or enables the account, given its current entry
+func (s directorySchema) disable(entry *ldap.Entry, disabled bool) *ldap.ModifyRequest {
+	req := ldap.NewModifyRequest(entry.DN, nil)
+	switch s.flavor {
+	case flavorActiveDirectory:
+		flags, err := strconv.Atoi(entry.GetAttributeValue("userAccountControl"))
+		if err != nil {
+			flags = uacNormalAccount
+		}
+		if disabled {
+			flags |= uacAccountDisable
+		} else {
+			flags &^= uacAccountDisable
+		}
+		req.Replace("userAccountControl", []string{strconv.Itoa(flags)})
+	case flavor389DS:
+		if disabled {
+			req.Replace("nsAccountLock", []string{"TRUE"})
+		} else {
+			req.Replace("nsAccountLock", []string{})
+		}
+	default:
+		// needs the ppolicy overlay, which also refuses binds while the attribute is set
+		if disabled {
+			req.Replace("pwdAccountLockedTime", []string{permanentLock})
+		} else {
+			req.Replace("pwdAccountLockedTime", []string{})
+		}
+	}
+	return req
+}
+
+// userFilter matches the user logging in as username
+func (s directorySchema) userFilter(username string) string {
+	return fmt.Sprintf("(&(objectClass=%s)(%s=%s))", s.userClass, s.loginAttribute, ldap.EscapeFilter(username))
+}
+
+// memberFilter matches the groups listing member, a DN or with memberByUID a login name
+func (s directorySchema) memberFilter(member string) string {
+	return fmt.Sprintf("(&(objectClass=%s)(%s=%s))", s.groupClass, s.memberAttribute, ldap.EscapeFilter(member))
+}
+
+// member is the value that puts the user in a group
+func (s directorySchema) member(entry *ldap.Entry) string {
+	if s.memberByUID {
+		return entry.GetAttributeValue(s.loginAttribute)
+	}
+	return entry.DN
+}
+
+// setPassword sets the password with unicodePwd on Active Directory and with the Password Modify
+// extended operation elsewhere, which leaves hashing to the server's password policy
+func (cl *LdapClient) setPassword(conn ldap.Client, userdn, password string) error {
+	if !cl.schema.activeDirectory() {
+		_, err := conn.PasswordModify(ldap.NewPasswordModifyRequest(userdn, "", password))
+		return err
+	}
+
+	// requires ldaps connection
+
+	utf16 := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	// The password needs to be enclosed in quotes
+	pwdEncoded, err := utf16.NewEncoder().String(fmt.Sprintf("\"%s\"", password))
+	if err != nil {
+		return err
+	}
+
+	// add additional control to request if supported
+	controlTypes, err := getSupportedControl(conn)
+	if err != nil {
+		return err
+	}
+	control := []ldap.Control{}
+	for _, oid := range controlTypes {
+		if oid == controlTypeLdapServerPolicyHints || oid == controlTypeLdapServerPolicyHintsDeprecated {
+			control = append(control, &ldapControlServerPolicyHints{oid: oid})
+			break
+		}
+	}
+
+	passReq := ldap.NewModifyRequest(userdn, control)
+	passReq.Replace("unicodePwd", []string{pwdEncoded})
+	return conn.Modify(passReq)
+}
+
+// removeMemberships takes the user out of every group before the entry is deleted. Active Directory
+// does that by itself; groups that would be left without members, which groupOfNames forbids, keep the user.
+func (cl *LdapClient) removeMemberships(conn ldap.Client, entry *ldap.Entry) error {
+	if cl.schema.activeDirectory() {
+		return nil
+	}
+
+	member := cl.schema.member(entry)
+	groups, err := cl.groupsWithMember(conn, member)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		req := ldap.NewModifyRequest(group, nil)
+		req.Delete(cl.schema.memberAttribute, []string{member})
+		err := conn.Modify(req)
+		if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultObjectClassViolation) {
+			return fmt.Errorf("Failed to remove user from group %s: %v", group, err)
+		}
+	}
+	return nil
+}
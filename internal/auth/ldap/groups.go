@@ -0,0 +1,272 @@
+package ldap
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	// matchingRuleInChain makes Active Directory follow group nesting when matching member
+	matchingRuleInChain = "1.2.840.113556.1.4.1941"
+
+	nestedGroupsNone      = "none"
+	nestedGroupsInChain   = "in_chain"
+	nestedGroupsRecursive = "recursive"
+
+	defaultMaxGroupDepth = 10
+	defaultGroupCacheTTL = time.Minute
+)
+
+type cachedGroups struct {
+	groups  []string
+	expires time.Time
+}
+
+// groupCache keeps resolved groups per user, since resolving nested groups can take several searches
+type groupCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedGroups
+}
+
+func newGroupCache(ttl time.Duration) *groupCache {
+	if ttl <= 0 {
+		ttl = defaultGroupCacheTTL
+	}
+	return &groupCache{ttl: ttl, entries: map[string]cachedGroups{}}
+}
+
+func (gc *groupCache) get(username string) ([]string, bool) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	cached, ok := gc.entries[strings.ToLower(username)]
+	if !ok || time.Now().After(cached.expires) {
+		return nil, false
+	}
+	return cached.groups, true
+}
+
+func (gc *groupCache) put(username string, groups []string) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.entries[strings.ToLower(username)] = cachedGroups{groups: groups, expires: time.Now().Add(gc.ttl)}
+}
+
+func (gc *groupCache) invalidate(username string) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	delete(gc.entries, strings.ToLower(username))
+}
+
+// UserGroups returns the DNs of every group the user is in, directly or through nested groups as
+// configured by NestedGroups. Results are cached for GroupCacheTTL, so it is cheap enough to call on
+// every request.
+func (cl *LdapClient) UserGroups(username string) ([]string, error) {
+	if groups, ok := cl.groups.get(username); ok {
+		return groups, nil
+	}
+
+	var groups []string
+	err := cl.withConn(func(conn ldap.Client) (err error) {
+		groups, err = cl.resolveGroups(conn, username)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	cl.groups.put(username, groups)
+	return groups, nil
+}
+
+// IsAdminReq reports whether the user is in AdminGroupDN, also through nested groups
+func (cl *LdapClient) IsAdminReq(username string) (bool, error) {
+	if cl.config.AdminGroupDN == "" {
+		return false, nil
+	}
+
+	groups, err := cl.UserGroups(username)
+	if err != nil {
+		return false, err
+	}
+	for _, group := range groups {
+		if strings.EqualFold(group, cl.config.AdminGroupDN) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (cl *LdapClient) resolveGroups(conn ldap.Client, username string) ([]string, error) {
+	memberOf := cl.fields().GroupMembership
+
+	attributes := []string{cl.schema.loginAttribute}
+	if memberOf != "" {
+		attributes = append(attributes, memberOf)
+	}
+	user, err := cl.findUser(conn, username, attributes)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	var direct []string
+	if memberOf != "" {
+		direct = user.GetAttributeValues(memberOf)
+	} else {
+		direct, err = cl.groupsWithMember(conn, cl.schema.member(user))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nested := cl.config.NestedGroups
+	if nested == "" && !cl.schema.activeDirectory() {
+		nested = nestedGroupsRecursive
+	}
+	switch nested {
+	case nestedGroupsNone:
+		return direct, nil
+	case nestedGroupsRecursive:
+		return cl.expandGroups(conn, direct)
+	case "", nestedGroupsInChain:
+		return cl.groupsInChain(conn, user.DN)
+	default:
+		return nil, fmt.Errorf("Unknown nested_groups setting %q", cl.config.NestedGroups)
+	}
+}
+
+// groupsInChain lets Active Directory find every group that has the user as a member at any depth
+func (cl *LdapClient) groupsInChain(conn ldap.Client, userDN string) ([]string, error) {
+	req := ldap.NewSearchRequest(
+		cl.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf("(member:%s:=%s)", matchingRuleInChain, ldap.EscapeFilter(userDN)),
+		[]string{"dn"},
+		nil,
+	)
+	res, err := conn.SearchWithPaging(req, 500)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to search for nested groups: %v", err)
+	}
+
+	groups := make([]string, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		groups = append(groups, entry.DN)
+	}
+	return groups, nil
+}
+
+// groupsWithMember searches for the groups listing member, for servers without a memberOf attribute
+func (cl *LdapClient) groupsWithMember(conn ldap.Client, member string) ([]string, error) {
+	req := ldap.NewSearchRequest(
+		cl.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		cl.schema.memberFilter(member),
+		[]string{"dn"},
+		nil,
+	)
+	res, err := conn.SearchWithPaging(req, 500)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to search for groups: %v", err)
+	}
+
+	groups := make([]string, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		groups = append(groups, entry.DN)
+	}
+	return groups, nil
+}
+
+// groupMembers maps each member, lowercased, to the groups listing it, so listing users takes a single search
+func (cl *LdapClient) groupMembers(conn ldap.Client) (map[string][]string, error) {
+	req := ldap.NewSearchRequest(
+		cl.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf("(objectClass=%s)", cl.schema.groupClass),
+		[]string{cl.schema.memberAttribute},
+		nil,
+	)
+	res, err := conn.SearchWithPaging(req, 500)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to search for groups: %v", err)
+	}
+
+	members := map[string][]string{}
+	for _, entry := range res.Entries {
+		for _, member := range entry.GetAttributeValues(cl.schema.memberAttribute) {
+			members[strings.ToLower(member)] = append(members[strings.ToLower(member)], entry.DN)
+		}
+	}
+	return members, nil
+}
+
+// parentGroups returns the groups a group is a member of, from its memberOf attribute or by searching.
+// posixGroup lists members by username, so those groups do not nest.
+func (cl *LdapClient) parentGroups(conn ldap.Client, dn string) ([]string, error) {
+	memberOf := cl.fields().GroupMembership
+	if memberOf == "" {
+		if cl.schema.memberByUID {
+			return nil, nil
+		}
+		return cl.groupsWithMember(conn, dn)
+	}
+
+	req := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)",
+		[]string{memberOf},
+		nil,
+	)
+	res, err := conn.Search(req)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read group %s: %v", dn, err)
+	}
+
+	var parents []string
+	for _, entry := range res.Entries {
+		parents = append(parents, entry.GetAttributeValues(memberOf)...)
+	}
+	return parents, nil
+}
+
+// expandGroups follows the parents of each group level by level, for servers without
+// LDAP_MATCHING_RULE_IN_CHAIN. Cycles are skipped and the depth is bounded by MaxGroupDepth.
+func (cl *LdapClient) expandGroups(conn ldap.Client, direct []string) ([]string, error) {
+	maxDepth := cl.config.MaxGroupDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxGroupDepth
+	}
+
+	seen := map[string]bool{}
+	var groups []string
+	level := direct
+	for depth := 0; len(level) > 0 && depth <= maxDepth; depth++ {
+		var next []string
+		for _, dn := range level {
+			if seen[strings.ToLower(dn)] {
+				continue
+			}
+			seen[strings.ToLower(dn)] = true
+			groups = append(groups, dn)
+
+			parents, err := cl.parentGroups(conn, dn)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, parents...)
+		}
+		level = next
+	}
+	return groups, nil
+}
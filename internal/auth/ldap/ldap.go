@@ -4,11 +4,13 @@ import (
 	"crypto/tls"
 	"fmt"
 	"goclone/internal/api/handlers"
+	"goclone/internal/auth"
+	"goclone/internal/auth/sessionstore"
 	"goclone/internal/config"
+	"net"
 	"net/http"
-	"regexp"
 	"strings"
-	uni "unicode"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -16,44 +18,56 @@ import (
 	"github.com/go-ldap/ldap/v3"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
-	"golang.org/x/text/encoding/unicode"
 )
 
 const (
+	// userAccountControl flags
+	uacAccountDisable = 0x2
+	uacNormalAccount  = 0x200
+
 	controlTypeLdapServerPolicyHints           = "1.2.840.113556.1.4.2239"
 	controlTypeLdapServerPolicyHintsDeprecated = "1.2.840.113556.1.4.2066"
 )
 
+// ErrUserNotFound is returned by GetUserDN when no user matches
+var ErrUserNotFound = fmt.Errorf("User not found")
+
+// LdapClient is safe for concurrent use. Searches and changes go through a pool of connections bound
+// as the service account, while users are authenticated on connections of their own.
 type LdapClient struct {
-	ldap   ldap.Client
+	pool   *connPool
 	config config.LdapProvider
     tracer trace.Tracer
+	groups *groupCache
+	schema directorySchema
 }
 
 type ldapControlServerPolicyHints struct {
 	oid string
 }
 
-func NewLdapManager(config config.LdapProvider, tracer trace.Tracer) *LdapClient {
-    return &LdapClient{config: config, tracer: tracer}
-}
-
-func (cl *LdapClient) Connect() error {
-	conn, err := cl.connect()
+// NewLdapManager checks the flavor settings, Active Directory unless Flavor says otherwise
+func NewLdapManager(config config.LdapProvider, tracer trace.Tracer) (*LdapClient, error) {
+	schema, err := newDirectorySchema(config.Flavor, config.GroupSchema)
 	if err != nil {
-		return fmt.Errorf("Failed to connect to LDAP server: %v", err)
+		return nil, err
 	}
-
-	if cl.config.BindUser != "" {
-		err = conn.Bind(cl.config.BindUser, cl.config.BindPassword)
-		if err != nil {
-			return fmt.Errorf("Failed to bind to LDAP server: %v", err)
-		}
+	if config.NestedGroups == nestedGroupsInChain && !schema.activeDirectory() {
+		return nil, fmt.Errorf("nested_groups in_chain needs Active Directory, use recursive")
 	}
 
-	cl.ldap = conn
+	cl := &LdapClient{config: config, tracer: tracer, groups: newGroupCache(config.GroupCacheTTL), schema: schema}
 
-	return nil
+	size := config.PoolSize
+	if size <= 0 {
+		size = defaultPoolSize
+	}
+	checkAfter := config.HealthCheckInterval
+	if checkAfter <= 0 {
+		checkAfter = defaultHealthCheckInterval
+	}
+	cl.pool = newConnPool(cl.serviceConn, size, cl.requestTimeout(), checkAfter)
+	return cl, nil
 }
 
 func (cl *LdapClient) Login(c *gin.Context) {
@@ -80,35 +94,20 @@ func (cl *LdapClient) Login(c *gin.Context) {
         return
     }
 
-    err := cl.Connect()
-    if err != nil {
-        c.String(http.StatusInternalServerError, "Internal Server Error", err)
+    isAdmin, err := cl.Authenticate(username, password)
+    if err == auth.ErrUnknownUser || err == auth.ErrInvalidCredentials {
+        c.String(http.StatusUnauthorized, "Unauthorized")
         return
     }
-
-    valid, err := cl.LoginReq(username, password)
     if err != nil {
+        fmt.Println(err)
         c.String(http.StatusInternalServerError, "Internal Server Error")
         return
     }
 
-    if !valid {
-        c.String(http.StatusUnauthorized, "Unauthorized")
-        return
-    }
-
     session := sessions.Default(c)
+    sessionstore.Renew(session)
     session.Set("id", username)
-
-    isAdmin := false
-    if cl.config.AdminGroupDN != "" {
-        isAdmin, err = cl.IsAdminReq(username)
-        if err != nil {
-            fmt.Println(err)
-            c.String(http.StatusInternalServerError, "Internal Server Error")
-            return
-        }
-    }
     session.Set("isAdmin", isAdmin)
 
     if err := session.Save(); err != nil {
@@ -119,17 +118,67 @@ func (cl *LdapClient) Login(c *gin.Context) {
     c.JSON(http.StatusOK, gin.H{"message": "Logged in"})
 }
 
+// Authenticate binds as the user and looks up admin group membership
+func (cl *LdapClient) Authenticate(username, password string) (bool, error) {
+    exists, err := cl.UserExists(username)
+    if err != nil {
+        return false, err
+    }
+    if !exists {
+        return false, auth.ErrUnknownUser
+    }
+
+    valid, err := cl.LoginReq(username, password)
+    if err != nil {
+        return false, err
+    }
+
+    if !valid {
+        return false, auth.ErrInvalidCredentials
+    }
+
+    // group changes apply from the next login at the latest
+    cl.groups.invalidate(username)
+    return cl.IsAdminReq(username)
+}
+
+func (cl *LdapClient) RegistrationEnabled() bool {
+    return cl.config.UserGroupDN != "" && cl.config.UserOU != ""
+}
+
 func (cl *LdapClient) LoginReq(username, password string) (bool, error) {
 	userdn, err := cl.GetUserDN(username)
+	if err == ErrUserNotFound {
+		return false, nil
+	}
 	if err != nil {
 		return false, fmt.Errorf("Failed to get user DN: %v", err)
 	}
 
-	err = cl.ldap.Bind(userdn, password)
-	if err != nil {
+	return cl.checkPassword(userdn, password)
+}
+
+// checkPassword binds as the user on a connection of its own, closed right after, so user binds never
+// touch the pooled service connections
+func (cl *LdapClient) checkPassword(userdn, password string) (bool, error) {
+	// an empty password would be an unauthenticated bind, which servers accept for any DN
+	if password == "" {
 		return false, nil
 	}
 
+	conn, err := cl.connect()
+	if err != nil {
+		return false, fmt.Errorf("Failed to connect to LDAP server: %v", err)
+	}
+	defer conn.Close()
+
+	err = conn.Bind(userdn, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Failed to bind as user: %v", err)
+	}
 	return true, nil
 }
 
@@ -155,13 +204,8 @@ func (cl *LdapClient) RegisterUserReq(userInfo map[string]interface{}) error {
         return fmt.Errorf("Username not provided or invalid")
     }
 
-    if len(username) < 1 || len(username) > 20 {
-        return fmt.Errorf("Username must be between 1 and 20 characters")
-    }
-
-    regex := regexp.MustCompile("^[a-zA-Z0-9_-]*$")
-    if !regex.MatchString(username) {
-        return fmt.Errorf("Username must only contain letters, numbers, underscores, and hyphens")
+    if err := auth.ValidateUsername(username); err != nil {
+        return err
     }
 
     password, ok := userInfo["password"].(string)
@@ -169,10 +213,11 @@ func (cl *LdapClient) RegisterUserReq(userInfo map[string]interface{}) error {
         return fmt.Errorf("Password not provided or invalid")
     }
 
-    valid := validatePassword(password)
+    valid := auth.ValidatePassword(password)
     if !valid {
-        return fmt.Errorf("Password must be at least 8 characters long and contain at least one letter and one number")
+        return auth.ErrWeakPassword
     }
+
 	dn, err := cl.CreateUser(username)
 	if err != nil {
 		return fmt.Errorf("Failed to create user: %v", err)
@@ -183,7 +228,7 @@ func (cl *LdapClient) RegisterUserReq(userInfo map[string]interface{}) error {
 		return fmt.Errorf("Failed to set password: %v", err)
 	}
 
-	err = cl.AddToGroup(dn, cl.config.UserGroupDN)
+	err = cl.AddToGroup(dn, username, cl.config.UserGroupDN)
 	if err != nil {
 		return fmt.Errorf("Failed to add user to group: %v", err)
 	}
@@ -197,43 +242,79 @@ func (cl *LdapClient) RegisterUserReq(userInfo map[string]interface{}) error {
 }
 
 func (cl *LdapClient) connect() (ldap.Client, error) {
-	var dialOpts []ldap.DialOpt
+	timeout := cl.config.DialTimeout
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+
+	dialOpts := []ldap.DialOpt{ldap.DialWithDialer(&net.Dialer{Timeout: timeout})}
 	if strings.HasPrefix(cl.config.URL, "ldaps://") {
 		dialOpts = append(dialOpts, ldap.DialWithTLSConfig(&tls.Config{InsecureSkipVerify: cl.config.SkipTLSVerify, MinVersion: tls.VersionTLS12}))
 	} else {
         return nil, fmt.Errorf("Only ldaps:// is supported")
     }
-	return ldap.DialURL(cl.config.URL, dialOpts...)
+	conn, err := ldap.DialURL(cl.config.URL, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(cl.requestTimeout())
+	return conn, nil
 }
 
-func (cl *LdapClient) CreateUser(name string) (string, error) {
-	var attributes []ldap.Attribute
+func (cl *LdapClient) requestTimeout() time.Duration {
+	if cl.config.RequestTimeout <= 0 {
+		return defaultRequestTimeout
+	}
+	return cl.config.RequestTimeout
+}
+
+// CreateUser adds a user named name in UserOU, with any extra attributes such as their email address.
+// Active Directory creates the account disabled until EnableAccount.
+func (cl *LdapClient) CreateUser(name string, extra ...ldap.Attribute) (string, error) {
+	attributes := append([]ldap.Attribute{}, extra...)
+	has := func(attr string) bool {
+		for _, attribute := range attributes {
+			if strings.EqualFold(attribute.Type, attr) {
+				return true
+			}
+		}
+		return false
+	}
 
 	attributes = append(attributes, ldap.Attribute{
 		Type: "objectClass",
-		Vals: []string{"top", "person", "organizationalPerson", "user"},
+		Vals: cl.schema.userObjectClasses,
 	})
 	attributes = append(attributes, ldap.Attribute{
-		Type: "sAMAccountName",
+		Type: cl.schema.loginAttribute,
 		Vals: []string{name},
 	})
 	attributes = append(attributes, ldap.Attribute{
 		Type: "cn",
 		Vals: []string{name},
 	})
+	// person requires a surname outside of Active Directory
+	if !cl.schema.activeDirectory() && !has("sn") {
+		attributes = append(attributes, ldap.Attribute{
+			Type: "sn",
+			Vals: []string{name},
+		})
+	}
 	attributes = append(attributes, ldap.Attribute{
 		Type: "Description",
 		Vals: []string{"Registered by Goclone"},
 	})
 
-	dn := fmt.Sprintf("%s=%s,%s", cl.config.FieldMap.UserIdentifier, name, cl.config.UserOU)
+	dn := fmt.Sprintf("%s=%s,%s", cl.fields().UserIdentifier, ldap.EscapeDN(name), cl.config.UserOU)
 
 	req := ldap.AddRequest{
 		DN:         dn,
 		Attributes: attributes,
 	}
 
-	err := cl.ldap.Add(&req)
+	err := cl.withConnOnce(func(conn ldap.Client) error {
+		return conn.Add(&req)
+	})
 	if err != nil {
 		return "", fmt.Errorf("Failed to add user: %v", err)
 	}
@@ -241,10 +322,95 @@ func (cl *LdapClient) CreateUser(name string) (string, error) {
 	return dn, nil
 }
 
-func (cl *LdapClient) AddToGroup(userdn, groupdn string) error {
+// AddToGroup adds the user to the group's member attribute, by DN or by username for posixGroup
+func (cl *LdapClient) AddToGroup(userdn, username, groupdn string) error {
+	member := userdn
+	if cl.schema.memberByUID {
+		member = username
+	}
 	req := ldap.NewModifyRequest(groupdn, nil)
-	req.Add("member", []string{userdn})
-	return cl.ldap.Modify(req)
+	req.Add(cl.schema.memberAttribute, []string{member})
+	return cl.withConnOnce(func(conn ldap.Client) error {
+		return conn.Modify(req)
+	})
+}
+
+// ProvisionUser creates an enabled account the way a registration does, with the user's name and email
+// address filled in and optionally an extra group
+func (cl *LdapClient) ProvisionUser(user auth.NewUser) error {
+	if err := auth.ValidateUsername(user.Username); err != nil {
+		return err
+	}
+	if !auth.ValidatePassword(user.Password) {
+		return auth.ErrWeakPassword
+	}
+
+	dn, err := cl.CreateUser(user.Username, cl.userAttributes(user)...)
+	if err != nil {
+		return fmt.Errorf("Failed to create user: %v", err)
+	}
+
+	err = cl.SetPassword(dn, user.Password)
+	if err != nil {
+		return fmt.Errorf("Failed to set password: %v", err)
+	}
+
+	for _, group := range []string{cl.config.UserGroupDN, user.Group} {
+		if group == "" {
+			continue
+		}
+		err = cl.AddToGroup(dn, user.Username, group)
+		if err != nil {
+			return fmt.Errorf("Failed to add user to group %s: %v", group, err)
+		}
+	}
+
+	err = cl.EnableAccount(dn)
+	if err != nil {
+		return fmt.Errorf("Failed to enable account: %v", err)
+	}
+
+	return nil
+}
+
+// userAttributes maps a provisioned user's details onto the attributes named in the FieldMap
+func (cl *LdapClient) userAttributes(user auth.NewUser) []ldap.Attribute {
+	var attributes []ldap.Attribute
+	add := func(attr, value string) {
+		if attr != "" && value != "" {
+			attributes = append(attributes, ldap.Attribute{Type: attr, Vals: []string{value}})
+		}
+	}
+
+	add("displayName", user.Name)
+	mail := cl.config.FieldMap.Email
+	if mail == "" {
+		mail = "mail"
+	}
+	add(mail, user.Email)
+
+	if i := strings.LastIndex(user.Name, " "); i > 0 {
+		add(cl.config.FieldMap.FirstName, user.Name[:i])
+		add(cl.config.FieldMap.LastName, user.Name[i+1:])
+	}
+	return attributes
+}
+
+// AddUserToGroup adds the user to the group DN
+func (cl *LdapClient) AddUserToGroup(username, groupdn string) error {
+	userdn, err := cl.GetUserDN(username)
+	if err == ErrUserNotFound {
+		return auth.ErrUnknownUser
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to get user DN: %v", err)
+	}
+
+	if err := cl.AddToGroup(userdn, username, groupdn); err != nil {
+		return fmt.Errorf("Failed to add user to group: %v", err)
+	}
+	cl.groups.invalidate(username)
+	return nil
 }
 
 func getSupportedControl(conn ldap.Client) ([]string, error) {
@@ -279,41 +445,29 @@ func (c *ldapControlServerPolicyHints) String() string {
 }
 
 func (cl *LdapClient) SetPassword(userdn string, password string) error {
-	// requires ldaps connection
-
-	utf16 := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
-	// The password needs to be enclosed in quotes
-	pwdEncoded, err := utf16.NewEncoder().String(fmt.Sprintf("\"%s\"", password))
-	if err != nil {
-		return err
-	}
-
-	// add additional control to request if supported
-	controlTypes, err := getSupportedControl(cl.ldap)
-	if err != nil {
-		return err
-	}
-	control := []ldap.Control{}
-	for _, oid := range controlTypes {
-		if oid == controlTypeLdapServerPolicyHints || oid == controlTypeLdapServerPolicyHintsDeprecated {
-			control = append(control, &ldapControlServerPolicyHints{oid: oid})
-			break
-		}
-	}
-
-	passReq := ldap.NewModifyRequest(userdn, control)
-	passReq.Replace("unicodePwd", []string{pwdEncoded})
-	return cl.ldap.Modify(passReq)
+	return cl.withConnOnce(func(conn ldap.Client) error {
+		return cl.setPassword(conn, userdn, password)
+	})
 }
 
+// EnableAccount clears the disabled flag Active Directory gives new accounts, other flavors create them enabled
 func (cl *LdapClient) EnableAccount(userdn string) error {
+	if !cl.schema.activeDirectory() {
+		return nil
+	}
 	req := ldap.NewModifyRequest(userdn, nil)
 	req.Replace("userAccountControl", []string{"512"})
-	return cl.ldap.Modify(req)
+	return cl.withConnOnce(func(conn ldap.Client) error {
+		return conn.Modify(req)
+	})
 }
 
 func (cl *LdapClient) SearchEntry(req *ldap.SearchRequest) (*ldap.Entry, error) {
-	res, err := cl.ldap.Search(req)
+	var res *ldap.SearchResult
+	err := cl.withConn(func(conn ldap.Client) (err error) {
+		res, err = conn.Search(req)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("Failed to search entry: %v", err)
 	}
@@ -323,18 +477,17 @@ func (cl *LdapClient) SearchEntry(req *ldap.SearchRequest) (*ldap.Entry, error)
 	return res.Entries[0], nil
 }
 
+// Disconnect closes the pooled connections
 func (cl *LdapClient) Disconnect() error {
-	if cl.ldap == nil {
-		return nil
-	}
-	return cl.ldap.Close()
+	cl.pool.close()
+	return nil
 }
 
 func (cl *LdapClient) GetUserDN(username string) (string, error) {
 	req := ldap.NewSearchRequest(
 		cl.config.BaseDN,
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		fmt.Sprintf("(&(objectClass=user)(%s=%s))", "sAMAccountName", username),
+		cl.schema.userFilter(username),
 		[]string{"dn"},
 		nil,
 	)
@@ -345,7 +498,7 @@ func (cl *LdapClient) GetUserDN(username string) (string, error) {
 	}
 
 	if entry == nil {
-		return "", fmt.Errorf("User not found")
+		return "", ErrUserNotFound
 	}
 
 	return entry.DN, nil
@@ -382,93 +535,297 @@ func (cl *LdapClient) IsAdmin(c *gin.Context) {
     c.Next()
 }
 
-func (cl *LdapClient) IsAdminReq(username string) (bool, error) {
-    // Get Users in Admin DN
-    req := ldap.NewSearchRequest(
-        cl.config.AdminGroupDN,
-        ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-        "(objectClass=group)",
-        []string{"member"},
-        nil,
-    )
-
-    // Get Username DN
-    userDN := ldap.NewSearchRequest(
-        cl.config.BaseDN,
-        ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-        fmt.Sprintf("(&(objectClass=user)(%s=%s))", cl.config.FieldMap.UserIdentifier, username),
-        []string{"dn"},
-        nil,
-    )
+// UserEmail reads the attribute named by FieldMap.Email, mail by default
+func (cl *LdapClient) UserEmail(username string) (string, error) {
+	mail := cl.config.FieldMap.Email
+	if mail == "" {
+		mail = "mail"
+	}
+
+	req := ldap.NewSearchRequest(
+		cl.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		cl.schema.userFilter(username),
+		[]string{mail},
+		nil,
+	)
 
 	entry, err := cl.SearchEntry(req)
 	if err != nil {
-		return false, fmt.Errorf("Failed to search for user: %v", err)
+		return "", fmt.Errorf("Failed to search for user: %v", err)
 	}
+	if entry == nil {
+		return "", auth.ErrUnknownUser
+	}
+	return entry.GetAttributeValue(mail), nil
+}
 
-    userEntry, err := cl.SearchEntry(userDN)
-    if err != nil {
-        return false, fmt.Errorf("Failed to search for user: %v", err)
-    }
+// ChangePassword checks the old password with a bind as the user on a separate connection,
+// then sets the new one through the service account so the password policy is enforced
+func (cl *LdapClient) ChangePassword(username, oldPassword, newPassword string) error {
+	if !auth.ValidatePassword(newPassword) {
+		return auth.ErrWeakPassword
+	}
+
+	userdn, err := cl.GetUserDN(username)
+	if err == ErrUserNotFound {
+		return auth.ErrUnknownUser
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to get user DN: %v", err)
+	}
 
-	if entry == nil || userEntry == nil {
-		return false, fmt.Errorf("Error finding user or group")
+	valid, err := cl.checkPassword(userdn, oldPassword)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return auth.ErrInvalidCredentials
 	}
 
-    for _, member := range entry.GetAttributeValues("member") {
-        if member == userEntry.DN {
-            return true, nil
-        }
-    }
+	if err := cl.SetPassword(userdn, newPassword); err != nil {
+		return fmt.Errorf("Failed to set password: %v", err)
+	}
+	return nil
+}
+
+// ResetPassword sets a new password without knowing the current one, for admins and reset links
+func (cl *LdapClient) ResetPassword(username, newPassword string) error {
+	if !auth.ValidatePassword(newPassword) {
+		return auth.ErrWeakPassword
+	}
+
+	userdn, err := cl.GetUserDN(username)
+	if err == ErrUserNotFound {
+		return auth.ErrUnknownUser
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to get user DN: %v", err)
+	}
 
-	return false, nil
+	if err := cl.SetPassword(userdn, newPassword); err != nil {
+		return fmt.Errorf("Failed to set password: %v", err)
+	}
+	return nil
+}
+
+// serviceConn opens a connection bound as the service account, the pool dials its connections with it
+func (cl *LdapClient) serviceConn() (ldap.Client, error) {
+	conn, err := cl.connect()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to LDAP server: %v", err)
+	}
+
+	if cl.config.BindUser != "" {
+		err = conn.Bind(cl.config.BindUser, cl.config.BindPassword)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Failed to bind to LDAP server: %v", err)
+		}
+	}
+	return conn, nil
 }
 
 func (cl *LdapClient) UserExists(username string) (bool, error) {
+	var entry *ldap.Entry
+	err := cl.withConn(func(conn ldap.Client) (err error) {
+		entry, err = cl.findUser(conn, username, []string{"dn"})
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return entry != nil, nil
+}
+
+// DeleteUser removes the user's entry from the directory
+func (cl *LdapClient) DeleteUser(username string) error {
+	return cl.withConnOnce(func(conn ldap.Client) error {
+		entry, err := cl.findUser(conn, username, []string{cl.schema.loginAttribute})
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return auth.ErrUnknownUser
+		}
+
+		if err := cl.removeMemberships(conn, entry); err != nil {
+			return err
+		}
+		err = conn.Del(ldap.NewDelRequest(entry.DN, nil))
+		if err != nil {
+			return fmt.Errorf("Failed to delete user: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// ListUsers returns the users in UserOU, or under BaseDN when no UserOU is set, with the attributes from the FieldMap
+func (cl *LdapClient) ListUsers() ([]auth.UserInfo, error) {
+	base := cl.config.UserOU
+	if base == "" {
+		base = cl.config.BaseDN
+	}
+
 	req := ldap.NewSearchRequest(
-		cl.config.BaseDN,
+		base,
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		fmt.Sprintf("(&(objectClass=user)(%s=%s))", "sAMAccountName", username),
-		[]string{"dn"},
+		fmt.Sprintf("(objectClass=%s)", cl.schema.userClass),
+		cl.userInfoAttributes(),
 		nil,
 	)
 
-	entry, err := cl.SearchEntry(req)
+	var res *ldap.SearchResult
+	var members map[string][]string
+	err := cl.withConn(func(conn ldap.Client) (err error) {
+		res, err = conn.SearchWithPaging(req, 500)
+		if err != nil {
+			return fmt.Errorf("Failed to search for users: %v", err)
+		}
+
+		// without a memberOf attribute the groups list their members instead
+		if cl.fields().GroupMembership == "" {
+			members, err = cl.groupMembers(conn)
+		}
+		return err
+	})
 	if err != nil {
-		return false, fmt.Errorf("Failed to search for user: %v", err)
+		return nil, err
 	}
 
-	return entry != nil, nil
+	users := make([]auth.UserInfo, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		users = append(users, cl.userInfo(entry, members[strings.ToLower(cl.schema.member(entry))]))
+	}
+	return users, nil
 }
 
-func (cl *LdapClient) DeleteUser(username string) error {
-	userdn, err := cl.GetUserDN(username)
+func (cl *LdapClient) GetUserInfo(username string) (*auth.UserInfo, error) {
+	var entry *ldap.Entry
+	var groups []string
+	err := cl.withConn(func(conn ldap.Client) (err error) {
+		entry, err = cl.findUser(conn, username, cl.userInfoAttributes())
+		if err != nil || entry == nil {
+			return err
+		}
+		if cl.fields().GroupMembership == "" {
+			groups, err = cl.groupsWithMember(conn, cl.schema.member(entry))
+		}
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("Failed to get user DN: %v", err)
+		return nil, err
+	}
+	if entry == nil {
+		return nil, auth.ErrUnknownUser
 	}
 
-	req := ldap.NewDelRequest(userdn, nil)
-	err = cl.ldap.Del(req)
+	info := cl.userInfo(entry, groups)
+	// userInfo only sees direct groups, the admin group may be further up
+	info.IsAdmin, err = cl.IsAdminReq(info.Username)
 	if err != nil {
-		return fmt.Errorf("Failed to delete user: %v", err)
+		return nil, err
 	}
+	return &info, nil
+}
 
-	return nil
+// SetUserDisabled disables or enables the account the flavor's way: the ACCOUNTDISABLE flag of
+// userAccountControl on Active Directory, nsAccountLock on 389-DS and pwdAccountLockedTime on OpenLDAP
+func (cl *LdapClient) SetUserDisabled(username string, disabled bool) error {
+	return cl.withConnOnce(func(conn ldap.Client) error {
+		entry, err := cl.findUser(conn, username, []string{cl.schema.disabledAttribute()})
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return auth.ErrUnknownUser
+		}
+
+		if err := conn.Modify(cl.schema.disable(entry, disabled)); err != nil {
+			return fmt.Errorf("Failed to update account: %v", err)
+		}
+		return nil
+	})
 }
 
-func validatePassword(password string) bool {
-	var number, letter bool
-	if len(password) < 8 {
-		return false
+func (cl *LdapClient) findUser(conn ldap.Client, username string, attributes []string) (*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		cl.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		cl.schema.userFilter(username),
+		attributes,
+		nil,
+	)
+
+	res, err := conn.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to search for user: %v", err)
 	}
-	for _, c := range password {
-		switch {
-		case uni.IsNumber(c):
-			number = true
-		case uni.IsLetter(c):
-			letter = true
-		}
+	if len(res.Entries) == 0 {
+		return nil, nil
 	}
+	return res.Entries[0], nil
+}
 
-	return number && letter
+// fields returns the attribute names from the FieldMap, with the flavor's defaults for unset ones.
+// GroupMembership stays empty outside of Active Directory, where groups are searched by member instead.
+func (cl *LdapClient) fields() config.LdapFields {
+	fields := cl.config.FieldMap
+	if fields.UserIdentifier == "" {
+		fields.UserIdentifier = cl.schema.rdnAttribute
+	}
+	if fields.Email == "" {
+		fields.Email = "mail"
+	}
+	if fields.FirstName == "" {
+		fields.FirstName = "givenName"
+	}
+	if fields.LastName == "" {
+		fields.LastName = "sn"
+	}
+	if fields.GroupMembership == "" && cl.schema.activeDirectory() {
+		fields.GroupMembership = "memberOf"
+	}
+	return fields
+}
+
+func (cl *LdapClient) userInfoAttributes() []string {
+	fields := cl.fields()
+	attributes := []string{
+		cl.schema.loginAttribute, fields.UserIdentifier, fields.Email, fields.FirstName, fields.LastName,
+		"displayName", cl.schema.disabledAttribute(),
+	}
+	if fields.GroupMembership != "" {
+		attributes = append(attributes, fields.GroupMembership)
+	}
+	return attributes
+}
+
+// userInfo reads the user's entry, groups holds the direct groups when the entry has no memberOf attribute
+func (cl *LdapClient) userInfo(entry *ldap.Entry, groups []string) auth.UserInfo {
+	fields := cl.fields()
+
+	info := auth.UserInfo{
+		Username:  entry.GetAttributeValue(cl.schema.loginAttribute),
+		Name:      entry.GetAttributeValue("displayName"),
+		FirstName: entry.GetAttributeValue(fields.FirstName),
+		LastName:  entry.GetAttributeValue(fields.LastName),
+		Email:     entry.GetAttributeValue(fields.Email),
+		Disabled:  cl.schema.disabled(entry),
+		DN:        entry.DN,
+	}
+	if info.Username == "" {
+		info.Username = entry.GetAttributeValue(fields.UserIdentifier)
+	}
+	info.Groups = append([]string{}, groups...)
+	if fields.GroupMembership != "" {
+		info.Groups = append(info.Groups, entry.GetAttributeValues(fields.GroupMembership)...)
+	}
+	for _, group := range info.Groups {
+		if cl.config.AdminGroupDN != "" && strings.EqualFold(group, cl.config.AdminGroupDN) {
+			info.IsAdmin = true
+		}
+	}
+	return info
 }
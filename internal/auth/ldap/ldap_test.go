@@ -0,0 +1,329 @@
+package ldap_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"goclone/internal/auth"
+	"goclone/internal/auth/ldap"
+	"goclone/internal/auth/ldap/ldaptest"
+	"goclone/internal/config"
+
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	baseDN     = "dc=example,dc=com"
+	serviceDN  = "cn=goclone,dc=example,dc=com"
+	usersDN    = "ou=users,dc=example,dc=com"
+	studentsDN = "cn=students,ou=groups,dc=example,dc=com"
+	staffDN    = "cn=staff,ou=groups,dc=example,dc=com"
+	adminsDN   = "cn=admins,ou=groups,dc=example,dc=com"
+)
+
+func entry(dn string, attributes map[string][]string) ldaptest.Entry {
+	return ldaptest.Entry{DN: dn, Attributes: attributes}
+}
+
+// newDirectory starts a server with the service account, the OUs and three groups of groupClass,
+// where staff is a member of admins
+func newDirectory(t *testing.T, activeDirectory bool, groupClass, memberAttr string) *ldaptest.Server {
+	group := func(dn string, members ...string) ldaptest.Entry {
+		attributes := map[string][]string{"objectClass": {"top", groupClass}}
+		if len(members) > 0 {
+			attributes[memberAttr] = members
+		}
+		return entry(dn, attributes)
+	}
+
+	entries := []ldaptest.Entry{
+		entry(baseDN, map[string][]string{"objectClass": {"top", "domain"}}),
+		entry(serviceDN, map[string][]string{"objectClass": {"top", "person"}, "userPassword": {"service1"}}),
+		entry(usersDN, map[string][]string{"objectClass": {"top", "organizationalUnit"}}),
+		entry("ou=groups,dc=example,dc=com", map[string][]string{"objectClass": {"top", "organizationalUnit"}}),
+		group(studentsDN),
+		group(staffDN),
+		group(adminsDN),
+	}
+	if memberAttr == "member" {
+		entries[4] = group(studentsDN, serviceDN)
+		entries[5] = group(staffDN, serviceDN)
+		entries[6] = group(adminsDN, staffDN)
+	}
+
+	server, err := ldaptest.NewServer(entries...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.ActiveDirectory = activeDirectory
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newManager(t *testing.T, server *ldaptest.Server, conf config.LdapProvider) *ldap.LdapClient {
+	conf.URL = server.URL
+	conf.SkipTLSVerify = true
+	conf.BaseDN = baseDN
+	conf.BindUser = serviceDN
+	conf.BindPassword = "service1"
+	conf.UserOU = usersDN
+	conf.UserGroupDN = studentsDN
+	conf.AdminGroupDN = adminsDN
+
+	manager, err := ldap.NewLdapManager(conf, noop.NewTracerProvider().Tracer("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { manager.Disconnect() })
+	return manager
+}
+
+func hasValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOpenLDAP(t *testing.T) {
+	server := newDirectory(t, false, "groupOfNames", "member")
+	manager := newManager(t, server, config.LdapProvider{Flavor: "openldap"})
+
+	err := manager.ProvisionUser(auth.NewUser{Username: "alice", Name: "Alice Smith", Email: "alice@example.com", Password: "password1", Group: staffDN})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const aliceDN = "uid=alice,ou=users,dc=example,dc=com"
+	alice := server.Get(aliceDN)
+	if alice == nil {
+		t.Fatal("user was not created under UserOU")
+	}
+	if !hasValue(alice.Attributes["objectClass"], "inetOrgPerson") || !hasValue(alice.Attributes["sn"], "alice") {
+		t.Errorf("user created without the inetOrgPerson schema: %v", alice.Attributes)
+	}
+	if !hasValue(alice.Attributes["userPassword"], "password1") {
+		t.Error("password was not set with the Password Modify operation")
+	}
+	if !hasValue(server.Get(studentsDN).Attributes["member"], aliceDN) {
+		t.Error("user was not added to UserGroupDN")
+	}
+
+	isAdmin, err := manager.Authenticate("alice", "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isAdmin {
+		t.Error("staff is in admins, so alice should be an admin")
+	}
+	if _, err := manager.Authenticate("alice", "wrong1234"); err != auth.ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := manager.Authenticate("bob", "password1"); err != auth.ErrUnknownUser {
+		t.Errorf("expected ErrUnknownUser, got %v", err)
+	}
+
+	if err := manager.ResetPassword("alice", "password2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Authenticate("alice", "password2"); err != nil {
+		t.Errorf("reset password not accepted: %v", err)
+	}
+
+	info, err := manager.GetUserInfo("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Email != "alice@example.com" || !info.IsAdmin || len(info.Groups) != 2 {
+		t.Errorf("unexpected user info %+v", info)
+	}
+
+	if err := manager.SetUserDisabled("alice", true); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Get(aliceDN).Attributes["pwdAccountLockedTime"]) == 0 {
+		t.Error("disabling should lock the account with pwdAccountLockedTime")
+	}
+	users, err := manager.ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Username != "alice" || !users[0].Disabled || len(users[0].Groups) != 2 {
+		t.Errorf("unexpected users %+v", users)
+	}
+	if err := manager.SetUserDisabled("alice", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Get(aliceDN).Attributes["pwdAccountLockedTime"]; ok {
+		t.Error("enabling should unlock the account")
+	}
+
+	if err := manager.DeleteUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if server.Get(aliceDN) != nil {
+		t.Error("user was not deleted")
+	}
+	if hasValue(server.Get(staffDN).Attributes["member"], aliceDN) {
+		t.Error("deleted user is still a member of staff")
+	}
+}
+
+func TestPosixGroups(t *testing.T) {
+	server := newDirectory(t, false, "posixGroup", "memberUid")
+	manager := newManager(t, server, config.LdapProvider{Flavor: "389ds", GroupSchema: "posixGroup"})
+
+	if err := manager.ProvisionUser(auth.NewUser{Username: "bob", Password: "password1"}); err != nil {
+		t.Fatal(err)
+	}
+	if !hasValue(server.Get(studentsDN).Attributes["memberUid"], "bob") {
+		t.Error("posixGroup members should be listed by username")
+	}
+	if err := manager.AddUserToGroup("bob", adminsDN); err != nil {
+		t.Fatal(err)
+	}
+	if isAdmin, err := manager.Authenticate("bob", "password1"); err != nil || !isAdmin {
+		t.Errorf("expected bob to be an admin, got %v, %v", isAdmin, err)
+	}
+
+	if err := manager.SetUserDisabled("bob", true); err != nil {
+		t.Fatal(err)
+	}
+	if !hasValue(server.Get("uid=bob,ou=users,dc=example,dc=com").Attributes["nsAccountLock"], "TRUE") {
+		t.Error("disabling should set nsAccountLock")
+	}
+	if info, err := manager.GetUserInfo("bob"); err != nil || !info.Disabled {
+		t.Errorf("expected bob to be disabled, got %+v, %v", info, err)
+	}
+}
+
+func TestActiveDirectory(t *testing.T) {
+	server := newDirectory(t, true, "group", "member")
+	manager := newManager(t, server, config.LdapProvider{})
+
+	if err := manager.ProvisionUser(auth.NewUser{Username: "carol", Password: "password1", Group: staffDN}); err != nil {
+		t.Fatal(err)
+	}
+
+	carol := server.Get("cn=carol,ou=users,dc=example,dc=com")
+	if carol == nil {
+		t.Fatal("user was not created under UserOU")
+	}
+	if !hasValue(carol.Attributes["sAMAccountName"], "carol") || !hasValue(carol.Attributes["userAccountControl"], "512") {
+		t.Errorf("user created without the Active Directory schema: %v", carol.Attributes)
+	}
+
+	if isAdmin, err := manager.Authenticate("carol", "password1"); err != nil || !isAdmin {
+		t.Errorf("expected carol to be an admin through nested groups, got %v, %v", isAdmin, err)
+	}
+
+	if err := manager.SetUserDisabled("carol", true); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := manager.GetUserInfo("carol"); err != nil || !info.Disabled || !info.IsAdmin {
+		t.Errorf("unexpected user info %+v, %v", info, err)
+	}
+}
+
+func TestFlavorConfig(t *testing.T) {
+	tracer := noop.NewTracerProvider().Tracer("test")
+	for _, conf := range []config.LdapProvider{
+		{Flavor: "novell"},
+		{Flavor: "openldap", GroupSchema: "groupOfUniqueNames"},
+		{Flavor: "openldap", NestedGroups: "in_chain"},
+	} {
+		if _, err := ldap.NewLdapManager(conf, tracer); err == nil {
+			t.Errorf("expected %+v to be refused", conf)
+		}
+	}
+}
+
+func TestConcurrentLogins(t *testing.T) {
+	server := newDirectory(t, false, "groupOfNames", "member")
+	manager := newManager(t, server, config.LdapProvider{Flavor: "openldap", PoolSize: 2})
+
+	for _, username := range []string{"dave", "erin"} {
+		if err := manager.ProvisionUser(auth.NewUser{Username: username, Password: username + "pass1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := manager.AddUserToGroup("erin", adminsDN); err != nil {
+		t.Fatal(err)
+	}
+	binds := server.Binds()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 20; i++ {
+		for _, username := range []string{"dave", "erin"} {
+			wg.Add(1)
+			go func(username string) {
+				defer wg.Done()
+				isAdmin, err := manager.Authenticate(username, username+"pass1")
+				if err == nil && isAdmin != (username == "erin") {
+					err = fmt.Errorf("%s got isAdmin %v", username, isAdmin)
+				}
+				if _, wrong := manager.Authenticate(username, "wrong1234"); wrong != auth.ErrInvalidCredentials && err == nil {
+					err = fmt.Errorf("%s logged in with a wrong password: %v", username, wrong)
+				}
+				errs <- err
+			}(username)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// every login binds once as the user, while at most PoolSize service connections are ever bound
+	if got := server.Binds() - binds; got < 40 || got > 42 {
+		t.Errorf("expected 40 user binds and at most 2 service binds, got %d binds", got)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	server := newDirectory(t, false, "groupOfNames", "member")
+	manager := newManager(t, server, config.LdapProvider{Flavor: "openldap", PoolSize: 1})
+
+	if exists, err := manager.UserExists("frank"); err != nil || exists {
+		t.Fatalf("expected no user, got %v, %v", exists, err)
+	}
+
+	server.CloseConns()
+	time.Sleep(50 * time.Millisecond)
+
+	if err := manager.ProvisionUser(auth.NewUser{Username: "frank", Password: "password1"}); err != nil {
+		t.Fatalf("the pool should have replaced the dropped connection: %v", err)
+	}
+	if exists, err := manager.UserExists("frank"); err != nil || !exists {
+		t.Errorf("expected frank to exist, got %v, %v", exists, err)
+	}
+}
+
+func TestWritesNotRetried(t *testing.T) {
+	server := newDirectory(t, false, "groupOfNames", "member")
+	manager := newManager(t, server, config.LdapProvider{Flavor: "openldap", PoolSize: 1})
+
+	// the add reaches the server but the connection breaks before the answer
+	server.DropAfterWrite()
+	writes := server.Writes()
+	if err := manager.ProvisionUser(auth.NewUser{Username: "frank", Password: "password1"}); err == nil {
+		t.Fatal("expected the dropped connection to fail the request")
+	}
+	if got := server.Writes() - writes; got != 1 {
+		t.Errorf("expected the add to be sent once, got %d writes", got)
+	}
+
+	// reads are still retried on a fresh connection
+	server.CloseConns()
+	if exists, err := manager.UserExists("frank"); err != nil || !exists {
+		t.Errorf("expected frank to exist, got %v, %v", exists, err)
+	}
+}
@@ -0,0 +1,734 @@
+// Package ldaptest runs a small in-process LDAP server over TLS for tests, in the spirit of httptest.
+// It keeps entries in memory and understands just enough of the protocol for goclone's LDAP client:
+// simple binds, searches with the common filters, add, modify, delete and the Password Modify extended
+// operation. With ActiveDirectory set it also behaves like Active Directory where goclone relies on it:
+// memberOf is computed from group members, LDAP_MATCHING_RULE_IN_CHAIN is understood and unicodePwd
+// sets the password.
+package ldaptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const (
+	appBindRequest     = 0
+	appBindResponse    = 1
+	appUnbindRequest   = 2
+	appSearchRequest   = 3
+	appSearchEntry     = 4
+	appSearchDone      = 5
+	appModifyRequest   = 6
+	appModifyResponse  = 7
+	appAddRequest      = 8
+	appAddResponse     = 9
+	appDelRequest      = 10
+	appDelResponse     = 11
+	appExtendedRequest = 23
+	appExtendedReponse = 24
+
+	resultSuccess             = 0
+	resultProtocolError       = 2
+	resultNoSuchAttribute     = 16
+	resultNoSuchObject        = 32
+	resultInvalidCredentials  = 49
+	resultUnwillingToPerform  = 53
+	resultEntryAlreadyExists  = 68
+	resultObjectClassViolated = 65
+
+	passwordModifyOID   = "1.3.6.1.4.1.4203.1.11.1"
+	matchingRuleInChain = "1.2.840.113556.1.4.1941"
+)
+
+// Entry is a directory entry. Attribute names are matched case-insensitively.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+func (e *Entry) get(name string) []string {
+	for attr, vals := range e.Attributes {
+		if strings.EqualFold(attr, name) {
+			return vals
+		}
+	}
+	return nil
+}
+
+func (e *Entry) set(name string, vals []string) {
+	for attr := range e.Attributes {
+		if strings.EqualFold(attr, name) {
+			delete(e.Attributes, attr)
+		}
+	}
+	if len(vals) > 0 {
+		e.Attributes[name] = vals
+	}
+}
+
+// Server is an LDAP server listening on a random local port. Passwords are kept in userPassword in clear text.
+type Server struct {
+	// URL is the ldaps:// URL to connect to, the certificate is self-signed
+	URL string
+	// ActiveDirectory turns on the Active Directory behaviour described in the package documentation
+	ActiveDirectory bool
+
+	listener net.Listener
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+	conns   map[net.Conn]bool
+	// Binds counts successful binds, so tests can tell how many connections were set up
+	binds int
+	// writes counts add, modify, delete and extended requests, so tests can tell whether one was sent twice
+	writes int
+	// dropWrites is how many of the next writes are applied without an answer, see DropAfterWrite
+	dropWrites int
+}
+
+// NewServer starts a server holding the given entries
+func NewServer(entries ...Entry) (*Server, error) {
+	cert, err := selfSigned()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		URL:      "ldaps://" + listener.Addr().String(),
+		listener: listener,
+		entries:  map[string]*Entry{},
+		conns:    map[net.Conn]bool{},
+	}
+	for _, entry := range entries {
+		s.Add(entry)
+	}
+
+	go s.serve()
+	return s, nil
+}
+
+// Close stops the server and drops open connections
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// CloseConns drops every client connection while the server keeps listening, as a restart would
+func (s *Server) CloseConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Add stores an entry, replacing any entry with the same DN
+func (s *Server) Add(entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := &Entry{DN: entry.DN, Attributes: map[string][]string{}}
+	for attr, vals := range entry.Attributes {
+		e.Attributes[attr] = append([]string{}, vals...)
+	}
+	s.entries[normalizeDN(entry.DN)] = e
+}
+
+// Get returns a copy of the entry with the DN, or nil
+func (s *Server) Get(dn string) *Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[normalizeDN(dn)]
+	if !ok {
+		return nil
+	}
+	return s.view(e)
+}
+
+// Binds returns how many successful binds the server has seen
+func (s *Server) Binds() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.binds
+}
+
+// Writes returns how many add, modify, delete and extended requests the server has seen
+func (s *Server) Writes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writes
+}
+
+// DropAfterWrite makes the server apply the next write and then drop the connection instead of answering,
+// as if the connection broke after the request reached the server
+func (s *Server) DropAfterWrite() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropWrites++
+}
+
+// wrote counts a write and reports whether its connection should be dropped instead of answered
+func (s *Server) wrote() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	if s.dropWrites > 0 {
+		s.dropWrites--
+		return true
+	}
+	return false
+}
+
+// OpenConns returns how many client connections are open
+func (s *Server) OpenConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	var bound string
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		var responses []*ber.Packet
+		switch op.Tag {
+		case appBindRequest:
+			var code int
+			code, bound = s.bind(op)
+			responses = append(responses, result(appBindResponse, code, ""))
+		case appUnbindRequest:
+			return
+		case appSearchRequest:
+			responses = s.search(op)
+		case appModifyRequest:
+			responses = append(responses, result(appModifyResponse, s.modify(op), ""))
+		case appAddRequest:
+			responses = append(responses, result(appAddResponse, s.add(op), ""))
+		case appDelRequest:
+			responses = append(responses, result(appDelResponse, s.del(op.Data.String()), ""))
+		case appExtendedRequest:
+			responses = append(responses, result(appExtendedReponse, s.extended(op, bound), ""))
+		default:
+			// abandon and anything else goes unanswered
+			continue
+		}
+
+		switch op.Tag {
+		case appModifyRequest, appAddRequest, appDelRequest, appExtendedRequest:
+			if s.wrote() {
+				return
+			}
+		}
+
+		for _, response := range responses {
+			envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+			envelope.AppendChild(response)
+			if _, err := conn.Write(envelope.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func result(tag ber.Tag, code int, message string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "diagnosticMessage"))
+	return op
+}
+
+func (s *Server) bind(op *ber.Packet) (int, string) {
+	if len(op.Children) < 3 {
+		return resultProtocolError, ""
+	}
+	dn, _ := op.Children[1].Value.(string)
+	password := op.Children[2].Data.String()
+	if dn == "" && password == "" {
+		return resultSuccess, ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[normalizeDN(dn)]
+	if !ok || password == "" || !contains(entry.get("userPassword"), password) {
+		return resultInvalidCredentials, ""
+	}
+	s.binds++
+	return resultSuccess, entry.DN
+}
+
+func (s *Server) search(op *ber.Packet) []*ber.Packet {
+	if len(op.Children) < 8 {
+		return []*ber.Packet{result(appSearchDone, resultProtocolError, "")}
+	}
+	base, _ := op.Children[0].Value.(string)
+	scope, _ := op.Children[1].Value.(int64)
+	filter := op.Children[6]
+	var attributes []string
+	for _, attr := range op.Children[7].Children {
+		name, _ := attr.Value.(string)
+		attributes = append(attributes, name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	base = normalizeDN(base)
+	if base == "" && scope == 0 {
+		root := &Entry{Attributes: map[string][]string{"objectClass": {"top"}}}
+		if s.ActiveDirectory {
+			root.Attributes["supportedControl"] = []string{}
+		}
+		return []*ber.Packet{entryPacket(root, attributes), result(appSearchDone, resultSuccess, "")}
+	}
+	if _, ok := s.entries[base]; !ok {
+		return []*ber.Packet{result(appSearchDone, resultNoSuchObject, "")}
+	}
+
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var responses []*ber.Packet
+	for _, key := range keys {
+		if !inScope(key, base, scope) {
+			continue
+		}
+		entry := s.view(s.entries[key])
+		if s.matches(entry, filter) {
+			responses = append(responses, entryPacket(entry, attributes))
+		}
+	}
+	return append(responses, result(appSearchDone, resultSuccess, ""))
+}
+
+// view returns a copy of the entry with the attributes the server computes, like memberOf
+func (s *Server) view(e *Entry) *Entry {
+	view := &Entry{DN: e.DN, Attributes: map[string][]string{}}
+	for attr, vals := range e.Attributes {
+		view.Attributes[attr] = append([]string{}, vals...)
+	}
+	if s.ActiveDirectory {
+		var memberOf []string
+		for _, group := range s.entries {
+			if containsDN(group.get("member"), e.DN) {
+				memberOf = append(memberOf, group.DN)
+			}
+		}
+		sort.Strings(memberOf)
+		view.set("memberOf", memberOf)
+	}
+	return view
+}
+
+func entryPacket(entry *Entry, attributes []string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, appSearchEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "objectName"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+
+	names := make([]string, 0, len(entry.Attributes))
+	for attr := range entry.Attributes {
+		if len(attributes) == 0 || containsFold(attributes, attr) || containsFold(attributes, "*") {
+			names = append(names, attr)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, val := range entry.Attributes[name] {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, val, "val"))
+		}
+		attr.AppendChild(vals)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	return op
+}
+
+// matches evaluates the filters goclone uses: and, or, not, equality, substrings, present and extensible match
+func (s *Server) matches(entry *Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case 0:
+		for _, child := range filter.Children {
+			if !s.matches(entry, child) {
+				return false
+			}
+		}
+		return true
+	case 1:
+		for _, child := range filter.Children {
+			if s.matches(entry, child) {
+				return true
+			}
+		}
+		return false
+	case 2:
+		return len(filter.Children) == 1 && !s.matches(entry, filter.Children[0])
+	case 3:
+		attr, _ := filter.Children[0].Value.(string)
+		value, _ := filter.Children[1].Value.(string)
+		if isDNAttribute(attr) {
+			return containsDN(entry.get(attr), value)
+		}
+		return containsFold(entry.get(attr), value)
+	case 4:
+		attr, _ := filter.Children[0].Value.(string)
+		for _, val := range entry.get(attr) {
+			if matchSubstrings(strings.ToLower(val), filter.Children[1].Children) {
+				return true
+			}
+		}
+		return false
+	case 7:
+		return len(entry.get(filter.Data.String())) > 0
+	case 9:
+		var rule, attr, value string
+		for _, child := range filter.Children {
+			switch child.Tag {
+			case 1:
+				rule = child.Data.String()
+			case 2:
+				attr = child.Data.String()
+			case 3:
+				value = child.Data.String()
+			}
+		}
+		if s.ActiveDirectory && rule == matchingRuleInChain && strings.EqualFold(attr, "member") {
+			return s.memberInChain(entry, value, map[string]bool{})
+		}
+		return false
+	}
+	return false
+}
+
+func (s *Server) memberInChain(group *Entry, dn string, seen map[string]bool) bool {
+	if seen[normalizeDN(group.DN)] {
+		return false
+	}
+	seen[normalizeDN(group.DN)] = true
+	for _, member := range group.get("member") {
+		if normalizeDN(member) == normalizeDN(dn) {
+			return true
+		}
+		if nested, ok := s.entries[normalizeDN(member)]; ok && s.memberInChain(nested, dn, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSubstrings(val string, parts []*ber.Packet) bool {
+	for i, part := range parts {
+		sub := strings.ToLower(part.Data.String())
+		switch part.Tag {
+		case 0:
+			if !strings.HasPrefix(val, sub) {
+				return false
+			}
+			val = val[len(sub):]
+		case 1:
+			j := strings.Index(val, sub)
+			if j < 0 {
+				return false
+			}
+			val = val[j+len(sub):]
+		case 2:
+			if i != len(parts)-1 || !strings.HasSuffix(val, sub) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *Server) add(op *ber.Packet) int {
+	if len(op.Children) < 2 {
+		return resultProtocolError
+	}
+	dn, _ := op.Children[0].Value.(string)
+	entry := &Entry{DN: dn, Attributes: map[string][]string{}}
+	for _, attr := range op.Children[1].Children {
+		name, vals := attribute(attr)
+		entry.Attributes[name] = vals
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[normalizeDN(dn)]; ok {
+		return resultEntryAlreadyExists
+	}
+	if len(entry.get("objectClass")) == 0 {
+		return resultObjectClassViolated
+	}
+	s.entries[normalizeDN(dn)] = entry
+	return resultSuccess
+}
+
+func (s *Server) modify(op *ber.Packet) int {
+	if len(op.Children) < 2 {
+		return resultProtocolError
+	}
+	dn, _ := op.Children[0].Value.(string)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.entries[normalizeDN(dn)]
+	if !ok {
+		return resultNoSuchObject
+	}
+
+	// changes apply to a copy so a failing one leaves the entry untouched
+	entry := &Entry{DN: stored.DN, Attributes: map[string][]string{}}
+	for attr, vals := range stored.Attributes {
+		entry.Attributes[attr] = append([]string{}, vals...)
+	}
+
+	for _, change := range op.Children[1].Children {
+		operation, _ := change.Children[0].Value.(int64)
+		name, vals := attribute(change.Children[1])
+
+		if s.ActiveDirectory && strings.EqualFold(name, "unicodePwd") {
+			password, ok := decodeUnicodePwd(vals)
+			if !ok {
+				return resultUnwillingToPerform
+			}
+			name, vals = "userPassword", []string{password}
+		}
+
+		current := entry.get(name)
+		switch operation {
+		case 0:
+			for _, val := range vals {
+				if !containsFold(current, val) {
+					current = append(current, val)
+				}
+			}
+			entry.set(name, current)
+		case 1:
+			if len(vals) == 0 {
+				if len(current) == 0 {
+					return resultNoSuchAttribute
+				}
+				entry.set(name, nil)
+				continue
+			}
+			var kept []string
+			for _, val := range current {
+				if !containsFold(vals, val) {
+					kept = append(kept, val)
+				}
+			}
+			if len(kept) == len(current) {
+				return resultNoSuchAttribute
+			}
+			entry.set(name, kept)
+		case 2:
+			entry.set(name, vals)
+		default:
+			return resultProtocolError
+		}
+	}
+
+	s.entries[normalizeDN(dn)] = entry
+	return resultSuccess
+}
+
+func (s *Server) del(dn string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[normalizeDN(dn)]; !ok {
+		return resultNoSuchObject
+	}
+	delete(s.entries, normalizeDN(dn))
+	return resultSuccess
+}
+
+// extended handles the Password Modify operation, changing the password of the named user or the bound one
+func (s *Server) extended(op *ber.Packet, bound string) int {
+	if len(op.Children) < 1 || op.Children[0].Data.String() != passwordModifyOID {
+		return resultProtocolError
+	}
+
+	var identity, oldPassword, newPassword string
+	if len(op.Children) > 1 {
+		value := ber.DecodePacket(op.Children[1].Data.Bytes())
+		for _, child := range value.Children {
+			switch child.Tag {
+			case 0:
+				identity = child.Data.String()
+			case 1:
+				oldPassword = child.Data.String()
+			case 2:
+				newPassword = child.Data.String()
+			}
+		}
+	}
+	if identity == "" {
+		identity = bound
+	}
+	if identity == "" || newPassword == "" {
+		return resultUnwillingToPerform
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[normalizeDN(identity)]
+	if !ok {
+		return resultNoSuchObject
+	}
+	if oldPassword != "" && !contains(entry.get("userPassword"), oldPassword) {
+		return resultInvalidCredentials
+	}
+	entry.set("userPassword", []string{newPassword})
+	return resultSuccess
+}
+
+func attribute(packet *ber.Packet) (string, []string) {
+	name, _ := packet.Children[0].Value.(string)
+	var vals []string
+	if len(packet.Children) > 1 {
+		for _, val := range packet.Children[1].Children {
+			v, _ := val.Value.(string)
+			vals = append(vals, v)
+		}
+	}
+	return name, vals
+}
+
+// decodeUnicodePwd reads a password the way Active Directory expects it, quoted and in UTF-16LE
+func decodeUnicodePwd(vals []string) (string, bool) {
+	if len(vals) != 1 {
+		return "", false
+	}
+	decoded, err := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder().String(vals[0])
+	if err != nil || len(decoded) < 2 || decoded[0] != '"' || decoded[len(decoded)-1] != '"' {
+		return "", false
+	}
+	return decoded[1 : len(decoded)-1], true
+}
+
+func inScope(dn, base string, scope int64) bool {
+	switch scope {
+	case 0:
+		return dn == base
+	case 1:
+		i := strings.Index(dn, ",")
+		return i >= 0 && dn[i+1:] == base
+	default:
+		return dn == base || strings.HasSuffix(dn, ","+base)
+	}
+}
+
+func normalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, part := range parts {
+		parts[i] = strings.ToLower(strings.TrimSpace(part))
+	}
+	return strings.Join(parts, ",")
+}
+
+func isDNAttribute(attr string) bool {
+	return strings.EqualFold(attr, "member") || strings.EqualFold(attr, "memberOf") || strings.EqualFold(attr, "uniqueMember")
+}
+
+func containsDN(list []string, dn string) bool {
+	for _, item := range list {
+		if normalizeDN(item) == normalizeDN(dn) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func selfSigned() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ldaptest"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
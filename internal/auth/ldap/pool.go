@@ -0,0 +1,154 @@
+package ldap
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	defaultPoolSize            = 5
+	defaultDialTimeout         = 10 * time.Second
+	defaultRequestTimeout      = 30 * time.Second
+	defaultHealthCheckInterval = time.Minute
+)
+
+// ErrPoolTimeout is returned when every pooled connection stayed busy for the whole RequestTimeout
+var ErrPoolTimeout = fmt.Errorf("Timed out waiting for an LDAP connection")
+
+var errPoolClosed = fmt.Errorf("LDAP connection pool is closed")
+
+type idleConn struct {
+	conn  ldap.Client
+	since time.Time
+}
+
+// connPool hands out service-bound connections, at most size at a time. Connections are dialed when
+// needed and kept once returned; ones that sat idle for longer than checkAfter are checked before reuse,
+// and closed ones are replaced, so the pool recovers by itself when the server restarts.
+type connPool struct {
+	dial       func() (ldap.Client, error)
+	wait       time.Duration
+	checkAfter time.Duration
+
+	// slots holds a token for every connection handed out
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []idleConn
+	closed bool
+}
+
+func newConnPool(dial func() (ldap.Client, error), size int, wait, checkAfter time.Duration) *connPool {
+	return &connPool{
+		dial:       dial,
+		wait:       wait,
+		checkAfter: checkAfter,
+		slots:      make(chan struct{}, size),
+	}
+}
+
+func (p *connPool) get() (ldap.Client, error) {
+	timer := time.NewTimer(p.wait)
+	defer timer.Stop()
+	select {
+	case p.slots <- struct{}{}:
+	case <-timer.C:
+		return nil, ErrPoolTimeout
+	}
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			<-p.slots
+			return nil, errPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		// the most recently used connection is the least likely to have been dropped
+		idle := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if idle.conn.IsClosing() || (time.Since(idle.since) > p.checkAfter && !healthy(idle.conn)) {
+			idle.conn.Close()
+			continue
+		}
+		return idle.conn, nil
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+// put returns a connection from get, closing it instead when it broke while in use
+func (p *connPool) put(conn ldap.Client) {
+	p.mu.Lock()
+	if p.closed || conn.IsClosing() {
+		p.mu.Unlock()
+		conn.Close()
+	} else {
+		p.idle = append(p.idle, idleConn{conn: conn, since: time.Now()})
+		p.mu.Unlock()
+	}
+	<-p.slots
+}
+
+// close closes the idle connections, the ones in use are closed as they are returned
+func (p *connPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, idle := range p.idle {
+		idle.conn.Close()
+	}
+	p.idle = nil
+}
+
+// healthy reads the root DSE, which every server answers without looking at the bound user's rights
+func healthy(conn ldap.Client) bool {
+	req := ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", []string{"1.1"}, nil)
+	_, err := conn.Search(req)
+	return err == nil
+}
+
+// withConn runs fn on a pooled service-bound connection. A connection that broke under fn is dropped
+// and fn runs once more on a fresh one, so requests survive the server closing idle connections.
+// fn must only read, writes go through withConnOnce.
+func (cl *LdapClient) withConn(fn func(conn ldap.Client) error) error {
+	for attempt := 0; ; attempt++ {
+		broken, err := cl.runConn(fn)
+		if err == nil || !broken || attempt > 0 {
+			return err
+		}
+	}
+}
+
+// withConnOnce runs fn on a pooled service-bound connection without retrying. A write may have reached
+// the server before the connection broke, so running it again could fail with "already exists" or
+// apply the change twice.
+func (cl *LdapClient) withConnOnce(fn func(conn ldap.Client) error) error {
+	_, err := cl.runConn(fn)
+	return err
+}
+
+// runConn runs fn on a pooled connection and reports whether the connection broke under it
+func (cl *LdapClient) runConn(fn func(conn ldap.Client) error) (bool, error) {
+	conn, err := cl.pool.get()
+	if err != nil {
+		return false, err
+	}
+	err = fn(conn)
+	broken := conn.IsClosing()
+	cl.pool.put(conn)
+	return broken, err
+}
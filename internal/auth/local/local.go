@@ -0,0 +1,520 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"goclone/internal/api/handlers"
+	"goclone/internal/auth"
+	"goclone/internal/auth/sessionstore"
+	"goclone/internal/config"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	bolt "go.etcd.io/bbolt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var usersBucket = []byte("users")
+
+// ErrUserNotFound is returned when no local user has the given name
+var ErrUserNotFound = fmt.Errorf("User not found")
+
+// User is a locally stored account. Usernames are unique regardless of case.
+type User struct {
+	Username     string    `json:"username"`
+	Name         string    `json:"name,omitempty"`
+	PasswordHash []byte    `json:"passwordHash"`
+	IsAdmin      bool      `json:"isAdmin"`
+	Groups       []string  `json:"groups,omitempty"`
+	Email        string    `json:"email,omitempty"`
+	Disabled     bool      `json:"disabled,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+// LocalManager authenticates users against a bbolt database, for deployments without a directory server
+type LocalManager struct {
+	db     *bolt.DB
+	config config.LocalProvider
+	tracer trace.Tracer
+}
+
+func NewLocalManager(conf config.LocalProvider, tracer trace.Tracer) (*LocalManager, error) {
+	db, err := bolt.Open(conf.DBPath, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open user database: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(usersBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to create users bucket: %v", err)
+	}
+
+	lm := &LocalManager{db: db, config: conf, tracer: tracer}
+	if err := lm.bootstrap(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return lm, nil
+}
+
+// bootstrap creates the configured admin the first time the database is opened
+func (lm *LocalManager) bootstrap() error {
+	if lm.config.BootstrapAdmin == "" {
+		return nil
+	}
+
+	exists, err := lm.UserExists(lm.config.BootstrapAdmin)
+	if err != nil || exists {
+		return err
+	}
+
+	if err := lm.CreateUser(lm.config.BootstrapAdmin, lm.config.BootstrapPassword, true); err != nil {
+		return fmt.Errorf("Failed to create bootstrap admin: %v", err)
+	}
+	fmt.Printf("Created bootstrap admin %s\n", lm.config.BootstrapAdmin)
+	return nil
+}
+
+func (lm *LocalManager) Close() error {
+	return lm.db.Close()
+}
+
+func (lm *LocalManager) Login(c *gin.Context) {
+	_, span := lm.tracer.Start(c.Request.Context(), "POST /api/v1/login")
+	defer span.End()
+
+	var loginInfo map[string]interface{}
+	if err := c.BindJSON(&loginInfo); err != nil {
+		c.String(http.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	username, ok := loginInfo["username"].(string)
+	if !ok {
+		c.String(http.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	span.SetAttributes(attribute.String("username", username))
+
+	password, ok := loginInfo["password"].(string)
+	if !ok {
+		c.String(http.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	user, err := lm.LoginReq(username, password)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	if user == nil {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	session := sessions.Default(c)
+	sessionstore.Renew(session)
+	session.Set("id", user.Username)
+	session.Set("isAdmin", user.IsAdmin)
+	if err := session.Save(); err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged in"})
+}
+
+// LoginReq returns the user when the password matches, or nil when the credentials are wrong or the account is disabled
+func (lm *LocalManager) LoginReq(username, password string) (*User, error) {
+	user, err := lm.GetUser(username)
+	if err == ErrUserNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Disabled {
+		return nil, nil
+	}
+	if bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)) != nil {
+		return nil, nil
+	}
+	return user, nil
+}
+
+func (lm *LocalManager) Authenticate(username, password string) (bool, error) {
+	user, err := lm.GetUser(username)
+	if err == ErrUserNotFound {
+		return false, auth.ErrUnknownUser
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if user.Disabled || bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)) != nil {
+		return false, auth.ErrInvalidCredentials
+	}
+	return user.IsAdmin, nil
+}
+
+func (lm *LocalManager) RegistrationEnabled() bool {
+	return !lm.config.DisableRegistration
+}
+
+func (lm *LocalManager) RegisterUser(c *gin.Context) {
+	if lm.config.DisableRegistration {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Registration is disabled"})
+		return
+	}
+
+	var userInfo map[string]interface{}
+	if err := c.BindJSON(&userInfo); err != nil {
+		c.String(http.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	err := lm.RegisterUserReq(userInfo)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User registered"})
+}
+
+func (lm *LocalManager) RegisterUserReq(userInfo map[string]interface{}) error {
+	username, ok := userInfo["username"].(string)
+	if !ok {
+		return fmt.Errorf("Username not provided or invalid")
+	}
+
+	password, ok := userInfo["password"].(string)
+	if !ok {
+		return fmt.Errorf("Password not provided or invalid")
+	}
+
+	if err := lm.CreateUser(username, password, false); err != nil {
+		return err
+	}
+
+	// the address is optional, without one the user cannot reset a forgotten password themselves
+	if email, ok := userInfo["email"].(string); ok && email != "" {
+		return lm.SetEmail(username, email)
+	}
+	return nil
+}
+
+// CreateUser enforces the shared username and password rules and stores a new user
+func (lm *LocalManager) CreateUser(username, password string, isAdmin bool) error {
+	if err := auth.ValidateUsername(username); err != nil {
+		return err
+	}
+
+	if !auth.ValidatePassword(password) {
+		return auth.ErrWeakPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("Failed to hash password: %v", err)
+	}
+
+	user := User{
+		Username:     username,
+		PasswordHash: hash,
+		IsAdmin:      isAdmin,
+		Created:      time.Now(),
+	}
+
+	return lm.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		if b.Get(userKey(username)) != nil {
+			return fmt.Errorf("User %s already exists", username)
+		}
+		return putUser(b, &user)
+	})
+}
+
+func (lm *LocalManager) GetUser(username string) (*User, error) {
+	var user *User
+	err := lm.db.View(func(tx *bolt.Tx) error {
+		var err error
+		user, err = getUser(tx.Bucket(usersBucket), username)
+		return err
+	})
+	return user, err
+}
+
+func (lm *LocalManager) UserExists(username string) (bool, error) {
+	_, err := lm.GetUser(username)
+	if err == ErrUserNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (lm *LocalManager) SetPassword(username, password string) error {
+	if !auth.ValidatePassword(password) {
+		return auth.ErrWeakPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("Failed to hash password: %v", err)
+	}
+
+	return lm.updateUser(username, func(user *User) {
+		user.PasswordHash = hash
+	})
+}
+
+// ProvisionUser creates a user with their details and group in one go, for admins adding accounts in bulk
+func (lm *LocalManager) ProvisionUser(newUser auth.NewUser) error {
+	if newUser.Email != "" {
+		if _, err := mail.ParseAddress(newUser.Email); err != nil {
+			return fmt.Errorf("Invalid email address: %v", err)
+		}
+	}
+
+	if err := lm.CreateUser(newUser.Username, newUser.Password, false); err != nil {
+		return err
+	}
+
+	return lm.updateUser(newUser.Username, func(user *User) {
+		user.Name = newUser.Name
+		user.Email = newUser.Email
+		if newUser.Group != "" {
+			user.Groups = []string{newUser.Group}
+		}
+	})
+}
+
+// ChangePassword sets a new password after checking the current one
+func (lm *LocalManager) ChangePassword(username, oldPassword, newPassword string) error {
+	if _, err := lm.Authenticate(username, oldPassword); err != nil {
+		return err
+	}
+	return lm.SetPassword(username, newPassword)
+}
+
+// ResetPassword sets a new password without knowing the current one, for admins and reset links
+func (lm *LocalManager) ResetPassword(username, newPassword string) error {
+	err := lm.SetPassword(username, newPassword)
+	if err == ErrUserNotFound {
+		return auth.ErrUnknownUser
+	}
+	return err
+}
+
+func (lm *LocalManager) SetEmail(username, email string) error {
+	if email != "" {
+		if _, err := mail.ParseAddress(email); err != nil {
+			return fmt.Errorf("Invalid email address: %v", err)
+		}
+	}
+	return lm.updateUser(username, func(user *User) {
+		user.Email = email
+	})
+}
+
+func (lm *LocalManager) UserEmail(username string) (string, error) {
+	user, err := lm.GetUser(username)
+	if err == ErrUserNotFound {
+		return "", auth.ErrUnknownUser
+	}
+	if err != nil {
+		return "", err
+	}
+	return user.Email, nil
+}
+
+func (lm *LocalManager) SetAdmin(username string, isAdmin bool) error {
+	return lm.updateUser(username, func(user *User) {
+		user.IsAdmin = isAdmin
+	})
+}
+
+// SetGroups replaces the groups a user belongs to, which roles are mapped from
+func (lm *LocalManager) SetGroups(username string, groups []string) error {
+	return lm.updateUser(username, func(user *User) {
+		user.Groups = groups
+	})
+}
+
+// AddUserToGroup adds a group to the user's groups unless they already have it
+func (lm *LocalManager) AddUserToGroup(username, group string) error {
+	err := lm.updateUser(username, func(user *User) {
+		for _, g := range user.Groups {
+			if strings.EqualFold(g, group) {
+				return
+			}
+		}
+		user.Groups = append(user.Groups, group)
+	})
+	if err == ErrUserNotFound {
+		return auth.ErrUnknownUser
+	}
+	return err
+}
+
+func (lm *LocalManager) UserGroups(username string) ([]string, error) {
+	user, err := lm.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	return user.Groups, nil
+}
+
+func (lm *LocalManager) DeleteUser(username string) error {
+	err := lm.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		if b.Get(userKey(username)) == nil {
+			return ErrUserNotFound
+		}
+		return b.Delete(userKey(username))
+	})
+	if err == ErrUserNotFound {
+		return auth.ErrUnknownUser
+	}
+	return err
+}
+
+// SetUserDisabled disables or re-enables an account. Disabled users keep their data but cannot log in.
+func (lm *LocalManager) SetUserDisabled(username string, disabled bool) error {
+	err := lm.updateUser(username, func(user *User) {
+		user.Disabled = disabled
+	})
+	if err == ErrUserNotFound {
+		return auth.ErrUnknownUser
+	}
+	return err
+}
+
+func (lm *LocalManager) ListUsers() ([]auth.UserInfo, error) {
+	users := []auth.UserInfo{}
+	err := lm.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			var user User
+			if err := json.Unmarshal(v, &user); err != nil {
+				return fmt.Errorf("Failed to decode user %s: %v", k, err)
+			}
+			users = append(users, user.info())
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (lm *LocalManager) GetUserInfo(username string) (*auth.UserInfo, error) {
+	user, err := lm.GetUser(username)
+	if err == ErrUserNotFound {
+		return nil, auth.ErrUnknownUser
+	}
+	if err != nil {
+		return nil, err
+	}
+	info := user.info()
+	return &info, nil
+}
+
+func (user *User) info() auth.UserInfo {
+	groups := user.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	return auth.UserInfo{
+		Username: user.Username,
+		Name:     user.Name,
+		Email:    user.Email,
+		Groups:   groups,
+		Disabled: user.Disabled,
+		IsAdmin:  user.IsAdmin,
+	}
+}
+
+// IsAdmin reads the admin flag from the database so demoted admins lose access straight away
+func (lm *LocalManager) IsAdmin(c *gin.Context) {
+	user := handlers.GetUser(c)
+	if user == "" {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		c.Abort()
+		return
+	}
+
+	isAdmin, err := lm.IsAdminReq(user)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		c.Abort()
+		return
+	}
+
+	if !isAdmin {
+		c.String(http.StatusForbidden, "Forbidden")
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+func (lm *LocalManager) IsAdminReq(username string) (bool, error) {
+	user, err := lm.GetUser(username)
+	if err != nil {
+		return false, err
+	}
+	return user.IsAdmin, nil
+}
+
+func (lm *LocalManager) updateUser(username string, fn func(user *User)) error {
+	return lm.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		user, err := getUser(b, username)
+		if err != nil {
+			return err
+		}
+		fn(user)
+		return putUser(b, user)
+	})
+}
+
+func userKey(username string) []byte {
+	return []byte(strings.ToLower(username))
+}
+
+func getUser(b *bolt.Bucket, username string) (*User, error) {
+	data := b.Get(userKey(username))
+	if data == nil {
+		return nil, ErrUserNotFound
+	}
+
+	var user User
+	if err := json.Unmarshal(data, &user); err != nil {
+		return nil, fmt.Errorf("Failed to decode user %s: %v", username, err)
+	}
+	return &user, nil
+}
+
+func putUser(b *bolt.Bucket, user *User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return b.Put(userKey(user.Username), data)
+}
@@ -0,0 +1,211 @@
+package local
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goclone/internal/auth"
+	"goclone/internal/config"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func newTestManager(t *testing.T, conf config.LocalProvider) *LocalManager {
+	conf.DBPath = filepath.Join(t.TempDir(), "users.db")
+	lm, err := NewLocalManager(conf, noop.NewTracerProvider().Tracer("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lm.Close() })
+	return lm
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	lm := newTestManager(t, config.LocalProvider{BootstrapAdmin: "admin", BootstrapPassword: "changeme1"})
+
+	isAdmin, err := lm.IsAdminReq("admin")
+	if err != nil || !isAdmin {
+		t.Fatalf("bootstrap admin missing: %v %v", isAdmin, err)
+	}
+
+	// a changed password survives the next bootstrap
+	if err := lm.SetPassword("admin", "rotated99"); err != nil {
+		t.Fatal(err)
+	}
+	if err := lm.bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := lm.LoginReq("admin", "rotated99"); user == nil {
+		t.Error("bootstrap should not reset an existing admin")
+	}
+}
+
+func TestRegisterUserRules(t *testing.T) {
+	lm := newTestManager(t, config.LocalProvider{})
+
+	cases := []struct {
+		username, password string
+		ok                 bool
+	}{
+		{"student1", "password1", true},
+		{"Student1", "password1", false},
+		{"", "password1", false},
+		{"averyveryverylongusername", "password1", false},
+		{"bad name", "password1", false},
+		{"student2", "short1", false},
+		{"student3", "lettersonly", false},
+		{"student4", "12345678", false},
+	}
+	for _, tc := range cases {
+		err := lm.RegisterUserReq(map[string]interface{}{"username": tc.username, "password": tc.password})
+		if (err == nil) != tc.ok {
+			t.Errorf("RegisterUserReq(%q, %q) = %v, want ok=%v", tc.username, tc.password, err, tc.ok)
+		}
+	}
+
+	if user, _ := lm.LoginReq("STUDENT1", "password1"); user == nil || user.Username != "student1" || user.IsAdmin {
+		t.Errorf("unexpected login result %+v", user)
+	}
+	if user, _ := lm.LoginReq("student1", "wrong-password1"); user != nil {
+		t.Error("wrong password accepted")
+	}
+	if user, err := lm.LoginReq("nobody", "password1"); user != nil || err != nil {
+		t.Errorf("unknown user should fail without error, got %+v %v", user, err)
+	}
+}
+
+func TestLoginAndIsAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lm := newTestManager(t, config.LocalProvider{BootstrapAdmin: "admin", BootstrapPassword: "changeme1"})
+	if err := lm.CreateUser("student", "password1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(sessions.Sessions("kamino", cookie.NewStore([]byte("kamino"))))
+	router.POST("/login", lm.Login)
+	router.GET("/admin", lm.IsAdmin, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	login := func(username, password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body := `{"username":"` + username + `","password":"` + password + `"}`
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body)))
+		return w
+	}
+	admin := func(cookie string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		req.Header.Set("Cookie", cookie)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if w := login("student", "nope"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected bad password to be rejected, got %d", w.Code)
+	}
+
+	w := login("student", "password1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("student login failed: %d", w.Code)
+	}
+	studentCookie := w.Header().Get("Set-Cookie")
+	if code := admin(studentCookie); code != http.StatusForbidden {
+		t.Errorf("student should not pass IsAdmin, got %d", code)
+	}
+
+	w = login("admin", "changeme1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("admin login failed: %d", w.Code)
+	}
+	if code := admin(w.Header().Get("Set-Cookie")); code != http.StatusOK {
+		t.Errorf("admin should pass IsAdmin, got %d", code)
+	}
+
+	// promotion takes effect without logging in again
+	if err := lm.SetAdmin("student", true); err != nil {
+		t.Fatal(err)
+	}
+	if code := admin(studentCookie); code != http.StatusOK {
+		t.Errorf("promoted student should pass IsAdmin, got %d", code)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	lm := newTestManager(t, config.LocalProvider{})
+	if err := lm.CreateUser("student", "password1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lm.ChangePassword("student", "wrong1234", "password2"); err != auth.ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if err := lm.ChangePassword("student", "password1", "short"); err != auth.ErrWeakPassword {
+		t.Errorf("expected ErrWeakPassword, got %v", err)
+	}
+	if err := lm.ChangePassword("student", "password1", "password2"); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := lm.LoginReq("student", "password2"); user == nil {
+		t.Error("new password should log in")
+	}
+
+	if err := lm.ResetPassword("nobody", "password3"); err != auth.ErrUnknownUser {
+		t.Errorf("expected ErrUnknownUser, got %v", err)
+	}
+	if err := lm.ResetPassword("Student", "password3"); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := lm.LoginReq("student", "password3"); user == nil {
+		t.Error("reset password should log in")
+	}
+}
+
+func TestDisableAndDeleteUser(t *testing.T) {
+	lm := newTestManager(t, config.LocalProvider{})
+	if err := lm.CreateUser("student", "password1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lm.SetUserDisabled("student", true); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := lm.LoginReq("student", "password1"); user != nil {
+		t.Error("disabled user should not log in")
+	}
+	if _, err := lm.Authenticate("student", "password1"); err != auth.ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	users, err := lm.ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || !users[0].Disabled {
+		t.Errorf("expected one disabled user, got %+v", users)
+	}
+
+	if err := lm.SetUserDisabled("student", false); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := lm.LoginReq("student", "password1"); user == nil {
+		t.Error("enabled user should log in")
+	}
+
+	if err := lm.DeleteUser("student"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lm.GetUserInfo("student"); err != auth.ErrUnknownUser {
+		t.Errorf("expected ErrUnknownUser, got %v", err)
+	}
+	if err := lm.SetUserDisabled("student", true); err != auth.ErrUnknownUser {
+		t.Errorf("expected ErrUnknownUser, got %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+package lockout
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"goclone/internal/config"
+)
+
+const (
+	defaultMaxAttempts   = 5
+	defaultIPMaxAttempts = 50
+	defaultBaseDelay     = time.Second
+	defaultMaxDelay      = 30 * time.Second
+	defaultDuration      = 15 * time.Minute
+	defaultWindow        = 15 * time.Minute
+)
+
+var ErrNotFound = fmt.Errorf("No failed logins recorded")
+
+type Kind string
+
+const (
+	KindUser Kind = "user"
+	KindIP   Kind = "ip"
+	// KindMFA counts wrong MFA codes of a username, apart from its wrong passwords
+	KindMFA Kind = "mfa"
+)
+
+// Entry is the failed login history of a username or client address
+type Entry struct {
+	Kind        Kind      `json:"kind"`
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"lastFailure"`
+	// NextAttempt is when the backoff after the last failure ends
+	NextAttempt time.Time `json:"nextAttempt"`
+	LockedUntil time.Time `json:"lockedUntil,omitempty"`
+}
+
+// Locked reports whether the entry is locked out at now
+func (e *Entry) Locked(now time.Time) bool {
+	return now.Before(e.LockedUntil)
+}
+
+// Guard tracks failed logins in memory. Usernames back off exponentially after each failure and are locked
+// once they reach MaxAttempts; client addresses are only locked, at IPMaxAttempts, so a shared address is not
+// slowed down by a single user mistyping. Only one attempt per username runs at a time, so parallel requests
+// cannot get around the backoff.
+type Guard struct {
+	conf      config.Lockout
+	audit     *log.Logger
+	auditFile *os.File
+
+	mu       sync.Mutex
+	entries  map[string]*Entry
+	inFlight map[string]bool
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewGuard(conf config.Lockout) (*Guard, error) {
+	if conf.MaxAttempts <= 0 {
+		conf.MaxAttempts = defaultMaxAttempts
+	}
+	if conf.IPMaxAttempts <= 0 {
+		conf.IPMaxAttempts = defaultIPMaxAttempts
+	}
+	if conf.BaseDelay <= 0 {
+		conf.BaseDelay = defaultBaseDelay
+	}
+	if conf.MaxDelay <= 0 {
+		conf.MaxDelay = defaultMaxDelay
+	}
+	if conf.Duration <= 0 {
+		conf.Duration = defaultDuration
+	}
+	if conf.Window <= 0 {
+		conf.Window = defaultWindow
+	}
+
+	g := &Guard{
+		conf:     conf,
+		audit:    log.Default(),
+		entries:  map[string]*Entry{},
+		inFlight: map[string]bool{},
+		stop:     make(chan struct{}),
+	}
+	if conf.AuditLog != "" {
+		f, err := os.OpenFile(conf.AuditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open audit log: %v", err)
+		}
+		g.auditFile = f
+		g.audit = log.New(f, "", log.LstdFlags)
+	}
+
+	go g.prune(conf.Window)
+	return g, nil
+}
+
+func (g *Guard) Close() error {
+	var err error
+	g.closeOnce.Do(func() {
+		close(g.stop)
+		if g.auditFile != nil {
+			err = g.auditFile.Close()
+		}
+	})
+	return err
+}
+
+// Begin is called before checking a password. It returns how long the caller has to wait when the
+// username or address is locked or backing off, or when another attempt for the username is running.
+// Every Begin that returns zero must be followed by Finish.
+func (g *Guard) Begin(username, ip string) time.Duration {
+	return g.begin(KindUser, username, ip)
+}
+
+// BeginMFA is Begin for the code asked for after the password, followed by FinishMFA. Wrong codes are
+// counted apart from wrong passwords, so logging in with the password again does not reset them.
+func (g *Guard) BeginMFA(username, ip string) time.Duration {
+	return g.begin(KindMFA, username, ip)
+}
+
+func (g *Guard) begin(kind Kind, username, ip string) time.Duration {
+	now := time.Now()
+	userKey, ipKey := key(kind, username), key(KindIP, ip)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var wait time.Duration
+	for _, k := range []string{userKey, ipKey} {
+		entry, ok := g.entries[k]
+		if !ok {
+			continue
+		}
+		if entry.Locked(now) {
+			wait = max(wait, entry.LockedUntil.Sub(now))
+		} else if now.Before(entry.NextAttempt) {
+			wait = max(wait, entry.NextAttempt.Sub(now))
+		}
+	}
+	if wait == 0 && g.inFlight[userKey] {
+		wait = g.conf.BaseDelay
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	g.inFlight[userKey] = true
+	return 0
+}
+
+// Finish records the outcome of an attempt started with Begin. A successful login clears the username's
+// failures, but not the address's, since one right password says little about the other guesses from there.
+func (g *Guard) Finish(username, ip string, success bool) {
+	g.finish(KindUser, username, ip, success)
+}
+
+// FinishMFA records the outcome of an attempt started with BeginMFA. Only a right code clears the wrong ones.
+func (g *Guard) FinishMFA(username, ip string, success bool) {
+	g.finish(KindMFA, username, ip, success)
+}
+
+func (g *Guard) finish(kind Kind, username, ip string, success bool) {
+	now := time.Now()
+	userKey := key(kind, username)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.inFlight, userKey)
+	if success {
+		delete(g.entries, userKey)
+		return
+	}
+
+	user := g.fail(kind, username, now)
+	if user.Failures >= g.conf.MaxAttempts {
+		g.lock(user, ip, now)
+	} else {
+		user.NextAttempt = now.Add(min(g.conf.BaseDelay<<(user.Failures-1), g.conf.MaxDelay))
+	}
+
+	addr := g.fail(KindIP, ip, now)
+	if addr.Failures >= g.conf.IPMaxAttempts {
+		g.lock(addr, ip, now)
+	}
+}
+
+// Cancel ends an attempt started with Begin without counting it, e.g. when the request was malformed
+func (g *Guard) Cancel(username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.inFlight, key(KindUser, username))
+}
+
+// CancelMFA ends an attempt started with BeginMFA without counting it
+func (g *Guard) CancelMFA(username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.inFlight, key(KindMFA, username))
+}
+
+// fail counts a failure, starting over when the previous one is older than the window
+func (g *Guard) fail(kind Kind, value string, now time.Time) *Entry {
+	k := key(kind, value)
+	entry, ok := g.entries[k]
+	if !ok || (!entry.Locked(now) && now.Sub(entry.LastFailure) > g.conf.Window) {
+		entry = &Entry{Kind: kind, Key: strings.ToLower(value)}
+		g.entries[k] = entry
+	}
+	entry.Failures++
+	entry.LastFailure = now
+	return entry
+}
+
+func (g *Guard) lock(entry *Entry, ip string, now time.Time) {
+	if entry.Locked(now) {
+		return
+	}
+	entry.LockedUntil = now.Add(g.conf.Duration)
+	entry.NextAttempt = entry.LockedUntil
+	g.audit.Printf("lockout: %s %s locked until %s after %d failed logins (last from %s)",
+		entry.Kind, entry.Key, entry.LockedUntil.Format(time.RFC3339), entry.Failures, ip)
+}
+
+// List returns the usernames and addresses with recent failures, locked ones first
+func (g *Guard) List() []Entry {
+	now := time.Now()
+
+	g.mu.Lock()
+	list := make([]Entry, 0, len(g.entries))
+	for _, entry := range g.entries {
+		list = append(list, *entry)
+	}
+	g.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Locked(now) != list[j].Locked(now) {
+			return list[i].Locked(now)
+		}
+		return list[i].LastFailure.After(list[j].LastFailure)
+	})
+	return list
+}
+
+// Unlock clears the failures of a username or address, lifting any lockout. Unlocking a username clears its
+// wrong MFA codes as well. by names the admin for the audit log.
+func (g *Guard) Unlock(kind Kind, value, by string) error {
+	kinds := []Kind{kind}
+	if kind == KindUser {
+		kinds = append(kinds, KindMFA)
+	}
+
+	found := false
+	for _, kind := range kinds {
+		k := key(kind, value)
+
+		g.mu.Lock()
+		entry, ok := g.entries[k]
+		delete(g.entries, k)
+		g.mu.Unlock()
+
+		if ok {
+			found = true
+			g.audit.Printf("lockout: %s %s unlocked by %s after %d failed logins", kind, entry.Key, by, entry.Failures)
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// prune forgets entries whose failures are older than the window until the guard is closed
+func (g *Guard) prune(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case now := <-ticker.C:
+			g.mu.Lock()
+			for k, entry := range g.entries {
+				if !entry.Locked(now) && now.Sub(entry.LastFailure) > g.conf.Window {
+					delete(g.entries, k)
+				}
+			}
+			g.mu.Unlock()
+		}
+	}
+}
+
+func key(kind Kind, value string) string {
+	return string(kind) + "/" + strings.ToLower(value)
+}
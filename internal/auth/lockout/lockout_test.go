@@ -0,0 +1,165 @@
+package lockout_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"goclone/internal/auth/lockout"
+	"goclone/internal/config"
+)
+
+func newGuard(t *testing.T, conf config.Lockout) *lockout.Guard {
+	guard, err := lockout.NewGuard(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { guard.Close() })
+	return guard
+}
+
+func fail(t *testing.T, guard *lockout.Guard, username, ip string) {
+	t.Helper()
+	if wait := guard.Begin(username, ip); wait > 0 {
+		t.Fatalf("attempt for %s from %s should be allowed, got wait %s", username, ip, wait)
+	}
+	guard.Finish(username, ip, false)
+}
+
+func TestBackoffAndLockout(t *testing.T) {
+	auditLog := filepath.Join(t.TempDir(), "audit.log")
+	guard := newGuard(t, config.Lockout{
+		MaxAttempts: 3,
+		BaseDelay:   20 * time.Millisecond,
+		Duration:    time.Hour,
+		AuditLog:    auditLog,
+	})
+
+	fail(t, guard, "student", "10.0.0.1")
+	if wait := guard.Begin("Student", "10.0.0.2"); wait <= 0 || wait > 20*time.Millisecond {
+		t.Fatalf("expected the first backoff, got %s", wait)
+	}
+	time.Sleep(25 * time.Millisecond)
+
+	fail(t, guard, "student", "10.0.0.1")
+	if wait := guard.Begin("student", "10.0.0.1"); wait <= 20*time.Millisecond {
+		t.Fatalf("expected the backoff to double, got %s", wait)
+	}
+	time.Sleep(45 * time.Millisecond)
+
+	fail(t, guard, "student", "10.0.0.1")
+	if wait := guard.Begin("student", "10.0.0.3"); wait < 59*time.Minute {
+		t.Fatalf("expected the username to be locked, got %s", wait)
+	}
+	if wait := guard.Begin("other", "10.0.0.1"); wait != 0 {
+		t.Fatalf("other users from the same address should not wait, got %s", wait)
+	}
+	guard.Finish("other", "10.0.0.1", true)
+
+	list := guard.List()
+	if len(list) != 2 || list[0].Kind != lockout.KindUser || list[0].Key != "student" || !list[0].Locked(time.Now()) {
+		t.Fatalf("expected the locked username first, got %+v", list)
+	}
+
+	if err := guard.Unlock(lockout.KindUser, "STUDENT", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := guard.Unlock(lockout.KindUser, "student", "admin"); err != lockout.ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if wait := guard.Begin("student", "10.0.0.1"); wait != 0 {
+		t.Fatalf("unlocked user should not wait, got %s", wait)
+	}
+	guard.Finish("student", "10.0.0.1", true)
+
+	data, err := os.ReadFile(auditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "user student locked until") || !strings.Contains(string(data), "user student unlocked by admin") {
+		t.Errorf("lockout and unlock should be audit logged, got %q", data)
+	}
+}
+
+func TestSuccessClearsUsername(t *testing.T) {
+	guard := newGuard(t, config.Lockout{MaxAttempts: 2, BaseDelay: time.Millisecond})
+
+	fail(t, guard, "student", "10.0.0.1")
+	time.Sleep(2 * time.Millisecond)
+	if wait := guard.Begin("student", "10.0.0.1"); wait != 0 {
+		t.Fatalf("backoff should be over, got %s", wait)
+	}
+	guard.Finish("student", "10.0.0.1", true)
+
+	fail(t, guard, "student", "10.0.0.1")
+	if wait := guard.Begin("student", "10.0.0.1"); wait > time.Millisecond {
+		t.Errorf("a success should reset the count, got wait %s", wait)
+	}
+}
+
+func TestMFACountedApart(t *testing.T) {
+	guard := newGuard(t, config.Lockout{MaxAttempts: 3, BaseDelay: time.Millisecond, Duration: time.Hour})
+
+	// logging in with the password between wrong codes does not reset them
+	for i := 0; i < 3; i++ {
+		if wait := guard.Begin("student", "10.0.0.1"); wait != 0 {
+			t.Fatalf("password login should be allowed, got wait %s", wait)
+		}
+		guard.Finish("student", "10.0.0.1", true)
+
+		time.Sleep(5 * time.Millisecond)
+		if wait := guard.BeginMFA("student", "10.0.0.1"); wait != 0 {
+			t.Fatalf("code %d should be allowed, got wait %s", i+1, wait)
+		}
+		guard.FinishMFA("student", "10.0.0.1", false)
+	}
+	if wait := guard.BeginMFA("student", "10.0.0.1"); wait < 59*time.Minute {
+		t.Fatalf("expected the codes to be locked, got %s", wait)
+	}
+
+	if err := guard.Unlock(lockout.KindUser, "student", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if wait := guard.BeginMFA("student", "10.0.0.1"); wait != 0 {
+		t.Errorf("unlocking the user should lift the MFA lockout, got %s", wait)
+	}
+}
+
+func TestAddressLockout(t *testing.T) {
+	guard := newGuard(t, config.Lockout{IPMaxAttempts: 3, Duration: time.Hour})
+
+	for _, username := range []string{"a", "b", "c"} {
+		fail(t, guard, username, "10.0.0.1")
+	}
+	if wait := guard.Begin("d", "10.0.0.1"); wait < 59*time.Minute {
+		t.Fatalf("expected the address to be locked, got %s", wait)
+	}
+	if wait := guard.Begin("d", "10.0.0.2"); wait != 0 {
+		t.Fatalf("other addresses should not wait, got %s", wait)
+	}
+	guard.Cancel("d")
+
+	if err := guard.Unlock(lockout.KindIP, "10.0.0.1", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if wait := guard.Begin("d", "10.0.0.1"); wait != 0 {
+		t.Errorf("unlocked address should not wait, got %s", wait)
+	}
+}
+
+func TestOneAttemptAtATime(t *testing.T) {
+	guard := newGuard(t, config.Lockout{})
+
+	if wait := guard.Begin("student", "10.0.0.1"); wait != 0 {
+		t.Fatalf("first attempt should be allowed, got %s", wait)
+	}
+	if wait := guard.Begin("student", "10.0.0.2"); wait == 0 {
+		t.Fatal("a parallel attempt for the same username should wait")
+	}
+	guard.Cancel("student")
+	if wait := guard.Begin("student", "10.0.0.2"); wait != 0 {
+		t.Errorf("attempt after the first finished should be allowed, got %s", wait)
+	}
+}
@@ -0,0 +1,398 @@
+package mfa
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"math/big"
+	"strings"
+	"time"
+
+	"goclone/internal/config"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultIssuer = "goclone"
+
+	period            = 30
+	skew              = 1
+	recoveryCodeCount = 10
+	recoveryAlphabet  = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+var enrollmentsBucket = []byte("enrollments")
+
+var (
+	ErrNotEnrolled     = fmt.Errorf("MFA is not enabled for this account")
+	ErrAlreadyEnrolled = fmt.Errorf("MFA is already enabled for this account")
+	ErrInvalidCode     = fmt.Errorf("Invalid code")
+)
+
+// Enrollment is a user's TOTP secret, sealed with the configured secret. It only protects logins once
+// the user confirmed it with a code, so a half finished enrollment cannot lock anyone out.
+type Enrollment struct {
+	Username  string `json:"username"`
+	Sealed    []byte `json:"sealed"`
+	Confirmed bool   `json:"confirmed"`
+	// RecoveryCodes are sha256 hashes of the unused recovery codes
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+	// LastCounter is the time step of the last accepted code, so a code cannot be used twice
+	LastCounter int64     `json:"lastCounter"`
+	Created     time.Time `json:"created"`
+}
+
+// Key is handed to the user when they enroll, for adding the account to an authenticator app
+type Key struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"`
+	// QRCode is the URL as a PNG data URI
+	QRCode string `json:"qrCode"`
+}
+
+// Manager keeps TOTP enrollments in a bbolt database
+type Manager struct {
+	db            *bolt.DB
+	aead          cipher.AEAD
+	issuer        string
+	requiredRoles []string
+}
+
+func NewManager(conf config.MFA) (*Manager, error) {
+	if conf.DBPath == "" {
+		return nil, fmt.Errorf("MFA needs a db_path")
+	}
+	if conf.Secret == "" {
+		return nil, fmt.Errorf("MFA needs a secret")
+	}
+
+	key := sha256.Sum256([]byte(conf.Secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(conf.DBPath, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open MFA database: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(enrollmentsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to create MFA bucket: %v", err)
+	}
+
+	issuer := conf.Issuer
+	if issuer == "" {
+		issuer = defaultIssuer
+	}
+
+	return &Manager{db: db, aead: aead, issuer: issuer, requiredRoles: conf.RequiredRoles}, nil
+}
+
+func (m *Manager) Close() error {
+	return m.db.Close()
+}
+
+// Required reports whether any of the roles must use MFA
+func (m *Manager) Required(roles []string) bool {
+	for _, required := range m.requiredRoles {
+		for _, role := range roles {
+			if strings.EqualFold(required, role) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Enrolled reports whether the user has confirmed an enrollment
+func (m *Manager) Enrolled(username string) (bool, error) {
+	enrollment, err := m.get(username)
+	if err != nil {
+		return false, err
+	}
+	return enrollment != nil && enrollment.Confirmed, nil
+}
+
+// Enroll generates a new TOTP secret for the user, replacing any unconfirmed one.
+// It refuses users who already have MFA enabled; they have to disable it first.
+func (m *Manager) Enroll(username string) (*Key, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      m.issuer,
+		AccountName: username,
+		Period:      period,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate TOTP secret: %v", err)
+	}
+
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate QR code: %v", err)
+	}
+	var qr bytes.Buffer
+	if err := png.Encode(&qr, img); err != nil {
+		return nil, fmt.Errorf("Failed to generate QR code: %v", err)
+	}
+
+	sealed, err := m.seal(username, key.Secret())
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.update(username, func(enrollment *Enrollment) (*Enrollment, error) {
+		if enrollment != nil && enrollment.Confirmed {
+			return nil, ErrAlreadyEnrolled
+		}
+		return &Enrollment{Username: username, Sealed: sealed, Created: time.Now()}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{
+		Secret: key.Secret(),
+		URL:    key.URL(),
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(qr.Bytes()),
+	}, nil
+}
+
+// Confirm finishes an enrollment with a code from the authenticator app and returns the recovery codes,
+// which are only ever shown this once
+func (m *Manager) Confirm(username, code string) ([]string, error) {
+	codes, hashes, err := recoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.update(username, func(enrollment *Enrollment) (*Enrollment, error) {
+		if enrollment == nil {
+			return nil, ErrNotEnrolled
+		}
+		if enrollment.Confirmed {
+			return nil, ErrAlreadyEnrolled
+		}
+		if err := m.checkCode(enrollment, code); err != nil {
+			return nil, err
+		}
+		enrollment.Confirmed = true
+		enrollment.RecoveryCodes = hashes
+		return enrollment, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify checks a code from the authenticator app, or a recovery code which is used up by it
+func (m *Manager) Verify(username, code string) error {
+	return m.update(username, func(enrollment *Enrollment) (*Enrollment, error) {
+		if enrollment == nil || !enrollment.Confirmed {
+			return nil, ErrNotEnrolled
+		}
+
+		if err := m.checkCode(enrollment, code); err == nil {
+			return enrollment, nil
+		} else if err != ErrInvalidCode {
+			return nil, err
+		}
+
+		hash := hashRecoveryCode(code)
+		for i, stored := range enrollment.RecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+				enrollment.RecoveryCodes = append(enrollment.RecoveryCodes[:i], enrollment.RecoveryCodes[i+1:]...)
+				return enrollment, nil
+			}
+		}
+		return nil, ErrInvalidCode
+	})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, e.g. after running low
+func (m *Manager) RegenerateRecoveryCodes(username string) ([]string, error) {
+	codes, hashes, err := recoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.update(username, func(enrollment *Enrollment) (*Enrollment, error) {
+		if enrollment == nil || !enrollment.Confirmed {
+			return nil, ErrNotEnrolled
+		}
+		enrollment.RecoveryCodes = hashes
+		return enrollment, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RecoveryCodesLeft returns how many unused recovery codes the user has
+func (m *Manager) RecoveryCodesLeft(username string) (int, error) {
+	enrollment, err := m.get(username)
+	if err != nil || enrollment == nil {
+		return 0, err
+	}
+	return len(enrollment.RecoveryCodes), nil
+}
+
+// Disable removes the user's enrollment, confirmed or not
+func (m *Manager) Disable(username string) error {
+	return m.update(username, func(enrollment *Enrollment) (*Enrollment, error) {
+		if enrollment == nil {
+			return nil, ErrNotEnrolled
+		}
+		return nil, nil
+	})
+}
+
+// checkCode accepts a code for the current time step or the ones next to it, but never one at or
+// before the last accepted step
+func (m *Manager) checkCode(enrollment *Enrollment, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) != int(otp.DigitsSix) {
+		return ErrInvalidCode
+	}
+
+	secret, err := m.open(enrollment)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := -skew; i <= skew; i++ {
+		t := now.Add(time.Duration(i*period) * time.Second)
+		counter := t.Unix() / period
+		if counter <= enrollment.LastCounter {
+			continue
+		}
+
+		expected, err := totp.GenerateCodeCustom(secret, t, totp.ValidateOpts{
+			Period:    period,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to generate code: %v", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			enrollment.LastCounter = counter
+			return nil
+		}
+	}
+	return ErrInvalidCode
+}
+
+func (m *Manager) seal(username, secret string) ([]byte, error) {
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return m.aead.Seal(nonce, nonce, []byte(secret), []byte(strings.ToLower(username))), nil
+}
+
+func (m *Manager) open(enrollment *Enrollment) (string, error) {
+	if len(enrollment.Sealed) < m.aead.NonceSize() {
+		return "", fmt.Errorf("MFA secret of %s cannot be decrypted", enrollment.Username)
+	}
+	nonce, sealed := enrollment.Sealed[:m.aead.NonceSize()], enrollment.Sealed[m.aead.NonceSize():]
+	secret, err := m.aead.Open(nil, nonce, sealed, []byte(strings.ToLower(enrollment.Username)))
+	if err != nil {
+		return "", fmt.Errorf("MFA secret of %s cannot be decrypted, was the secret changed? %v", enrollment.Username, err)
+	}
+	return string(secret), nil
+}
+
+func (m *Manager) get(username string) (*Enrollment, error) {
+	var enrollment *Enrollment
+	err := m.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(enrollmentsBucket).Get(enrollmentKey(username))
+		if data == nil {
+			return nil
+		}
+		enrollment = &Enrollment{}
+		return json.Unmarshal(data, enrollment)
+	})
+	return enrollment, err
+}
+
+// update runs fn on the user's enrollment, or nil when there is none, in one transaction.
+// The enrollment fn returns is stored, and returning nil deletes it.
+func (m *Manager) update(username string, fn func(enrollment *Enrollment) (*Enrollment, error)) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(enrollmentsBucket)
+		key := enrollmentKey(username)
+
+		var enrollment *Enrollment
+		if data := b.Get(key); data != nil {
+			enrollment = &Enrollment{}
+			if err := json.Unmarshal(data, enrollment); err != nil {
+				return fmt.Errorf("Failed to decode MFA enrollment of %s: %v", username, err)
+			}
+		}
+
+		enrollment, err := fn(enrollment)
+		if err != nil {
+			return err
+		}
+		if enrollment == nil {
+			return b.Delete(key)
+		}
+
+		data, err := json.Marshal(enrollment)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, data)
+	})
+}
+
+func enrollmentKey(username string) []byte {
+	return []byte(strings.ToLower(username))
+}
+
+// recoveryCodes generates a set of codes like k7m2p-x9qrt along with their hashes
+func recoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		for j := range b {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(recoveryAlphabet))))
+			if err != nil {
+				return nil, nil, fmt.Errorf("Failed to generate recovery code: %v", err)
+			}
+			b[j] = recoveryAlphabet[n.Int64()]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
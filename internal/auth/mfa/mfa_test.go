@@ -0,0 +1,98 @@
+package mfa_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"goclone/internal/auth/mfa"
+	"goclone/internal/config"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func newManager(t *testing.T, conf config.MFA) *mfa.Manager {
+	conf.DBPath = filepath.Join(t.TempDir(), "mfa.db")
+	manager, err := mfa.NewManager(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { manager.Close() })
+	return manager
+}
+
+func code(t *testing.T, secret string, at time.Time) string {
+	code, err := totp.GenerateCode(secret, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestEnrollAndVerify(t *testing.T) {
+	manager := newManager(t, config.MFA{Secret: "secret"})
+
+	key, err := manager.Enroll("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Secret == "" || key.URL == "" || key.QRCode == "" {
+		t.Fatalf("incomplete key %+v", key)
+	}
+	if enrolled, _ := manager.Enrolled("admin"); enrolled {
+		t.Fatal("enrollment should not count before it is confirmed")
+	}
+	if err := manager.Verify("admin", code(t, key.Secret, time.Now())); err != mfa.ErrNotEnrolled {
+		t.Errorf("expected ErrNotEnrolled, got %v", err)
+	}
+
+	if _, err := manager.Confirm("admin", "000000x"); err != mfa.ErrInvalidCode {
+		t.Errorf("expected ErrInvalidCode, got %v", err)
+	}
+	now := code(t, key.Secret, time.Now())
+	recovery, err := manager.Confirm("Admin", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovery) != 10 {
+		t.Fatalf("expected 10 recovery codes, got %d", len(recovery))
+	}
+	if _, err := manager.Enroll("admin"); err != mfa.ErrAlreadyEnrolled {
+		t.Errorf("expected ErrAlreadyEnrolled, got %v", err)
+	}
+
+	if err := manager.Verify("admin", now); err != mfa.ErrInvalidCode {
+		t.Errorf("a code should not be accepted twice, got %v", err)
+	}
+	if err := manager.Verify("admin", code(t, key.Secret, time.Now().Add(30*time.Second))); err != nil {
+		t.Errorf("the next code should be accepted for clock skew, got %v", err)
+	}
+
+	if err := manager.Verify("admin", recovery[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Verify("admin", recovery[0]); err != mfa.ErrInvalidCode {
+		t.Errorf("a recovery code should only work once, got %v", err)
+	}
+	if left, _ := manager.RecoveryCodesLeft("admin"); left != 9 {
+		t.Errorf("expected 9 recovery codes left, got %d", left)
+	}
+
+	if err := manager.Disable("admin"); err != nil {
+		t.Fatal(err)
+	}
+	if enrolled, _ := manager.Enrolled("admin"); enrolled {
+		t.Error("disabled user should not be enrolled")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	manager := newManager(t, config.MFA{Secret: "secret", RequiredRoles: []string{"admin"}})
+	if !manager.Required([]string{"student", "Admin"}) || manager.Required([]string{"student"}) {
+		t.Error("required roles not matched")
+	}
+
+	if _, err := mfa.NewManager(config.MFA{DBPath: filepath.Join(t.TempDir(), "mfa.db")}); err == nil {
+		t.Error("expected a missing secret to be refused")
+	}
+}
@@ -0,0 +1,252 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"goclone/internal/auth"
+	"goclone/internal/auth/sessionstore"
+	"goclone/internal/config"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+)
+
+// session keys holding the in-flight authorization request between Login and Callback
+const (
+	stateKey    = "oidcState"
+	nonceKey    = "oidcNonce"
+	verifierKey = "oidcVerifier"
+)
+
+// OidcManager logs users in against an OpenID Connect issuer using the
+// authorization code flow with PKCE. Users and groups are managed by the
+// issuer, so registration is not supported.
+type OidcManager struct {
+	config   config.OidcProvider
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+	oauth    oauth2.Config
+	tracer   trace.Tracer
+}
+
+func NewOidcManager(conf config.OidcProvider, tracer trace.Tracer) (*OidcManager, error) {
+	if conf.UsernameClaim == "" {
+		conf.UsernameClaim = "preferred_username"
+	}
+	if conf.GroupsClaim == "" {
+		conf.GroupsClaim = "groups"
+	}
+	if conf.PostLoginRedirect == "" {
+		conf.PostLoginRedirect = "/"
+	}
+
+	provider, err := oidc.NewProvider(context.Background(), conf.IssuerURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to discover OIDC issuer")
+	}
+
+	scopes := conf.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
+	}
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+
+	return &OidcManager{
+		config:   conf,
+		provider: provider,
+		verifier: provider.Verifier(&oidc.Config{ClientID: conf.ClientID}),
+		oauth: oauth2.Config{
+			ClientID:     conf.ClientID,
+			ClientSecret: conf.ClientSecret,
+			RedirectURL:  conf.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		tracer: tracer,
+	}, nil
+}
+
+// Login starts the authorization request. Browsers are redirected to the issuer,
+// API clients posting to /login get the authorization URL back instead.
+func (om *OidcManager) Login(c *gin.Context) {
+	_, span := om.tracer.Start(c.Request.Context(), "GET /api/v1/login")
+	defer span.End()
+
+	state, err := randomString()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	session := sessions.Default(c)
+	session.Set(stateKey, state)
+	session.Set(nonceKey, nonce)
+	session.Set(verifierKey, verifier)
+	if err := session.Save(); err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	authURL := om.oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	if c.Request.Method == http.MethodGet {
+		c.Redirect(http.StatusFound, authURL)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"redirect": authURL})
+}
+
+// Callback completes the authorization request, verifies the ID token and populates the session
+func (om *OidcManager) Callback(c *gin.Context) {
+	ctx, span := om.tracer.Start(c.Request.Context(), "GET /api/v1/login/callback")
+	defer span.End()
+
+	if errMsg := c.Query("error"); errMsg != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": errMsg, "description": c.Query("error_description")})
+		return
+	}
+
+	session := sessions.Default(c)
+	state, _ := session.Get(stateKey).(string)
+	nonce, _ := session.Get(nonceKey).(string)
+	verifier, _ := session.Get(verifierKey).(string)
+	session.Delete(stateKey)
+	session.Delete(nonceKey)
+	session.Delete(verifierKey)
+
+	if state == "" || c.Query("state") != state {
+		c.String(http.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	token, err := om.oauth.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	idToken, err := om.verifier.Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != nonce {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// the username becomes part of pod names, so it has to pass the same rules as registered users
+	username, ok := claims[om.config.UsernameClaim].(string)
+	if !ok || auth.ValidateUsername(username) != nil {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	span.SetAttributes(attribute.String("username", username))
+
+	sessionstore.Renew(session)
+	session.Set("id", username)
+	session.Set("isAdmin", om.IsAdminClaims(claims))
+	session.Set("groups", stringsClaim(claims[om.config.GroupsClaim]))
+	if err := session.Save(); err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	c.Redirect(http.StatusFound, om.config.PostLoginRedirect)
+}
+
+// IsAdminClaims reports whether the claims carry the configured admin claim or one of the admin groups
+func (om *OidcManager) IsAdminClaims(claims map[string]interface{}) bool {
+	if om.config.AdminClaim != "" {
+		switch v := claims[om.config.AdminClaim].(type) {
+		case bool:
+			if v {
+				return true
+			}
+		case string:
+			if v == "true" {
+				return true
+			}
+		}
+	}
+
+	for _, group := range stringsClaim(claims[om.config.GroupsClaim]) {
+		if slices.Contains(om.config.AdminGroups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func (om *OidcManager) RegisterUser(c *gin.Context) {
+	c.JSON(http.StatusNotImplemented, gin.H{"error": "Users are registered with the identity provider"})
+}
+
+// IsAdmin relies on the admin flag set from the ID token at login, the issuer is not consulted again
+func (om *OidcManager) IsAdmin(c *gin.Context) {
+	session := sessions.Default(c)
+	if session.Get("id") == nil {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		c.Abort()
+		return
+	}
+
+	isAdmin, _ := session.Get("isAdmin").(bool)
+	if !isAdmin {
+		c.String(http.StatusForbidden, "Forbidden")
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+// stringsClaim accepts a claim holding either a single string or a list of strings
+func stringsClaim(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Failed to generate random value: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
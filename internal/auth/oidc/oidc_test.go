@@ -0,0 +1,301 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"goclone/internal/auth/sessionstore"
+	"goclone/internal/config"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// mockIssuer is a minimal OpenID Connect issuer that signs in whichever user is set on it
+type mockIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims map[string]interface{}
+	codes  map[string]authRequest
+}
+
+type authRequest struct {
+	challenge string
+	nonce     string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, codes: map[string]authRequest{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                m.server.URL,
+			"authorization_endpoint":                m.server.URL + "/authorize",
+			"token_endpoint":                        m.server.URL + "/token",
+			"jwks_uri":                              m.server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+			http.Error(w, "missing PKCE challenge", http.StatusBadRequest)
+			return
+		}
+		code := q.Get("state") + "-code"
+		m.mu.Lock()
+		m.codes[code] = authRequest{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+		m.mu.Unlock()
+
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		values := redirect.Query()
+		values.Set("code", code)
+		values.Set("state", q.Get("state"))
+		redirect.RawQuery = values.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.mu.Lock()
+		req, ok := m.codes[r.Form.Get("code")]
+		delete(m.codes, r.Form.Get("code"))
+		claims := m.claims
+		m.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != req.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		idClaims := map[string]interface{}{
+			"iss":   m.server.URL,
+			"aud":   "goclone",
+			"sub":   "subject",
+			"nonce": req.nonce,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range claims {
+			idClaims[k] = v
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     m.sign(t, idClaims),
+		})
+	})
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (m *mockIssuer) signIn(claims map[string]interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.claims = claims
+}
+
+func TestOidcLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	issuer := newMockIssuer(t)
+
+	store, err := sessionstore.NewStore(config.Sessions{Secret: "kamino"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	router := gin.New()
+	router.Use(sessions.Sessions("kamino", store))
+	app := httptest.NewServer(router)
+	defer app.Close()
+
+	manager, err := NewOidcManager(config.OidcProvider{
+		IssuerURL:   issuer.server.URL,
+		ClientID:    "goclone",
+		RedirectURL: app.URL + "/api/v1/login/callback",
+		AdminGroups: []string{"kamino-admins"},
+	}, noop.NewTracerProvider().Tracer("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// groups are read from the ID token, asking for a groups scope is left to the config
+	if !slices.Equal(manager.oauth.Scopes, []string{"openid", "profile", "email"}) {
+		t.Errorf("unexpected default scopes %v", manager.oauth.Scopes)
+	}
+
+	router.GET("/api/v1/login", manager.Login)
+	router.GET("/api/v1/login/callback", manager.Callback)
+	router.GET("/", func(c *gin.Context) {
+		session := sessions.Default(c)
+		c.JSON(http.StatusOK, gin.H{"id": session.Get("id"), "isAdmin": session.Get("isAdmin")})
+	})
+	router.GET("/admin", manager.IsAdmin, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	login := func(claims map[string]interface{}) (*http.Client, map[string]interface{}) {
+		issuer.signIn(claims)
+		jar, _ := cookiejar.New(nil)
+		client := &http.Client{Jar: jar}
+
+		resp, err := client.Get(app.URL + "/api/v1/login")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("login flow ended with status %d", resp.StatusCode)
+		}
+		var session map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&session)
+		return client, session
+	}
+
+	client, session := login(map[string]interface{}{"preferred_username": "admin", "groups": []string{"kamino-admins"}})
+	if session["id"] != "admin" || session["isAdmin"] != true {
+		t.Errorf("unexpected admin session %v", session)
+	}
+	if resp, _ := client.Get(app.URL + "/admin"); resp.StatusCode != http.StatusOK {
+		t.Errorf("admin should pass IsAdmin, got %d", resp.StatusCode)
+	}
+
+	client, session = login(map[string]interface{}{"preferred_username": "student", "groups": "students"})
+	if session["id"] != "student" || session["isAdmin"] != false {
+		t.Errorf("unexpected user session %v", session)
+	}
+	if resp, _ := client.Get(app.URL + "/admin"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("user should be rejected by IsAdmin, got %d", resp.StatusCode)
+	}
+
+	// a callback that does not match the state stored in the session is rejected
+	resp, err := client.Get(app.URL + "/api/v1/login/callback?code=forged&state=forged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected forged callback to be rejected, got %d", resp.StatusCode)
+	}
+
+	// the session that carried the authorization request moves to a new ID once the user is signed in
+	issuer.signIn(map[string]interface{}{"preferred_username": "student"})
+	jar, _ := cookiejar.New(nil)
+	client = &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err = client.Get(app.URL + "/api/v1/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	appURL, _ := url.Parse(app.URL)
+	before := jar.Cookies(appURL)
+
+	client.CheckRedirect = nil
+	resp, err = client.Get(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	after := jar.Cookies(appURL)
+	if len(before) != 1 || len(after) != 1 || before[0].Value == after[0].Value {
+		t.Fatalf("expected the callback to change the session ID, got %v and %v", before, after)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, app.URL+"/", nil)
+	req.AddCookie(before[0])
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var replayed map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&replayed)
+	if replayed["id"] != nil {
+		t.Errorf("the session ID from before the login still works: %v", replayed)
+	}
+
+	// usernames end up in pod names and have to be valid ones
+	issuer.signIn(map[string]interface{}{"preferred_username": "jane_doe@example.com"})
+	resp, err = client.Get(app.URL + "/api/v1/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected an invalid username to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestIsAdminClaims(t *testing.T) {
+	om := &OidcManager{config: config.OidcProvider{AdminClaim: "kamino_admin", GroupsClaim: "groups", AdminGroups: []string{"admins"}}}
+
+	cases := []struct {
+		claims map[string]interface{}
+		admin  bool
+	}{
+		{map[string]interface{}{"kamino_admin": true}, true},
+		{map[string]interface{}{"kamino_admin": false}, false},
+		{map[string]interface{}{"groups": "admins"}, true},
+		{map[string]interface{}{"groups": []interface{}{"users", "admins"}}, true},
+		{map[string]interface{}{"groups": []interface{}{"users"}}, false},
+		{map[string]interface{}{}, false},
+	}
+	for _, tc := range cases {
+		if got := om.IsAdminClaims(tc.claims); got != tc.admin {
+			t.Errorf("IsAdminClaims(%v) = %v, want %v", tc.claims, got, tc.admin)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package provision
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+
+	"goclone/internal/auth"
+)
+
+// MaxRows bounds a single roster upload
+const MaxRows = 2000
+
+type Status string
+
+const (
+	StatusCreated     Status = "created"
+	StatusWouldCreate Status = "would_create"
+	StatusExists      Status = "exists"
+	StatusInvalid     Status = "invalid"
+	StatusFailed      Status = "failed"
+)
+
+// Row is one account read from a roster. Line is the line in the CSV file, for pointing at mistakes.
+type Row struct {
+	Line     int    `json:"line"`
+	Username string `json:"username"`
+	Name     string `json:"name,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Group    string `json:"group,omitempty"`
+}
+
+// Result reports what happened to a row. Password is only set for accounts that were created.
+type Result struct {
+	Row
+	Status   Status `json:"status"`
+	Password string `json:"password,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+var columns = []string{"username", "name", "email", "group"}
+
+// ParseRoster reads a CSV roster. The columns are username, name, email and an optional group in that order,
+// unless the first line is a header naming them, in which case they may come in any order.
+func ParseRoster(r io.Reader) ([]Row, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read roster: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("Roster is empty")
+	}
+
+	index := map[string]int{}
+	for i, name := range columns {
+		index[name] = i
+	}
+	start := 0
+	if containsFold(records[0], "username") {
+		index = map[string]int{}
+		for i, name := range records[0] {
+			index[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		start = 1
+	}
+
+	if len(records)-start > MaxRows {
+		return nil, fmt.Errorf("Roster has more than %d rows", MaxRows)
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := index[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []Row
+	for i, record := range records[start:] {
+		row := Row{
+			Line:     start + i + 1,
+			Username: field(record, "username"),
+			Name:     field(record, "name"),
+			Email:    field(record, "email"),
+			Group:    field(record, "group"),
+		}
+		if row == (Row{Line: row.Line}) {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Run creates an account with a random password for each row. With dryRun nothing is changed,
+// but every row is still checked so the report shows what a real run would do.
+func Run(provisioner auth.Provisioner, rows []Row, dryRun bool) []Result {
+	results := make([]Result, 0, len(rows))
+	seen := map[string]int{}
+	for _, row := range rows {
+		results = append(results, provisionRow(provisioner, row, dryRun, seen))
+	}
+	return results
+}
+
+func provisionRow(provisioner auth.Provisioner, row Row, dryRun bool, seen map[string]int) Result {
+	if err := validate(row); err != nil {
+		return Result{Row: row, Status: StatusInvalid, Error: err.Error()}
+	}
+
+	key := strings.ToLower(row.Username)
+	if line, dup := seen[key]; dup {
+		return Result{Row: row, Status: StatusInvalid, Error: fmt.Sprintf("Username already used on line %d", line)}
+	}
+	seen[key] = row.Line
+
+	exists, err := provisioner.UserExists(row.Username)
+	if err != nil {
+		return Result{Row: row, Status: StatusFailed, Error: err.Error()}
+	}
+	if exists {
+		return Result{Row: row, Status: StatusExists}
+	}
+	if dryRun {
+		return Result{Row: row, Status: StatusWouldCreate}
+	}
+
+	password, err := auth.RandomPassword()
+	if err != nil {
+		return Result{Row: row, Status: StatusFailed, Error: err.Error()}
+	}
+
+	err = provisioner.ProvisionUser(auth.NewUser{
+		Username: row.Username,
+		Name:     row.Name,
+		Email:    row.Email,
+		Password: password,
+		Group:    row.Group,
+	})
+	if err != nil {
+		return Result{Row: row, Status: StatusFailed, Error: err.Error()}
+	}
+	return Result{Row: row, Status: StatusCreated, Password: password}
+}
+
+func validate(row Row) error {
+	if err := auth.ValidateUsername(row.Username); err != nil {
+		return err
+	}
+	if row.Email != "" {
+		if _, err := mail.ParseAddress(row.Email); err != nil {
+			return fmt.Errorf("Invalid email address %q", row.Email)
+		}
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package provision_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goclone/internal/auth/local"
+	"goclone/internal/auth/provision"
+	"goclone/internal/config"
+
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const roster = `Email,Username,Name,Group
+ada@example.com,ada,Ada Lovelace,"cn=CS101,ou=Groups"
+,grace,Grace Hopper,
+bad@,bad name,Bad Row,
+,ADA,Duplicate,
+,existing,Already There,
+
+`
+
+func TestParseRoster(t *testing.T) {
+	rows, err := provision.ParseRoster(strings.NewReader(roster))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 {
+		t.Fatalf("expected 5 rows, got %d", len(rows))
+	}
+	if rows[0].Username != "ada" || rows[0].Email != "ada@example.com" || rows[0].Name != "Ada Lovelace" || rows[0].Line != 2 {
+		t.Errorf("header columns not mapped: %+v", rows[0])
+	}
+
+	rows, err = provision.ParseRoster(strings.NewReader("linus,Linus Torvalds,linus@example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[0].Username != "linus" || rows[0].Email != "linus@example.com" || rows[0].Line != 1 {
+		t.Errorf("positional columns not mapped: %+v", rows[0])
+	}
+}
+
+func TestRun(t *testing.T) {
+	lm, err := local.NewLocalManager(config.LocalProvider{DBPath: filepath.Join(t.TempDir(), "users.db")}, noop.NewTracerProvider().Tracer("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+	if err := lm.CreateUser("existing", "password1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := provision.ParseRoster(strings.NewReader(roster))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []provision.Status{
+		provision.StatusWouldCreate,
+		provision.StatusWouldCreate,
+		provision.StatusInvalid,
+		provision.StatusInvalid,
+		provision.StatusExists,
+	}
+	for i, result := range provision.Run(lm, rows, true) {
+		if result.Status != want[i] {
+			t.Errorf("dry run line %d: got %s, want %s (%s)", result.Line, result.Status, want[i], result.Error)
+		}
+		if result.Password != "" {
+			t.Errorf("dry run line %d should not generate a password", result.Line)
+		}
+	}
+	if exists, _ := lm.UserExists("ada"); exists {
+		t.Fatal("dry run should not create users")
+	}
+
+	want[0], want[1] = provision.StatusCreated, provision.StatusCreated
+	results := provision.Run(lm, rows, false)
+	for i, result := range results {
+		if result.Status != want[i] {
+			t.Errorf("line %d: got %s, want %s (%s)", result.Line, result.Status, want[i], result.Error)
+		}
+	}
+
+	ada := results[0]
+	if user, _ := lm.LoginReq("ada", ada.Password); user == nil {
+		t.Fatal("created user should log in with the generated password")
+	}
+	user, _ := lm.GetUser("ada")
+	if user.Email != "ada@example.com" || user.Name != "Ada Lovelace" || len(user.Groups) != 1 || user.Groups[0] != "cn=CS101,ou=Groups" {
+		t.Errorf("user details not stored: %+v", user)
+	}
+}
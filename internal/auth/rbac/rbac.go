@@ -0,0 +1,321 @@
+package rbac
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"goclone/internal/auth"
+	"goclone/internal/config"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+type Permission string
+
+const (
+	// All grants every permission
+	All Permission = "*"
+
+	PodsViewAll         Permission = "pods.view_all"
+	PodsBulkClone       Permission = "pods.bulk_clone"
+	PodsBulkDelete      Permission = "pods.bulk_delete"
+	PodsBulkRevert      Permission = "pods.bulk_revert"
+	PodsBulkPower       Permission = "pods.bulk_power"
+	OrphansManage       Permission = "orphans.manage"
+	TemplatesRefresh    Permission = "templates.refresh"
+	TemplatesViewHidden Permission = "templates.view_hidden"
+	TokensManage        Permission = "tokens.manage"
+	SessionsManage      Permission = "sessions.manage"
+	UsersManage         Permission = "users.manage"
+)
+
+// Permissions lists every permission a role can be given
+var Permissions = []Permission{
+	PodsViewAll,
+	PodsBulkClone,
+	PodsBulkDelete,
+	PodsBulkRevert,
+	PodsBulkPower,
+	OrphansManage,
+	TemplatesRefresh,
+	TemplatesViewHidden,
+	TokensManage,
+	SessionsManage,
+	UsersManage,
+}
+
+const (
+	defaultCacheTTL = time.Minute
+	grantsKey       = "rbacGrants"
+)
+
+// grants are the roles and permissions resolved for one request
+type grants struct {
+	roles       []string
+	permissions map[Permission]bool
+}
+
+func (g *grants) has(perm Permission) bool {
+	return g.permissions[All] || g.permissions[perm]
+}
+
+type cachedGroups struct {
+	groups  []string
+	isAdmin bool
+	expires time.Time
+}
+
+// Enforcer resolves a user's roles from their groups and checks route permissions.
+// Groups and admin status are looked up through the auth backend and cached briefly, so
+// changes in the directory apply to existing sessions once the cache expires.
+//
+// Without any roles configured the enforcer keeps the old behaviour: every permission
+// requires the auth manager's IsAdmin check to pass.
+type Enforcer struct {
+	roles       []config.Role
+	authManager auth.AuthManager
+	ttl         time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedGroups
+}
+
+func NewEnforcer(conf config.Auth, authManager auth.AuthManager) (*Enforcer, error) {
+	seen := map[string]bool{}
+	for _, role := range conf.Roles {
+		if role.Name == "" || seen[role.Name] {
+			return nil, fmt.Errorf("Role names must be unique and not empty, got %q", role.Name)
+		}
+		seen[role.Name] = true
+
+		for _, perm := range role.Permissions {
+			if Permission(perm) != All && !slices.Contains(Permissions, Permission(perm)) {
+				return nil, fmt.Errorf("Role %s has unknown permission %s", role.Name, perm)
+			}
+		}
+	}
+
+	ttl := conf.RoleCacheTTL
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+
+	return &Enforcer{
+		roles:       conf.Roles,
+		authManager: authManager,
+		ttl:         ttl,
+		cache:       map[string]cachedGroups{},
+	}, nil
+}
+
+// Enabled reports whether roles are configured
+func (e *Enforcer) Enabled() bool {
+	return len(e.roles) > 0
+}
+
+// Require lets the request through only if the user holds perm
+func (e *Enforcer) Require(perm Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !e.Enabled() {
+			e.authManager.IsAdmin(c)
+			return
+		}
+
+		g, err := e.load(c)
+		if err != nil {
+			fmt.Println(err)
+			c.String(http.StatusInternalServerError, "Internal Server Error")
+			c.Abort()
+			return
+		}
+
+		if !g.has(perm) {
+			c.String(http.StatusForbidden, "Forbidden")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Has reports whether the user holds perm, for handlers that change what they return rather than refusing.
+// Without roles configured only admins hold any permission.
+func (e *Enforcer) Has(c *gin.Context, perm Permission) bool {
+	if !e.Enabled() {
+		return e.isAdmin(c)
+	}
+
+	g, err := e.load(c)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return g.has(perm)
+}
+
+// Privileged reports whether the user holds any permission, i.e. may use at least one admin route
+func (e *Enforcer) Privileged(c *gin.Context) bool {
+	if !e.Enabled() {
+		return e.isAdmin(c)
+	}
+
+	g, err := e.load(c)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return len(g.permissions) > 0
+}
+
+// Roles returns the names of the caller's roles. Without roles configured admins get the role admin.
+func (e *Enforcer) Roles(c *gin.Context) ([]string, error) {
+	if !e.Enabled() {
+		if e.isAdmin(c) {
+			return []string{"admin"}, nil
+		}
+		return []string{}, nil
+	}
+
+	g, err := e.load(c)
+	if err != nil {
+		return nil, err
+	}
+	return g.roles, nil
+}
+
+// GetRoles returns the caller's roles and permissions so the frontend can show what they may do
+func (e *Enforcer) GetRoles(c *gin.Context) {
+	if !e.Enabled() {
+		roles, permissions := []string{}, []Permission{}
+		if e.isAdmin(c) {
+			roles, permissions = []string{"admin"}, Permissions
+		}
+		c.JSON(http.StatusOK, gin.H{"roles": roles, "permissions": permissions})
+		return
+	}
+
+	g, err := e.load(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting roles"})
+		return
+	}
+
+	permissions := []Permission{}
+	for _, perm := range Permissions {
+		if g.has(perm) {
+			permissions = append(permissions, perm)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": g.roles, "permissions": permissions})
+}
+
+// isAdmin reports whether the user is an admin, resolved and cached like their groups
+func (e *Enforcer) isAdmin(c *gin.Context) bool {
+	username, _ := sessions.Default(c).Get("id").(string)
+	_, isAdmin, err := e.groups(c, username)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return isAdmin
+}
+
+// load resolves the request's grants once and keeps them on the context
+func (e *Enforcer) load(c *gin.Context) (*grants, error) {
+	if g, ok := c.Get(grantsKey); ok {
+		return g.(*grants), nil
+	}
+
+	username, _ := sessions.Default(c).Get("id").(string)
+
+	groups, isAdmin, err := e.groups(c, username)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &grants{roles: []string{}, permissions: map[Permission]bool{}}
+	for _, role := range e.roles {
+		if !(role.BackendAdmins && isAdmin) && !containsFold(role.Groups, groups) {
+			continue
+		}
+		g.roles = append(g.roles, role.Name)
+		for _, perm := range role.Permissions {
+			g.permissions[Permission(perm)] = true
+		}
+	}
+
+	c.Set(grantsKey, g)
+	return g, nil
+}
+
+// groups asks the backend that authenticated the session for the user's groups and whether they are an
+// admin, or falls back to what was stored in the session at login for backends that cannot look them up later
+func (e *Enforcer) groups(c *gin.Context, username string) ([]string, bool, error) {
+	session := sessions.Default(c)
+	groups, _ := session.Get("groups").([]string)
+	isAdmin, _ := session.Get("isAdmin").(bool)
+
+	backend, ok := auth.SessionBackend(c, e.authManager)
+	groupResolver, resolvesGroups := backend.(auth.GroupResolver)
+	adminResolver, resolvesAdmin := backend.(auth.AdminResolver)
+	if username == "" || !ok || (!resolvesGroups && !resolvesAdmin) {
+		return groups, isAdmin, nil
+	}
+
+	name, _ := session.Get(auth.SessionBackendKey).(string)
+	key := name + "/" + strings.ToLower(username)
+
+	e.mu.Lock()
+	cached, found := e.cache[key]
+	e.mu.Unlock()
+	if found && time.Now().Before(cached.expires) {
+		return cached.groups, cached.isAdmin, nil
+	}
+
+	var err error
+	if resolvesGroups {
+		groups, err = groupResolver.UserGroups(username)
+		if err != nil {
+			return nil, false, fmt.Errorf("Failed to get groups for %s: %v", username, err)
+		}
+	}
+	if resolvesAdmin {
+		isAdmin, err = adminResolver.IsAdminReq(username)
+		if err != nil {
+			return nil, false, fmt.Errorf("Failed to get admin status for %s: %v", username, err)
+		}
+	}
+
+	e.mu.Lock()
+	e.cache[key] = cachedGroups{groups: groups, isAdmin: isAdmin, expires: time.Now().Add(e.ttl)}
+	e.mu.Unlock()
+	return groups, isAdmin, nil
+}
+
+// Invalidate drops the cached groups and admin status of a user so a role change applies on their next request
+func (e *Enforcer) Invalidate(username string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for key := range e.cache {
+		if _, user, _ := strings.Cut(key, "/"); user == strings.ToLower(username) {
+			delete(e.cache, key)
+		}
+	}
+}
+
+func containsFold(want, have []string) bool {
+	for _, w := range want {
+		for _, h := range have {
+			if strings.EqualFold(w, h) {
+				return true
+			}
+		}
+	}
+	return false
+}
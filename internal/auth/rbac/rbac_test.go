@@ -0,0 +1,198 @@
+package rbac_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"goclone/internal/auth/local"
+	"goclone/internal/auth/rbac"
+	"goclone/internal/config"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+var roles = []config.Role{
+	{Name: "student", Groups: []string{"cn=Students,ou=Groups"}},
+	{Name: "ta", Groups: []string{"cn=TAs,ou=Groups"}, Permissions: []string{"pods.view_all", "pods.bulk_power"}},
+	{Name: "instructor", Groups: []string{"cn=Instructors,ou=Groups"}, Permissions: []string{"pods.view_all", "pods.bulk_power", "pods.bulk_delete", "templates.refresh"}},
+	{Name: "admin", BackendAdmins: true, Permissions: []string{"*"}},
+}
+
+func setup(t *testing.T, conf config.Auth) (*local.LocalManager, *rbac.Enforcer, func(username string, perm rbac.Permission) int) {
+	gin.SetMode(gin.TestMode)
+
+	lm, err := local.NewLocalManager(config.LocalProvider{
+		DBPath:            filepath.Join(t.TempDir(), "users.db"),
+		BootstrapAdmin:    "admin",
+		BootstrapPassword: "password1",
+	}, noop.NewTracerProvider().Tracer("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lm.Close() })
+
+	enforcer, err := rbac.NewEnforcer(conf, lm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(sessions.Sessions("kamino", cookie.NewStore([]byte("kamino"))))
+	router.POST("/login", lm.Login)
+	for _, perm := range rbac.Permissions {
+		router.GET("/"+string(perm), enforcer.Require(perm), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		// handlers that only change what they return ask Has
+		router.GET("/has/"+string(perm), func(c *gin.Context) {
+			if !enforcer.Has(c, perm) {
+				c.Status(http.StatusForbidden)
+				return
+			}
+			c.Status(http.StatusOK)
+		})
+	}
+
+	cookies := map[string]string{}
+	check := func(username string, perm rbac.Permission) int {
+		if _, ok := cookies[username]; !ok {
+			w := httptest.NewRecorder()
+			body := `{"username":"` + username + `","password":"password1"}`
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("login as %s failed: %d", username, w.Code)
+			}
+			cookies[username] = w.Header().Get("Set-Cookie")
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/"+string(perm), nil)
+		req.Header.Set("Cookie", cookies[username])
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	return lm, enforcer, check
+}
+
+func TestRolePermissions(t *testing.T) {
+	lm, enforcer, check := setup(t, config.Auth{Roles: roles, RoleCacheTTL: time.Hour})
+	for username, groups := range map[string][]string{
+		"student":    {"cn=Students,ou=Groups"},
+		"ta":         {"cn=students,ou=groups", "cn=TAs,ou=Groups"},
+		"instructor": {"cn=Instructors,ou=Groups"},
+	} {
+		if err := lm.CreateUser(username, "password1", false); err != nil {
+			t.Fatal(err)
+		}
+		if err := lm.SetGroups(username, groups); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		username string
+		perm     rbac.Permission
+		status   int
+	}{
+		{"student", rbac.PodsViewAll, http.StatusForbidden},
+		{"ta", rbac.PodsViewAll, http.StatusOK},
+		{"ta", rbac.PodsBulkPower, http.StatusOK},
+		{"ta", rbac.PodsBulkDelete, http.StatusForbidden},
+		{"instructor", rbac.PodsBulkDelete, http.StatusOK},
+		{"instructor", rbac.TemplatesRefresh, http.StatusOK},
+		{"instructor", rbac.TokensManage, http.StatusForbidden},
+		{"admin", rbac.TokensManage, http.StatusOK},
+		{"admin", rbac.PodsBulkDelete, http.StatusOK},
+	}
+	for _, tc := range cases {
+		if status := check(tc.username, tc.perm); status != tc.status {
+			t.Errorf("%s requesting %s: got %d, want %d", tc.username, tc.perm, status, tc.status)
+		}
+	}
+
+	// promoting the student applies to the existing session once the cached groups are dropped
+	if err := lm.SetGroups("student", []string{"cn=Instructors,ou=Groups"}); err != nil {
+		t.Fatal(err)
+	}
+	if status := check("student", rbac.PodsBulkDelete); status != http.StatusForbidden {
+		t.Errorf("groups should be cached, got %d", status)
+	}
+	enforcer.Invalidate("student")
+	if status := check("student", rbac.PodsBulkDelete); status != http.StatusOK {
+		t.Errorf("promotion should apply without logging out, got %d", status)
+	}
+}
+
+func TestRoleCacheExpires(t *testing.T) {
+	lm, _, check := setup(t, config.Auth{Roles: roles, RoleCacheTTL: time.Millisecond})
+	if err := lm.CreateUser("student", "password1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := check("student", rbac.PodsViewAll); status != http.StatusForbidden {
+		t.Fatalf("student should not view all pods, got %d", status)
+	}
+	if err := lm.SetGroups("student", []string{"cn=TAs,ou=Groups"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if status := check("student", rbac.PodsViewAll); status != http.StatusOK {
+		t.Errorf("group change should apply after the cache expires, got %d", status)
+	}
+
+	// demoted admins lose the backend admin role without logging out
+	if status := check("admin", rbac.TokensManage); status != http.StatusOK {
+		t.Fatalf("admin should manage tokens, got %d", status)
+	}
+	if err := lm.SetAdmin("admin", false); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if status := check("admin", rbac.TokensManage); status != http.StatusForbidden {
+		t.Errorf("demotion should apply after the cache expires, got %d", status)
+	}
+}
+
+func TestWithoutRoles(t *testing.T) {
+	lm, _, check := setup(t, config.Auth{RoleCacheTTL: time.Millisecond})
+	if err := lm.CreateUser("student", "password1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := check("student", rbac.PodsViewAll); status != http.StatusForbidden {
+		t.Errorf("without roles only admins pass, got %d", status)
+	}
+	if status := check("student", "has/"+rbac.PodsViewAll); status != http.StatusForbidden {
+		t.Errorf("without roles only admins hold permissions, got %d", status)
+	}
+	if status := check("admin", rbac.PodsBulkDelete); status != http.StatusOK {
+		t.Errorf("without roles admins pass everything, got %d", status)
+	}
+	if status := check("admin", "has/"+rbac.PodsViewAll); status != http.StatusOK {
+		t.Errorf("without roles admins hold every permission, got %d", status)
+	}
+
+	// demoted admins lose their permissions without logging out
+	if err := lm.SetAdmin("admin", false); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if status := check("admin", "has/"+rbac.PodsViewAll); status != http.StatusForbidden {
+		t.Errorf("demotion should apply after the cache expires, got %d", status)
+	}
+}
+
+func TestUnknownPermission(t *testing.T) {
+	_, err := rbac.NewEnforcer(config.Auth{Roles: []config.Role{{Name: "ta", Permissions: []string{"pods.everything"}}}}, nil)
+	if err == nil {
+		t.Error("expected unknown permission to be rejected")
+	}
+}
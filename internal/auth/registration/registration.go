@@ -0,0 +1,457 @@
+package registration
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"goclone/internal/auth"
+	"goclone/internal/config"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type Mode string
+
+const (
+	ModeOpen     Mode = "open"
+	ModeInvite   Mode = "invite"
+	ModeApproval Mode = "approval"
+)
+
+var (
+	invitesBucket = []byte("invites")
+	pendingBucket = []byte("pending")
+)
+
+var (
+	ErrInviteRequired = fmt.Errorf("An invite code is required to register")
+	ErrInvalidInvite  = fmt.Errorf("Invalid or expired invite code")
+	ErrNotFound       = fmt.Errorf("Not found")
+	ErrPendingExists  = fmt.Errorf("A registration for this username is already waiting for approval")
+)
+
+// Invite lets a limited number of people register before it expires, optionally placing them in a group
+type Invite struct {
+	Code      string    `json:"code"`
+	CreatedBy string    `json:"createdBy"`
+	Role      string    `json:"role,omitempty"`
+	Group     string    `json:"group,omitempty"`
+	MaxUses   int       `json:"maxUses"` // 0 means unlimited
+	Uses      int       `json:"uses"`
+	Expires   time.Time `json:"expires"`
+	Created   time.Time `json:"created"`
+}
+
+// Pending is a registration waiting for an admin. The submitted form, password included, is kept encrypted.
+type Pending struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	Email      string    `json:"email,omitempty"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Requested  time.Time `json:"requested"`
+	Sealed     []byte    `json:"sealed,omitempty"`
+}
+
+// Gate sits in front of the auth manager's registration and decides whether a registration
+// goes through straight away, needs an invite code or waits for approval
+type Gate struct {
+	mode      Mode
+	db        *bolt.DB
+	registrar auth.Registrar
+	assigner  auth.GroupAssigner
+	roles     []config.Role
+	aead      cipher.AEAD
+}
+
+func NewGate(conf config.Registration, roles []config.Role, authManager auth.AuthManager) (*Gate, error) {
+	mode := Mode(strings.ToLower(conf.Mode))
+	switch mode {
+	case "":
+		mode = ModeOpen
+	case ModeOpen, ModeInvite, ModeApproval:
+	default:
+		return nil, fmt.Errorf("Unknown registration mode %q", conf.Mode)
+	}
+
+	registrar, ok := authManager.(auth.Registrar)
+	if !ok {
+		return nil, fmt.Errorf("The auth backend does not support registration")
+	}
+	if conf.DBPath == "" {
+		return nil, fmt.Errorf("Registration mode %s needs a db_path", mode)
+	}
+	if mode == ModeApproval && conf.Secret == "" {
+		return nil, fmt.Errorf("Registration mode approval needs a secret")
+	}
+
+	g := &Gate{mode: mode, registrar: registrar, roles: roles}
+	g.assigner, _ = authManager.(auth.GroupAssigner)
+
+	if conf.Secret != "" {
+		key := sha256.Sum256([]byte(conf.Secret))
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return nil, err
+		}
+		g.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := bolt.Open(conf.DBPath, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open registration database: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{invitesBucket, pendingBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to create registration buckets: %v", err)
+	}
+	g.db = db
+
+	return g, nil
+}
+
+func (g *Gate) Mode() Mode {
+	return g.mode
+}
+
+func (g *Gate) Close() error {
+	return g.db.Close()
+}
+
+// Validate applies the shared username and password rules before anything is stored or created
+func Validate(userInfo map[string]interface{}) error {
+	username, _ := userInfo["username"].(string)
+	if err := auth.ValidateUsername(username); err != nil {
+		return err
+	}
+	password, _ := userInfo["password"].(string)
+	if !auth.ValidatePassword(password) {
+		return auth.ErrWeakPassword
+	}
+	return nil
+}
+
+// Register creates the user, or queues the registration for approval and reports it as pending.
+// A valid invite code in the invite field always registers straight away.
+func (g *Gate) Register(userInfo map[string]interface{}, remoteAddr string) (pending bool, err error) {
+	if err := Validate(userInfo); err != nil {
+		return false, err
+	}
+	if !g.registrar.RegistrationEnabled() {
+		return false, fmt.Errorf("Registration is not available")
+	}
+
+	code, _ := userInfo["invite"].(string)
+	delete(userInfo, "invite")
+	if code != "" {
+		return false, g.registerWithInvite(code, userInfo)
+	}
+
+	switch g.mode {
+	case ModeInvite:
+		return false, ErrInviteRequired
+	case ModeApproval:
+		return true, g.addPending(userInfo, remoteAddr)
+	}
+	return false, g.registrar.RegisterUserReq(userInfo)
+}
+
+func (g *Gate) registerWithInvite(code string, userInfo map[string]interface{}) error {
+	invite, err := g.useInvite(code)
+	if err != nil {
+		return err
+	}
+
+	if err := g.registrar.RegisterUserReq(userInfo); err != nil {
+		// the use is given back so a typo in the form does not cost the invite
+		if releaseErr := g.releaseInvite(invite.Code); releaseErr != nil {
+			fmt.Println(releaseErr)
+		}
+		return err
+	}
+
+	if invite.Group == "" {
+		return nil
+	}
+	username, _ := userInfo["username"].(string)
+	if g.assigner == nil {
+		return fmt.Errorf("User %s was registered but the auth backend cannot add users to %s", username, invite.Group)
+	}
+	if err := g.assigner.AddUserToGroup(username, invite.Group); err != nil {
+		return fmt.Errorf("User %s was registered but could not be added to %s: %v", username, invite.Group, err)
+	}
+	return nil
+}
+
+// CreateInvite makes a new code. A role is turned into the first group it is mapped from, so the
+// invited user holds the role through their group like everyone else.
+func (g *Gate) CreateInvite(createdBy string, maxUses int, ttl time.Duration, group, role string) (*Invite, error) {
+	if maxUses < 0 {
+		return nil, fmt.Errorf("Max uses cannot be negative")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("Invites must expire")
+	}
+
+	if role != "" {
+		found := false
+		for _, r := range g.roles {
+			if r.Name == role && len(r.Groups) > 0 {
+				group, found = r.Groups[0], true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Role %s does not exist or is not mapped from a group", role)
+		}
+	}
+	if group != "" && g.assigner == nil {
+		return nil, fmt.Errorf("The auth backend cannot add users to groups")
+	}
+
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("Failed to generate invite code: %v", err)
+	}
+
+	now := time.Now()
+	invite := &Invite{
+		Code:      base32.StdEncoding.EncodeToString(b),
+		CreatedBy: createdBy,
+		Role:      role,
+		Group:     group,
+		MaxUses:   maxUses,
+		Expires:   now.Add(ttl),
+		Created:   now,
+	}
+
+	err := g.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(invitesBucket), invite.Code, invite)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+func (g *Gate) ListInvites() ([]Invite, error) {
+	invites := []Invite{}
+	err := g.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(invitesBucket).ForEach(func(k, v []byte) error {
+			var invite Invite
+			if err := json.Unmarshal(v, &invite); err != nil {
+				return err
+			}
+			invites = append(invites, invite)
+			return nil
+		})
+	})
+	return invites, err
+}
+
+func (g *Gate) DeleteInvite(code string) error {
+	return g.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(invitesBucket)
+		key := []byte(normalizeCode(code))
+		if b.Get(key) == nil {
+			return ErrNotFound
+		}
+		return b.Delete(key)
+	})
+}
+
+// useInvite checks the code and counts a use in one transaction, so a code cannot be used more often than allowed
+func (g *Gate) useInvite(code string) (*Invite, error) {
+	var invite Invite
+	err := g.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(invitesBucket)
+		data := b.Get([]byte(normalizeCode(code)))
+		if data == nil {
+			return ErrInvalidInvite
+		}
+		if err := json.Unmarshal(data, &invite); err != nil {
+			return err
+		}
+		if time.Now().After(invite.Expires) || (invite.MaxUses > 0 && invite.Uses >= invite.MaxUses) {
+			return ErrInvalidInvite
+		}
+		invite.Uses++
+		return put(b, invite.Code, &invite)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+func (g *Gate) releaseInvite(code string) error {
+	return g.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(invitesBucket)
+		data := b.Get([]byte(code))
+		if data == nil {
+			return nil
+		}
+		var invite Invite
+		if err := json.Unmarshal(data, &invite); err != nil {
+			return err
+		}
+		if invite.Uses > 0 {
+			invite.Uses--
+		}
+		return put(b, invite.Code, &invite)
+	})
+}
+
+func (g *Gate) addPending(userInfo map[string]interface{}, remoteAddr string) error {
+	username, _ := userInfo["username"].(string)
+	email, _ := userInfo["email"].(string)
+
+	form, err := json.Marshal(userInfo)
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("Failed to generate registration ID: %v", err)
+	}
+	id := hex.EncodeToString(b)
+
+	nonce := make([]byte, g.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	pending := &Pending{
+		ID:         id,
+		Username:   username,
+		Email:      email,
+		RemoteAddr: remoteAddr,
+		Requested:  time.Now(),
+		Sealed:     g.aead.Seal(nonce, nonce, form, []byte(id)),
+	}
+
+	return g.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(pendingBucket)
+		err := b.ForEach(func(k, v []byte) error {
+			var other Pending
+			if err := json.Unmarshal(v, &other); err != nil {
+				return err
+			}
+			if strings.EqualFold(other.Username, username) {
+				return ErrPendingExists
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return put(b, id, pending)
+	})
+}
+
+// ListPending returns the registrations waiting for approval, without their encrypted forms
+func (g *Gate) ListPending() ([]Pending, error) {
+	list := []Pending{}
+	err := g.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBucket).ForEach(func(k, v []byte) error {
+			var pending Pending
+			if err := json.Unmarshal(v, &pending); err != nil {
+				return err
+			}
+			pending.Sealed = nil
+			list = append(list, pending)
+			return nil
+		})
+	})
+	return list, err
+}
+
+// Approve creates the user from the pending registration. If the backend refuses, the registration
+// stays pending so it can be rejected.
+func (g *Gate) Approve(id string) (*Pending, error) {
+	pending, err := g.getPending(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.aead == nil || len(pending.Sealed) < g.aead.NonceSize() {
+		return nil, fmt.Errorf("Pending registration %s cannot be decrypted", id)
+	}
+	nonce, sealed := pending.Sealed[:g.aead.NonceSize()], pending.Sealed[g.aead.NonceSize():]
+	form, err := g.aead.Open(nil, nonce, sealed, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("Pending registration %s cannot be decrypted, was the secret changed? %v", id, err)
+	}
+
+	var userInfo map[string]interface{}
+	if err := json.Unmarshal(form, &userInfo); err != nil {
+		return nil, err
+	}
+
+	if err := g.registrar.RegisterUserReq(userInfo); err != nil {
+		return nil, err
+	}
+
+	if err := g.Reject(id); err != nil {
+		return nil, err
+	}
+	pending.Sealed = nil
+	return pending, nil
+}
+
+// Reject drops a pending registration
+func (g *Gate) Reject(id string) error {
+	return g.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(pendingBucket)
+		if b.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+func (g *Gate) getPending(id string) (*Pending, error) {
+	var pending Pending
+	err := g.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(pendingBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &pending)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pending, nil
+}
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func put(b *bolt.Bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), data)
+}
@@ -0,0 +1,166 @@
+package registration_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"goclone/internal/auth/local"
+	"goclone/internal/auth/registration"
+	"goclone/internal/config"
+
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+var roles = []config.Role{
+	{Name: "student", Groups: []string{"cn=Students,ou=Groups"}},
+	{Name: "admin", BackendAdmins: true, Permissions: []string{"*"}},
+}
+
+func setup(t *testing.T, mode string) (*local.LocalManager, *registration.Gate) {
+	dir := t.TempDir()
+	lm, err := local.NewLocalManager(config.LocalProvider{DBPath: filepath.Join(dir, "users.db")}, noop.NewTracerProvider().Tracer("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lm.Close() })
+
+	gate, err := registration.NewGate(config.Registration{
+		Mode:   mode,
+		DBPath: filepath.Join(dir, "registration.db"),
+		Secret: "secret",
+	}, roles, lm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { gate.Close() })
+	return lm, gate
+}
+
+func form(username, invite string) map[string]interface{} {
+	return map[string]interface{}{"username": username, "password": "password1", "invite": invite}
+}
+
+func TestInviteMode(t *testing.T) {
+	lm, gate := setup(t, "invite")
+
+	if _, err := gate.Register(form("student1", ""), "127.0.0.1"); err != registration.ErrInviteRequired {
+		t.Errorf("expected ErrInviteRequired, got %v", err)
+	}
+	if _, err := gate.Register(form("student1", "NOTACODE"), "127.0.0.1"); err != registration.ErrInvalidInvite {
+		t.Errorf("expected ErrInvalidInvite, got %v", err)
+	}
+
+	invite, err := gate.CreateInvite("admin", 2, time.Hour, "", "student")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invite.Group != "cn=Students,ou=Groups" {
+		t.Errorf("role should resolve to its group, got %q", invite.Group)
+	}
+
+	pending, err := gate.Register(form("student1", invite.Code), "127.0.0.1")
+	if err != nil || pending {
+		t.Fatalf("register with invite: pending=%v err=%v", pending, err)
+	}
+	groups, _ := lm.UserGroups("student1")
+	if len(groups) != 1 || groups[0] != invite.Group {
+		t.Errorf("invited user should be in %s, got %v", invite.Group, groups)
+	}
+
+	// a failed registration gives the use back
+	if _, err := gate.Register(form("student1", invite.Code), "127.0.0.1"); err == nil {
+		t.Error("registering an existing user should fail")
+	}
+	if _, err := gate.Register(form("student2", invite.Code), "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gate.Register(form("student3", invite.Code), "127.0.0.1"); err != registration.ErrInvalidInvite {
+		t.Errorf("expected used up invite to be refused, got %v", err)
+	}
+
+	expired, err := gate.CreateInvite("admin", 0, time.Millisecond, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := gate.Register(form("student4", expired.Code), "127.0.0.1"); err != registration.ErrInvalidInvite {
+		t.Errorf("expected expired invite to be refused, got %v", err)
+	}
+
+	if _, err := gate.CreateInvite("admin", 0, time.Hour, "", "admin"); err == nil {
+		t.Error("a role without groups cannot be given through an invite")
+	}
+}
+
+func TestApprovalMode(t *testing.T) {
+	lm, gate := setup(t, "approval")
+
+	pending, err := gate.Register(form("student1", ""), "10.0.0.1")
+	if err != nil || !pending {
+		t.Fatalf("registration should wait for approval: pending=%v err=%v", pending, err)
+	}
+	if _, err := gate.Register(form("Student1", ""), "10.0.0.1"); err != registration.ErrPendingExists {
+		t.Errorf("expected ErrPendingExists, got %v", err)
+	}
+	if exists, _ := lm.UserExists("student1"); exists {
+		t.Fatal("user should not exist before approval")
+	}
+
+	if _, err := gate.Register(form("student2", ""), "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := gate.ListPending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected 2 pending registrations, got %d", len(list))
+	}
+	ids := map[string]string{}
+	for _, p := range list {
+		if p.Sealed != nil {
+			t.Error("listed registrations should not include the sealed form")
+		}
+		ids[p.Username] = p.ID
+	}
+
+	if _, err := gate.Approve(ids["student1"]); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := lm.LoginReq("student1", "password1"); user == nil {
+		t.Error("approved user should be able to log in")
+	}
+
+	if err := gate.Reject(ids["student2"]); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := lm.UserExists("student2"); exists {
+		t.Error("rejected user should not exist")
+	}
+	if _, err := gate.Approve(ids["student2"]); err != registration.ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// an invite skips the queue
+	invite, err := gate.CreateInvite("admin", 1, time.Hour, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := gate.Register(form("student3", invite.Code), "10.0.0.3"); err != nil || pending {
+		t.Errorf("invited registration should not wait: pending=%v err=%v", pending, err)
+	}
+}
+
+func TestGateConfig(t *testing.T) {
+	lm, _ := setup(t, "open")
+	dbPath := filepath.Join(t.TempDir(), "registration.db")
+
+	if _, err := registration.NewGate(config.Registration{Mode: "whenever", DBPath: dbPath}, nil, lm); err == nil {
+		t.Error("expected unknown mode to be rejected")
+	}
+	if _, err := registration.NewGate(config.Registration{Mode: "approval", DBPath: dbPath}, nil, lm); err == nil {
+		t.Error("expected approval without a secret to be rejected")
+	}
+}
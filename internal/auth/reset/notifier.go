@@ -0,0 +1,59 @@
+package reset
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"goclone/internal/config"
+)
+
+// Notifier delivers a message to a user, such as a reset link by email
+type Notifier interface {
+	Notify(to, subject, body string) error
+}
+
+// SMTPNotifier sends plain text email through an SMTP relay, using STARTTLS when the server offers it
+type SMTPNotifier struct {
+	conf config.SMTP
+}
+
+func NewSMTPNotifier(conf config.SMTP) *SMTPNotifier {
+	if conf.Port == 0 {
+		conf.Port = 587
+	}
+	return &SMTPNotifier{conf: conf}
+}
+
+func (n *SMTPNotifier) Notify(to, subject, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("Invalid recipient or subject")
+	}
+
+	var a smtp.Auth
+	if n.conf.Username != "" {
+		a = smtp.PlainAuth("", n.conf.Username, n.conf.Password, n.conf.Host)
+	}
+
+	msg := "From: " + n.conf.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + body
+
+	addr := net.JoinHostPort(n.conf.Host, strconv.Itoa(n.conf.Port))
+	return smtp.SendMail(addr, a, n.conf.From, []string{to}, []byte(msg))
+}
+
+// LogNotifier prints messages instead of sending them, for trying the flow without a mail server
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(to, subject, body string) error {
+	fmt.Printf("To: %s\nSubject: %s\n\n%s\n", to, subject, body)
+	return nil
+}
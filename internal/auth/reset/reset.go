@@ -0,0 +1,188 @@
+package reset
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"goclone/internal/auth"
+	"goclone/internal/config"
+)
+
+const (
+	defaultTokenTTL = 30 * time.Minute
+
+	// requestInterval stops the same user being sent a flood of reset emails
+	requestInterval = time.Minute
+)
+
+var ErrInvalidToken = fmt.Errorf("Invalid or expired reset token")
+
+type pending struct {
+	username string
+	expires  time.Time
+}
+
+// Manager issues one-time password reset tokens and delivers them through a Notifier.
+// Only a hash of each token is kept, and tokens live in memory so a restart invalidates them.
+type Manager struct {
+	passwords auth.PasswordManager
+	emails    auth.EmailResolver
+	notifier  Notifier
+	resetURL  string
+	ttl       time.Duration
+
+	mu        sync.Mutex
+	tokens    map[string]pending
+	requested map[string]time.Time
+}
+
+// NewManager needs an auth manager that can both reset passwords and look up email addresses
+func NewManager(conf config.PasswordReset, authManager auth.AuthManager, notifier Notifier) (*Manager, error) {
+	passwords, ok := authManager.(auth.PasswordManager)
+	if !ok {
+		return nil, fmt.Errorf("Password reset needs an auth backend that manages passwords")
+	}
+	emails, ok := authManager.(auth.EmailResolver)
+	if !ok {
+		return nil, fmt.Errorf("Password reset needs an auth backend that knows email addresses")
+	}
+	if conf.ResetURL == "" {
+		return nil, fmt.Errorf("Password reset needs a reset URL")
+	}
+
+	ttl := conf.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+
+	return &Manager{
+		passwords: passwords,
+		emails:    emails,
+		notifier:  notifier,
+		resetURL:  conf.ResetURL,
+		ttl:       ttl,
+		tokens:    map[string]pending{},
+		requested: map[string]time.Time{},
+	}, nil
+}
+
+// Request sends the user a reset link, replacing any link sent before. It returns auth.ErrUnknownUser when
+// the user does not exist or has no address on record; callers should not reveal that to the requester.
+// The email is sent in the background, so how long Request takes does not give away whether it went out.
+func (m *Manager) Request(username string) error {
+	key := strings.ToLower(username)
+	m.mu.Lock()
+	last, ok := m.requested[key]
+	m.mu.Unlock()
+	if ok && time.Since(last) < requestInterval {
+		return nil
+	}
+
+	email, err := m.emails.UserEmail(username)
+	if err != nil {
+		return err
+	}
+	if email == "" {
+		return auth.ErrUnknownUser
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.prune(time.Now())
+	for hash, p := range m.tokens {
+		if strings.EqualFold(p.username, username) {
+			delete(m.tokens, hash)
+		}
+	}
+	m.tokens[hashToken(token)] = pending{username: username, expires: time.Now().Add(m.ttl)}
+	m.requested[key] = time.Now()
+	m.mu.Unlock()
+
+	link, err := m.link(token)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("A password reset was requested for your account %s.\r\n\r\n"+
+		"Open the link below within %s to choose a new password:\r\n%s\r\n\r\n"+
+		"If you did not ask for this you can ignore this email.\r\n", username, m.ttl, link)
+	go func() {
+		if err := m.notifier.Notify(email, "Reset your password", body); err != nil {
+			fmt.Printf("Failed to send reset link to %s: %v\n", username, err)
+		}
+	}()
+	return nil
+}
+
+// Redeem sets the new password and uses up the token, returning the user it belonged to.
+// A password that fails validation leaves the token valid so the user can try again.
+func (m *Manager) Redeem(token, newPassword string) (string, error) {
+	if !auth.ValidatePassword(newPassword) {
+		return "", auth.ErrWeakPassword
+	}
+
+	hash := hashToken(token)
+	m.mu.Lock()
+	p, ok := m.tokens[hash]
+	if ok {
+		delete(m.tokens, hash)
+	}
+	m.mu.Unlock()
+	if !ok || time.Now().After(p.expires) {
+		return "", ErrInvalidToken
+	}
+
+	if err := m.passwords.ResetPassword(p.username, newPassword); err != nil {
+		return "", err
+	}
+	return p.username, nil
+}
+
+func (m *Manager) link(token string) (string, error) {
+	u, err := url.Parse(m.resetURL)
+	if err != nil {
+		return "", fmt.Errorf("Invalid reset URL: %v", err)
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// prune drops expired tokens and rate limit entries, the caller holds m.mu
+func (m *Manager) prune(now time.Time) {
+	for hash, p := range m.tokens {
+		if now.After(p.expires) {
+			delete(m.tokens, hash)
+		}
+	}
+	for key, last := range m.requested {
+		if now.Sub(last) > requestInterval {
+			delete(m.requested, key)
+		}
+	}
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Failed to generate reset token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
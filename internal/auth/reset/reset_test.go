@@ -0,0 +1,255 @@
+package reset_test
+
+import (
+	"bufio"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"goclone/internal/auth"
+	"goclone/internal/auth/local"
+	"goclone/internal/auth/reset"
+	"goclone/internal/config"
+
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// smtpServer is a minimal SMTP stand-in that hands every message it receives to a channel
+type smtpServer struct {
+	ln   net.Listener
+	mail chan string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{ln: ln, mail: make(chan string, 10)}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 go ahead")
+			var msg strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				msg.WriteString(line)
+			}
+			s.mail <- msg.String()
+			reply("250 queued")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *smtpServer) conf() config.SMTP {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return config.SMTP{Host: addr.IP.String(), Port: addr.Port, From: "goclone@example.com"}
+}
+
+func (s *smtpServer) next(t *testing.T) string {
+	select {
+	case msg := <-s.mail:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+		return ""
+	}
+}
+
+var tokenRegex = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+func setup(t *testing.T, ttl time.Duration) (*local.LocalManager, *reset.Manager, *smtpServer) {
+	lm, err := local.NewLocalManager(config.LocalProvider{DBPath: filepath.Join(t.TempDir(), "users.db")}, noop.NewTracerProvider().Tracer("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lm.Close() })
+
+	server := newSMTPServer(t)
+	resets, err := reset.NewManager(config.PasswordReset{
+		ResetURL: "https://goclone.example.com/reset-password",
+		TokenTTL: ttl,
+	}, lm, reset.NewSMTPNotifier(server.conf()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lm, resets, server
+}
+
+func TestResetFlow(t *testing.T) {
+	lm, resets, server := setup(t, time.Hour)
+	err := lm.RegisterUserReq(map[string]interface{}{"username": "student", "password": "password1", "email": "student@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := resets.Request("student"); err != nil {
+		t.Fatal(err)
+	}
+	msg := server.next(t)
+	if !strings.Contains(msg, "To: student@example.com") {
+		t.Errorf("email not addressed to the user:\n%s", msg)
+	}
+	match := tokenRegex.FindStringSubmatch(msg)
+	if match == nil {
+		t.Fatalf("no reset link in email:\n%s", msg)
+	}
+	token, _ := url.QueryUnescape(match[1])
+
+	if _, err := resets.Redeem(token, "weak"); err != auth.ErrWeakPassword {
+		t.Errorf("expected ErrWeakPassword, got %v", err)
+	}
+	username, err := resets.Redeem(token, "password2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "student" {
+		t.Errorf("expected student, got %s", username)
+	}
+	if user, _ := lm.LoginReq("student", "password2"); user == nil {
+		t.Error("new password should log in")
+	}
+
+	// tokens work once
+	if _, err := resets.Redeem(token, "password3"); err != reset.ErrInvalidToken {
+		t.Errorf("expected ErrInvalidToken on reuse, got %v", err)
+	}
+	if _, err := resets.Redeem("forged", "password3"); err != reset.ErrInvalidToken {
+		t.Errorf("expected ErrInvalidToken for a forged token, got %v", err)
+	}
+}
+
+func TestResetRequestRules(t *testing.T) {
+	lm, resets, server := setup(t, 10*time.Millisecond)
+	if err := lm.CreateUser("noemail", "password1", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := lm.CreateUser("student", "password1", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := lm.SetEmail("student", "student@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := resets.Request("nobody"); err != auth.ErrUnknownUser {
+		t.Errorf("expected ErrUnknownUser, got %v", err)
+	}
+	if err := resets.Request("noemail"); err != auth.ErrUnknownUser {
+		t.Errorf("expected ErrUnknownUser without an address, got %v", err)
+	}
+
+	if err := resets.Request("student"); err != nil {
+		t.Fatal(err)
+	}
+	token := tokenRegex.FindStringSubmatch(server.next(t))[1]
+
+	// a second request straight away does not send another email
+	if err := resets.Request("student"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-server.mail:
+		t.Error("repeated request should be rate limited")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the link has expired by now
+	if _, err := resets.Redeem(token, "password2"); err != reset.ErrInvalidToken {
+		t.Errorf("expected ErrInvalidToken after expiry, got %v", err)
+	}
+}
+
+// slowNotifier stands in for an SMTP relay that takes a while to accept mail
+type slowNotifier struct {
+	sent chan string
+}
+
+func (n slowNotifier) Notify(to, subject, body string) error {
+	time.Sleep(200 * time.Millisecond)
+	n.sent <- to
+	return nil
+}
+
+func TestResetRequestDoesNotWaitForEmail(t *testing.T) {
+	lm, err := local.NewLocalManager(config.LocalProvider{DBPath: filepath.Join(t.TempDir(), "users.db")}, noop.NewTracerProvider().Tracer("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lm.Close() })
+	err = lm.RegisterUserReq(map[string]interface{}{"username": "student", "password": "password1", "email": "student@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := slowNotifier{sent: make(chan string, 1)}
+	resets, err := reset.NewManager(config.PasswordReset{ResetURL: "https://goclone.example.com/reset-password"}, lm, notifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// requests for existing users return as quickly as those for unknown ones
+	start := time.Now()
+	if err := resets.Request("student"); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > 100*time.Millisecond {
+		t.Errorf("request waited %s for the email to be sent", took)
+	}
+	select {
+	case to := <-notifier.sent:
+		if to != "student@example.com" {
+			t.Errorf("email sent to %s", to)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no email sent")
+	}
+}
+
+func TestNewManagerNeedsPasswordBackend(t *testing.T) {
+	_, err := reset.NewManager(config.PasswordReset{ResetURL: "https://goclone.example.com/reset"}, nil, reset.LogNotifier{})
+	if err == nil {
+		t.Error("expected a backend without password support to be rejected")
+	}
+}
@@ -0,0 +1,132 @@
+package sessionstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// MemoryBackend keeps sessions in process, they are lost on restart
+type MemoryBackend struct {
+	mu       sync.Mutex
+	sessions map[string]Record
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{sessions: map[string]Record{}}
+}
+
+func (m *MemoryBackend) Load(id string) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &rec, nil
+}
+
+func (m *MemoryBackend) Save(rec *Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[rec.ID] = *rec
+	return nil
+}
+
+func (m *MemoryBackend) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemoryBackend) List() ([]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	records := make([]Record, 0, len(m.sessions))
+	for _, rec := range m.sessions {
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func (m *MemoryBackend) Close() error {
+	return nil
+}
+
+var sessionsBucket = []byte("sessions")
+
+// BoltBackend keeps sessions in a bbolt file so they survive restarts
+type BoltBackend struct {
+	db *bolt.DB
+}
+
+func NewBoltBackend(path string) (*BoltBackend, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open session database: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to create sessions bucket: %v", err)
+	}
+	return &BoltBackend{db: db}, nil
+}
+
+func (b *BoltBackend) Load(id string) (*Record, error) {
+	var rec Record
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(sessionsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (b *BoltBackend) Save(rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte(rec.ID), data)
+	})
+}
+
+func (b *BoltBackend) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete([]byte(id))
+	})
+}
+
+func (b *BoltBackend) List() ([]Record, error) {
+	records := []Record{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(k, v []byte) error {
+			var rec Record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			records = append(records, rec)
+			return nil
+		})
+	})
+	return records, err
+}
+
+func (b *BoltBackend) Close() error {
+	return b.db.Close()
+}
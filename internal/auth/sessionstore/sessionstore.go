@@ -0,0 +1,371 @@
+package sessionstore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"goclone/internal/config"
+
+	ginsessions "github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	defaultIdleTimeout     = 2 * time.Hour
+	defaultAbsoluteTimeout = 12 * time.Hour
+
+	// touchInterval limits how often reading a session writes its last seen time back to the backend,
+	// short idle timeouts touch more often so activity is not missed
+	touchInterval = time.Minute
+)
+
+// renewKey marks a session that Save gives a new ID, see Renew
+const renewKey = "sessionstore.renew"
+
+// endKey marks a session that Save deletes, see End
+const endKey = "sessionstore.end"
+
+var ErrNotFound = fmt.Errorf("Session not found")
+
+// Record is a session as kept by a Backend. Values holds the gob encoded session values.
+type Record struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	Values     []byte    `json:"values,omitempty"`
+	Created    time.Time `json:"created"`
+	LastSeen   time.Time `json:"lastSeen"`
+	RemoteAddr string    `json:"remoteAddr"`
+	UserAgent  string    `json:"userAgent"`
+}
+
+// Backend persists session records
+type Backend interface {
+	Load(id string) (*Record, error)
+	Save(rec *Record) error
+	Delete(id string) error
+	List() ([]Record, error)
+	Close() error
+}
+
+// Store keeps sessions on the server and only hands the browser a signed session ID, so
+// sessions can be listed, expired and revoked. It implements the gin-contrib sessions Store.
+type Store struct {
+	backend         Backend
+	codecs          []securecookie.Codec
+	serializer      securecookie.GobEncoder
+	options         *sessions.Options
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+	stop            chan struct{}
+	closeOnce       sync.Once
+}
+
+var _ ginsessions.Store = (*Store)(nil)
+
+func NewStore(conf config.Sessions) (*Store, error) {
+	sameSite, err := parseSameSite(conf.SameSite)
+	if err != nil {
+		return nil, err
+	}
+
+	var backend Backend
+	switch conf.Store {
+	case "", "memory":
+		backend = NewMemoryBackend()
+	case "bolt":
+		backend, err = NewBoltBackend(conf.DBPath)
+	default:
+		err = fmt.Errorf("Unknown session store %q", conf.Store)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := append([]string{conf.Secret}, conf.PreviousSecrets...)
+	if conf.Secret == "" {
+		secret, err := randomID()
+		if err != nil {
+			backend.Close()
+			return nil, err
+		}
+		fmt.Println("No session secret configured, sessions will not survive a restart")
+		secrets[0] = secret
+	}
+
+	s := &Store{
+		backend:         backend,
+		idleTimeout:     conf.IdleTimeout,
+		absoluteTimeout: conf.AbsoluteTimeout,
+		stop:            make(chan struct{}),
+	}
+	if s.idleTimeout <= 0 {
+		s.idleTimeout = defaultIdleTimeout
+	}
+	if s.absoluteTimeout <= 0 {
+		s.absoluteTimeout = defaultAbsoluteTimeout
+	}
+
+	// the first secret signs new cookies, the previous ones are only used to read cookies issued before a rotation
+	var keyPairs [][]byte
+	for _, secret := range secrets {
+		key := sha256.Sum256([]byte(secret))
+		keyPairs = append(keyPairs, key[:], nil)
+	}
+	s.codecs = securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range s.codecs {
+		codec.(*securecookie.SecureCookie).MaxAge(int(s.absoluteTimeout.Seconds()))
+	}
+
+	s.options = &sessions.Options{
+		Path:     "/",
+		Domain:   conf.Domain,
+		MaxAge:   int(s.absoluteTimeout.Seconds()),
+		Secure:   conf.Secure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	}
+
+	go s.reap(s.idleTimeout / 2)
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		err = s.backend.Close()
+	})
+	return err
+}
+
+func (s *Store) Options(options ginsessions.Options) {
+	s.options = options.ToGorillaOptions()
+}
+
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session named by the request's cookie, or a fresh one when there is no valid session.
+// Reading a session counts as activity for the idle timeout.
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.codecs...); err != nil {
+		return session, nil
+	}
+
+	rec, err := s.backend.Load(id)
+	if err == ErrNotFound {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+
+	now := time.Now()
+	if s.expired(rec, now) {
+		return session, s.backend.Delete(id)
+	}
+
+	if err := s.serializer.Deserialize(rec.Values, &session.Values); err != nil {
+		return session, err
+	}
+	session.ID = id
+	session.IsNew = false
+
+	if now.Sub(rec.LastSeen) > min(touchInterval, s.idleTimeout/4) {
+		rec.LastSeen = now
+		if err := s.backend.Save(rec); err != nil {
+			return session, err
+		}
+	}
+	return session, nil
+}
+
+// Renew makes the next save of session move it to a new ID and drop the old one. Login handlers call it
+// before saving the user into the session, so an ID handed out before the login is worthless after it.
+func Renew(session ginsessions.Session) {
+	session.Set(renewKey, true)
+}
+
+// End makes the next save of session delete it and expire its cookie. The expiring cookie keeps the
+// configured options, a browser only drops the cookie when its domain and path match.
+func End(session ginsessions.Session) {
+	session.Set(endKey, true)
+}
+
+// Save writes the session to the backend and sets the ID cookie. A negative MaxAge deletes the session.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if end, _ := session.Values[endKey].(bool); end {
+		delete(session.Values, endKey)
+		session.Options.MaxAge = -1
+	}
+	if renew, _ := session.Values[renewKey].(bool); renew {
+		delete(session.Values, renewKey)
+		if session.ID != "" {
+			if err := s.backend.Delete(session.ID); err != nil {
+				return err
+			}
+			session.ID = ""
+		}
+	}
+
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.backend.Delete(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	now := time.Now()
+	rec := &Record{ID: session.ID, Created: now}
+	if session.ID == "" {
+		id, err := randomID()
+		if err != nil {
+			return err
+		}
+		session.ID = id
+		rec.ID = id
+	} else if existing, err := s.backend.Load(session.ID); err == nil {
+		rec.Created = existing.Created
+	}
+
+	values, err := s.serializer.Serialize(session.Values)
+	if err != nil {
+		return err
+	}
+	rec.Values = values
+	rec.Username, _ = session.Values["id"].(string)
+	rec.LastSeen = now
+	rec.RemoteAddr = r.RemoteAddr
+	rec.UserAgent = r.UserAgent()
+	if err := s.backend.Save(rec); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// List returns the live sessions of username, or of everyone when username is empty
+func (s *Store) List(username string) ([]Record, error) {
+	records, err := s.backend.List()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sessions := []Record{}
+	for _, rec := range records {
+		if rec.Username == "" || s.expired(&rec, now) {
+			continue
+		}
+		if username == "" || strings.EqualFold(rec.Username, username) {
+			rec.Values = nil
+			sessions = append(sessions, rec)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *Store) Revoke(id string) error {
+	if _, err := s.backend.Load(id); err != nil {
+		return err
+	}
+	return s.backend.Delete(id)
+}
+
+// RevokeUser ends every session of username and returns how many there were
+func (s *Store) RevokeUser(username string) (int, error) {
+	return s.RevokeUserExcept(username, "")
+}
+
+// RevokeUserExcept ends every session of username other than keepID, e.g. the one that just changed the password
+func (s *Store) RevokeUserExcept(username, keepID string) (int, error) {
+	records, err := s.backend.List()
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, rec := range records {
+		if rec.ID != keepID && rec.Username != "" && strings.EqualFold(rec.Username, username) {
+			if err := s.backend.Delete(rec.ID); err != nil {
+				return revoked, err
+			}
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+func (s *Store) expired(rec *Record, now time.Time) bool {
+	return now.Sub(rec.LastSeen) > s.idleTimeout || now.Sub(rec.Created) > s.absoluteTimeout
+}
+
+// reap deletes expired sessions in the background until the store is closed
+func (s *Store) reap(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			records, err := s.backend.List()
+			if err != nil {
+				fmt.Println("Failed to list sessions:", err)
+				continue
+			}
+			for _, rec := range records {
+				if s.expired(&rec, now) {
+					s.backend.Delete(rec.ID)
+				}
+			}
+		}
+	}
+}
+
+func parseSameSite(mode string) (http.SameSite, error) {
+	switch strings.ToLower(mode) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("Unknown SameSite mode %q", mode)
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Failed to generate session ID: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,226 @@
+package sessionstore_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"goclone/internal/auth/sessionstore"
+	"goclone/internal/config"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+type client struct {
+	t      *testing.T
+	router *gin.Engine
+}
+
+func newClient(t *testing.T, conf config.Sessions) (*sessionstore.Store, *client) {
+	gin.SetMode(gin.TestMode)
+
+	store, err := sessionstore.NewStore(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	router := gin.New()
+	router.Use(sessions.Sessions("kamino", store))
+	router.GET("/visit", func(c *gin.Context) {
+		session := sessions.Default(c)
+		session.Set("visited", true)
+		if err := session.Save(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	router.GET("/login/:user", func(c *gin.Context) {
+		session := sessions.Default(c)
+		sessionstore.Renew(session)
+		session.Set("id", c.Param("user"))
+		if err := session.Save(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	router.GET("/whoami", func(c *gin.Context) {
+		if id, ok := sessions.Default(c).Get("id").(string); ok {
+			c.String(http.StatusOK, id)
+			return
+		}
+		c.Status(http.StatusUnauthorized)
+	})
+	router.GET("/logout", func(c *gin.Context) {
+		session := sessions.Default(c)
+		session.Clear()
+		sessionstore.End(session)
+		if err := session.Save(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	return store, &client{t: t, router: router}
+}
+
+func (cl *client) get(path, cookie string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if cookie != "" {
+		req.Header.Set("Cookie", cookie)
+	}
+	cl.router.ServeHTTP(w, req)
+	return w
+}
+
+func (cl *client) login(user string) string {
+	w := cl.get("/login/"+user, "")
+	cookie := w.Header().Get("Set-Cookie")
+	if cookie == "" {
+		cl.t.Fatalf("login as %s set no cookie", user)
+	}
+	return cookie
+}
+
+func (cl *client) whoami(cookie string) string {
+	w := cl.get("/whoami", cookie)
+	if w.Code != http.StatusOK {
+		return ""
+	}
+	return w.Body.String()
+}
+
+func TestSessionCookie(t *testing.T) {
+	_, cl := newClient(t, config.Sessions{Secret: "secret", Secure: true, SameSite: "strict", Domain: "goclone.example"})
+
+	cookie := cl.login("alice")
+	for _, attr := range []string{"HttpOnly", "Secure", "SameSite=Strict", "Path=/", "Domain=goclone.example"} {
+		if !strings.Contains(cookie, attr) {
+			t.Errorf("cookie %q is missing %s", cookie, attr)
+		}
+	}
+	if user := cl.whoami(cookie); user != "alice" {
+		t.Errorf("expected alice, got %q", user)
+	}
+
+	// the cookie only holds a signed ID, tampering with it loses the session
+	if user := cl.whoami("kamino=forged"); user != "" {
+		t.Errorf("forged cookie was accepted as %q", user)
+	}
+
+	// another deployment with a different secret cannot read the cookie
+	_, other := newClient(t, config.Sessions{Secret: "other"})
+	if user := other.whoami(cookie); user != "" {
+		t.Errorf("cookie signed with another secret was accepted as %q", user)
+	}
+
+	// the expired cookie matches the one it replaces, or the browser keeps the old one
+	expired := cl.get("/logout", cookie).Header().Get("Set-Cookie")
+	for _, attr := range []string{"Max-Age=0", "HttpOnly", "Secure", "SameSite=Strict", "Path=/", "Domain=goclone.example"} {
+		if !strings.Contains(expired, attr) {
+			t.Errorf("expired cookie %q is missing %s", expired, attr)
+		}
+	}
+	if user := cl.whoami(cookie); user != "" {
+		t.Errorf("session survived logout as %q", user)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	store, cl := newClient(t, config.Sessions{Secret: "secret"})
+
+	// a session handed out before the login, e.g. one planted by an attacker
+	planted := cl.get("/visit", "").Header().Get("Set-Cookie")
+	cookie := cl.get("/login/alice", planted).Header().Get("Set-Cookie")
+	if cookie == "" || cookie == planted {
+		t.Fatalf("expected login to issue a new session cookie, got %q", cookie)
+	}
+	if user := cl.whoami(cookie); user != "alice" {
+		t.Errorf("expected alice, got %q", user)
+	}
+	if user := cl.whoami(planted); user != "" {
+		t.Errorf("the session from before the login was kept as %q", user)
+	}
+	if list, _ := store.List("alice"); len(list) != 1 {
+		t.Errorf("expected the old session to be dropped, got %+v", list)
+	}
+}
+
+func TestSecretRotation(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "sessions.db")
+
+	store, cl := newClient(t, config.Sessions{Secret: "old", Store: "bolt", DBPath: dbPath})
+	cookie := cl.login("alice")
+	store.Close()
+
+	_, rotated := newClient(t, config.Sessions{Secret: "new", PreviousSecrets: []string{"old"}, Store: "bolt", DBPath: dbPath})
+	if user := rotated.whoami(cookie); user != "alice" {
+		t.Errorf("session should survive a restart and rotation, got %q", user)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	_, idle := newClient(t, config.Sessions{Secret: "secret", IdleTimeout: 20 * time.Millisecond, AbsoluteTimeout: time.Hour})
+	cookie := idle.login("alice")
+	time.Sleep(40 * time.Millisecond)
+	if user := idle.whoami(cookie); user != "" {
+		t.Errorf("idle session should have expired, got %q", user)
+	}
+
+	_, absolute := newClient(t, config.Sessions{Secret: "secret", IdleTimeout: time.Hour, AbsoluteTimeout: 50 * time.Millisecond})
+	cookie = absolute.login("alice")
+	if user := absolute.whoami(cookie); user != "alice" {
+		t.Fatalf("expected alice, got %q", user)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if user := absolute.whoami(cookie); user != "" {
+		t.Errorf("session should have expired after the absolute timeout, got %q", user)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	store, cl := newClient(t, config.Sessions{Secret: "secret"})
+	alice1 := cl.login("alice")
+	alice2 := cl.login("Alice")
+	bob := cl.login("bob")
+
+	list, err := store.List("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected 2 sessions for alice, got %d", len(list))
+	}
+
+	if err := store.Revoke(list[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke(list[0].ID); err != sessionstore.ErrNotFound {
+		t.Errorf("expected ErrNotFound revoking twice, got %v", err)
+	}
+
+	revoked, err := store.RevokeUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked != 1 {
+		t.Errorf("expected 1 remaining session revoked, got %d", revoked)
+	}
+	if cl.whoami(alice1) != "" || cl.whoami(alice2) != "" {
+		t.Error("alice's sessions should be revoked")
+	}
+	if user := cl.whoami(bob); user != "bob" {
+		t.Errorf("bob's session should be untouched, got %q", user)
+	}
+}
+
+func TestUnknownStore(t *testing.T) {
+	if _, err := sessionstore.NewStore(config.Sessions{Store: "redis"}); err == nil {
+		t.Error("expected unknown store to be rejected")
+	}
+	if _, err := sessionstore.NewStore(config.Sessions{SameSite: "sometimes"}); err == nil {
+		t.Error("expected unknown SameSite mode to be rejected")
+	}
+}
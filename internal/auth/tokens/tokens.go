@@ -0,0 +1,315 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"goclone/internal/config"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Prefix starts every token so they are easy to spot in scripts and secret scanners
+const Prefix = "gct_"
+
+const (
+	defaultTTL = 30 * 24 * time.Hour
+	maxTTL     = 365 * 24 * time.Hour
+
+	// lastUsedInterval limits how often use of a token is written back to the database
+	lastUsedInterval = time.Minute
+)
+
+var tokensBucket = []byte("tokens")
+
+var (
+	ErrInvalidToken  = fmt.Errorf("Invalid or expired token")
+	ErrTokenNotFound = fmt.Errorf("Token not found")
+)
+
+// Scope limits which routes a token may call. Each scope includes the ones below it.
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"
+	ScopePods  Scope = "pods"
+	ScopeAdmin Scope = "admin"
+)
+
+var scopeRank = map[Scope]int{
+	ScopeRead:  1,
+	ScopePods:  2,
+	ScopeAdmin: 3,
+}
+
+func ParseScope(s string) (Scope, error) {
+	scope := Scope(s)
+	if _, ok := scopeRank[scope]; !ok {
+		return "", fmt.Errorf("Unknown token scope %q", s)
+	}
+	return scope, nil
+}
+
+// Allows reports whether a token with scope s may call a route requiring the given scope
+func (s Scope) Allows(required Scope) bool {
+	return scopeRank[s] >= scopeRank[required]
+}
+
+// Token is the stored form of an API token. Only a hash of the secret is kept.
+type Token struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Username string    `json:"username"`
+	Scope    Scope     `json:"scope"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	LastUsed time.Time `json:"lastUsed"`
+
+	// Backend copies the session that created the token, so requests made with it are resolved
+	// against the same auth backend. Admin status is not kept, it is looked up on every request.
+	Backend string `json:"backend,omitempty"`
+}
+
+// Owner describes the session a token is created for
+type Owner struct {
+	Username string
+	Backend  string
+}
+
+type record struct {
+	Token
+	Hash string `json:"hash"`
+}
+
+type Store struct {
+	db         *bolt.DB
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+}
+
+func NewStore(conf config.Tokens) (*Store, error) {
+	db, err := bolt.Open(conf.DBPath, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open token database: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(tokensBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to create tokens bucket: %v", err)
+	}
+
+	s := &Store{db: db, defaultTTL: conf.DefaultTTL, maxTTL: conf.MaxTTL}
+	if s.defaultTTL <= 0 {
+		s.defaultTTL = defaultTTL
+	}
+	if s.maxTTL <= 0 {
+		s.maxTTL = maxTTL
+	}
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Create issues a token for owner and returns its plaintext form, which is not stored and cannot be shown again.
+// A zero ttl uses the configured default.
+func (s *Store) Create(owner Owner, name string, scope Scope, ttl time.Duration) (string, *Token, error) {
+	if ttl == 0 {
+		ttl = s.defaultTTL
+	}
+	if ttl < 0 || ttl > s.maxTTL {
+		return "", nil, fmt.Errorf("Token lifetime must be at most %s", s.maxTTL)
+	}
+
+	id, err := randomString(6, hex.EncodeToString)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	rec := record{
+		Token: Token{
+			ID:       id,
+			Name:     name,
+			Username: owner.Username,
+			Scope:    scope,
+			Created:  now,
+			Expires:  now.Add(ttl),
+			Backend:  owner.Backend,
+		},
+		Hash: hashSecret(secret),
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tokensBucket)
+		// IDs are short, one that is taken is drawn again rather than overwriting another token
+		for b.Get([]byte(rec.ID)) != nil {
+			rec.ID, err = randomString(6, hex.EncodeToString)
+			if err != nil {
+				return err
+			}
+		}
+		return putRecord(b, &rec)
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to store token: %v", err)
+	}
+
+	return Prefix + rec.ID + "_" + secret, &rec.Token, nil
+}
+
+// Validate returns the token matching raw, or ErrInvalidToken if it is unknown, revoked or expired
+func (s *Store) Validate(raw string) (*Token, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(raw, Prefix), "_")
+	if !ok || !strings.HasPrefix(raw, Prefix) {
+		return nil, ErrInvalidToken
+	}
+
+	var rec *record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		rec, err = getRecord(tx.Bucket(tokensBucket), id)
+		return err
+	})
+	if err == ErrTokenNotFound {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(rec.Hash), []byte(hashSecret(secret))) != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	if now.After(rec.Expires) {
+		return nil, ErrInvalidToken
+	}
+
+	if now.Sub(rec.LastUsed) > lastUsedInterval {
+		rec.LastUsed = now
+		err = s.db.Update(func(tx *bolt.Tx) error {
+			return putRecord(tx.Bucket(tokensBucket), rec)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to update token: %v", err)
+		}
+	}
+
+	return &rec.Token, nil
+}
+
+// List returns the tokens of username, or every token when username is empty, oldest first
+func (s *Store) List(username string) ([]Token, error) {
+	tokens := []Token{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokensBucket).ForEach(func(k, v []byte) error {
+			var rec record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if username == "" || strings.EqualFold(rec.Username, username) {
+				tokens = append(tokens, rec.Token)
+			}
+			return nil
+		})
+	})
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created.Before(tokens[j].Created)
+	})
+	return tokens, err
+}
+
+// Revoke deletes a token. Unless username is empty, the token must belong to that user.
+func (s *Store) Revoke(id, username string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tokensBucket)
+		rec, err := getRecord(b, id)
+		if err != nil {
+			return err
+		}
+		if username != "" && !strings.EqualFold(rec.Username, username) {
+			return ErrTokenNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// RevokeUser deletes every token belonging to username
+func (s *Store) RevokeUser(username string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tokensBucket)
+		var ids [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var rec record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if strings.EqualFold(rec.Username, username) {
+				ids = append(ids, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := b.Delete(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func getRecord(b *bolt.Bucket, id string) (*record, error) {
+	data := b.Get([]byte(id))
+	if data == nil {
+		return nil, ErrTokenNotFound
+	}
+
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("Failed to decode token %s: %v", id, err)
+	}
+	return &rec, nil
+}
+
+func putRecord(b *bolt.Bucket, rec *record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(rec.ID), data)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Failed to generate token: %v", err)
+	}
+	return encode(b), nil
+}
@@ -0,0 +1,80 @@
+package tokens
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"goclone/internal/config"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStore(t *testing.T) {
+	store, err := NewStore(config.Tokens{DBPath: filepath.Join(t.TempDir(), "tokens.db"), MaxTTL: 48 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	student := Owner{Username: "student"}
+	if _, _, err := store.Create(student, "too-long", ScopeRead, 72*time.Hour); err == nil {
+		t.Error("expected lifetime over the maximum to be rejected")
+	}
+
+	raw, token, err := store.Create(student, "script", ScopePods, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.Validate(raw); err != nil || got.ID != token.ID || got.Username != "student" {
+		t.Fatalf("valid token rejected: %+v %v", got, err)
+	}
+	if _, err := store.Validate(raw + "x"); err != ErrInvalidToken {
+		t.Errorf("tampered token accepted: %v", err)
+	}
+
+	// expire the token in place
+	expired, _, err := store.Create(student, "old", ScopeRead, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tok := range mustList(t, store, "student") {
+		if tok.Name == "old" {
+			store.db.Update(func(tx *bolt.Tx) error {
+				rec, _ := getRecord(tx.Bucket(tokensBucket), tok.ID)
+				rec.Expires = time.Now().Add(-time.Minute)
+				return putRecord(tx.Bucket(tokensBucket), rec)
+			})
+		}
+	}
+	if _, err := store.Validate(expired); err != ErrInvalidToken {
+		t.Errorf("expired token accepted: %v", err)
+	}
+
+	if err := store.Revoke(token.ID, "someone-else"); err != ErrTokenNotFound {
+		t.Errorf("revoked another user's token: %v", err)
+	}
+	if err := store.RevokeUser("student"); err != nil {
+		t.Fatal(err)
+	}
+	if list := mustList(t, store, ""); len(list) != 0 {
+		t.Errorf("expected no tokens left, got %+v", list)
+	}
+}
+
+func TestScopeAllows(t *testing.T) {
+	if !ScopeAdmin.Allows(ScopePods) || !ScopePods.Allows(ScopeRead) || ScopeRead.Allows(ScopePods) || ScopePods.Allows(ScopeAdmin) {
+		t.Error("scopes should include the ones below them")
+	}
+	if _, err := ParseScope("root"); err == nil {
+		t.Error("unknown scope accepted")
+	}
+}
+
+func mustList(t *testing.T, store *Store, username string) []Token {
+	list, err := store.List(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"regexp"
+	"unicode"
+)
+
+var usernameRegex = regexp.MustCompile("^[a-zA-Z0-9_-]*$")
+
+// ValidateUsername applies the username rules shared by every backend that registers users
+func ValidateUsername(username string) error {
+	if len(username) < 1 || len(username) > 20 {
+		return fmt.Errorf("Username must be between 1 and 20 characters")
+	}
+
+	if !usernameRegex.MatchString(username) {
+		return fmt.Errorf("Username must only contain letters, numbers, underscores, and hyphens")
+	}
+
+	return nil
+}
+
+// ValidatePassword checks a password is at least 8 characters long and contains a letter and a number
+func ValidatePassword(password string) bool {
+	var number, letter bool
+	if len(password) < 8 {
+		return false
+	}
+	for _, c := range password {
+		switch {
+		case unicode.IsNumber(c):
+			number = true
+		case unicode.IsLetter(c):
+			letter = true
+		}
+	}
+
+	return number && letter
+}
+
+const passwordAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// RandomPassword generates an initial password that passes ValidatePassword. Easily confused characters are left out
+// since these passwords are often handed out on paper.
+func RandomPassword() (string, error) {
+	for {
+		b := make([]byte, 14)
+		for i := range b {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(passwordAlphabet))))
+			if err != nil {
+				return "", fmt.Errorf("Failed to generate password: %v", err)
+			}
+			b[i] = passwordAlphabet[n.Int64()]
+		}
+		if ValidatePassword(string(b)) {
+			return string(b), nil
+		}
+	}
+}
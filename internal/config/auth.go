@@ -1,7 +1,33 @@
 package config
 
+import "time"
+
 type Auth struct {
+	// Chain lists the backends tried at login in order, any of ldap, local and oidc.
+	// When empty the first configured backend is used on its own.
+	Chain []string `mapstructure:"chain"`
+
 	Ldap LdapProvider `mapstructure:"ldap"`
+	Oidc  OidcProvider  `mapstructure:"oidc"`
+	Local LocalProvider `mapstructure:"local"`
+
+	Tokens Tokens `mapstructure:"tokens"`
+
+	Sessions Sessions `mapstructure:"sessions"`
+
+	PasswordReset PasswordReset `mapstructure:"password_reset"`
+
+	Registration Registration `mapstructure:"registration"`
+
+	Lockout Lockout `mapstructure:"lockout"`
+
+	MFA MFA `mapstructure:"mfa"`
+
+	// Roles replace the single admin check with per-route permissions. When empty,
+	// every permission requires the backend to report the user as an admin.
+	Roles []Role `mapstructure:"roles"`
+	// RoleCacheTTL is how long a user's groups are cached before asking the backend again, defaults to a minute
+	RoleCacheTTL time.Duration `mapstructure:"role_cache_ttl"`
 }
 
 type CommonAttributes struct {
@@ -36,4 +62,158 @@ type LdapProvider struct {
 	AdminGroupDN       string   `mapstructure:"admin_group_dn"`
     UserGroupDN        string   `mapstructure:"user_group_dn"`
     UserOU             string   `mapstructure:"user_ou"`
+
+	// Flavor is the kind of directory server: ad (the default) for Active Directory, openldap or 389ds.
+	// It decides the object classes, how passwords are set and how accounts are disabled.
+	Flavor string `mapstructure:"flavor"`
+	// GroupSchema is groupOfNames (the default) or posixGroup outside of Active Directory
+	GroupSchema string `mapstructure:"group_schema"`
+
+	// NestedGroups controls how groups inside groups are resolved: in_chain (the default on Active Directory)
+	// asks the server with LDAP_MATCHING_RULE_IN_CHAIN, recursive (the default elsewhere) follows the parents
+	// of each group, and none only uses the user's direct groups
+	NestedGroups string `mapstructure:"nested_groups"`
+	// MaxGroupDepth bounds recursive resolution, defaults to 10
+	MaxGroupDepth int `mapstructure:"max_group_depth"`
+	// GroupCacheTTL is how long a user's resolved groups are cached, defaults to a minute
+	GroupCacheTTL time.Duration `mapstructure:"group_cache_ttl"`
+
+	// PoolSize caps the connections bound as BindUser that are open at once, defaults to 5
+	PoolSize int `mapstructure:"pool_size"`
+	// DialTimeout bounds connecting to the server, defaults to 10 seconds
+	DialTimeout time.Duration `mapstructure:"dial_timeout"`
+	// RequestTimeout bounds each operation and the wait for a free pooled connection, defaults to 30 seconds
+	RequestTimeout time.Duration `mapstructure:"request_timeout"`
+	// HealthCheckInterval is how long a pooled connection may sit idle before it is checked on reuse, defaults to a minute
+	HealthCheckInterval time.Duration `mapstructure:"health_check_interval"`
+}
+
+type OidcProvider struct {
+	IssuerURL    string `mapstructure:"issuer_url"`
+	ClientID     string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"`
+	RedirectURL  string `mapstructure:"redirect_url"`
+	// Scopes defaults to profile and email, issuers that only release GroupsClaim for a scope need it added here
+	Scopes []string `mapstructure:"scopes"`
+
+	// UsernameClaim is stored as the session id, defaults to preferred_username
+	UsernameClaim string `mapstructure:"username_claim"`
+	// AdminClaim optionally names a boolean claim that marks admins
+	AdminClaim  string   `mapstructure:"admin_claim"`
+	GroupsClaim string   `mapstructure:"groups_claim"`
+	AdminGroups []string `mapstructure:"admin_groups"`
+
+	// PostLoginRedirect is where the browser is sent after the callback, defaults to /
+	PostLoginRedirect string `mapstructure:"post_login_redirect"`
+}
+
+type LocalProvider struct {
+	// DBPath is the bbolt file holding local users
+	DBPath string `mapstructure:"db_path"`
+
+	// BootstrapAdmin is created on startup when no user with that name exists
+	BootstrapAdmin      string `mapstructure:"bootstrap_admin"`
+	BootstrapPassword   string `mapstructure:"bootstrap_password"`
+	DisableRegistration bool   `mapstructure:"disable_registration"`
+}
+
+// Tokens configures personal API tokens, which are disabled unless DBPath is set
+type Tokens struct {
+	DBPath     string        `mapstructure:"db_path"`
+	DefaultTTL time.Duration `mapstructure:"default_ttl"`
+	MaxTTL     time.Duration `mapstructure:"max_ttl"`
+}
+
+// Sessions configures the server-side session store behind the login cookie
+type Sessions struct {
+	// Secret signs the session cookie. When empty a random secret is generated and sessions end on restart.
+	Secret string `mapstructure:"secret"`
+	// PreviousSecrets are still accepted for existing cookies, so the secret can be rotated without logging everyone out
+	PreviousSecrets []string `mapstructure:"previous_secrets"`
+
+	// Store is memory (the default) or bolt, which keeps sessions in DBPath across restarts
+	Store  string `mapstructure:"store"`
+	DBPath string `mapstructure:"db_path"`
+
+	// IdleTimeout ends sessions unused for that long, defaults to 2 hours
+	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+	// AbsoluteTimeout ends sessions that long after login regardless of activity, defaults to 12 hours
+	AbsoluteTimeout time.Duration `mapstructure:"absolute_timeout"`
+
+	Secure   bool   `mapstructure:"secure"`
+	SameSite string `mapstructure:"same_site"` // lax, strict or none
+	Domain   string `mapstructure:"domain"`
+}
+
+// PasswordReset enables the forgotten password flow, which sends users a one-time reset link
+type PasswordReset struct {
+	Enabled bool `mapstructure:"enabled"`
+	// ResetURL is the frontend page that takes the token from its token query parameter,
+	// defaults to /reset-password under the external URL
+	ResetURL string `mapstructure:"reset_url"`
+	// TokenTTL is how long a reset link stays valid, defaults to 30 minutes
+	TokenTTL time.Duration `mapstructure:"token_ttl"`
+
+	SMTP SMTP `mapstructure:"smtp"`
+}
+
+type SMTP struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	From     string `mapstructure:"from"`
+}
+
+// Registration controls who may create an account through /register
+type Registration struct {
+	// Mode is open (the default), invite, where an invite code is required, or approval, where
+	// registrations without an invite code wait for an admin to approve them
+	Mode string `mapstructure:"mode"`
+	// DBPath is the bbolt file holding invite codes and pending registrations, required unless the mode is open
+	DBPath string `mapstructure:"db_path"`
+	// Secret encrypts the passwords of pending registrations until they are approved, required in approval mode
+	Secret string `mapstructure:"secret"`
+}
+
+type Role struct {
+	Name string `mapstructure:"name"`
+	// Groups grant the role to their members, e.g. LDAP group DNs or OIDC group names
+	Groups []string `mapstructure:"groups"`
+	// BackendAdmins grants the role to users the auth backend reports as admins, checked again whenever the cached groups expire
+	BackendAdmins bool `mapstructure:"backend_admins"`
+	// Permissions held by the role, such as pods.bulk_delete, or * for all of them
+	Permissions []string `mapstructure:"permissions"`
+}
+
+// Lockout slows down and then blocks repeated failed logins, per username and per client address
+type Lockout struct {
+	Disabled bool `mapstructure:"disabled"`
+
+	// MaxAttempts is how many failed logins lock a username, defaults to 5
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// IPMaxAttempts is how many failed logins lock a client address, defaults to 50 since classrooms often share one
+	IPMaxAttempts int `mapstructure:"ip_max_attempts"`
+	// BaseDelay is the wait after a username's first failure, doubled after each further one up to MaxDelay.
+	// Defaults to a second and 30 seconds.
+	BaseDelay time.Duration `mapstructure:"base_delay"`
+	MaxDelay  time.Duration `mapstructure:"max_delay"`
+	// Duration is how long a lockout lasts unless an admin lifts it, defaults to 15 minutes
+	Duration time.Duration `mapstructure:"duration"`
+	// Window is how long failures are remembered, defaults to 15 minutes
+	Window time.Duration `mapstructure:"window"`
+
+	// AuditLog is a file lockouts and unlocks are appended to, defaults to the main log
+	AuditLog string `mapstructure:"audit_log"`
+}
+
+// MFA lets users protect their account with TOTP codes. It is disabled unless DBPath is set.
+type MFA struct {
+	DBPath string `mapstructure:"db_path"`
+	// Secret encrypts the stored TOTP secrets
+	Secret string `mapstructure:"secret"`
+	// Issuer is the name authenticator apps show for the account, defaults to goclone
+	Issuer string `mapstructure:"issuer"`
+	// RequiredRoles must enroll and enter a code at login before they can use the API, e.g. admin
+	RequiredRoles []string `mapstructure:"required_roles"`
 }
@@ -21,6 +21,20 @@ type Config struct {
     Core Core `mapstructure:"core"`
     Auth Auth `mapstructure:"auth"`
     Provider Provider `mapstructure:"provider"`
+    Providers []Provider `mapstructure:"providers"`
+    Jobs Jobs `mapstructure:"jobs"`
+    Orphans Orphans `mapstructure:"orphans"`
+    Leases Leases `mapstructure:"leases"`
+}
+
+// AllProviders returns every configured provider. The single provider block is kept for
+// existing configs and is served alongside anything listed under providers.
+func (c *Config) AllProviders() []Provider {
+    var all []Provider
+    if c.Provider.Kind() != "" {
+        all = append(all, c.Provider)
+    }
+    return append(all, c.Providers...)
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -0,0 +1,15 @@
+package config
+
+import "time"
+
+// Jobs configures the workers clones run on after the request that asked for them has returned
+type Jobs struct {
+	// Disabled clones within the request again, for clients that cannot follow a job
+	Disabled bool `mapstructure:"disabled"`
+	// Workers is how many clones run at once, defaults to 4
+	Workers int `mapstructure:"workers"`
+	// QueueSize is how many clones may wait for a worker before new ones are refused, defaults to 100
+	QueueSize int `mapstructure:"queue_size"`
+	// Retention is how long finished jobs can still be looked up, defaults to a day
+	Retention time.Duration `mapstructure:"retention"`
+}
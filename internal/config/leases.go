@@ -0,0 +1,35 @@
+package config
+
+import "time"
+
+// Leases expire pods after a while so they stop holding on to capacity. They are off unless DBPath is set.
+type Leases struct {
+	// DBPath is where leases are kept across restarts
+	DBPath string `mapstructure:"db_path"`
+	// DefaultTTL is how long a pod lives before it is deleted, defaults to 7 days
+	DefaultTTL time.Duration `mapstructure:"default_ttl"`
+	// Templates replace the default TTL for pods cloned from the templates named
+	Templates []LeaseTTL `mapstructure:"templates"`
+	// Roles give the users holding them the longest of their TTLs when it is longer than the template's
+	Roles []LeaseTTL `mapstructure:"roles"`
+
+	// Extension is the most a single extension adds to a lease, defaults to the default TTL
+	Extension time.Duration `mapstructure:"extension"`
+	// MaxExtensions is how many times a lease may be extended, defaults to 3
+	MaxExtensions int `mapstructure:"max_extensions"`
+	// MaxLifetime is how long after it was cloned extensions can keep a pod around, defaults to 30 days
+	MaxLifetime time.Duration `mapstructure:"max_lifetime"`
+
+	// WarnBefore is how long before a pod expires its owner is warned, defaults to a day
+	WarnBefore time.Duration `mapstructure:"warn_before"`
+	// Interval is the time between checks for pods to warn about or delete, defaults to 5 minutes
+	Interval time.Duration `mapstructure:"interval"`
+	// SMTP sends the warnings by email, otherwise they are printed
+	SMTP SMTP `mapstructure:"smtp"`
+}
+
+// LeaseTTL sets the TTL of pods cloned from a template or by a role
+type LeaseTTL struct {
+	Name string        `mapstructure:"name"`
+	TTL  time.Duration `mapstructure:"ttl"`
+}
@@ -0,0 +1,13 @@
+package config
+
+import "time"
+
+// Orphans configures the scans for resources left behind by failed clones and manual deletes
+type Orphans struct {
+	// Disabled turns the scans off
+	Disabled bool `mapstructure:"disabled"`
+	// Interval is the time between scans, defaults to 10 minutes
+	Interval time.Duration `mapstructure:"interval"`
+	// AutoCleanup removes what two scans in a row found, otherwise it is only reported
+	AutoCleanup bool `mapstructure:"auto_cleanup"`
+}
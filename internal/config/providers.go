@@ -11,6 +11,29 @@ type Provider struct {
     Domain             string `mapstructure:"domain"`
 
 	VCenter VCenter `mapstructure:"vcenter"`
+	Proxmox Proxmox `mapstructure:"proxmox"`
+	Fake    Fake    `mapstructure:"fake"`
+}
+
+// Kind returns which backend a provider block configures, or "" if none is set
+func (p Provider) Kind() string {
+    switch {
+    case p.Fake.Enabled:
+        return "fake"
+    case p.VCenter != VCenter{}:
+        return "vsphere"
+    case p.Proxmox != Proxmox{}:
+        return "proxmox"
+    }
+    return ""
+}
+
+// DisplayName is the name templates from this provider are namespaced under
+func (p Provider) DisplayName() string {
+    if p.Name != "" {
+        return p.Name
+    }
+    return p.Kind()
 }
 
 type VCenter struct {
@@ -37,4 +60,52 @@ type VCenter struct {
     CompetitionResourcePool    string `mapstructure:"competition_resource_pool"`
     CompetitionStartPortGroup  int    `mapstructure:"competition_start_port_group"`
     CompetitionWanPortGroup    string `mapstructure:"competition_wan_port_group"`
+    Simulator                  bool   `mapstructure:"simulator"`
+}
+
+type Proxmox struct {
+    Node                      string `mapstructure:"node"`
+    Realm                     string `mapstructure:"realm"`
+    SkipTLSVerify             bool   `mapstructure:"skip_tls_verify"`
+    CloneRole                 string `mapstructure:"clone_role"`
+    CustomCloneRole           string `mapstructure:"custom_clone_role"`
+    PodBridge                 string `mapstructure:"pod_bridge"`
+    WanBridge                 string `mapstructure:"wan_bridge"`
+    WanVlan                   int    `mapstructure:"wan_vlan"`
+    CompetitionWanVlan        int    `mapstructure:"competition_wan_vlan"`
+    PresetTemplatePrefix      string `mapstructure:"preset_template_prefix"`
+    CustomTemplatePrefix      string `mapstructure:"custom_template_prefix"`
+    RouterTemplate            string `mapstructure:"router_template"`
+    NattedRouterTemplate      string `mapstructure:"natted_router_template"`
+    RouterProgram             string `mapstructure:"router_program"`
+    RouterProgramArgs         string `mapstructure:"router_program_args"`
+    StartingPortGroup         int    `mapstructure:"starting_port_group"`
+    EndingPortGroup           int    `mapstructure:"ending_port_group"`
+    CompetitionStartPortGroup int    `mapstructure:"competition_start_port_group"`
+    CompetitionEndPortGroup   int    `mapstructure:"competition_end_port_group"`
+}
+
+type Fake struct {
+    Enabled                   bool                 `mapstructure:"enabled"`
+    ServerGUID                string               `mapstructure:"server_guid"`
+    StartingPortGroup         int                  `mapstructure:"starting_port_group"`
+    EndingPortGroup           int                  `mapstructure:"ending_port_group"`
+    CompetitionStartPortGroup int                  `mapstructure:"competition_start_port_group"`
+    CompetitionEndPortGroup   int                  `mapstructure:"competition_end_port_group"`
+    Templates                 []FakeTemplate       `mapstructure:"templates"`
+    CustomTemplates           []FakeCustomTemplate `mapstructure:"custom_templates"`
+}
+
+type FakeTemplate struct {
+    Name           string   `mapstructure:"name"`
+    VMs            []string `mapstructure:"vms"`
+    Natted         bool     `mapstructure:"natted"`
+    NoRouter       bool     `mapstructure:"no_router"`
+    CompetitionPod bool     `mapstructure:"competition_pod"`
+    AdminOnly      bool     `mapstructure:"admin_only"`
+}
+
+type FakeCustomTemplate struct {
+    Name string   `mapstructure:"name"`
+    VMs  []string `mapstructure:"vms"`
 }
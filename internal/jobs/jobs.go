@@ -0,0 +1,393 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"goclone/internal/config"
+	"goclone/internal/providers"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultWorkers   = 4
+	defaultQueueSize = 100
+	defaultRetention = 24 * time.Hour
+
+	// maxEvents bounds a job's log, past it progress updates are only passed on to subscribers
+	maxEvents = 1000
+	// subscriberBuffer is how far a subscriber may fall behind before it is dropped
+	subscriberBuffer = 64
+)
+
+var (
+	ErrNotFound  = fmt.Errorf("Job not found")
+	ErrQueueFull = fmt.Errorf("Too many clones waiting, try again later")
+	ErrClosed    = fmt.Errorf("Job manager is shutting down")
+)
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Step is one of the providers.Step* stages of a clone
+type Step struct {
+	Name       string     `json:"name"`
+	Status     Status     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Event types, in the order a job logs them
+const (
+	EventJobStarted   = "job_started"
+	EventStepStarted  = "step_started"
+	EventStepProgress = "step_progress"
+	EventStepFinished = "step_finished"
+	EventJobFinished  = "job_finished"
+)
+
+// Event is an entry of a job's log. IDs count up from 1 within the job, so clients that lost the
+// stream can pick it up after the last event they saw.
+type Event struct {
+	ID   int       `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	providers.Update
+	// Status is set on job_finished
+	Status Status `json:"status,omitempty"`
+}
+
+// Job is a clone running in the background. Kind and Target say what is cloned, for example
+// a template clone of the template's name.
+type Job struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind"`
+	Owner  string `json:"owner"`
+	Target string `json:"target"`
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// CleanupErrors lists what a failed clone could not tear down
+	CleanupErrors []string   `json:"cleanupErrors,omitempty"`
+	Steps         []Step     `json:"steps"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	StartedAt     *time.Time `json:"startedAt,omitempty"`
+	FinishedAt    *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Done reports whether the job has finished, either way
+func (j *Job) Done() bool {
+	return j.Status == StatusSucceeded || j.Status == StatusFailed
+}
+
+func (j *Job) clone() Job {
+	c := *j
+	c.Steps = append([]Step{}, j.Steps...)
+	c.CleanupErrors = append([]string(nil), j.CleanupErrors...)
+	return c
+}
+
+// Func does a job's work. Providers called with ctx report their steps to the job.
+type Func func(ctx context.Context) error
+
+type task struct {
+	id string
+	fn Func
+}
+
+// entry is a job with its log and the channels following it
+type entry struct {
+	job    Job
+	events []Event
+	nextID int
+	subs   map[chan Event]struct{}
+}
+
+// Manager runs jobs on a fixed number of workers and keeps them in memory for Retention after they finish
+type Manager struct {
+	conf  config.Jobs
+	queue chan task
+	wg    sync.WaitGroup
+
+	mu     sync.Mutex
+	jobs   map[string]*entry
+	closed bool
+}
+
+func NewManager(conf config.Jobs) *Manager {
+	if conf.Workers <= 0 {
+		conf.Workers = defaultWorkers
+	}
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = defaultQueueSize
+	}
+	if conf.Retention <= 0 {
+		conf.Retention = defaultRetention
+	}
+
+	m := &Manager{
+		conf:  conf,
+		queue: make(chan task, conf.QueueSize),
+		jobs:  map[string]*entry{},
+	}
+	for i := 0; i < conf.Workers; i++ {
+		m.wg.Add(1)
+		go m.work()
+	}
+	return m
+}
+
+// Close stops taking jobs and waits for the queued ones to finish
+func (m *Manager) Close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	close(m.queue)
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+// Submit queues fn and returns the job at once. It fails with ErrQueueFull when QueueSize jobs are already waiting.
+func (m *Manager) Submit(kind, owner, target string, fn Func) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return Job{}, ErrClosed
+	}
+	m.prune(time.Now())
+
+	job := Job{
+		ID:        uuid.NewString(),
+		Kind:      kind,
+		Owner:     owner,
+		Target:    target,
+		Status:    StatusQueued,
+		Steps:     []Step{},
+		CreatedAt: time.Now(),
+	}
+
+	select {
+	case m.queue <- task{id: job.ID, fn: fn}:
+	default:
+		return Job{}, ErrQueueFull
+	}
+	m.jobs[job.ID] = &entry{job: job, subs: map[chan Event]struct{}{}}
+	return job.clone(), nil
+}
+
+// Get returns a copy of the job
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return e.job.clone(), nil
+}
+
+// Subscribe returns the events of the job after the event with ID after, and a channel with the ones
+// still to come. The channel is closed once the job finished, when cancel is called, or when the
+// subscriber fell too far behind; the last event received tells which.
+func (m *Manager) Subscribe(id string, after int) (past []Event, live <-chan Event, cancel func(), err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.jobs[id]
+	if !ok {
+		return nil, nil, nil, ErrNotFound
+	}
+
+	for _, event := range e.events {
+		if event.ID > after {
+			past = append(past, event)
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	if e.job.Done() {
+		close(ch)
+		return past, ch, func() {}, nil
+	}
+	e.subs[ch] = struct{}{}
+	cancel = func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := e.subs[ch]; ok {
+			delete(e.subs, ch)
+			close(ch)
+		}
+	}
+	return past, ch, cancel, nil
+}
+
+// List returns the jobs of owner, or every job when owner is empty, newest first
+func (m *Manager) List(owner string) []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := []Job{}
+	for _, e := range m.jobs {
+		if owner == "" || strings.EqualFold(e.job.Owner, owner) {
+			jobs = append(jobs, e.job.clone())
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+func (m *Manager) work() {
+	defer m.wg.Done()
+	for t := range m.queue {
+		m.run(t)
+	}
+}
+
+func (m *Manager) run(t task) {
+	m.update(t.id, func(e *entry) {
+		now := time.Now()
+		e.job.Status = StatusRunning
+		e.job.StartedAt = &now
+		e.publish(Event{Type: EventJobStarted, Time: now})
+	})
+
+	err := m.call(t)
+
+	m.update(t.id, func(e *entry) {
+		job := &e.job
+		now := time.Now()
+		job.FinishedAt = &now
+		job.Status = StatusSucceeded
+		if err != nil {
+			job.Status = StatusFailed
+			job.Error = err.Error()
+			var cloneErr *providers.CloneError
+			if errors.As(err, &cloneErr) {
+				job.CleanupErrors = cloneErr.CleanupErrors()
+			}
+		}
+		// a step the provider never finished did not get anywhere either
+		for i := range job.Steps {
+			if job.Steps[i].Status == StatusRunning {
+				job.Steps[i].Status = job.Status
+				job.Steps[i].FinishedAt = &now
+				if err != nil {
+					job.Steps[i].Error = job.Error
+				}
+				e.publish(Event{Type: EventStepFinished, Time: now, Update: providers.Update{Step: job.Steps[i].Name, Error: job.Steps[i].Error}})
+			}
+		}
+		e.publish(Event{Type: EventJobFinished, Time: now, Status: job.Status, Update: providers.Update{Error: job.Error}})
+		for ch := range e.subs {
+			delete(e.subs, ch)
+			close(ch)
+		}
+	})
+}
+
+// call runs the job's function, turning a panic into an error so one bad clone cannot take the workers down
+func (m *Manager) call(t task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Job panicked: %v", r)
+		}
+	}()
+	return t.fn(providers.WithProgress(context.Background(), &progress{m: m, id: t.id}))
+}
+
+func (m *Manager) update(id string, fn func(e *entry)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.jobs[id]; ok {
+		fn(e)
+	}
+}
+
+// publish logs event and passes it on to the subscribers. It must be called with m.mu held.
+func (e *entry) publish(event Event) {
+	e.nextID++
+	event.ID = e.nextID
+	if event.Type != EventStepProgress || len(e.events) < maxEvents {
+		e.events = append(e.events, event)
+	}
+	for ch := range e.subs {
+		select {
+		case ch <- event:
+		default:
+			// the client can reconnect with the last event it got
+			delete(e.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// prune must be called with m.mu held
+func (m *Manager) prune(now time.Time) {
+	for id, e := range m.jobs {
+		if e.job.FinishedAt != nil && now.Sub(*e.job.FinishedAt) > m.conf.Retention {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// progress records the steps a provider reports on the job
+type progress struct {
+	m  *Manager
+	id string
+}
+
+func (p *progress) StepStarted(step string) {
+	p.m.update(p.id, func(e *entry) {
+		now := time.Now()
+		s := Step{Name: step, Status: StatusRunning, StartedAt: now}
+		e.publish(Event{Type: EventStepStarted, Time: now, Update: providers.Update{Step: step}})
+		for i := range e.job.Steps {
+			if e.job.Steps[i].Name == step {
+				e.job.Steps[i] = s
+				return
+			}
+		}
+		e.job.Steps = append(e.job.Steps, s)
+	})
+}
+
+func (p *progress) StepFinished(step string, err error) {
+	p.m.update(p.id, func(e *entry) {
+		for i := range e.job.Steps {
+			if e.job.Steps[i].Name != step {
+				continue
+			}
+			now := time.Now()
+			e.job.Steps[i].FinishedAt = &now
+			e.job.Steps[i].Status = StatusSucceeded
+			if err != nil {
+				e.job.Steps[i].Status = StatusFailed
+				e.job.Steps[i].Error = err.Error()
+			}
+			e.publish(Event{Type: EventStepFinished, Time: now, Update: providers.Update{Step: step, Error: e.job.Steps[i].Error}})
+			return
+		}
+	})
+}
+
+func (p *progress) StepUpdated(update providers.Update) {
+	p.m.update(p.id, func(e *entry) {
+		e.publish(Event{Type: EventStepProgress, Time: time.Now(), Update: update})
+	})
+}
@@ -0,0 +1,200 @@
+package jobs_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"goclone/internal/config"
+	"goclone/internal/jobs"
+	"goclone/internal/providers"
+)
+
+func wait(t *testing.T, m *jobs.Manager, id string) jobs.Job {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		job, err := m.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Done() {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return jobs.Job{}
+}
+
+func TestSteps(t *testing.T) {
+	m := jobs.NewManager(config.Jobs{})
+	defer m.Close()
+
+	job, err := m.Submit("template", "alice", "Web", func(ctx context.Context) error {
+		providers.RunStep(ctx, providers.StepPortGroup, func() error { return nil })
+		providers.StartStep(ctx, providers.StepCloneVMs)
+		return fmt.Errorf("Failed to clone VM: out of space")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != jobs.StatusQueued || job.ID == "" {
+		t.Errorf("unexpected submitted job %+v", job)
+	}
+
+	job = wait(t, m, job.ID)
+	if job.Status != jobs.StatusFailed || job.Error != "Failed to clone VM: out of space" {
+		t.Errorf("unexpected job %+v", job)
+	}
+	if len(job.Steps) != 2 {
+		t.Fatalf("expected 2 steps, got %+v", job.Steps)
+	}
+	if job.Steps[0].Name != providers.StepPortGroup || job.Steps[0].Status != jobs.StatusSucceeded {
+		t.Errorf("unexpected first step %+v", job.Steps[0])
+	}
+	// the step the clone never finished takes the job's error
+	if job.Steps[1].Status != jobs.StatusFailed || job.Steps[1].Error != job.Error || job.Steps[1].FinishedAt == nil {
+		t.Errorf("unexpected second step %+v", job.Steps[1])
+	}
+}
+
+func TestPanic(t *testing.T) {
+	m := jobs.NewManager(config.Jobs{Workers: 1})
+	defer m.Close()
+
+	job, err := m.Submit("custom", "alice", "pod", func(ctx context.Context) error {
+		panic("boom")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job = wait(t, m, job.ID); job.Status != jobs.StatusFailed {
+		t.Errorf("expected the panic to fail the job, got %+v", job)
+	}
+
+	// the worker survived
+	job, err = m.Submit("custom", "alice", "pod", func(ctx context.Context) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job = wait(t, m, job.ID); job.Status != jobs.StatusSucceeded {
+		t.Errorf("unexpected job %+v", job)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	m := jobs.NewManager(config.Jobs{Workers: 1, QueueSize: 1})
+	release := make(chan struct{})
+	defer m.Close()
+	defer close(release)
+
+	started := make(chan struct{})
+	block := func(ctx context.Context) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}
+	if _, err := m.Submit("template", "alice", "Web", block); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// one waits for the busy worker, the next is refused
+	if _, err := m.Submit("template", "alice", "Web", func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Submit("template", "bob", "Web", func(ctx context.Context) error { return nil }); err != jobs.ErrQueueFull {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+
+	if got := len(m.List("alice")); got != 2 {
+		t.Errorf("expected 2 jobs for alice, got %d", got)
+	}
+	if got := len(m.List("bob")); got != 0 {
+		t.Errorf("expected no jobs for bob, got %d", got)
+	}
+	if _, err := m.Get("missing"); err != jobs.ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	m := jobs.NewManager(config.Jobs{Retention: time.Millisecond})
+	defer m.Close()
+
+	job, err := m.Submit("template", "alice", "Web", func(ctx context.Context) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait(t, m, job.ID)
+	time.Sleep(5 * time.Millisecond)
+
+	// finished jobs are dropped as new ones come in
+	if _, err := m.Submit("template", "alice", "Web", func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get(job.ID); err != jobs.ErrNotFound {
+		t.Errorf("expected the old job to be gone, got %v", err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	m := jobs.NewManager(config.Jobs{})
+	defer m.Close()
+
+	release := make(chan struct{})
+	job, err := m.Submit("template", "alice", "Web", func(ctx context.Context) error {
+		<-release
+		return providers.RunStep(ctx, providers.StepCloneVMs, func() error {
+			providers.Report(ctx, providers.Update{Step: providers.StepCloneVMs, VM: "1801-Kali", Percent: 40})
+			providers.Report(ctx, providers.Update{Step: providers.StepCloneVMs, VM: "1801-Kali", Percent: 100, Message: "Cloned"})
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	past, live, cancel, err := m.Subscribe(job.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	close(release)
+
+	events := past
+	for event := range live {
+		events = append(events, event)
+	}
+
+	types := []string{}
+	for i, event := range events {
+		if event.ID != i+1 {
+			t.Errorf("expected event %d to have ID %d, got %d", i, i+1, event.ID)
+		}
+		types = append(types, event.Type)
+	}
+	expected := []string{jobs.EventJobStarted, jobs.EventStepStarted, jobs.EventStepProgress, jobs.EventStepProgress, jobs.EventStepFinished, jobs.EventJobFinished}
+	if fmt.Sprint(types) != fmt.Sprint(expected) {
+		t.Fatalf("expected events %v, got %v", expected, types)
+	}
+	if events[3].VM != "1801-Kali" || events[3].Percent != 100 {
+		t.Errorf("unexpected progress event %+v", events[3])
+	}
+	if events[5].Status != jobs.StatusSucceeded {
+		t.Errorf("unexpected final event %+v", events[5])
+	}
+
+	// a finished job replays what came after the last event a client saw
+	past, live, _, err = m.Subscribe(job.ID, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(past) != 2 || past[0].ID != 5 {
+		t.Errorf("expected the last 2 events, got %+v", past)
+	}
+	if _, ok := <-live; ok {
+		t.Error("the live channel of a finished job should be closed")
+	}
+}
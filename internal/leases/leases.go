@@ -0,0 +1,553 @@
+package leases
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"goclone/internal/auth"
+	"goclone/internal/config"
+	"goclone/internal/providers"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultTTL           = 7 * 24 * time.Hour
+	defaultMaxExtensions = 3
+	defaultMaxLifetime   = 30 * 24 * time.Hour
+	defaultWarnBefore    = 24 * time.Hour
+	defaultInterval      = 5 * time.Minute
+)
+
+var leasesBucket = []byte("leases")
+
+var (
+	ErrLeaseNotFound  = fmt.Errorf("Lease not found")
+	ErrExtensionLimit = fmt.Errorf("Lease cannot be extended any further")
+	ErrAmbiguousPod   = fmt.Errorf("Pod is on several servers, pick one with server")
+	ErrLeaseExpired   = fmt.Errorf("Lease expired, the pod is being deleted")
+)
+
+// Lease is how long a pod may live. Pods are identified by ServerGUID and PodID like providers.Pod.
+type Lease struct {
+	PodID      string `json:"podId"`
+	ServerGUID string `json:"serverGuid"`
+	Owner      string `json:"owner"`
+	Template   string `json:"template,omitempty"`
+
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires"`
+	Extensions int       `json:"extensions"`
+	// Warned is when the owner was told the pod is about to expire, reset by extensions
+	Warned *time.Time `json:"warned,omitempty"`
+	// Adopted is set for leases given to pods that were found without one.
+	// Their owner is the one the provider recorded, and their template is read from the pod's name.
+	Adopted bool `json:"adopted"`
+}
+
+func (l *Lease) key() string {
+	return l.ServerGUID + "/" + l.PodID
+}
+
+// Notifier sends the expiry warnings, reset.SMTPNotifier and reset.LogNotifier both fit
+type Notifier interface {
+	Notify(to, subject, body string) error
+}
+
+// Manager keeps a lease for every pod. Every Interval it gives pods without one the TTL of their template,
+// warns owners of pods expiring within WarnBefore and deletes the pods whose lease is up.
+type Manager struct {
+	conf     config.Leases
+	db       *bolt.DB
+	provider providers.Provider
+	// warnings are only logged when emails or notifier is nil
+	emails   auth.EmailResolver
+	notifier Notifier
+
+	// mu keeps the checks from racing with new leases and extensions
+	mu sync.Mutex
+	// expiring holds the keys of the leases whose pod is being deleted, so they cannot be extended meanwhile.
+	// It is guarded by mu.
+	expiring map[string]bool
+	// checking keeps checks from overlapping
+	checking sync.Mutex
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewManager(conf config.Leases, provider providers.Provider, emails auth.EmailResolver, notifier Notifier) (*Manager, error) {
+	if conf.DefaultTTL <= 0 {
+		conf.DefaultTTL = defaultTTL
+	}
+	if conf.Extension <= 0 {
+		conf.Extension = conf.DefaultTTL
+	}
+	if conf.MaxExtensions <= 0 {
+		conf.MaxExtensions = defaultMaxExtensions
+	}
+	if conf.MaxLifetime <= 0 {
+		conf.MaxLifetime = defaultMaxLifetime
+	}
+	if conf.WarnBefore <= 0 {
+		conf.WarnBefore = defaultWarnBefore
+	}
+	if conf.Interval <= 0 {
+		conf.Interval = defaultInterval
+	}
+
+	db, err := bolt.Open(conf.DBPath, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open lease database: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(leasesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to create leases bucket: %v", err)
+	}
+
+	m := &Manager{
+		conf:     conf,
+		db:       db,
+		provider: provider,
+		emails:   emails,
+		notifier: notifier,
+		expiring: map[string]bool{},
+		stop:     make(chan struct{}),
+	}
+	go m.run()
+	return m, nil
+}
+
+// Close stops the checks and closes the database
+func (m *Manager) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+	})
+	m.checking.Lock()
+	defer m.checking.Unlock()
+	return m.db.Close()
+}
+
+// TTL returns how long a pod cloned from template by a user holding roles lives.
+// A template TTL replaces the default, and the longest role TTL wins when it is longer still.
+func (m *Manager) TTL(template string, roles []string) time.Duration {
+	// preset templates are namespaced by provider when several are registered
+	if _, name, ok := strings.Cut(template, providers.TemplateSeparator); ok {
+		template = name
+	}
+
+	ttl := m.conf.DefaultTTL
+	for _, t := range m.conf.Templates {
+		if strings.EqualFold(t.Name, template) && t.TTL > 0 {
+			ttl = t.TTL
+		}
+	}
+	for _, r := range m.conf.Roles {
+		for _, role := range roles {
+			if strings.EqualFold(r.Name, role) && r.TTL > ttl {
+				ttl = r.TTL
+			}
+		}
+	}
+	return ttl
+}
+
+// Track runs clone and gives the pod it creates for owner a lease of ttl. name is the template or
+// custom pod name the pod is named after. If the new pod cannot be found it is adopted by the next check.
+func (m *Manager) Track(ctx context.Context, owner, name string, ttl time.Duration, clone func(ctx context.Context) error) error {
+	before, err := m.provider.ListPods(ctx, owner)
+	if err != nil {
+		// without knowing which pods were there, the new one is left to be adopted
+		return clone(ctx)
+	}
+
+	err = clone(ctx)
+	if err != nil {
+		return err
+	}
+
+	after, err := m.provider.ListPods(ctx, owner)
+	if err != nil {
+		log.Printf("leases: failed to find the pod %s cloned: %v", owner, err)
+		return nil
+	}
+
+	existing := map[string]bool{}
+	for _, pod := range before {
+		existing[pod.ServerGUID+"/"+pod.Name] = true
+	}
+	if _, bare, ok := strings.Cut(name, providers.TemplateSeparator); ok {
+		name = bare
+	}
+	suffix := strings.ToLower("_" + name + "_" + owner)
+
+	now := time.Now()
+	for _, pod := range after {
+		if existing[pod.ServerGUID+"/"+pod.Name] || !strings.HasSuffix(strings.ToLower(pod.Name), suffix) {
+			continue
+		}
+		// the pod was just created, so a lease left over from an earlier pod of the same name is replaced
+		lease := &Lease{PodID: pod.Name, ServerGUID: pod.ServerGUID, Owner: owner, Template: name, Created: now, Expires: now.Add(ttl)}
+		if err := m.put(lease); err != nil {
+			log.Printf("leases: %v", err)
+		}
+	}
+	return nil
+}
+
+// List returns the leases of owner's pods, or of every pod when owner is empty, soonest to expire first
+func (m *Manager) List(owner string) ([]Lease, error) {
+	leases := []Lease{}
+	err := m.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(leasesBucket).ForEach(func(k, v []byte) error {
+			var lease Lease
+			if err := json.Unmarshal(v, &lease); err != nil {
+				return err
+			}
+			if owner == "" || strings.EqualFold(lease.Owner, owner) {
+				leases = append(leases, lease)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list leases: %v", err)
+	}
+
+	sort.Slice(leases, func(i, j int) bool {
+		return leases[i].Expires.Before(leases[j].Expires)
+	})
+	return leases, nil
+}
+
+// Extend pushes back the expiry of owner's pod by by, or by the configured extension when by is zero.
+// serverGUID may be empty unless owner has pods of the same name on several servers.
+// A lease is extended at most MaxExtensions times and never past MaxLifetime after it was created.
+func (m *Manager) Extend(owner, podID, serverGUID string, by time.Duration) (*Lease, error) {
+	if by == 0 {
+		by = m.conf.Extension
+	}
+	if by < 0 || by > m.conf.Extension {
+		return nil, fmt.Errorf("Leases may be extended by at most %s", m.conf.Extension)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var lease *Lease
+	err := m.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(leasesBucket)
+		var err error
+		lease, err = findLease(b, podID, serverGUID)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(lease.Owner, owner) {
+			return ErrLeaseNotFound
+		}
+		if m.expiring[lease.key()] {
+			return ErrLeaseExpired
+		}
+		if lease.Extensions >= m.conf.MaxExtensions {
+			return ErrExtensionLimit
+		}
+
+		now := time.Now()
+		expires := lease.Expires
+		if expires.Before(now) {
+			expires = now
+		}
+		expires = expires.Add(by)
+		if limit := lease.Created.Add(m.conf.MaxLifetime); expires.After(limit) {
+			expires = limit
+		}
+		if !expires.After(lease.Expires) {
+			return ErrExtensionLimit
+		}
+
+		lease.Expires = expires
+		lease.Extensions++
+		lease.Warned = nil
+		return putLease(b, lease)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// Check adopts pods without a lease, forgets the leases of pods that are gone, warns the owners of
+// pods about to expire and deletes the expired ones. It runs every Interval on its own.
+func (m *Manager) Check(ctx context.Context) {
+	m.checking.Lock()
+	defer m.checking.Unlock()
+
+	select {
+	case <-m.stop:
+		return
+	default:
+	}
+
+	pods, err := m.provider.ListAllPods(ctx)
+	synced := err == nil
+	if !synced {
+		// pods missing from a partial list must not lose their lease
+		log.Printf("leases: failed to list pods, not syncing leases: %v", err)
+	}
+
+	now := time.Now()
+	var warn, expired []Lease
+	m.mu.Lock()
+	err = m.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(leasesBucket)
+		current := map[string]bool{}
+		for _, pod := range pods {
+			lease := &Lease{PodID: pod.Name, ServerGUID: pod.ServerGUID}
+			current[lease.key()] = true
+			if !synced || b.Get([]byte(lease.key())) != nil {
+				continue
+			}
+			m.adopt(lease, pod, now)
+			if err := putLease(b, lease); err != nil {
+				return err
+			}
+		}
+
+		var gone [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var lease Lease
+			if err := json.Unmarshal(v, &lease); err != nil {
+				return err
+			}
+			switch {
+			case synced && !current[string(k)]:
+				gone = append(gone, k)
+			case !now.Before(lease.Expires):
+				expired = append(expired, lease)
+			case lease.Warned == nil && !now.Before(lease.Expires.Add(-m.conf.WarnBefore)):
+				warn = append(warn, lease)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range gone {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	m.mu.Unlock()
+	if err != nil {
+		log.Printf("leases: failed to sync leases: %v", err)
+		return
+	}
+
+	for _, lease := range warn {
+		m.warn(lease, now)
+	}
+	for _, lease := range expired {
+		m.expire(ctx, lease)
+	}
+}
+
+// adopt fills in a lease for pod, found without one. The owner is the one the provider recorded.
+// Pod names are <pg>_<name>_<owner>, where both the name and the owner may contain underscores,
+// so the name is what is left between the port group and the owner.
+func (m *Manager) adopt(lease *Lease, pod providers.Pod, now time.Time) {
+	lease.Owner = pod.Owner
+	if _, rest, ok := strings.Cut(pod.Name, "_"); ok {
+		suffix := "_" + strings.ToLower(pod.Owner)
+		if pod.Owner != "" && strings.HasSuffix(strings.ToLower(rest), suffix) {
+			lease.Template = rest[:len(rest)-len(suffix)]
+		}
+	}
+	lease.Created = now
+	lease.Expires = now.Add(m.TTL(lease.Template, nil))
+	lease.Adopted = true
+	log.Printf("leases: adopted pod %s, expiring %s", lease.PodID, lease.Expires.Format(time.RFC3339))
+}
+
+// warn tells the owner their pod is about to be deleted and records that they were told
+func (m *Manager) warn(lease Lease, now time.Time) {
+	subject := fmt.Sprintf("Your pod %s expires soon", lease.PodID)
+	body := fmt.Sprintf("Your pod %s will be deleted at %s.\r\n\r\n"+
+		"Extend its lease if you still need it.\r\n", lease.PodID, lease.Expires.Format(time.RFC1123))
+
+	var email string
+	if m.emails != nil && lease.Owner != "" {
+		var err error
+		email, err = m.emails.UserEmail(lease.Owner)
+		if err != nil {
+			log.Printf("leases: failed to look up the email of %s: %v", lease.Owner, err)
+		}
+	}
+	if email == "" || m.notifier == nil {
+		log.Printf("leases: pod %s of %s expires at %s", lease.PodID, lease.Owner, lease.Expires.Format(time.RFC3339))
+	} else if err := m.notifier.Notify(email, subject, body); err != nil {
+		// the warning is tried again on the next check
+		log.Printf("leases: failed to warn %s about pod %s: %v", lease.Owner, lease.PodID, err)
+		return
+	}
+
+	m.update(lease, func(current *Lease) bool {
+		if !current.Expires.Equal(lease.Expires) {
+			return false
+		}
+		current.Warned = &now
+		return true
+	})
+}
+
+// expire deletes a pod whose lease is up, unless it was extended since the check found it.
+// The lease is marked as expiring while the pod is deleted, without holding mu, since that can take minutes.
+func (m *Manager) expire(ctx context.Context, lease Lease) {
+	key := lease.key()
+	m.mu.Lock()
+	current, err := m.get(key)
+	if err != nil || current.Expires.After(time.Now()) {
+		m.mu.Unlock()
+		return
+	}
+	m.expiring[key] = true
+	m.mu.Unlock()
+
+	err = m.provider.DeletePod(ctx, providers.DeletePodRequest{PodID: lease.PodID, ServerGUID: lease.ServerGUID, Owner: lease.Owner})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.expiring, key)
+
+	var notFound *providers.NotFoundError
+	if err != nil && !errors.As(err, &notFound) {
+		log.Printf("leases: failed to delete expired pod %s: %v", lease.PodID, err)
+		return
+	}
+	log.Printf("leases: deleted expired pod %s of %s", lease.PodID, lease.Owner)
+
+	err = m.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(leasesBucket).Delete([]byte(key))
+	})
+	if err != nil {
+		log.Printf("leases: failed to remove lease of pod %s: %v", lease.PodID, err)
+	}
+}
+
+// run checks every interval until the manager is closed
+func (m *Manager) run() {
+	ticker := time.NewTicker(m.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.Check(context.Background())
+		}
+	}
+}
+
+func (m *Manager) put(lease *Lease) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.db.Update(func(tx *bolt.Tx) error {
+		return putLease(tx.Bucket(leasesBucket), lease)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to store lease of pod %s: %v", lease.PodID, err)
+	}
+	return nil
+}
+
+func (m *Manager) get(key string) (*Lease, error) {
+	var lease *Lease
+	err := m.db.View(func(tx *bolt.Tx) error {
+		var err error
+		lease, err = getLease(tx.Bucket(leasesBucket), key)
+		return err
+	})
+	return lease, err
+}
+
+// update applies fn to the stored lease, writing it back when fn returns true
+func (m *Manager) update(lease Lease, fn func(current *Lease) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(leasesBucket)
+		current, err := getLease(b, lease.key())
+		if err != nil {
+			return err
+		}
+		if !fn(current) {
+			return nil
+		}
+		return putLease(b, current)
+	})
+	if err != nil && err != ErrLeaseNotFound {
+		log.Printf("leases: failed to update lease of pod %s: %v", lease.PodID, err)
+	}
+}
+
+// findLease looks podID up on serverGUID, or on any server when serverGUID is empty
+func findLease(b *bolt.Bucket, podID, serverGUID string) (*Lease, error) {
+	if serverGUID != "" {
+		return getLease(b, serverGUID+"/"+podID)
+	}
+
+	var found *Lease
+	err := b.ForEach(func(k, v []byte) error {
+		var lease Lease
+		if err := json.Unmarshal(v, &lease); err != nil {
+			return err
+		}
+		if lease.PodID != podID {
+			return nil
+		}
+		if found != nil {
+			return ErrAmbiguousPod
+		}
+		found = &lease
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrLeaseNotFound
+	}
+	return found, nil
+}
+
+func getLease(b *bolt.Bucket, key string) (*Lease, error) {
+	data := b.Get([]byte(key))
+	if data == nil {
+		return nil, ErrLeaseNotFound
+	}
+	var lease Lease
+	if err := json.Unmarshal(data, &lease); err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+func putLease(b *bolt.Bucket, lease *Lease) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(lease.key()), data)
+}
@@ -0,0 +1,252 @@
+package leases_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goclone/internal/config"
+	"goclone/internal/leases"
+	"goclone/internal/providers"
+	"goclone/internal/providers/fake"
+)
+
+// outbox is a leases.Notifier that keeps what it was asked to send
+type outbox struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (o *outbox) Notify(to, subject, body string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sent = append(o.sent, to+": "+subject)
+	return nil
+}
+
+type emails map[string]string
+
+func (e emails) UserEmail(username string) (string, error) {
+	return e[username], nil
+}
+
+func newProvider() *fake.FakeProvider {
+	return fake.NewFakeProvider(&config.Config{
+		Provider: config.Provider{
+			MaxPodLimit: 5,
+			Fake: config.Fake{
+				Enabled:   true,
+				Templates: []config.FakeTemplate{{Name: "Web", VMs: []string{"Web-Server"}}, {Name: "Red_Team", VMs: []string{"Kali"}}},
+			},
+		},
+	}, nil)
+}
+
+func newManager(t *testing.T, conf config.Leases, provider providers.Provider, notifier leases.Notifier) *leases.Manager {
+	conf.DBPath = filepath.Join(t.TempDir(), "leases.db")
+	conf.Interval = time.Hour
+	m, err := leases.NewManager(conf, provider, emails{"alice": "alice@example.com"}, notifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// clone clones Web for owner, giving the pod a lease of ttl
+func clone(t *testing.T, m *leases.Manager, provider providers.Provider, owner string, ttl time.Duration) {
+	err := m.Track(context.Background(), owner, "Web", ttl, func(ctx context.Context) error {
+		return provider.CloneTemplate(ctx, providers.TemplateCloneRequest{Template: "Web", Username: owner})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	m := newManager(t, config.Leases{
+		DefaultTTL: 24 * time.Hour,
+		Templates:  []config.LeaseTTL{{Name: "Web", TTL: 48 * time.Hour}, {Name: "CTF", TTL: 4 * time.Hour}},
+		Roles:      []config.LeaseTTL{{Name: "staff", TTL: 14 * 24 * time.Hour}, {Name: "tester", TTL: 12 * time.Hour}},
+	}, newProvider(), nil)
+
+	tests := []struct {
+		template string
+		roles    []string
+		expected time.Duration
+	}{
+		{"Linux", nil, 24 * time.Hour},
+		{"web", nil, 48 * time.Hour},
+		{"east/Web", nil, 48 * time.Hour},
+		// a shorter template TTL still applies, only longer role TTLs win
+		{"CTF", []string{"tester"}, 12 * time.Hour},
+		{"Web", []string{"tester"}, 48 * time.Hour},
+		{"Web", []string{"tester", "staff"}, 14 * 24 * time.Hour},
+	}
+	for _, tc := range tests {
+		if got := m.TTL(tc.template, tc.roles); got != tc.expected {
+			t.Errorf("TTL(%q, %v) = %s, expected %s", tc.template, tc.roles, got, tc.expected)
+		}
+	}
+}
+
+func TestExtend(t *testing.T) {
+	provider := newProvider()
+	m := newManager(t, config.Leases{
+		Extension:     24 * time.Hour,
+		MaxExtensions: 2,
+		MaxLifetime:   60 * time.Hour,
+	}, provider, nil)
+	clone(t, m, provider, "alice", 24*time.Hour)
+
+	list, _ := m.List("alice")
+	if len(list) != 1 || list[0].Template != "Web" || list[0].Adopted {
+		t.Fatalf("expected a lease for the new pod, got %+v", list)
+	}
+	lease := list[0]
+
+	if _, err := m.Extend("bob", lease.PodID, "", 0); err != leases.ErrLeaseNotFound {
+		t.Errorf("expected only the owner to extend, got %v", err)
+	}
+	if _, err := m.Extend("alice", lease.PodID, "", 25*time.Hour); err == nil {
+		t.Errorf("expected extensions past the configured one to be refused")
+	}
+
+	extended, err := m.Extend("alice", lease.PodID, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extended.Expires.Sub(lease.Created) != 48*time.Hour || extended.Extensions != 1 {
+		t.Errorf("expected a day to be added, got %+v", extended)
+	}
+
+	// the lifetime caps the second extension
+	extended, err = m.Extend("alice", lease.PodID, lease.ServerGUID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extended.Expires.Sub(lease.Created) != 60*time.Hour {
+		t.Errorf("expected the lease to end with its lifetime, got %+v", extended)
+	}
+
+	if _, err := m.Extend("alice", lease.PodID, "", time.Hour); err != leases.ErrExtensionLimit {
+		t.Errorf("expected the extensions to run out, got %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	provider := newProvider()
+	notifier := &outbox{}
+	m := newManager(t, config.Leases{DefaultTTL: 3 * time.Hour, WarnBefore: 2 * time.Hour}, provider, notifier)
+	ctx := context.Background()
+
+	// pods cloned without a lease are adopted with the default TTL
+	err := provider.CloneTemplate(ctx, providers.TemplateCloneRequest{Template: "Web", Username: "bob_smith"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the owner is the one recorded, not read from a name where the template has underscores too
+	err = provider.CloneTemplate(ctx, providers.TemplateCloneRequest{Template: "Red_Team", Username: "smith"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone(t, m, provider, "alice", 90*time.Minute)
+	clone(t, m, provider, "alice", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	m.Check(ctx)
+
+	red, _ := m.List("smith")
+	if len(red) != 1 || red[0].Owner != "smith" || red[0].Template != "Red_Team" {
+		t.Fatalf("expected smith's Red_Team pod to be adopted, got %+v", red)
+	}
+	if err := provider.DeletePod(ctx, providers.DeletePodRequest{PodID: red[0].PodID, Owner: "smith"}); err != nil {
+		t.Fatal(err)
+	}
+	m.Check(ctx)
+
+	list, _ := m.List("")
+	if len(list) != 2 {
+		t.Fatalf("expected the expired pod to lose its lease, got %+v", list)
+	}
+	alice, bob := list[0], list[1]
+	if !bob.Adopted || bob.Owner != "bob_smith" || bob.Template != "Web" || bob.Expires.Sub(bob.Created) != 3*time.Hour {
+		t.Errorf("expected bob's pod to be adopted, got %+v", bob)
+	}
+	if alice.Warned == nil || bob.Warned != nil {
+		t.Errorf("expected only alice to be warned, got %+v and %+v", alice, bob)
+	}
+	if len(notifier.sent) != 1 || !strings.HasPrefix(notifier.sent[0], "alice@example.com: Your pod "+alice.PodID) {
+		t.Errorf("unexpected warnings %v", notifier.sent)
+	}
+
+	pods, _ := provider.ListAllPods(ctx)
+	if len(pods) != 2 {
+		t.Errorf("expected the expired pod to be deleted, got %+v", pods)
+	}
+
+	// warnings go out once, and pods deleted by hand lose their lease
+	err = provider.DeletePod(ctx, providers.DeletePodRequest{PodID: bob.PodID, Owner: "bob_smith"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Check(ctx)
+	if list, _ := m.List(""); len(list) != 1 || list[0].PodID != alice.PodID {
+		t.Errorf("expected bob's lease to be dropped, got %+v", list)
+	}
+	if len(notifier.sent) != 1 {
+		t.Errorf("expected no new warnings, got %v", notifier.sent)
+	}
+}
+
+// slowDeletes is a provider whose pod deletions wait for release
+type slowDeletes struct {
+	*fake.FakeProvider
+	deleting chan struct{}
+	release  chan struct{}
+}
+
+func (s *slowDeletes) DeletePod(ctx context.Context, req providers.DeletePodRequest) error {
+	s.deleting <- struct{}{}
+	<-s.release
+	return s.FakeProvider.DeletePod(ctx, req)
+}
+
+func TestExpireDoesNotBlock(t *testing.T) {
+	provider := &slowDeletes{FakeProvider: newProvider(), deleting: make(chan struct{}), release: make(chan struct{})}
+	m := newManager(t, config.Leases{}, provider, nil)
+	ctx := context.Background()
+
+	clone(t, m, provider, "alice", time.Millisecond)
+	expired, _ := m.List("alice")
+	time.Sleep(5 * time.Millisecond)
+
+	checked := make(chan struct{})
+	go func() {
+		m.Check(ctx)
+		close(checked)
+	}()
+	<-provider.deleting
+
+	// clones finish and leases are extended while the expired pod is deleted, but not that pod's
+	clone(t, m, provider, "bob", time.Hour)
+	bob, _ := m.List("bob")
+	if len(bob) != 1 {
+		t.Fatalf("expected bob's pod to get a lease, got %+v", bob)
+	}
+	if _, err := m.Extend("bob", bob[0].PodID, "", 0); err != nil {
+		t.Errorf("expected bob's lease to be extended, got %v", err)
+	}
+	if _, err := m.Extend("alice", expired[0].PodID, "", 0); err != leases.ErrLeaseExpired {
+		t.Errorf("expected the expiring lease not to be extended, got %v", err)
+	}
+
+	close(provider.release)
+	<-checked
+	if list, _ := m.List(""); len(list) != 1 || list[0].Owner != "bob" {
+		t.Errorf("expected only bob's lease to be left, got %+v", list)
+	}
+}
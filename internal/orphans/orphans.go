@@ -0,0 +1,132 @@
+package orphans
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"goclone/internal/config"
+	"goclone/internal/providers"
+)
+
+const defaultInterval = 10 * time.Minute
+
+// Report is what a scan found. With auto cleanup, Orphans holds what is left after the cleanup.
+type Report struct {
+	Orphans []providers.Orphan `json:"orphans"`
+	Removed []providers.Orphan `json:"removed"`
+	// Errors lists what failed to be scanned or removed
+	Errors    []string   `json:"errors,omitempty"`
+	ScannedAt *time.Time `json:"scannedAt,omitempty"`
+}
+
+// Collector scans the providers for leaked resources every Interval and keeps the last report.
+// With AutoCleanup it removes what was found by two scans in a row, so resources that are only
+// leaked for a moment, like those of a pod being deleted, are left alone.
+type Collector struct {
+	conf     config.Orphans
+	provider providers.OrphanCollector
+
+	// scanning keeps scans from overlapping
+	scanning sync.Mutex
+
+	mu     sync.Mutex
+	report Report
+	// seen holds what the last scan found
+	seen map[providers.Orphan]bool
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewCollector(conf config.Orphans, provider providers.OrphanCollector) *Collector {
+	if conf.Interval <= 0 {
+		conf.Interval = defaultInterval
+	}
+
+	c := &Collector{
+		conf:     conf,
+		provider: provider,
+		report:   Report{Orphans: []providers.Orphan{}, Removed: []providers.Orphan{}},
+		seen:     map[providers.Orphan]bool{},
+		stop:     make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func (c *Collector) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
+// Report returns the last scan's report
+func (c *Collector) Report() Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.report
+}
+
+// Scan looks for leaked resources now, removing them if AutoCleanup is set
+func (c *Collector) Scan(ctx context.Context) Report {
+	c.scanning.Lock()
+	defer c.scanning.Unlock()
+
+	found, err := c.provider.FindOrphans(ctx)
+	now := time.Now()
+	report := Report{Orphans: []providers.Orphan{}, Removed: []providers.Orphan{}, ScannedAt: &now}
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	}
+
+	c.mu.Lock()
+	seen := c.seen
+	c.mu.Unlock()
+
+	current := map[providers.Orphan]bool{}
+	for _, orphan := range found {
+		current[orphan] = true
+		if !c.conf.AutoCleanup || !seen[orphan] {
+			report.Orphans = append(report.Orphans, orphan)
+			continue
+		}
+
+		err := c.provider.RemoveOrphan(ctx, orphan)
+		if err != nil {
+			err = fmt.Errorf("Failed to remove %s %s: %v", orphan.Kind, orphan.Name, err)
+			log.Println(err)
+			report.Errors = append(report.Errors, err.Error())
+			report.Orphans = append(report.Orphans, orphan)
+			continue
+		}
+		log.Printf("orphans: removed %s %s: %s", orphan.Kind, orphan.Name, orphan.Reason)
+		report.Removed = append(report.Removed, orphan)
+		delete(current, orphan)
+	}
+
+	c.mu.Lock()
+	c.report = report
+	// a failed scan keeps what the last one saw, so it does not put off the cleanup
+	if err == nil {
+		c.seen = current
+	}
+	c.mu.Unlock()
+	return report
+}
+
+// run scans every interval until the collector is closed
+func (c *Collector) run() {
+	ticker := time.NewTicker(c.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.Scan(context.Background())
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package orphans_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"goclone/internal/config"
+	"goclone/internal/orphans"
+	"goclone/internal/providers"
+)
+
+// leaks is a providers.OrphanCollector over a fixed set of leaked resources
+type leaks struct {
+	mu      sync.Mutex
+	orphans []providers.Orphan
+	// stuck fails the removal of the resources named
+	stuck map[string]bool
+}
+
+func (l *leaks) FindOrphans(ctx context.Context) ([]providers.Orphan, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.orphans), nil
+}
+
+func (l *leaks) RemoveOrphan(ctx context.Context, orphan providers.Orphan) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stuck[orphan.Name] {
+		return fmt.Errorf("in use")
+	}
+	l.orphans = slices.DeleteFunc(l.orphans, func(o providers.Orphan) bool { return o == orphan })
+	return nil
+}
+
+func (l *leaks) add(orphan providers.Orphan) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.orphans = append(l.orphans, orphan)
+}
+
+var (
+	portGroup = providers.Orphan{Kind: providers.OrphanPortGroup, Name: "1801_PodNetwork", PortGroup: 1801, Reason: "Port group has no pod"}
+	folder    = providers.Orphan{Kind: providers.OrphanFolder, Name: "1802_Web_alice", PortGroup: 1802, Reason: "Folder is empty"}
+	pool      = providers.Orphan{Kind: providers.OrphanResourcePool, Name: "1803_Web_bob", PortGroup: 1803, Reason: "Resource pool has no VMs"}
+)
+
+func TestReportOnly(t *testing.T) {
+	provider := &leaks{orphans: []providers.Orphan{portGroup, folder}}
+	c := orphans.NewCollector(config.Orphans{Interval: time.Hour}, provider)
+	defer c.Close()
+
+	c.Scan(context.Background())
+	report := c.Scan(context.Background())
+	if len(report.Orphans) != 2 || len(report.Removed) != 0 || report.ScannedAt == nil {
+		t.Errorf("expected both orphans to be reported and kept, got %+v", report)
+	}
+	if got := c.Report(); len(got.Orphans) != 2 {
+		t.Errorf("expected the last report, got %+v", got)
+	}
+}
+
+func TestAutoCleanup(t *testing.T) {
+	provider := &leaks{orphans: []providers.Orphan{portGroup, folder}, stuck: map[string]bool{folder.Name: true}}
+	c := orphans.NewCollector(config.Orphans{Interval: time.Hour, AutoCleanup: true}, provider)
+	defer c.Close()
+
+	report := c.Scan(context.Background())
+	if len(report.Removed) != 0 {
+		t.Errorf("expected resources found once to be kept, got %+v", report)
+	}
+
+	// the port group and folder are found a second time, the pool a first
+	provider.add(pool)
+	report = c.Scan(context.Background())
+	if len(report.Removed) != 1 || report.Removed[0] != portGroup {
+		t.Errorf("expected the port group to be removed, got %+v", report)
+	}
+
+	report = c.Scan(context.Background())
+	if len(report.Removed) != 1 || report.Removed[0] != pool {
+		t.Errorf("expected the pool to be removed, got %+v", report)
+	}
+	// what cannot be removed stays reported with why
+	if len(report.Orphans) != 1 || report.Orphans[0] != folder {
+		t.Errorf("expected the folder to be left, got %+v", report.Orphans)
+	}
+	if len(report.Errors) != 1 || report.Errors[0] != "Failed to remove folder 1802_Web_alice: in use" {
+		t.Errorf("unexpected errors %v", report.Errors)
+	}
+
+	found, _ := provider.FindOrphans(context.Background())
+	if len(found) != 1 {
+		t.Errorf("expected only the folder left behind, got %+v", found)
+	}
+}
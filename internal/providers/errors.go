@@ -0,0 +1,27 @@
+package providers
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrPodLimit     = errors.New("Max pod limit reached")
+	ErrNoPortGroups = errors.New("No port groups available")
+	ErrTooManyVMs   = errors.New("Too many VMs in custom pod")
+)
+
+// NotFoundError is returned when a template, VM or pod named in a request does not exist
+type NotFoundError struct {
+	Kind string
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %s not found", e.Kind, e.Name)
+}
+
+func NotFound(kind, name string) error {
+	return &NotFoundError{Kind: kind, Name: name}
+}
@@ -0,0 +1,441 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"goclone/internal/auth"
+	"goclone/internal/config"
+	"goclone/internal/providers"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+var _ providers.Backend = (*FakeProvider)(nil)
+
+// FakeProvider keeps pods, templates, port groups and snapshots in memory.
+// It follows the same naming scheme and limits as the vSphere provider so the
+// API can be run and tested without a hypervisor.
+type FakeProvider struct {
+	conf     *config.Provider
+	fakeConf config.Fake
+	authMgr  *auth.AuthManager
+	tracer   trace.Tracer
+
+	mu              sync.Mutex
+	templates       map[string]Template
+	customTemplates []providers.CustomTemplate
+	pods            map[string]*pod
+	portGroups      map[int]string
+}
+
+type Template struct {
+	Name           string
+	VMs            []string
+	Natted         bool
+	NoRouter       bool
+	CompetitionPod bool
+	AdminOnly      bool
+}
+
+type VM struct {
+	Name      string
+	PoweredOn bool
+	Snapshots []string
+	// RevertedTo is the snapshot the VM was last reverted to
+	RevertedTo string
+}
+
+type pod struct {
+	Name      string
+	PortGroup int
+	Owner     string
+	VMs       []*VM
+}
+
+func NewFakeProvider(conf *config.Config, authMgr *auth.AuthManager) *FakeProvider {
+	tracer := conf.Core.Tracer
+	if tracer == nil {
+		tracer = noop.NewTracerProvider().Tracer("goclone")
+	}
+
+	f := &FakeProvider{
+		conf:       &conf.Provider,
+		fakeConf:   conf.Provider.Fake,
+		authMgr:    authMgr,
+		tracer:     tracer,
+		pods:       map[string]*pod{},
+		portGroups: map[int]string{},
+	}
+
+	if f.fakeConf.ServerGUID == "" {
+		f.fakeConf.ServerGUID = "fake"
+		if conf.Provider.Name != "" {
+			f.fakeConf.ServerGUID = "fake-" + conf.Provider.Name
+		}
+	}
+	if f.fakeConf.StartingPortGroup == 0 && f.fakeConf.EndingPortGroup == 0 {
+		f.fakeConf.StartingPortGroup, f.fakeConf.EndingPortGroup = 1801, 2000
+	}
+
+	f.loadTemplates()
+	return f
+}
+
+func (f *FakeProvider) loadTemplates() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.templates = map[string]Template{}
+	for _, t := range f.fakeConf.Templates {
+		f.templates[t.Name] = Template{
+			Name:           t.Name,
+			VMs:            slices.Clone(t.VMs),
+			Natted:         t.Natted,
+			NoRouter:       t.NoRouter,
+			CompetitionPod: t.CompetitionPod,
+			AdminOnly:      t.AdminOnly,
+		}
+	}
+
+	f.customTemplates = []providers.CustomTemplate{}
+	for _, t := range f.fakeConf.CustomTemplates {
+		if len(t.VMs) == 0 {
+			continue
+		}
+		f.customTemplates = append(f.customTemplates, providers.CustomTemplate{Name: t.Name, VMs: slices.Clone(t.VMs)})
+	}
+}
+
+func (f *FakeProvider) ListPresetTemplates(ctx context.Context, isAdmin bool) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	templates := []string{}
+	for name, t := range f.templates {
+		if !isAdmin && t.AdminOnly {
+			continue
+		}
+		templates = append(templates, name)
+	}
+	slices.Sort(templates)
+	return templates, nil
+}
+
+func (f *FakeProvider) ListCustomTemplates(ctx context.Context) ([]providers.CustomTemplate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.customTemplates), nil
+}
+
+func (f *FakeProvider) ListPods(ctx context.Context, owner string) ([]providers.Pod, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.podsOf(owner), nil
+}
+
+func (f *FakeProvider) ListAllPods(ctx context.Context) ([]providers.Pod, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pods := []providers.Pod{}
+	for _, name := range f.sortedPodNames() {
+		pods = append(pods, f.podInfo(f.pods[name]))
+	}
+	return pods, nil
+}
+
+func (f *FakeProvider) podsOf(owner string) []providers.Pod {
+	pods := []providers.Pod{}
+	for _, name := range f.sortedPodNames() {
+		if pod := f.podInfo(f.pods[name]); pod.OwnedBy(owner) {
+			pods = append(pods, pod)
+		}
+	}
+	return pods
+}
+
+func (f *FakeProvider) podInfo(p *pod) providers.Pod {
+	return providers.Pod{Name: p.Name, ResourceGroup: p.Name, ServerGUID: f.fakeConf.ServerGUID, Owner: p.Owner}
+}
+
+func (f *FakeProvider) sortedPodNames() []string {
+	names := make([]string, 0, len(f.pods))
+	for name := range f.pods {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (f *FakeProvider) podsMatchingFilter(filter providers.PodFilter) []*pod {
+	var pods []*pod
+	for _, name := range f.sortedPodNames() {
+		if filter.Matches(name) {
+			pods = append(pods, f.pods[name])
+		}
+	}
+	return pods
+}
+
+// podLimit must be called with f.mu held
+func (f *FakeProvider) podLimit(username string) error {
+	if len(f.podsOf(username)) >= f.conf.MaxPodLimit {
+		return providers.ErrPodLimit
+	}
+	return nil
+}
+
+func (f *FakeProvider) reservePortGroup(start, end int, name string) (int, error) {
+	for i := start; i < end; i++ {
+		if _, exists := f.portGroups[i]; !exists {
+			f.portGroups[i] = name
+			return i, nil
+		}
+	}
+	return 0, providers.ErrNoPortGroups
+}
+
+func (f *FakeProvider) CloneTemplate(ctx context.Context, req providers.TemplateCloneRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.podLimit(req.Username)
+	if err != nil {
+		return err
+	}
+
+	templateName := req.Template
+	template, ok := f.templates[templateName]
+	if !ok {
+		return providers.NotFound("Template", templateName)
+	}
+
+	start, end := f.fakeConf.StartingPortGroup, f.fakeConf.EndingPortGroup
+	if template.CompetitionPod {
+		start, end = f.fakeConf.CompetitionStartPortGroup, f.fakeConf.CompetitionEndPortGroup
+	}
+
+	vms := slices.Clone(template.VMs)
+	if !template.NoRouter && !slices.ContainsFunc(vms, isRouter) {
+		vms = append(vms, routerName(templateName, template.Natted))
+	}
+	return f.createPod(ctx, templateName, req.Username, start, end, vms)
+}
+
+func (f *FakeProvider) CloneCustom(ctx context.Context, req providers.CustomCloneRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.podLimit(req.Username)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range req.VMs {
+		found := false
+		for _, t := range f.customTemplates {
+			if slices.Contains(t.VMs, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return providers.NotFound("VM", name)
+		}
+	}
+
+	vms := slices.Clone(req.VMs)
+	if req.Natted && !slices.ContainsFunc(vms, isRouter) {
+		vms = append(vms, routerName(req.Name, req.Natted))
+	}
+	return f.createPod(ctx, req.Name, req.Username, f.fakeConf.StartingPortGroup, f.fakeConf.EndingPortGroup, vms)
+}
+
+// createPod reports the same steps as the real providers, though it has nothing to wait for
+func (f *FakeProvider) createPod(ctx context.Context, name, username string, start, end int, vmNames []string) error {
+	finish := providers.StartStep(ctx, providers.StepPortGroup)
+	pg, err := f.reservePortGroup(start, end, name)
+	finish(err)
+	if err != nil {
+		return err
+	}
+	providers.StartStep(ctx, providers.StepResourcePool)(nil)
+	providers.StartStep(ctx, providers.StepFolder)(nil)
+
+	finish = providers.StartStep(ctx, providers.StepCloneVMs)
+
+	podID := strings.Join([]string{strconv.Itoa(pg), name, username}, "_")
+	p := &pod{Name: podID, PortGroup: pg, Owner: username}
+	for _, vmName := range vmNames {
+		vm := &VM{
+			Name:      strings.Join([]string{strconv.Itoa(pg), vmName}, "-"),
+			PoweredOn: isRouter(vmName),
+			Snapshots: []string{"Base"},
+		}
+		p.VMs = append(p.VMs, vm)
+		providers.Report(ctx, providers.Update{Step: providers.StepCloneVMs, VM: vm.Name, Percent: 100, Message: "Cloned"})
+	}
+	finish(nil)
+
+	if slices.ContainsFunc(vmNames, isRouter) {
+		providers.StartStep(ctx, providers.StepRouter)(nil)
+	}
+	finish = providers.StartStep(ctx, providers.StepSnapshots)
+	for _, vm := range p.VMs {
+		providers.Report(ctx, providers.Update{Step: providers.StepSnapshots, VM: vm.Name, Percent: 100, Message: "Snapshot Base taken"})
+	}
+	finish(nil)
+	providers.StartStep(ctx, providers.StepPermissions)(nil)
+
+	f.pods[podID] = p
+	return nil
+}
+
+func isRouter(name string) bool {
+	return strings.Contains(name, "PodRouter")
+}
+
+func routerName(podName string, natted bool) string {
+	if natted {
+		return strings.Join([]string{podName, "Natted-PodRouter"}, "-")
+	}
+	return strings.Join([]string{podName, "PodRouter"}, "-")
+}
+
+func (f *FakeProvider) ServerGUID() string {
+	return f.fakeConf.ServerGUID
+}
+
+func (f *FakeProvider) DeletePod(ctx context.Context, req providers.DeletePodRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	podID := req.PodID
+	p, ok := f.pods[podID]
+	if !ok {
+		return providers.NotFound("Pod", podID)
+	}
+	delete(f.pods, podID)
+	delete(f.portGroups, p.PortGroup)
+	return nil
+}
+
+func (f *FakeProvider) BulkDelete(ctx context.Context, filter providers.PodFilter) (providers.BulkResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, p := range f.podsMatchingFilter(filter) {
+		delete(f.pods, p.Name)
+		delete(f.portGroups, p.PortGroup)
+	}
+	return providers.BulkResult{Failed: []string{}}, nil
+}
+
+func (f *FakeProvider) BulkRevert(ctx context.Context, filter providers.PodFilter, snapshot string) (providers.BulkResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	failed := []string{}
+	for _, p := range f.podsMatchingFilter(filter) {
+		for _, vm := range p.VMs {
+			if isRouter(vm.Name) {
+				continue
+			}
+			if !slices.Contains(vm.Snapshots, snapshot) {
+				failed = append(failed, vm.Name)
+				continue
+			}
+			vm.RevertedTo = snapshot
+		}
+	}
+	return providers.BulkResult{Failed: failed}, nil
+}
+
+func (f *FakeProvider) BulkPower(ctx context.Context, filter providers.PodFilter, powerOn bool) (providers.BulkResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, p := range f.podsMatchingFilter(filter) {
+		for _, vm := range p.VMs {
+			vm.PoweredOn = powerOn
+		}
+	}
+	return providers.BulkResult{Failed: []string{}}, nil
+}
+
+// PodVMs returns a copy of the VMs in a pod so tests can inspect power, snapshot and revert state
+func (f *FakeProvider) PodVMs(podID string) ([]VM, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.pods[podID]
+	if !ok {
+		return nil, false
+	}
+	vms := []VM{}
+	for _, vm := range p.VMs {
+		vms = append(vms, VM{Name: vm.Name, PoweredOn: vm.PoweredOn, Snapshots: slices.Clone(vm.Snapshots), RevertedTo: vm.RevertedTo})
+	}
+	return vms, true
+}
+
+func (f *FakeProvider) RefreshTemplates(ctx context.Context) error {
+	_, span := f.tracer.Start(ctx, "RefreshTemplates")
+	defer span.End()
+	f.loadTemplates()
+	return nil
+}
+
+var _ providers.OrphanCollector = (*FakeProvider)(nil)
+
+// FindOrphans reports the port groups no pod uses. The fake clones while holding its lock,
+// so it has no clones running to leave out.
+func (f *FakeProvider) FindOrphans(ctx context.Context) ([]providers.Orphan, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	inUse := map[int]bool{}
+	for _, p := range f.pods {
+		inUse[p.PortGroup] = true
+	}
+	orphans := []providers.Orphan{}
+	for pg := range f.portGroups {
+		if !inUse[pg] {
+			orphans = append(orphans, providers.Orphan{Kind: providers.OrphanPortGroup, Name: portGroupName(pg), PortGroup: pg, Reason: "Port group has no pod"})
+		}
+	}
+	slices.SortFunc(orphans, func(a, b providers.Orphan) int { return a.PortGroup - b.PortGroup })
+	return orphans, nil
+}
+
+func (f *FakeProvider) RemoveOrphan(ctx context.Context, orphan providers.Orphan) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if orphan.Kind != providers.OrphanPortGroup {
+		return providers.NotFound(orphan.Kind, orphan.Name)
+	}
+	for _, p := range f.pods {
+		if p.PortGroup == orphan.PortGroup {
+			return fmt.Errorf("Port group %d is in use by pod %s", orphan.PortGroup, p.Name)
+		}
+	}
+	delete(f.portGroups, orphan.PortGroup)
+	return nil
+}
+
+// LeakPortGroup takes port group pg without a pod, like a failed clone would, so tests can find it
+func (f *FakeProvider) LeakPortGroup(pg int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.portGroups[pg] = "leaked"
+}
+
+func portGroupName(pg int) string {
+	return strconv.Itoa(pg) + "_PodNetwork"
+}
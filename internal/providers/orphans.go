@@ -0,0 +1,77 @@
+package providers
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+)
+
+// Kinds of resources a clone creates, which are left behind when it fails or a pod is deleted by hand
+const (
+	OrphanPortGroup    = "portGroup"
+	OrphanFolder       = "folder"
+	OrphanResourcePool = "resourcePool"
+)
+
+// Orphan is a resource named after a pod that no pod accounts for
+type Orphan struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	PortGroup int    `json:"portGroup"`
+	Reason    string `json:"reason"`
+	// Provider names the provider the resource lives on when several are registered
+	Provider string `json:"provider,omitempty"`
+}
+
+// OrphanCollector is implemented by backends that can find the resources failed clones and manual
+// deletes leave behind. Backends that cannot are skipped by the Registry.
+type OrphanCollector interface {
+	// FindOrphans lists leaked resources. Resources of clones still running are never reported.
+	// Backends also sync which port groups they hand out with the ones that exist.
+	FindOrphans(ctx context.Context) ([]Orphan, error)
+	// RemoveOrphan removes a resource FindOrphans reported
+	RemoveOrphan(ctx context.Context, orphan Orphan) error
+}
+
+var _ OrphanCollector = (*Registry)(nil)
+
+var podIDPattern = regexp.MustCompile(`^(\d+)_.+_.+$`)
+
+// PodPortGroup returns the port group of a name following the <pg>_<name>_<owner> naming scheme
+func PodPortGroup(podID string) (int, bool) {
+	match := podIDPattern.FindStringSubmatch(podID)
+	if match == nil {
+		return 0, false
+	}
+	pg, err := strconv.Atoi(match[1])
+	return pg, err == nil
+}
+
+func (r *Registry) FindOrphans(ctx context.Context) ([]Orphan, error) {
+	orphans := []Orphan{}
+	err := r.each(func(name string, backend Backend) error {
+		collector, ok := backend.(OrphanCollector)
+		if !ok {
+			return nil
+		}
+		found, err := collector.FindOrphans(ctx)
+		for _, orphan := range found {
+			orphan.Provider = name
+			orphans = append(orphans, orphan)
+		}
+		return err
+	})
+	return orphans, err
+}
+
+func (r *Registry) RemoveOrphan(ctx context.Context, orphan Orphan) error {
+	backend, ok := r.backends[orphan.Provider]
+	if !ok {
+		backend, ok = r.single()
+	}
+	collector, isCollector := backend.(OrphanCollector)
+	if !ok || !isCollector {
+		return NotFound("Provider", orphan.Provider)
+	}
+	return collector.RemoveOrphan(ctx, orphan)
+}
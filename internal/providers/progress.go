@@ -0,0 +1,68 @@
+package providers
+
+import "context"
+
+// Steps a provider reports while it clones a pod. Providers skip the ones their hypervisor has no
+// counterpart for, like folders on Proxmox.
+const (
+	StepPortGroup    = "port_group"
+	StepResourcePool = "resource_pool"
+	StepFolder       = "folder"
+	StepCloneVMs     = "vm_clones"
+	StepRouter       = "router_config"
+	StepSnapshots    = "snapshots"
+	StepPermissions  = "permissions"
+)
+
+// Update is news from within a running step, like how far along a VM's clone task is
+type Update struct {
+	Step string `json:"step,omitempty"`
+	// VM is the VM the update is about, if any
+	VM string `json:"vm,omitempty"`
+	// Percent is how far along the VM's task is, when the hypervisor says
+	Percent int    `json:"percent,omitempty"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Progress follows a clone step by step. It must be safe for concurrent use.
+type Progress interface {
+	StepStarted(step string)
+	StepFinished(step string, err error)
+	StepUpdated(update Update)
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context that makes providers report their steps to progress
+func WithProgress(ctx context.Context, progress Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, progress)
+}
+
+// Report passes update on to the context's Progress, if any
+func Report(ctx context.Context, update Update) {
+	if progress, ok := ctx.Value(progressKey{}).(Progress); ok {
+		progress.StepUpdated(update)
+	}
+}
+
+// StartStep reports step as started to the context's Progress, if any, and returns the function
+// that reports it finished with the step's error
+func StartStep(ctx context.Context, step string) func(err error) {
+	progress, ok := ctx.Value(progressKey{}).(Progress)
+	if !ok {
+		return func(error) {}
+	}
+	progress.StepStarted(step)
+	return func(err error) {
+		progress.StepFinished(step, err)
+	}
+}
+
+// RunStep runs fn as step, reporting it to the context's Progress
+func RunStep(ctx context.Context, step string, fn func() error) error {
+	finish := StartStep(ctx, step)
+	err := fn()
+	finish(err)
+	return err
+}
@@ -1,20 +1,114 @@
 package providers
 
-import "github.com/gin-gonic/gin"
+import (
+	"context"
+	"strings"
+)
 
+// Provider is the transport-agnostic service every virtualization backend implements.
+// The HTTP layer in internal/api/handlers handles binding, sessions and error mapping on top of it.
 type Provider interface {
-    GetPodsHandler(c *gin.Context)
-    DeletePodHandler(c *gin.Context)
+	ListPods(ctx context.Context, owner string) ([]Pod, error)
+	// ListAllPods lists the pods of every user
+	ListAllPods(ctx context.Context) ([]Pod, error)
+	DeletePod(ctx context.Context, req DeletePodRequest) error
 
-    GetPresetTemplatesHandler(c *gin.Context)
-    GetTemplateVMsHandler(c *gin.Context)
+	ListPresetTemplates(ctx context.Context, isAdmin bool) ([]string, error)
+	ListCustomTemplates(ctx context.Context) ([]CustomTemplate, error)
 
-    CloneFromTemplateHandler(c *gin.Context)
-    CloneCustomPodHandler(c *gin.Context)
+	CloneTemplate(ctx context.Context, req TemplateCloneRequest) error
+	CloneCustom(ctx context.Context, req CustomCloneRequest) error
 
-    RefreshTemplatesHandler(c *gin.Context)
-    BulkClonePodsHandler(c *gin.Context)
-    BulkDeletePodsHandler(c *gin.Context)
-    BulkRevertPodHandler(c *gin.Context)
-    BulkPowerPodHandler(c *gin.Context)
+	RefreshTemplates(ctx context.Context) error
+	BulkDelete(ctx context.Context, filter PodFilter) (BulkResult, error)
+	BulkRevert(ctx context.Context, filter PodFilter, snapshot string) (BulkResult, error)
+	BulkPower(ctx context.Context, filter PodFilter, powerOn bool) (BulkResult, error)
+}
+
+// Backend is a Provider bound to a single hypervisor or management server
+type Backend interface {
+	Provider
+
+	// ServerGUID identifies the server a backend's pods live on and matches Pod.ServerGUID
+	ServerGUID() string
+}
+
+// MaxCustomPodVMs is the largest number of VMs a user may put in a custom pod
+const MaxCustomPodVMs = 10
+
+type Pod struct {
+	Name          string
+	ResourceGroup string
+	ServerGUID    string
+	// Owner is the user the pod was cloned for, as recorded by the provider
+	Owner string
+}
+
+// OwnedBy reports whether the pod was cloned for username. Usernames are compared ignoring case like the auth backends do.
+func (p Pod) OwnedBy(username string) bool {
+	return p.Owner != "" && strings.EqualFold(p.Owner, username)
+}
+
+type CustomTemplate struct {
+	Name     string   `json:"name"`
+	VMs      []string `json:"vms"`
+	Provider string   `json:"provider,omitempty"`
+}
+
+type DeletePodRequest struct {
+	PodID string
+	// ServerGUID selects the provider when several are registered.
+	// If it is empty the pod is looked up among Owner's pods instead.
+	ServerGUID string
+	Owner      string
+}
+
+type TemplateCloneRequest struct {
+	Template string
+	Username string
+}
+
+type CustomCloneRequest struct {
+	Name     string
+	VMs      []string
+	Natted   bool
+	Username string
+	// Provider names the provider the VMs come from when several are registered
+	Provider string
+}
+
+func (r CustomCloneRequest) Validate() error {
+	if len(r.VMs) > MaxCustomPodVMs {
+		return ErrTooManyVMs
+	}
+	return nil
+}
+
+// BulkResult lists the pods or VMs a bulk operation could not act on
+type BulkResult struct {
+	Failed []string `json:"failed"`
+}
+
+// PodFilter selects pods whose name contains any of its non-empty entries
+type PodFilter []string
+
+func (f PodFilter) Matches(podID string) bool {
+	for _, s := range f {
+		if s != "" && strings.Contains(podID, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// PodOwner reads the owner of a pod named <pg>_<name>_<owner>, for pods cloned before providers recorded
+// their owner. Usernames may contain underscores, so the name is taken to end at the next underscore.
+// Pods whose name has underscores get the wrong owner, which locks them to admins rather than
+// handing them to whoever's username ends the same.
+func PodOwner(podID string) string {
+	parts := strings.SplitN(podID, "_", 3)
+	if len(parts) != 3 {
+		return ""
+	}
+	return parts[2]
 }
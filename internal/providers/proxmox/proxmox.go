@@ -0,0 +1,815 @@
+package proxmox
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goclone/internal/auth"
+	"goclone/internal/config"
+	"goclone/internal/providers"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"golang.org/x/sync/errgroup"
+)
+
+type ProxmoxClient struct {
+	conf         *config.Provider
+	pveConf      config.Proxmox
+	authMgr      *auth.AuthManager
+	http         *http.Client
+	baseURL      string
+	tracer       trace.Tracer
+	serverGUID   string
+	pollInterval time.Duration
+
+	templatesMu sync.RWMutex
+	templates   map[string]Template
+
+	portGroupsMu sync.Mutex
+	portGroups   map[int]string
+
+	// vmIDMu hands out VM IDs to one clone at a time
+	vmIDMu sync.Mutex
+}
+
+type Template struct {
+	Name           string
+	VMs            []pveResource
+	Natted         bool
+	NoRouter       bool
+	CompetitionPod bool
+	AdminOnly      bool
+}
+
+var portGroupRegex = regexp.MustCompile(`^(\d+)_`)
+
+func NewProxmoxProvider(conf *config.Config, authMgr *auth.AuthManager) *ProxmoxClient {
+	fmt.Println("Setting up Proxmox Provider")
+	p, err := newProxmoxClient(conf, authMgr)
+	if err != nil {
+		log.Fatalln(errors.Wrap(err, "Error setting up Proxmox provider"))
+	}
+
+	go p.refreshSession()
+
+	return p
+}
+
+func newProxmoxClient(conf *config.Config, authMgr *auth.AuthManager) (*ProxmoxClient, error) {
+	tracer := conf.Core.Tracer
+	if tracer == nil {
+		tracer = noop.NewTracerProvider().Tracer("goclone")
+	}
+
+	p := &ProxmoxClient{
+		conf:    &conf.Provider,
+		pveConf: conf.Provider.Proxmox,
+		authMgr: authMgr,
+		http: &http.Client{
+			Timeout: 60 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: conf.Provider.Proxmox.SkipTLSVerify},
+			},
+		},
+		baseURL:      strings.TrimSuffix(conf.Provider.URL, "/") + "/api2/json",
+		tracer:       tracer,
+		pollInterval: time.Second,
+		templates:    map[string]Template{},
+		portGroups:   map[int]string{},
+	}
+
+	ctx := context.Background()
+	guid, err := p.loadServerGUID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.serverGUID = guid
+
+	err = p.loadTakenPortGroups(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error finding taken port groups")
+	}
+
+	err = p.loadTemplates(ctx)
+	if err != nil {
+		fmt.Println("Error loading templates", err)
+	}
+
+	return p, nil
+}
+
+func (p *ProxmoxClient) refreshSession() {
+	for {
+		time.Sleep(time.Second * 30)
+
+		err := p.loadTakenPortGroups(context.Background())
+		if err != nil {
+			log.Println(errors.Wrap(err, "Error finding taken port groups"))
+		}
+	}
+}
+
+// loadServerGUID identifies this Proxmox cluster, falling back to the node name for standalone hosts
+func (p *ProxmoxClient) loadServerGUID(ctx context.Context) (string, error) {
+	var status []pveClusterStatus
+	err := p.get(ctx, "/cluster/status", nil, &status)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to get cluster status")
+	}
+
+	for _, s := range status {
+		if s.Type == "cluster" {
+			return s.Name, nil
+		}
+	}
+	return p.pveConf.Node, nil
+}
+
+func (p *ProxmoxClient) inPortGroupRange(pg int) bool {
+	return (pg >= p.pveConf.StartingPortGroup && pg < p.pveConf.EndingPortGroup) ||
+		(pg >= p.pveConf.CompetitionStartPortGroup && pg < p.pveConf.CompetitionEndPortGroup)
+}
+
+func (p *ProxmoxClient) loadTakenPortGroups(ctx context.Context) error {
+	pools, err := p.listPools(ctx)
+	if err != nil {
+		return err
+	}
+
+	p.portGroupsMu.Lock()
+	defer p.portGroupsMu.Unlock()
+	for _, pool := range pools {
+		match := portGroupRegex.FindStringSubmatch(pool.PoolID)
+		if match == nil {
+			continue
+		}
+		pg, _ := strconv.Atoi(match[1])
+		if p.inPortGroupRange(pg) {
+			p.portGroups[pg] = pool.PoolID
+		}
+	}
+	log.Printf("Found %d port groups", len(p.portGroups))
+	return nil
+}
+
+// reservePortGroup claims the first free port group in [start, end) for the pod named name owned by
+// username, and returns it with the pod's ID
+func (p *ProxmoxClient) reservePortGroup(start, end int, name, username string) (int, string, error) {
+	p.portGroupsMu.Lock()
+	defer p.portGroupsMu.Unlock()
+	for i := start; i < end; i++ {
+		if _, exists := p.portGroups[i]; !exists {
+			podID := strings.Join([]string{strconv.Itoa(i), name, username}, "_")
+			p.portGroups[i] = podID
+			return i, podID, nil
+		}
+	}
+	return 0, "", providers.ErrNoPortGroups
+}
+
+func (p *ProxmoxClient) releasePortGroup(pg int) {
+	p.portGroupsMu.Lock()
+	delete(p.portGroups, pg)
+	p.portGroupsMu.Unlock()
+}
+
+func (p *ProxmoxClient) template(name string) (Template, bool) {
+	p.templatesMu.RLock()
+	defer p.templatesMu.RUnlock()
+	t, ok := p.templates[name]
+	return t, ok
+}
+
+func (p *ProxmoxClient) loadTemplates(ctx context.Context) error {
+	pools, err := p.listPools(ctx)
+	if err != nil {
+		return err
+	}
+
+	vms, err := p.listVMs(ctx)
+	if err != nil {
+		return err
+	}
+
+	templates := map[string]Template{}
+	for _, pool := range pools {
+		if p.pveConf.PresetTemplatePrefix == "" || !strings.HasPrefix(pool.PoolID, p.pveConf.PresetTemplatePrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(pool.PoolID, p.pveConf.PresetTemplatePrefix)
+		template := Template{Name: name}
+
+		attrs := parseAttributes(pool.Comment)
+		template.Natted = attrs["goclone.template.natted"] == "true"
+		template.NoRouter = attrs["goclone.template.noRouter"] == "true"
+		template.CompetitionPod = attrs["goclone.template.competitionPod"] == "true"
+		template.AdminOnly = attrs["goclone.template.adminOnly"] == "true"
+
+		for _, vm := range vms {
+			if vm.Pool == pool.PoolID {
+				template.VMs = append(template.VMs, vm)
+			}
+		}
+		templates[name] = template
+		fmt.Println("Loaded template: ", name)
+	}
+
+	p.templatesMu.Lock()
+	p.templates = templates
+	p.templatesMu.Unlock()
+	return nil
+}
+
+// podOwnerAttribute is the pool comment setting naming the user a pod was cloned for
+const podOwnerAttribute = "goclone.pod.owner"
+
+// parseAttributes reads goclone key=value settings from a pool comment, one per line
+func parseAttributes(comment string) map[string]string {
+	attrs := map[string]string{}
+	for _, line := range strings.Split(comment, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		attrs[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return attrs
+}
+
+func hasTag(vm pveResource, tag string) bool {
+	for _, t := range strings.FieldsFunc(vm.Tags, func(r rune) bool { return r == ';' || r == ',' || r == ' ' }) {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func isRouter(name string) bool {
+	return strings.Contains(name, "PodRouter")
+}
+
+func (p *ProxmoxClient) getPresetTemplates(isAdmin bool) []string {
+	p.templatesMu.RLock()
+	defer p.templatesMu.RUnlock()
+
+	templates := []string{}
+	for name, t := range p.templates {
+		if !isAdmin && t.AdminOnly {
+			continue
+		}
+		templates = append(templates, name)
+	}
+	slices.Sort(templates)
+	return templates
+}
+
+func (p *ProxmoxClient) getCustomTemplates(ctx context.Context) ([]providers.CustomTemplate, error) {
+	if p.pveConf.CustomTemplatePrefix == "" {
+		return []providers.CustomTemplate{}, nil
+	}
+
+	pools, err := p.listPools(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	vms, err := p.listVMs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := []providers.CustomTemplate{}
+	for _, pool := range pools {
+		if !strings.HasPrefix(pool.PoolID, p.pveConf.CustomTemplatePrefix) {
+			continue
+		}
+		t := providers.CustomTemplate{Name: strings.TrimPrefix(pool.PoolID, p.pveConf.CustomTemplatePrefix)}
+		for _, vm := range vms {
+			if vm.Pool == pool.PoolID {
+				t.VMs = append(t.VMs, vm.Name)
+			}
+		}
+		if len(t.VMs) == 0 {
+			continue
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// getAllPods returns every pool that follows the <pg>_<name>_<user> naming scheme, with the owner
+// recorded in its comment
+func (p *ProxmoxClient) getAllPods(ctx context.Context) ([]providers.Pod, error) {
+	pools, err := p.listPools(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pods := []providers.Pod{}
+	for _, pool := range pools {
+		match := portGroupRegex.FindStringSubmatch(pool.PoolID)
+		if match == nil {
+			continue
+		}
+		pg, _ := strconv.Atoi(match[1])
+		if !p.inPortGroupRange(pg) {
+			continue
+		}
+		owner, ok := parseAttributes(pool.Comment)[podOwnerAttribute]
+		if !ok {
+			owner = providers.PodOwner(pool.PoolID)
+		}
+		pods = append(pods, providers.Pod{Name: pool.PoolID, ResourceGroup: pool.PoolID, ServerGUID: p.serverGUID, Owner: owner})
+	}
+	return pods, nil
+}
+
+func (p *ProxmoxClient) getPods(ctx context.Context, owner string) ([]providers.Pod, error) {
+	all, err := p.getAllPods(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get pod list")
+	}
+
+	pods := []providers.Pod{}
+	for _, pod := range all {
+		if pod.OwnedBy(owner) {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+func (p *ProxmoxClient) getPodsMatchingFilter(ctx context.Context, filter providers.PodFilter) ([]string, error) {
+	all, err := p.getAllPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []string
+	for _, pod := range all {
+		if filter.Matches(pod.Name) {
+			pods = append(pods, pod.Name)
+		}
+	}
+	return pods, nil
+}
+
+func (p *ProxmoxClient) podLimit(ctx context.Context, username string) error {
+	existingPods, err := p.getPods(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	if len(existingPods) >= p.conf.MaxPodLimit {
+		return providers.ErrPodLimit
+	}
+	return nil
+}
+
+func (p *ProxmoxClient) templateClone(ctx context.Context, templateName, username string) (err error) {
+	ctx, span := p.tracer.Start(ctx, "TemplateClone")
+	defer span.End()
+
+	template, ok := p.template(templateName)
+	if !ok {
+		return providers.NotFound("Template", templateName)
+	}
+
+	// tears down whatever was built when any of the steps below fails
+	rollback := &providers.Rollback{}
+	defer func() {
+		err = rollback.Fail(ctx, err)
+	}()
+
+	err = p.podLimit(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	start, end := p.pveConf.StartingPortGroup, p.pveConf.EndingPortGroup
+	wanVlan := p.pveConf.WanVlan
+	if template.CompetitionPod {
+		start, end = p.pveConf.CompetitionStartPortGroup, p.pveConf.CompetitionEndPortGroup
+		wanVlan = p.pveConf.CompetitionWanVlan
+	}
+
+	finish := providers.StartStep(ctx, providers.StepPortGroup)
+	pg, podID, err := p.reservePortGroup(start, end, templateName, username)
+	finish(err)
+	if err != nil {
+		return err
+	}
+	rollback.Add(fmt.Sprintf("port group %d reservation", pg), func(context.Context) error {
+		p.releasePortGroup(pg)
+		return nil
+	})
+
+	sources := template.VMs
+	if !template.NoRouter && !containsRouter(sources) {
+		router, err := p.findRouterTemplate(ctx, template.Natted)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, router)
+	}
+
+	vms, err := p.clonePod(ctx, rollback, podID, username, pg, sources)
+	if err != nil {
+		return err
+	}
+
+	if !template.NoRouter {
+		err = providers.RunStep(ctx, providers.StepRouter, func() error {
+			return p.configureRouter(ctx, vms, pg, wanVlan, template.Natted, template.CompetitionPod)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = providers.RunStep(ctx, providers.StepSnapshots, func() error {
+		return p.snapshotAll(ctx, vms, "Base")
+	})
+	if err != nil {
+		return err
+	}
+
+	return providers.RunStep(ctx, providers.StepPermissions, func() error {
+		err := p.setPermission(ctx, "/pool/"+podID, username, p.pveConf.CloneRole)
+		if err != nil {
+			return errors.Wrap(err, "Error assigning permissions")
+		}
+
+		for i, src := range sources {
+			if hasTag(src, "goclone-hidden") {
+				err = p.setPermission(ctx, fmt.Sprintf("/vms/%d", vms[i].VMID), username, "NoAccess")
+				if err != nil {
+					log.Println(errors.Wrap(err, "Failed to hide VM"))
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (p *ProxmoxClient) customClone(ctx context.Context, podName string, vmsToClone []string, natted bool, username string) (err error) {
+	ctx, span := p.tracer.Start(ctx, "CustomClone")
+	defer span.End()
+
+	rollback := &providers.Rollback{}
+	defer func() {
+		err = rollback.Fail(ctx, err)
+	}()
+
+	err = p.podLimit(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	all, err := p.listVMs(ctx)
+	if err != nil {
+		return err
+	}
+
+	var sources []pveResource
+	for _, name := range vmsToClone {
+		found := false
+		for _, vm := range all {
+			if vm.Name == name {
+				sources = append(sources, vm)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return providers.NotFound("VM", name)
+		}
+	}
+
+	if natted && !containsRouter(sources) {
+		router, err := p.findRouterTemplate(ctx, natted)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, router)
+	}
+
+	finish := providers.StartStep(ctx, providers.StepPortGroup)
+	pg, podID, err := p.reservePortGroup(p.pveConf.StartingPortGroup, p.pveConf.EndingPortGroup, podName, username)
+	finish(err)
+	if err != nil {
+		return err
+	}
+	rollback.Add(fmt.Sprintf("port group %d reservation", pg), func(context.Context) error {
+		p.releasePortGroup(pg)
+		return nil
+	})
+
+	vms, err := p.clonePod(ctx, rollback, podID, username, pg, sources)
+	if err != nil {
+		return err
+	}
+
+	if natted {
+		err = providers.RunStep(ctx, providers.StepRouter, func() error {
+			return p.configureRouter(ctx, vms, pg, p.pveConf.WanVlan, natted, false)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = providers.RunStep(ctx, providers.StepSnapshots, func() error {
+		return p.snapshotAll(ctx, vms, "Base")
+	})
+	if err != nil {
+		return err
+	}
+
+	return providers.RunStep(ctx, providers.StepPermissions, func() error {
+		err := p.setPermission(ctx, "/pool/"+podID, username, p.pveConf.CustomCloneRole)
+		if err != nil {
+			return errors.Wrap(err, "Error assigning permissions")
+		}
+		return nil
+	})
+}
+
+func containsRouter(vms []pveResource) bool {
+	for _, vm := range vms {
+		if isRouter(vm.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ProxmoxClient) findRouterTemplate(ctx context.Context, natted bool) (pveResource, error) {
+	name := p.pveConf.RouterTemplate
+	if natted {
+		name = p.pveConf.NattedRouterTemplate
+	}
+
+	vms, err := p.listVMs(ctx)
+	if err != nil {
+		return pveResource{}, err
+	}
+	for _, vm := range vms {
+		if vm.Name == name {
+			return vm, nil
+		}
+	}
+	return pveResource{}, fmt.Errorf("Router template %s not found", name)
+}
+
+// clonePod creates the pod pool and clones every source VM into it, attaching non-router VMs to the pod VLAN.
+// The pool comment records the owner, and the pool is recorded on rollback, destroying it takes the VMs cloned into it along.
+func (p *ProxmoxClient) clonePod(ctx context.Context, rollback *providers.Rollback, podID, owner string, pg int, sources []pveResource) ([]pveResource, error) {
+	err := providers.RunStep(ctx, providers.StepResourcePool, func() error {
+		comment := "Cloned by goclone\n" + podOwnerAttribute + "=" + owner
+		err := p.post(ctx, "/pools", url.Values{"poolid": {podID}, "comment": {comment}}, nil)
+		if err != nil {
+			return errors.Wrap(err, "Error creating pool")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	rollback.Add("pool "+podID, func(ctx context.Context) error {
+		return p.destroyPod(ctx, podID)
+	})
+
+	finish := providers.StartStep(ctx, providers.StepCloneVMs)
+
+	vms := make([]pveResource, len(sources))
+	eg := errgroup.Group{}
+	for i, src := range sources {
+		eg.Go(func() error {
+			name := fmt.Sprintf("%d-%s", pg, src.Name)
+			vm, err := p.cloneVM(ctx, src, name, podID)
+			if err != nil {
+				providers.Report(ctx, providers.Update{Step: providers.StepCloneVMs, VM: name, Error: err.Error()})
+				return err
+			}
+			vms[i] = vm
+			providers.Report(ctx, providers.Update{Step: providers.StepCloneVMs, VM: name, Percent: 100, Message: "Cloned"})
+
+			if isRouter(src.Name) {
+				return nil
+			}
+			return p.setNIC(ctx, vm, "net0", p.pveConf.PodBridge, pg)
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		err = errors.Wrap(err, "Error cloning VMs")
+		finish(err)
+		return nil, err
+	}
+	finish(nil)
+	return vms, nil
+}
+
+func (p *ProxmoxClient) configureRouter(ctx context.Context, vms []pveResource, pg, wanVlan int, natted, competitionPod bool) error {
+	var router *pveResource
+	for i := range vms {
+		if isRouter(vms[i].Name) {
+			router = &vms[i]
+			break
+		}
+	}
+	if router == nil {
+		return errors.New("Pod has no router")
+	}
+
+	err := p.setNIC(ctx, *router, "net0", p.pveConf.WanBridge, wanVlan)
+	if err != nil {
+		return errors.Wrap(err, "Error configuring router networks")
+	}
+	err = p.setNIC(ctx, *router, "net1", p.pveConf.PodBridge, pg)
+	if err != nil {
+		return errors.Wrap(err, "Error configuring router networks")
+	}
+
+	providers.Report(ctx, providers.Update{Step: providers.StepRouter, VM: router.Name, Message: "Powering on router"})
+	err = p.powerOn(ctx, *router)
+	if err != nil {
+		return errors.Wrap(err, "Error powering on router")
+	}
+	router.Status = "running"
+	providers.Report(ctx, providers.Update{Step: providers.StepRouter, VM: router.Name, Message: "Router powered on"})
+
+	if !natted {
+		return nil
+	}
+
+	start := p.pveConf.StartingPortGroup
+	networkID := p.conf.DefaultNetworkID
+	if competitionPod {
+		start = p.pveConf.CompetitionStartPortGroup
+		networkID = p.conf.CompetitionNetworkID
+	}
+	octets := strings.Split(networkID, ".")
+	if len(octets) < 2 {
+		return fmt.Errorf("Invalid network ID %s", networkID)
+	}
+	pgOctet := pg - start + 1
+	if pgOctet < 1 || pgOctet > 255 {
+		return errors.New("Port group out of range")
+	}
+
+	args := fmt.Sprintf(p.pveConf.RouterProgramArgs, pgOctet, fmt.Sprintf("%s.%s", octets[0], octets[1]))
+	providers.Report(ctx, providers.Update{Step: providers.StepRouter, VM: router.Name, Message: "Waiting for the guest agent"})
+	err = p.runProgramOnVM(ctx, *router, strings.TrimSpace(p.pveConf.RouterProgram+" "+args))
+	if err != nil {
+		return errors.Wrap(err, "Error running program on router")
+	}
+	return nil
+}
+
+func (p *ProxmoxClient) snapshotAll(ctx context.Context, vms []pveResource, name string) error {
+	eg := errgroup.Group{}
+	for _, vm := range vms {
+		eg.Go(func() error {
+			err := p.setSnapshot(ctx, vm, name)
+			update := providers.Update{Step: providers.StepSnapshots, VM: vm.Name, Percent: 100, Message: "Snapshot " + name + " taken"}
+			if err != nil {
+				update = providers.Update{Step: providers.StepSnapshots, VM: vm.Name, Error: err.Error()}
+			}
+			providers.Report(ctx, update)
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return errors.Wrap(err, "Error setting snapshot")
+	}
+	return nil
+}
+
+func (p *ProxmoxClient) destroyPod(ctx context.Context, podID string) error {
+	ctx, span := p.tracer.Start(ctx, "DestroyPod")
+	defer span.End()
+
+	vms, err := p.vmsInPool(ctx, podID)
+	if err != nil {
+		return err
+	}
+
+	eg := errgroup.Group{}
+	for _, vm := range vms {
+		eg.Go(func() error {
+			return p.destroyVM(ctx, vm)
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return errors.Wrap(err, "Error destroying VMs")
+	}
+
+	err = p.delete(ctx, "/pools/"+podID, nil, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error destroying pool")
+	}
+
+	if match := portGroupRegex.FindStringSubmatch(podID); match != nil {
+		pg, _ := strconv.Atoi(match[1])
+		p.releasePortGroup(pg)
+	}
+	return nil
+}
+
+func (p *ProxmoxClient) bulkDeletePods(ctx context.Context, filter providers.PodFilter) ([]string, error) {
+	pods, err := p.getPodsMatchingFilter(ctx, filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get all pods")
+	}
+
+	var mu sync.Mutex
+	failed := []string{}
+	eg := errgroup.Group{}
+	for _, podID := range pods {
+		eg.Go(func() error {
+			err := p.destroyPod(ctx, podID)
+			if err != nil {
+				mu.Lock()
+				failed = append(failed, podID)
+				mu.Unlock()
+			}
+			return err
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return failed, errors.Wrap(err, "Failed to destroy resources")
+	}
+	return failed, nil
+}
+
+// forEachPodVM runs fn against every VM of the pods matching filters, leaving out the routers when skipRouters
+// is set, and collects the failed VM names
+func (p *ProxmoxClient) forEachPodVM(ctx context.Context, filter providers.PodFilter, skipRouters bool, fn func(pveResource) error) ([]string, error) {
+	pods, err := p.getPodsMatchingFilter(ctx, filter)
+	if err != nil {
+		return []string{}, errors.Wrap(err, "Error getting pods matching filter")
+	}
+
+	all, err := p.listVMs(ctx)
+	if err != nil {
+		return []string{}, err
+	}
+
+	var mu sync.Mutex
+	failed := []string{}
+	eg := errgroup.Group{}
+	for _, vm := range all {
+		if !slices.Contains(pods, vm.Pool) || (skipRouters && isRouter(vm.Name)) {
+			continue
+		}
+		eg.Go(func() error {
+			err := fn(vm)
+			if err != nil {
+				mu.Lock()
+				failed = append(failed, vm.Name)
+				mu.Unlock()
+			}
+			return err
+		})
+	}
+	return failed, eg.Wait()
+}
+
+func (p *ProxmoxClient) bulkRevertPods(ctx context.Context, filter providers.PodFilter, snapshot string) ([]string, error) {
+	failed, err := p.forEachPodVM(ctx, filter, true, func(vm pveResource) error {
+		return p.revertSnapshot(ctx, vm, snapshot)
+	})
+	if err != nil {
+		return failed, errors.Wrap(err, "Error reverting pods to snapshot")
+	}
+	return failed, nil
+}
+
+func (p *ProxmoxClient) bulkPowerPods(ctx context.Context, filter providers.PodFilter, state bool) ([]string, error) {
+	failed, err := p.forEachPodVM(ctx, filter, false, func(vm pveResource) error {
+		if state {
+			return p.powerOn(ctx, vm)
+		}
+		return p.powerOff(ctx, vm)
+	})
+	if err != nil {
+		return failed, errors.Wrap(err, "Error modifying power state for pods")
+	}
+	return failed, nil
+}
@@ -0,0 +1,352 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxCloneIDAttempts is how many VM IDs a clone tries when they turn out to be taken
+const maxCloneIDAttempts = 5
+
+// pveResource is a single entry of /cluster/resources?type=vm
+type pveResource struct {
+	VMID     int    `json:"vmid"`
+	Name     string `json:"name"`
+	Node     string `json:"node"`
+	Pool     string `json:"pool"`
+	Status   string `json:"status"`
+	Tags     string `json:"tags"`
+	Template int    `json:"template"`
+}
+
+type pvePool struct {
+	PoolID  string `json:"poolid"`
+	Comment string `json:"comment"`
+}
+
+type pveTaskStatus struct {
+	Status     string `json:"status"`
+	ExitStatus string `json:"exitstatus"`
+}
+
+type pveClusterStatus struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	ID   string `json:"id"`
+}
+
+type apiResponse struct {
+	Data   json.RawMessage   `json:"data"`
+	Errors map[string]string `json:"errors"`
+}
+
+func (p *ProxmoxClient) do(ctx context.Context, method, path string, params url.Values, out interface{}) error {
+	endpoint := p.baseURL + path
+	var body io.Reader
+	if params != nil {
+		if method == http.MethodGet || method == http.MethodDelete {
+			endpoint += "?" + params.Encode()
+		} else {
+			body = strings.NewReader(params.Encode())
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return errors.Wrap(err, "Failed to build Proxmox request")
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", p.conf.Username, p.conf.Password))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "Proxmox request %s %s failed", method, path)
+	}
+	defer resp.Body.Close()
+
+	var apiResp apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil && err != io.EOF {
+		return errors.Wrapf(err, "Failed to decode Proxmox response for %s %s", method, path)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg := resp.Status
+		for field, e := range apiResp.Errors {
+			msg = fmt.Sprintf("%s (%s: %s)", msg, field, e)
+		}
+		return fmt.Errorf("Proxmox request %s %s failed: %s", method, path, msg)
+	}
+
+	if out == nil || len(apiResp.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(apiResp.Data, out)
+}
+
+func (p *ProxmoxClient) get(ctx context.Context, path string, params url.Values, out interface{}) error {
+	return p.do(ctx, http.MethodGet, path, params, out)
+}
+
+func (p *ProxmoxClient) post(ctx context.Context, path string, params url.Values, out interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	return p.do(ctx, http.MethodPost, path, params, out)
+}
+
+func (p *ProxmoxClient) put(ctx context.Context, path string, params url.Values) error {
+	return p.do(ctx, http.MethodPut, path, params, nil)
+}
+
+func (p *ProxmoxClient) delete(ctx context.Context, path string, params url.Values, out interface{}) error {
+	return p.do(ctx, http.MethodDelete, path, params, out)
+}
+
+// runTask issues a request that returns a task UPID and waits for the task to finish
+func (p *ProxmoxClient) runTask(ctx context.Context, method, path string, params url.Values) error {
+	var upid string
+	err := p.do(ctx, method, path, params, &upid)
+	if err != nil {
+		return err
+	}
+	return p.waitTask(ctx, upid)
+}
+
+func (p *ProxmoxClient) waitTask(ctx context.Context, upid string) error {
+	if upid == "" {
+		return nil
+	}
+
+	// UPID:<node>:<pid>:<pstart>:<starttime>:<type>:<id>:<user>:
+	node := p.pveConf.Node
+	if parts := strings.Split(upid, ":"); len(parts) > 1 {
+		node = parts[1]
+	}
+
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+	for {
+		var status pveTaskStatus
+		err := p.get(ctx, fmt.Sprintf("/nodes/%s/tasks/%s/status", node, url.PathEscape(upid)), nil, &status)
+		if err != nil {
+			return errors.Wrap(err, "Failed to get task status")
+		}
+		if status.Status == "stopped" {
+			if status.ExitStatus != "OK" {
+				return fmt.Errorf("Task %s failed: %s", upid, status.ExitStatus)
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *ProxmoxClient) listVMs(ctx context.Context) ([]pveResource, error) {
+	var vms []pveResource
+	err := p.get(ctx, "/cluster/resources", url.Values{"type": {"vm"}}, &vms)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list VMs")
+	}
+	return vms, nil
+}
+
+func (p *ProxmoxClient) listPools(ctx context.Context) ([]pvePool, error) {
+	var pools []pvePool
+	err := p.get(ctx, "/pools", nil, &pools)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list pools")
+	}
+	return pools, nil
+}
+
+func (p *ProxmoxClient) vmsInPool(ctx context.Context, pool string) ([]pveResource, error) {
+	vms, err := p.listVMs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var poolVMs []pveResource
+	for _, vm := range vms {
+		if vm.Pool == pool {
+			poolVMs = append(poolVMs, vm)
+		}
+	}
+	return poolVMs, nil
+}
+
+func (p *ProxmoxClient) vmPath(vm pveResource) string {
+	node := vm.Node
+	if node == "" {
+		node = p.pveConf.Node
+	}
+	return fmt.Sprintf("/nodes/%s/qemu/%d", node, vm.VMID)
+}
+
+func (p *ProxmoxClient) nextID(ctx context.Context) (int, error) {
+	// nextid is returned as a string
+	var id json.Number
+	err := p.get(ctx, "/cluster/nextid", nil, &id)
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to get next VM ID")
+	}
+	n, err := id.Int64()
+	if err != nil {
+		return 0, errors.Wrap(err, "Invalid next VM ID")
+	}
+	return int(n), nil
+}
+
+func (p *ProxmoxClient) cloneVM(ctx context.Context, src pveResource, name, pool string) (pveResource, error) {
+	params := url.Values{
+		"name":   {name},
+		"pool":   {pool},
+		"target": {p.pveConf.Node},
+	}
+	// linked clones are only possible from templates
+	if src.Template == 1 {
+		params.Set("full", "0")
+	} else {
+		params.Set("full", "1")
+	}
+
+	newID, upid, err := p.startClone(ctx, src, params)
+	if err == nil {
+		err = p.waitTask(ctx, upid)
+	}
+	if err != nil {
+		return pveResource{}, errors.Wrapf(err, "Failed to clone %s", src.Name)
+	}
+
+	return pveResource{VMID: newID, Name: name, Node: p.pveConf.Node, Pool: pool}, nil
+}
+
+// startClone picks a VM ID and starts cloning src to it. PVE does not reserve the ID nextid returns, so
+// IDs are handed out one clone at a time until the clone has claimed its ID. IDs taken by someone else
+// in the meantime are retried.
+func (p *ProxmoxClient) startClone(ctx context.Context, src pveResource, params url.Values) (int, string, error) {
+	p.vmIDMu.Lock()
+	defer p.vmIDMu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		newID, err := p.nextID(ctx)
+		if err != nil {
+			return 0, "", err
+		}
+		params.Set("newid", fmt.Sprint(newID))
+
+		var upid string
+		err = p.do(ctx, http.MethodPost, p.vmPath(src)+"/clone", params, &upid)
+		if err != nil && strings.Contains(err.Error(), "already exists") && attempt < maxCloneIDAttempts {
+			continue
+		}
+		return newID, upid, err
+	}
+}
+
+// setNIC points the given network device at a bridge and VLAN while keeping its model and MAC address
+func (p *ProxmoxClient) setNIC(ctx context.Context, vm pveResource, device, bridge string, vlan int) error {
+	var vmConfig map[string]interface{}
+	err := p.get(ctx, p.vmPath(vm)+"/config", nil, &vmConfig)
+	if err != nil {
+		return errors.Wrap(err, "Failed to get VM config")
+	}
+
+	current, ok := vmConfig[device].(string)
+	if !ok {
+		return fmt.Errorf("VM %s has no %s device", vm.Name, device)
+	}
+
+	err = p.put(ctx, p.vmPath(vm)+"/config", url.Values{device: {rewriteNIC(current, bridge, vlan)}})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to configure %s on %s", device, vm.Name)
+	}
+	return nil
+}
+
+func rewriteNIC(nic, bridge string, vlan int) string {
+	var opts []string
+	for _, opt := range strings.Split(nic, ",") {
+		if strings.HasPrefix(opt, "bridge=") || strings.HasPrefix(opt, "tag=") {
+			continue
+		}
+		opts = append(opts, opt)
+	}
+	opts = append(opts, "bridge="+bridge)
+	if vlan > 0 {
+		opts = append(opts, fmt.Sprintf("tag=%d", vlan))
+	}
+	return strings.Join(opts, ",")
+}
+
+func (p *ProxmoxClient) powerOn(ctx context.Context, vm pveResource) error {
+	return p.runTask(ctx, http.MethodPost, p.vmPath(vm)+"/status/start", nil)
+}
+
+func (p *ProxmoxClient) powerOff(ctx context.Context, vm pveResource) error {
+	return p.runTask(ctx, http.MethodPost, p.vmPath(vm)+"/status/stop", nil)
+}
+
+func (p *ProxmoxClient) setSnapshot(ctx context.Context, vm pveResource, name string) error {
+	return p.runTask(ctx, http.MethodPost, p.vmPath(vm)+"/snapshot", url.Values{"snapname": {name}})
+}
+
+func (p *ProxmoxClient) revertSnapshot(ctx context.Context, vm pveResource, name string) error {
+	return p.runTask(ctx, http.MethodPost, fmt.Sprintf("%s/snapshot/%s/rollback", p.vmPath(vm), url.PathEscape(name)), nil)
+}
+
+func (p *ProxmoxClient) destroyVM(ctx context.Context, vm pveResource) error {
+	if vm.Status == "running" {
+		err := p.powerOff(ctx, vm)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to power off %s", vm.Name)
+		}
+	}
+	params := url.Values{"purge": {"1"}, "destroy-unreferenced-disks": {"1"}}
+	return p.runTask(ctx, http.MethodDelete, p.vmPath(vm), params)
+}
+
+func (p *ProxmoxClient) setPermission(ctx context.Context, path, username, role string) error {
+	params := url.Values{
+		"path":      {path},
+		"users":     {fmt.Sprintf("%s@%s", username, p.pveConf.Realm)},
+		"roles":     {role},
+		"propagate": {"1"},
+	}
+	return p.put(ctx, "/access/acl", params)
+}
+
+// runProgramOnVM runs a command through the QEMU guest agent once it responds, retrying while the VM boots
+func (p *ProxmoxClient) runProgramOnVM(ctx context.Context, vm pveResource, command string) error {
+	timeout := time.After(2 * time.Minute)
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return errors.New("Timeout waiting for guest agent")
+		case <-ticker.C:
+			if err := p.post(ctx, p.vmPath(vm)+"/agent/ping", nil, nil); err != nil {
+				continue
+			}
+			params := url.Values{"command": {"/bin/sh", "-c", command}}
+			return p.post(ctx, p.vmPath(vm)+"/agent/exec", params, nil)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package proxmox
+
+import (
+	"context"
+
+	"goclone/internal/providers"
+
+	"github.com/pkg/errors"
+)
+
+var _ providers.Backend = (*ProxmoxClient)(nil)
+
+func (p *ProxmoxClient) ServerGUID() string {
+	return p.serverGUID
+}
+
+func (p *ProxmoxClient) ListPods(ctx context.Context, owner string) ([]providers.Pod, error) {
+	return p.getPods(ctx, owner)
+}
+
+func (p *ProxmoxClient) ListAllPods(ctx context.Context) ([]providers.Pod, error) {
+	pods, err := p.getAllPods(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get pod list")
+	}
+	return pods, nil
+}
+
+func (p *ProxmoxClient) DeletePod(ctx context.Context, req providers.DeletePodRequest) error {
+	return p.destroyPod(ctx, req.PodID)
+}
+
+func (p *ProxmoxClient) ListPresetTemplates(ctx context.Context, isAdmin bool) ([]string, error) {
+	return p.getPresetTemplates(isAdmin), nil
+}
+
+func (p *ProxmoxClient) ListCustomTemplates(ctx context.Context) ([]providers.CustomTemplate, error) {
+	return p.getCustomTemplates(ctx)
+}
+
+func (p *ProxmoxClient) CloneTemplate(ctx context.Context, req providers.TemplateCloneRequest) error {
+	return p.templateClone(ctx, req.Template, req.Username)
+}
+
+func (p *ProxmoxClient) CloneCustom(ctx context.Context, req providers.CustomCloneRequest) error {
+	return p.customClone(ctx, req.Name, req.VMs, req.Natted, req.Username)
+}
+
+func (p *ProxmoxClient) RefreshTemplates(ctx context.Context) error {
+	return p.loadTemplates(ctx)
+}
+
+func (p *ProxmoxClient) BulkDelete(ctx context.Context, filter providers.PodFilter) (providers.BulkResult, error) {
+	failed, err := p.bulkDeletePods(ctx, filter)
+	return providers.BulkResult{Failed: failed}, err
+}
+
+func (p *ProxmoxClient) BulkRevert(ctx context.Context, filter providers.PodFilter, snapshot string) (providers.BulkResult, error) {
+	failed, err := p.bulkRevertPods(ctx, filter, snapshot)
+	return providers.BulkResult{Failed: failed}, err
+}
+
+func (p *ProxmoxClient) BulkPower(ctx context.Context, filter providers.PodFilter, powerOn bool) (providers.BulkResult, error) {
+	failed, err := p.bulkPowerPods(ctx, filter, powerOn)
+	return providers.BulkResult{Failed: failed}, err
+}
@@ -0,0 +1,499 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goclone/internal/config"
+	"goclone/internal/providers"
+)
+
+// fakePVE is a minimal in-memory stand-in for the Proxmox VE REST API
+type fakePVE struct {
+	mu        sync.Mutex
+	pools     map[string]string
+	vms       map[int]*fakeVM
+	acls      []string
+	agentExec []string
+
+	// failing makes the actions listed fail, like "snapshot" or "delete"
+	failing map[string]bool
+	// stolenIDs is how many IDs nextid returns that someone else takes before the clone starts
+	stolenIDs int
+	// onAction is called with every VM action requested, like "snapshot"
+	onAction func(action string)
+}
+
+type fakeVM struct {
+	pveResource
+	config    map[string]string
+	snapshots []string
+}
+
+func newFakePVE() *fakePVE {
+	f := &fakePVE{
+		pools: map[string]string{
+			"Template_Web":    "goclone.template.natted=true",
+			"Template_Secret": "goclone.template.adminOnly=true\ngoclone.template.noRouter=true",
+			"Custom_Linux":    "",
+		},
+		vms:     map[int]*fakeVM{},
+		failing: map[string]bool{},
+	}
+	f.addVM(100, "Web-Server", "Template_Web", 1, "")
+	f.addVM(101, "Web-PodRouter", "Template_Web", 1, "")
+	f.addVM(102, "Secret-Box", "Template_Secret", 1, "goclone-hidden")
+	f.addVM(103, "Ubuntu", "Custom_Linux", 1, "")
+	f.addVM(104, "VyOS_PodRouter", "", 1, "")
+	f.addVM(105, "VyOS_Natted_PodRouter", "", 1, "")
+	return f
+}
+
+func (f *fakePVE) addVM(id int, name, pool string, template int, tags string) *fakeVM {
+	vm := &fakeVM{
+		pveResource: pveResource{VMID: id, Name: name, Node: "pve", Pool: pool, Template: template, Tags: tags, Status: "stopped"},
+		config: map[string]string{
+			"net0": "virtio=BC:24:11:00:00:01,bridge=vmbr0",
+			"net1": "virtio=BC:24:11:00:00:02,bridge=vmbr0",
+		},
+	}
+	f.vms[id] = vm
+	return vm
+}
+
+func (f *fakePVE) reply(w http.ResponseWriter, data interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func (f *fakePVE) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "PVEAPIToken=goclone@pve!test=") {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	r.ParseForm()
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/api2/json/"), "/")
+	const upid = "UPID:pve:00000001:00000001:00000001:task:1:goclone@pve:"
+
+	switch {
+	case path[0] == "cluster" && path[1] == "status":
+		f.reply(w, []pveClusterStatus{{Type: "node", Name: "pve"}, {Type: "cluster", Name: "lab"}})
+	case path[0] == "cluster" && path[1] == "resources":
+		vms := []pveResource{}
+		for _, vm := range f.vms {
+			vms = append(vms, vm.pveResource)
+		}
+		f.reply(w, vms)
+	case path[0] == "cluster" && path[1] == "nextid":
+		// like PVE, the lowest free ID is returned without being reserved
+		id := 200
+		for f.vms[id] != nil {
+			id++
+		}
+		f.reply(w, strconv.Itoa(id))
+		if f.stolenIDs > 0 {
+			f.stolenIDs--
+			f.addVM(id, "Elsewhere", "", 0, "")
+		}
+	case path[0] == "pools" && len(path) == 1 && r.Method == http.MethodGet:
+		pools := []pvePool{}
+		for id, comment := range f.pools {
+			pools = append(pools, pvePool{PoolID: id, Comment: comment})
+		}
+		f.reply(w, pools)
+	case path[0] == "pools" && r.Method == http.MethodPost:
+		f.pools[r.PostForm.Get("poolid")] = r.PostForm.Get("comment")
+		f.reply(w, nil)
+	case path[0] == "pools" && r.Method == http.MethodDelete:
+		for _, vm := range f.vms {
+			if vm.Pool == path[1] {
+				w.WriteHeader(http.StatusInternalServerError)
+				f.reply(w, nil)
+				return
+			}
+		}
+		delete(f.pools, path[1])
+		f.reply(w, nil)
+	case path[0] == "access" && path[1] == "acl":
+		f.acls = append(f.acls, fmt.Sprintf("%s:%s:%s", r.PostForm.Get("path"), r.PostForm.Get("users"), r.PostForm.Get("roles")))
+		f.reply(w, nil)
+	case path[0] == "nodes" && path[2] == "tasks":
+		f.reply(w, pveTaskStatus{Status: "stopped", ExitStatus: "OK"})
+	case path[0] == "nodes" && path[2] == "qemu":
+		id, _ := strconv.Atoi(path[3])
+		vm, ok := f.vms[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			f.reply(w, nil)
+			return
+		}
+		action := strings.Join(path[4:], "/")
+		if f.onAction != nil {
+			f.onAction(action)
+		}
+		if f.failing[action] || (action == "" && r.Method == http.MethodDelete && f.failing["delete"]) {
+			w.WriteHeader(http.StatusInternalServerError)
+			f.reply(w, nil)
+			return
+		}
+		switch {
+		case action == "" && r.Method == http.MethodDelete:
+			delete(f.vms, id)
+			f.reply(w, upid)
+		case action == "clone":
+			newID, _ := strconv.Atoi(r.PostForm.Get("newid"))
+			if f.vms[newID] != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]interface{}{"errors": map[string]string{"newid": fmt.Sprintf("VM %d already exists", newID)}})
+				return
+			}
+			clone := f.addVM(newID, r.PostForm.Get("name"), r.PostForm.Get("pool"), 0, "")
+			for k, v := range vm.config {
+				clone.config[k] = v
+			}
+			f.reply(w, upid)
+		case action == "config" && r.Method == http.MethodGet:
+			f.reply(w, vm.config)
+		case action == "config":
+			for k := range r.PostForm {
+				vm.config[k] = r.PostForm.Get(k)
+			}
+			f.reply(w, nil)
+		case action == "status/start":
+			vm.Status = "running"
+			f.reply(w, upid)
+		case action == "status/stop":
+			vm.Status = "stopped"
+			f.reply(w, upid)
+		case action == "snapshot":
+			vm.snapshots = append(vm.snapshots, r.PostForm.Get("snapname"))
+			f.reply(w, upid)
+		case strings.HasSuffix(action, "/rollback"):
+			f.reply(w, upid)
+		case action == "agent/ping":
+			f.reply(w, nil)
+		case action == "agent/exec":
+			f.agentExec = append(f.agentExec, strings.Join(r.PostForm["command"], " "))
+			f.reply(w, map[string]int{"pid": 1})
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (f *fakePVE) vmsInPool(pool string) []*fakeVM {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var vms []*fakeVM
+	for _, vm := range f.vms {
+		if vm.Pool == pool {
+			vms = append(vms, vm)
+		}
+	}
+	return vms
+}
+
+func newTestClient(t *testing.T) (*ProxmoxClient, *fakePVE) {
+	t.Helper()
+	fake := newFakePVE()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	conf := &config.Config{
+		Provider: config.Provider{
+			URL:                  server.URL,
+			Username:             "goclone@pve!test",
+			Password:             "secret",
+			MaxPodLimit:          2,
+			DefaultNetworkID:     "172.16.0.0/16",
+			CompetitionNetworkID: "172.26.0.0/16",
+			Proxmox: config.Proxmox{
+				Node:                      "pve",
+				Realm:                     "ad",
+				CloneRole:                 "GocloneUser",
+				CustomCloneRole:           "GocloneCustom",
+				PodBridge:                 "vmbr1",
+				WanBridge:                 "vmbr0",
+				WanVlan:                   40,
+				PresetTemplatePrefix:      "Template_",
+				CustomTemplatePrefix:      "Custom_",
+				RouterTemplate:            "VyOS_PodRouter",
+				NattedRouterTemplate:      "VyOS_Natted_PodRouter",
+				RouterProgram:             "/usr/bin/sed",
+				RouterProgramArgs:         "%v %v",
+				StartingPortGroup:         1801,
+				EndingPortGroup:           2000,
+				CompetitionStartPortGroup: 2001,
+				CompetitionEndPortGroup:   2200,
+			},
+		},
+	}
+
+	p, err := newProxmoxClient(conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.pollInterval = time.Millisecond
+	return p, fake
+}
+
+func TestLoadTemplates(t *testing.T) {
+	p, _ := newTestClient(t)
+
+	if p.serverGUID != "lab" {
+		t.Errorf("expected server GUID lab, got %s", p.serverGUID)
+	}
+
+	if got := p.getPresetTemplates(true); strings.Join(got, ",") != "Secret,Web" {
+		t.Errorf("admin templates = %v", got)
+	}
+	if got := p.getPresetTemplates(false); strings.Join(got, ",") != "Web" {
+		t.Errorf("user templates = %v", got)
+	}
+
+	web, _ := p.template("Web")
+	if !web.Natted || web.NoRouter || len(web.VMs) != 2 {
+		t.Errorf("unexpected Web template %+v", web)
+	}
+
+	custom, err := p.getCustomTemplates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(custom) != 1 || custom[0].Name != "Linux" || custom[0].VMs[0] != "Ubuntu" {
+		t.Errorf("unexpected custom templates %+v", custom)
+	}
+}
+
+func TestTemplateClone(t *testing.T) {
+	p, fake := newTestClient(t)
+	ctx := context.Background()
+
+	err := p.templateClone(ctx, "Web", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pods, err := p.getPods(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 1 || pods[0].Name != "1801_Web_alice" || pods[0].ServerGUID != "lab" || pods[0].Owner != "alice" {
+		t.Fatalf("unexpected pods %+v", pods)
+	}
+
+	vms := fake.vmsInPool("1801_Web_alice")
+	if len(vms) != 2 {
+		t.Fatalf("expected 2 cloned VMs, got %d", len(vms))
+	}
+	for _, vm := range vms {
+		if !strings.HasPrefix(vm.Name, "1801-") {
+			t.Errorf("clone %s is missing the port group prefix", vm.Name)
+		}
+		if len(vm.snapshots) != 1 || vm.snapshots[0] != "Base" {
+			t.Errorf("clone %s snapshots = %v", vm.Name, vm.snapshots)
+		}
+		if isRouter(vm.Name) {
+			if vm.config["net0"] != "virtio=BC:24:11:00:00:01,bridge=vmbr0,tag=40" || vm.config["net1"] != "virtio=BC:24:11:00:00:02,bridge=vmbr1,tag=1801" {
+				t.Errorf("router networks = %v", vm.config)
+			}
+			if vm.Status != "running" {
+				t.Errorf("router was not powered on")
+			}
+		} else if vm.config["net0"] != "virtio=BC:24:11:00:00:01,bridge=vmbr1,tag=1801" {
+			t.Errorf("VM network = %s", vm.config["net0"])
+		}
+	}
+
+	if len(fake.agentExec) != 1 || fake.agentExec[0] != "/bin/sh -c /usr/bin/sed 1 172.16" {
+		t.Errorf("router program = %v", fake.agentExec)
+	}
+	if fake.acls[0] != "/pool/1801_Web_alice:alice@ad:GocloneUser" {
+		t.Errorf("pool permission = %v", fake.acls)
+	}
+
+	// The next clone takes the next port group and the third hits the pod limit
+	if err := p.templateClone(ctx, "Web", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.templateClone(ctx, "Web", "alice"); err == nil || err.Error() != "Max pod limit reached" {
+		t.Errorf("expected pod limit error, got %v", err)
+	}
+}
+
+func TestCloneRetriesTakenIDs(t *testing.T) {
+	p, fake := newTestClient(t)
+	fake.stolenIDs = 2
+
+	err := p.templateClone(context.Background(), "Web", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vms := fake.vmsInPool("1801_Web_alice"); len(vms) != 2 {
+		t.Errorf("expected 2 cloned VMs, got %d", len(vms))
+	}
+
+	// the IDs run out after a few tries
+	fake.stolenIDs = maxCloneIDAttempts
+	err = p.templateClone(context.Background(), "Web", "bob")
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected the clone to give up, got %v", err)
+	}
+}
+
+func TestCloneRollback(t *testing.T) {
+	p, fake := newTestClient(t)
+	ctx := context.Background()
+
+	fake.failing["snapshot"] = true
+	err := p.templateClone(ctx, "Web", "alice")
+	var cloneErr *providers.CloneError
+	if !errors.As(err, &cloneErr) || len(cloneErr.Cleanup) != 0 {
+		t.Fatalf("expected a clean rollback, got %v", err)
+	}
+	if _, ok := fake.pools["1801_Web_alice"]; ok {
+		t.Error("pool was not destroyed")
+	}
+	if vms := fake.vmsInPool("1801_Web_alice"); len(vms) != 0 {
+		t.Errorf("%d cloned VMs were left behind", len(vms))
+	}
+	if _, ok := p.portGroups[1801]; ok {
+		t.Error("port group 1801 is still reserved")
+	}
+
+	// what cannot be torn down is reported apart, the port group is released regardless
+	fake.failing["delete"] = true
+	err = p.customClone(ctx, "Lab", []string{"Ubuntu"}, false, "alice")
+	if !errors.As(err, &cloneErr) || len(cloneErr.Cleanup) != 1 {
+		t.Fatalf("expected one cleanup error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "Error setting snapshot") || !strings.Contains(cloneErr.Cleanup[0].Error(), "pool 1801_Lab_alice") {
+		t.Errorf("unexpected errors %v, %v", err, cloneErr.Cleanup)
+	}
+	if _, ok := p.portGroups[1801]; ok {
+		t.Error("port group 1801 is still reserved")
+	}
+}
+
+func TestCloneRollbackOnCancel(t *testing.T) {
+	p, fake := newTestClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the client goes away while the VMs are snapshotted, the pod is torn down all the same
+	fake.onAction = func(action string) {
+		if action == "snapshot" {
+			cancel()
+		}
+	}
+	err := p.templateClone(ctx, "Web", "alice")
+	var cloneErr *providers.CloneError
+	if !errors.As(err, &cloneErr) || len(cloneErr.Cleanup) != 0 {
+		t.Fatalf("expected a clean rollback, got %v", err)
+	}
+	fake.mu.Lock()
+	_, ok := fake.pools["1801_Web_alice"]
+	fake.mu.Unlock()
+	if ok {
+		t.Error("pool was not destroyed")
+	}
+	if vms := fake.vmsInPool("1801_Web_alice"); len(vms) != 0 {
+		t.Errorf("%d cloned VMs were left behind", len(vms))
+	}
+}
+
+func TestHiddenVMsAndRouterTemplates(t *testing.T) {
+	p, fake := newTestClient(t)
+	ctx := context.Background()
+
+	err := p.templateClone(ctx, "Secret", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vms := fake.vmsInPool("1801_Secret_bob")
+	if len(vms) != 1 {
+		t.Fatalf("noRouter template cloned %d VMs", len(vms))
+	}
+	if fake.acls[1] != fmt.Sprintf("/vms/%d:bob@ad:NoAccess", vms[0].VMID) {
+		t.Errorf("hidden VM permission = %v", fake.acls)
+	}
+
+	err = p.customClone(ctx, "Lab", []string{"Ubuntu"}, true, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vms = fake.vmsInPool("1802_Lab_bob")
+	if len(vms) != 2 || !containsRouter([]pveResource{vms[0].pveResource, vms[1].pveResource}) {
+		t.Errorf("custom natted pod should get a router, got %d VMs", len(vms))
+	}
+	if p.portGroups[1801] != "1801_Secret_bob" || p.portGroups[1802] != "1802_Lab_bob" {
+		t.Errorf("port groups reserved for %v", p.portGroups)
+	}
+}
+
+func TestBulkOperations(t *testing.T) {
+	p, fake := newTestClient(t)
+	ctx := context.Background()
+
+	for _, user := range []string{"alice", "bob"} {
+		if err := p.templateClone(ctx, "Web", user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failed, err := p.bulkPowerPods(ctx, []string{"_alice"}, false)
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("bulk power failed: %v %v", failed, err)
+	}
+	for _, vm := range fake.vmsInPool("1801_Web_alice") {
+		if vm.Status != "stopped" {
+			t.Errorf("%s still running", vm.Name)
+		}
+	}
+
+	failed, err = p.bulkDeletePods(ctx, []string{"", "bob"})
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("bulk delete failed: %v %v", failed, err)
+	}
+	if len(fake.vmsInPool("1802_Web_bob")) != 0 {
+		t.Errorf("bob's VMs were not deleted")
+	}
+
+	pods, _ := p.getPods(ctx, "bob")
+	if len(pods) != 0 {
+		t.Errorf("bob still has pods %v", pods)
+	}
+
+	// The released port group is handed out again
+	if err := p.templateClone(ctx, "Web", "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if pods, _ := p.getPods(ctx, "carol"); len(pods) != 1 || pods[0].Name != "1802_Web_carol" {
+		t.Errorf("unexpected pods %v", pods)
+	}
+
+	// owners are matched exactly, not by the end of the pod name
+	if err := p.templateClone(ctx, "Web", "alice_bob"); err != nil {
+		t.Fatal(err)
+	}
+	if pods, _ := p.getPods(ctx, "bob"); len(pods) != 0 {
+		t.Errorf("bob sees alice_bob's pods %+v", pods)
+	}
+	if pods, _ := p.getPods(ctx, "Alice_Bob"); len(pods) != 1 || pods[0].Name != "1803_Web_alice_bob" {
+		t.Errorf("unexpected pods %+v", pods)
+	}
+}
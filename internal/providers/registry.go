@@ -0,0 +1,229 @@
+package providers
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateSeparator joins a provider name and a template name when several providers are registered
+const TemplateSeparator = "/"
+
+// Registry serves several backends as a single Provider. Pods are merged across backends and
+// routed back by Pod.ServerGUID. With more than one backend, preset templates are namespaced
+// as <provider>/<template> and custom templates carry the name of their provider.
+//
+// Backends are registered during setup; the registry is read-only once it starts serving.
+type Registry struct {
+	names    []string
+	backends map[string]Backend
+	byGUID   map[string]string
+}
+
+var _ Provider = (*Registry)(nil)
+
+func NewRegistry() *Registry {
+	return &Registry{
+		backends: map[string]Backend{},
+		byGUID:   map[string]string{},
+	}
+}
+
+func (r *Registry) Register(name string, backend Backend) error {
+	if name == "" || strings.Contains(name, TemplateSeparator) {
+		return fmt.Errorf("Invalid provider name %q", name)
+	}
+	if _, exists := r.backends[name]; exists {
+		return fmt.Errorf("Provider %s is already registered", name)
+	}
+	guid := backend.ServerGUID()
+	if other, exists := r.byGUID[guid]; exists {
+		return fmt.Errorf("Provider %s has the same server GUID as %s", name, other)
+	}
+
+	r.names = append(r.names, name)
+	r.backends[name] = backend
+	r.byGUID[guid] = name
+	return nil
+}
+
+func (r *Registry) Len() int {
+	return len(r.names)
+}
+
+func (r *Registry) Get(name string) (Backend, bool) {
+	backend, ok := r.backends[name]
+	return backend, ok
+}
+
+func (r *Registry) ByServerGUID(guid string) (Backend, bool) {
+	name, ok := r.byGUID[guid]
+	if !ok {
+		return nil, false
+	}
+	return r.backends[name], true
+}
+
+// single returns the only backend when just one is registered, so requests pass through untouched
+func (r *Registry) single() (Backend, bool) {
+	if len(r.names) != 1 {
+		return nil, false
+	}
+	return r.backends[r.names[0]], true
+}
+
+// each runs fn against every backend in registration order and joins the errors, tagged with the provider name
+func (r *Registry) each(fn func(name string, backend Backend) error) error {
+	var errs []error
+	for _, name := range r.names {
+		err := fn(name, r.backends[name])
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "Provider %s", name))
+		}
+	}
+	return stderrors.Join(errs...)
+}
+
+func (r *Registry) ListPods(ctx context.Context, owner string) ([]Pod, error) {
+	pods := []Pod{}
+	err := r.each(func(name string, backend Backend) error {
+		found, err := backend.ListPods(ctx, owner)
+		pods = append(pods, found...)
+		return err
+	})
+	return pods, err
+}
+
+func (r *Registry) ListAllPods(ctx context.Context) ([]Pod, error) {
+	pods := []Pod{}
+	err := r.each(func(name string, backend Backend) error {
+		found, err := backend.ListAllPods(ctx)
+		pods = append(pods, found...)
+		return err
+	})
+	return pods, err
+}
+
+func (r *Registry) DeletePod(ctx context.Context, req DeletePodRequest) error {
+	if backend, ok := r.single(); ok {
+		return backend.DeletePod(ctx, req)
+	}
+
+	if req.ServerGUID != "" {
+		backend, ok := r.ByServerGUID(req.ServerGUID)
+		if !ok {
+			return NotFound("Server", req.ServerGUID)
+		}
+		return backend.DeletePod(ctx, req)
+	}
+
+	for _, name := range r.names {
+		backend := r.backends[name]
+		pods, err := backend.ListPods(ctx, req.Owner)
+		if err != nil {
+			return errors.Wrapf(err, "Provider %s", name)
+		}
+		if slices.ContainsFunc(pods, func(p Pod) bool { return p.Name == req.PodID }) {
+			return backend.DeletePod(ctx, req)
+		}
+	}
+	return NotFound("Pod", req.PodID)
+}
+
+func (r *Registry) ListPresetTemplates(ctx context.Context, isAdmin bool) ([]string, error) {
+	if backend, ok := r.single(); ok {
+		return backend.ListPresetTemplates(ctx, isAdmin)
+	}
+
+	templates := []string{}
+	err := r.each(func(name string, backend Backend) error {
+		found, err := backend.ListPresetTemplates(ctx, isAdmin)
+		for _, t := range found {
+			templates = append(templates, name+TemplateSeparator+t)
+		}
+		return err
+	})
+	return templates, err
+}
+
+func (r *Registry) ListCustomTemplates(ctx context.Context) ([]CustomTemplate, error) {
+	if backend, ok := r.single(); ok {
+		return backend.ListCustomTemplates(ctx)
+	}
+
+	templates := []CustomTemplate{}
+	err := r.each(func(name string, backend Backend) error {
+		found, err := backend.ListCustomTemplates(ctx)
+		for _, t := range found {
+			t.Provider = name
+			templates = append(templates, t)
+		}
+		return err
+	})
+	return templates, err
+}
+
+func (r *Registry) CloneTemplate(ctx context.Context, req TemplateCloneRequest) error {
+	if backend, ok := r.single(); ok {
+		return backend.CloneTemplate(ctx, req)
+	}
+
+	name, template, ok := strings.Cut(req.Template, TemplateSeparator)
+	backend, exists := r.backends[name]
+	if !ok || !exists {
+		return NotFound("Template", req.Template)
+	}
+	req.Template = template
+	return backend.CloneTemplate(ctx, req)
+}
+
+func (r *Registry) CloneCustom(ctx context.Context, req CustomCloneRequest) error {
+	if backend, ok := r.single(); ok {
+		return backend.CloneCustom(ctx, req)
+	}
+
+	backend, ok := r.backends[req.Provider]
+	if !ok {
+		return NotFound("Provider", req.Provider)
+	}
+	return backend.CloneCustom(ctx, req)
+}
+
+func (r *Registry) RefreshTemplates(ctx context.Context) error {
+	return r.each(func(name string, backend Backend) error {
+		return backend.RefreshTemplates(ctx)
+	})
+}
+
+// bulk fans a bulk operation out to every backend and merges what failed
+func (r *Registry) bulk(fn func(backend Backend) (BulkResult, error)) (BulkResult, error) {
+	result := BulkResult{Failed: []string{}}
+	err := r.each(func(name string, backend Backend) error {
+		res, err := fn(backend)
+		result.Failed = append(result.Failed, res.Failed...)
+		return err
+	})
+	return result, err
+}
+
+func (r *Registry) BulkDelete(ctx context.Context, filter PodFilter) (BulkResult, error) {
+	return r.bulk(func(backend Backend) (BulkResult, error) {
+		return backend.BulkDelete(ctx, filter)
+	})
+}
+
+func (r *Registry) BulkRevert(ctx context.Context, filter PodFilter, snapshot string) (BulkResult, error) {
+	return r.bulk(func(backend Backend) (BulkResult, error) {
+		return backend.BulkRevert(ctx, filter, snapshot)
+	})
+}
+
+func (r *Registry) BulkPower(ctx context.Context, filter PodFilter, powerOn bool) (BulkResult, error) {
+	return r.bulk(func(backend Backend) (BulkResult, error) {
+		return backend.BulkPower(ctx, filter, powerOn)
+	})
+}
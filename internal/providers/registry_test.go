@@ -0,0 +1,153 @@
+package providers_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"goclone/internal/config"
+	"goclone/internal/providers"
+	"goclone/internal/providers/fake"
+
+	"github.com/pkg/errors"
+)
+
+func newFake(name string, templates ...string) *fake.FakeProvider {
+	conf := &config.Config{
+		Provider: config.Provider{
+			Name:        name,
+			MaxPodLimit: 5,
+			Fake:        config.Fake{Enabled: true},
+		},
+	}
+	for _, t := range templates {
+		conf.Provider.Fake.Templates = append(conf.Provider.Fake.Templates, config.FakeTemplate{Name: t, VMs: []string{t + "-Server"}})
+		conf.Provider.Fake.CustomTemplates = append(conf.Provider.Fake.CustomTemplates, config.FakeCustomTemplate{Name: t, VMs: []string{t + "-Server"}})
+	}
+	return fake.NewFakeProvider(conf, nil)
+}
+
+func TestRegistryRouting(t *testing.T) {
+	ctx := context.Background()
+	east, west := newFake("east", "Web"), newFake("west", "Web", "AD")
+
+	registry := providers.NewRegistry()
+	if err := registry.Register("east", east); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register("west", west); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register("east", newFake("other")); err == nil {
+		t.Error("expected duplicate provider name to be rejected")
+	}
+	if err := registry.Register("copy", newFake("east")); err == nil {
+		t.Error("expected duplicate server GUID to be rejected")
+	}
+
+	templates, err := registry.ListPresetTemplates(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(templates, []string{"east/Web", "west/AD", "west/Web"}) {
+		t.Errorf("unexpected templates %v", templates)
+	}
+
+	custom, err := registry.ListCustomTemplates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(custom) != 3 || custom[0].Provider != "east" || custom[2].Provider != "west" {
+		t.Errorf("unexpected custom templates %+v", custom)
+	}
+
+	err = registry.CloneTemplate(ctx, providers.TemplateCloneRequest{Template: "Web", Username: "alice"})
+	var notFound *providers.NotFoundError
+	if !errors.As(err, &notFound) {
+		t.Errorf("expected unqualified template to be rejected, got %v", err)
+	}
+
+	for _, template := range []string{"east/Web", "west/Web"} {
+		err = registry.CloneTemplate(ctx, providers.TemplateCloneRequest{Template: template, Username: "alice"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = registry.CloneCustom(ctx, providers.CustomCloneRequest{Name: "Lab", VMs: []string{"AD-Server"}, Username: "alice", Provider: "west"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pods, err := registry.ListPods(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 3 || pods[0].ServerGUID != east.ServerGUID() || pods[1].ServerGUID != west.ServerGUID() {
+		t.Fatalf("unexpected pods %+v", pods)
+	}
+	if all, err := registry.ListAllPods(ctx); err != nil || len(all) != 3 {
+		t.Errorf("expected every pod across providers, got %+v %v", all, err)
+	}
+
+	// both providers hand out 1801, so the server GUID decides which pod goes
+	err = registry.DeletePod(ctx, providers.DeletePodRequest{PodID: "1801_Web_alice", ServerGUID: west.ServerGUID(), Owner: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if westPods, _ := west.ListPods(ctx, "alice"); len(westPods) != 1 || westPods[0].Name != "1802_Lab_alice" {
+		t.Errorf("wrong pod deleted from west: %+v", westPods)
+	}
+	if eastPods, _ := east.ListPods(ctx, "alice"); len(eastPods) != 1 {
+		t.Errorf("east pod should not have been deleted: %+v", eastPods)
+	}
+
+	// without a server GUID the pod is found among the owner's pods
+	err = registry.DeletePod(ctx, providers.DeletePodRequest{PodID: "1802_Lab_alice", Owner: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := registry.BulkDelete(ctx, providers.PodFilter{"_alice"})
+	if err != nil || len(result.Failed) != 0 {
+		t.Fatalf("bulk delete failed: %v %v", result.Failed, err)
+	}
+	if pods, _ := registry.ListPods(ctx, "alice"); len(pods) != 0 {
+		t.Errorf("expected all pods deleted, got %+v", pods)
+	}
+}
+
+func TestRegistryOrphans(t *testing.T) {
+	ctx := context.Background()
+	east, west := newFake("east", "Web"), newFake("west", "Web")
+
+	registry := providers.NewRegistry()
+	registry.Register("east", east)
+	registry.Register("west", west)
+
+	if err := registry.CloneTemplate(ctx, providers.TemplateCloneRequest{Template: "west/Web", Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	east.LeakPortGroup(1801)
+	west.LeakPortGroup(1850)
+
+	orphans, err := registry.FindOrphans(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 2 || orphans[0].Provider != "east" || orphans[1].Provider != "west" || orphans[1].PortGroup != 1850 {
+		t.Fatalf("unexpected orphans %+v", orphans)
+	}
+
+	// each orphan goes back to the provider it was found on
+	for _, orphan := range orphans {
+		if err := registry.RemoveOrphan(ctx, orphan); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if orphans, _ := registry.FindOrphans(ctx); len(orphans) != 0 {
+		t.Errorf("expected the orphans removed, got %+v", orphans)
+	}
+	if pods, _ := west.ListPods(ctx, "alice"); len(pods) != 1 {
+		t.Errorf("the pod should have been left alone, got %+v", pods)
+	}
+}
@@ -0,0 +1,89 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StepRollback is reported while a failed clone tears down what it had created
+const StepRollback = "rollback"
+
+// RollbackTimeout bounds how long a failed clone may take tearing down what it had created
+var RollbackTimeout = 5 * time.Minute
+
+// CloneError is returned by a clone that failed partway, once what it had created was torn down.
+// Its message is the one of the error the clone failed with, so it maps to a status like that error.
+type CloneError struct {
+	Err error
+	// Cleanup holds what went wrong tearing the pod down, those resources were left behind
+	Cleanup []error
+}
+
+func (e *CloneError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CloneError) Unwrap() error {
+	return e.Err
+}
+
+// CleanupErrors returns the messages of the cleanup errors
+func (e *CloneError) CleanupErrors() []string {
+	msgs := make([]string, len(e.Cleanup))
+	for i, err := range e.Cleanup {
+		msgs[i] = err.Error()
+	}
+	return msgs
+}
+
+type undo struct {
+	resource string
+	fn       func(ctx context.Context) error
+}
+
+// Rollback records what a clone created, so that a failed clone leaves nothing half-built behind.
+// The zero value is ready to use.
+type Rollback struct {
+	undos []undo
+}
+
+// Add records that resource was created and how to remove it again. fn is passed the context to
+// remove it with, which is not the clone's own.
+func (r *Rollback) Add(resource string, fn func(ctx context.Context) error) {
+	r.undos = append(r.undos, undo{resource: resource, fn: fn})
+}
+
+// Fail tears down what was recorded, newest first, and returns err as a *CloneError with what could
+// not be torn down. A nil err is returned as is.
+// The teardown outlives ctx, up to RollbackTimeout, since a clone often fails because ctx was cancelled.
+func (r *Rollback) Fail(ctx context.Context, err error) error {
+	if err == nil || len(r.undos) == 0 {
+		return err
+	}
+
+	undoCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), RollbackTimeout)
+	defer cancel()
+
+	cloneErr := &CloneError{Err: err}
+	RunStep(ctx, StepRollback, func() error {
+		for i := len(r.undos) - 1; i >= 0; i-- {
+			u := r.undos[i]
+			if err := u.fn(undoCtx); err != nil {
+				err = fmt.Errorf("Failed to remove %s: %v", u.resource, err)
+				Report(ctx, Update{Step: StepRollback, Error: err.Error()})
+				cloneErr.Cleanup = append(cloneErr.Cleanup, err)
+				continue
+			}
+			Report(ctx, Update{Step: StepRollback, Message: "Removed " + u.resource})
+		}
+		r.undos = nil
+
+		if len(cloneErr.Cleanup) > 0 {
+			return fmt.Errorf("%s", strings.Join(cloneErr.CleanupErrors(), "; "))
+		}
+		return nil
+	})
+	return cloneErr
+}
@@ -0,0 +1,69 @@
+package providers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"goclone/internal/providers"
+)
+
+func TestRollback(t *testing.T) {
+	ctx := context.Background()
+
+	rollback := &providers.Rollback{}
+	if err := rollback.Fail(ctx, providers.ErrNoPortGroups); err != providers.ErrNoPortGroups {
+		t.Errorf("expected the error as is when nothing was created, got %v", err)
+	}
+
+	var removed []string
+	for _, resource := range []string{"pool", "port group", "folder"} {
+		rollback.Add(resource, func(context.Context) error {
+			removed = append(removed, resource)
+			if resource == "port group" {
+				return fmt.Errorf("in use")
+			}
+			return nil
+		})
+	}
+	if err := rollback.Fail(ctx, nil); err != nil || len(removed) != 0 {
+		t.Fatalf("a clone that succeeded should keep what it created, got %v, %v", err, removed)
+	}
+
+	err := rollback.Fail(ctx, providers.ErrNoPortGroups)
+	if fmt.Sprint(removed) != "[folder port group pool]" {
+		t.Errorf("expected resources removed newest first, got %v", removed)
+	}
+	// the clone's error comes first, what could not be removed is kept apart
+	if !errors.Is(err, providers.ErrNoPortGroups) || err.Error() != providers.ErrNoPortGroups.Error() {
+		t.Errorf("expected the clone's error, got %v", err)
+	}
+	var cloneErr *providers.CloneError
+	if !errors.As(err, &cloneErr) {
+		t.Fatalf("expected a CloneError, got %T", err)
+	}
+	if cleanup := cloneErr.CleanupErrors(); len(cleanup) != 1 || cleanup[0] != "Failed to remove port group: in use" {
+		t.Errorf("unexpected cleanup errors %v", cleanup)
+	}
+}
+
+func TestRollbackOutlivesContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rollback := &providers.Rollback{}
+	var undoErr error
+	rollback.Add("pool", func(ctx context.Context) error {
+		undoErr = ctx.Err()
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("expected the teardown to be bounded")
+		}
+		return undoErr
+	})
+	err := rollback.Fail(ctx, ctx.Err())
+	var cloneErr *providers.CloneError
+	if !errors.As(err, &cloneErr) || len(cloneErr.Cleanup) != 0 {
+		t.Fatalf("expected a clean rollback of a cancelled clone, got %v, %v", err, undoErr)
+	}
+}
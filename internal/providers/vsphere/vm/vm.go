@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/vmware/govmomi/guest"
@@ -13,6 +12,7 @@ import (
 	"github.com/vmware/govmomi/property"
 	"github.com/vmware/govmomi/vim25"
 	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/progress"
 	"github.com/vmware/govmomi/vim25/types"
 )
 
@@ -58,13 +58,14 @@ func (vm *VM) PowerOff() error {
     return nil
 }
 
-func (vm *VM) SetSnapshot(name string) error {
+// SetSnapshot takes the snapshot name, telling sinks how far along the task is
+func (vm *VM) SetSnapshot(name string, sinks ...progress.Sinker) error {
     vmObj := object.NewVirtualMachine(vm.Client, vm.Ref.Reference())
     task, err := vmObj.CreateSnapshot(*vm.Ctx, name, "", false, false)
     if err != nil {
         return err
     }
-    err = task.Wait(*vm.Ctx)
+    _, err = task.WaitForResult(*vm.Ctx, sinks...)
     if err != nil {
         return err
     }
@@ -97,15 +98,15 @@ func (vm *VM) RevertSnapshot(name string) error {
     return nil
 }
 
-func (vm *VM) CloneVM(wg *sync.WaitGroup, spec *types.VirtualMachineCloneSpec, folder *object.Folder) error {
-    defer wg.Done()
+// CloneVM clones the VM into folder, telling sinks how far along the clone task is
+func (vm *VM) CloneVM(spec *types.VirtualMachineCloneSpec, folder *object.Folder, sinks ...progress.Sinker) error {
     vmObj := object.NewVirtualMachine(vm.Client, vm.Ref.Reference())
     task, err := vmObj.Clone(*vm.Ctx, folder, vm.Name, *spec)
     if err != nil {
         fmt.Println(err)
         return err
     }
-    err = task.Wait(*vm.Ctx)
+    _, err = task.WaitForResult(*vm.Ctx, sinks...)
     if err != nil {
         fmt.Println(err)
         return err
@@ -173,12 +174,18 @@ func (vm *VM) GetGuestOS() string {
     return vm.Ref.(*mo.VirtualMachine).Config.GuestFullName
 }
 
-func (vm *VM) RunProgramOnVM(program types.GuestProgramSpec, auth types.NamePasswordAuthentication) error {
+// RunProgramOnVM starts program once VMware Tools runs in the guest. notify, which may be nil,
+// is told what the VM is being waited on for and about every retry.
+func (vm *VM) RunProgramOnVM(program types.GuestProgramSpec, auth types.NamePasswordAuthentication, notify func(message string)) error {
+    if notify == nil {
+        notify = func(string) {}
+    }
     pc := property.DefaultCollector(vm.Client)
 
     timeout := time.After(2 * time.Minute)
     ticker := time.Tick(2 * time.Second)
     retries := 0
+    waiting := false
     for {
         select {
         case <-timeout:
@@ -189,25 +196,31 @@ func (vm *VM) RunProgramOnVM(program types.GuestProgramSpec, auth types.NamePass
             if err != nil {
                 return err
             }
-            if vmMo.Guest != nil && vmMo.Guest.ToolsRunningStatus == "guestToolsRunning" {
-                gom := guest.NewOperationsManager(vm.Client, vm.Ref.Reference())
-
-                procMan, err := gom.ProcessManager(*vm.Ctx)
-                if err != nil {
-                    return err
+            if vmMo.Guest == nil || vmMo.Guest.ToolsRunningStatus != "guestToolsRunning" {
+                if !waiting {
+                    waiting = true
+                    notify("Waiting for VMware Tools to start")
                 }
+                continue
+            }
+            gom := guest.NewOperationsManager(vm.Client, vm.Ref.Reference())
 
-                _, err = procMan.StartProgram(*vm.Ctx, &auth, &program)
-                if err != nil {
-                    if retries < 2 && strings.Contains(err.Error(), "Failed to authenticate") {
-                        retries++
-                        time.Sleep(time.Second * 20)
-                        continue
-                    }
-                    return err
+            procMan, err := gom.ProcessManager(*vm.Ctx)
+            if err != nil {
+                return err
+            }
+
+            _, err = procMan.StartProgram(*vm.Ctx, &auth, &program)
+            if err != nil {
+                if retries < 2 && strings.Contains(err.Error(), "Failed to authenticate") {
+                    retries++
+                    notify(fmt.Sprintf("Guest login failed, retrying (%d/2)", retries))
+                    time.Sleep(time.Second * 20)
+                    continue
                 }
-                return nil
+                return err
             }
+            return nil
         }
     }
 }
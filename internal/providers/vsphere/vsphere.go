@@ -11,9 +11,9 @@ import (
 	"sync"
 	"time"
 
+	"goclone/internal/providers"
 	"goclone/internal/providers/vsphere/vm"
 
-	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
@@ -23,15 +23,14 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// runRouterProgram runs the program that configures a pod router, vcsim cannot run programs in guests
+var runRouterProgram = (*vm.VM).RunProgramOnVM
+
 type RWPortGroupMap struct {
 	Mu   sync.Mutex
 	Data map[int]string
-}
-
-type Pod struct {
-	Name          string
-	ResourceGroup string
-	ServerGUID    string
+	// Cloning holds the port groups of clones still running
+	Cloning map[int]bool
 }
 
 type Template struct {
@@ -46,18 +45,12 @@ type Template struct {
 	WanPG          *object.DistributedVirtualPortgroup
 }
 
-var (
-	availablePortGroups = &RWPortGroupMap{
-		Data: make(map[int]string),
-	}
-)
-
 
-func refreshSession() {
+func (v *VSphereClient) refreshSession() {
 	for {
 		time.Sleep(time.Second * 30)
 
-		err := vSphereLoadTakenPortGroups()
+		err := v.vSphereLoadTakenPortGroups()
 		if err != nil {
 			log.Println(errors.Wrap(err, "Error finding taken port groups"))
 		} else {
@@ -66,82 +59,68 @@ func refreshSession() {
 	}
 }
 
-func vSphereLoadTakenPortGroups() error {
-	podNetworks, err := finder.NetworkList(vSphereClient.ctx, "*_"+vCenterConfig.PortGroupSuffix)
-	if err != nil {
-		return errors.Wrap(err, "Failed to list networks")
-	}
-
-	// Collect found DistributedVirtualPortgroup refs
-	var refs []types.ManagedObjectReference
-	for _, pgRef := range podNetworks {
-		refs = append(refs, pgRef.Reference())
-	}
-
-	pc := property.DefaultCollector(vSphereClient.client)
-
-	// Collect property from references list
-	var pgs []mo.DistributedVirtualPortgroup
-	err = pc.Retrieve(vSphereClient.ctx, refs, []string{"name"}, &pgs)
+func (v *VSphereClient) vSphereLoadTakenPortGroups() error {
+	pgs, err := v.podNetworks()
 	if err != nil {
-		errors.Wrap(err, "Failed to get references for Virtual Port Groups")
+		return err
 	}
 
-	availablePortGroups.Mu.Lock()
+	v.availablePortGroups.Mu.Lock()
 	for _, pg := range pgs {
 		r, _ := regexp.Compile("^\\d+")
 		match := r.FindString(pg.Name)
 		pgNumber, _ := strconv.Atoi(match)
-		if (pgNumber >= vCenterConfig.StartingPortGroup && pgNumber < vCenterConfig.EndingPortGroup) || (pgNumber >= vCenterConfig.CompetitionStartPortGroup && pgNumber < vCenterConfig.CompetitionEndPortGroup) {
-			availablePortGroups.Data[pgNumber] = pg.Name
+		if v.inPortGroupRange(pgNumber) {
+			v.availablePortGroups.Data[pgNumber] = pg.Name
 		}
 	}
-	availablePortGroups.Mu.Unlock()
-	log.Printf("Found %d port groups", len(availablePortGroups.Data))
+	v.availablePortGroups.Mu.Unlock()
+	log.Printf("Found %d port groups", len(v.availablePortGroups.Data))
 	return nil
 }
 
 func (v *VSphereClient) vSpherePodLimit(username string) error {
-	existingPods, err := vSphereGetPods(username)
+	existingPods, err := v.vSphereGetPods(username)
 
 	if err != nil {
 		return err
 	}
 
 	if len(existingPods) >= v.conf.MaxPodLimit {
-		return errors.New("Max pod limit reached")
+		return providers.ErrPodLimit
 	}
 	return nil
 }
 
 func (v *VSphereClient) vSphereGetPresetTemplates(isAdmin bool) ([]string, error) {
 	var templates []string
-	templateResourcePool, err := finder.ResourcePool(vSphereClient.ctx, vCenterConfig.PresetTemplateResourcePool)
+	templateResourcePool, err := v.finder.ResourcePool(v.ctx, v.vCenterConfig.PresetTemplateResourcePool)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to find preset template resource pool")
 	}
 
 	var trp mo.ResourcePool
-	err = templateResourcePool.Properties(vSphereClient.ctx, templateResourcePool.Reference(), []string{"resourcePool"}, &trp)
+	err = templateResourcePool.Properties(v.ctx, templateResourcePool.Reference(), []string{"resourcePool"}, &trp)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to find preset templates")
 	}
 
-	pc := property.DefaultCollector(vSphereClient.client)
+	pc := property.DefaultCollector(v.client)
 	var rps []mo.ResourcePool
-	err = pc.Retrieve(vSphereClient.ctx, trp.ResourcePool, []string{"name", "customValue"}, &rps)
+	err = pc.Retrieve(v.ctx, trp.ResourcePool, []string{"name", "customValue"}, &rps)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to collect references for preset templates")
 	}
 
 	for _, rp := range rps {
-		rpObj := object.NewResourcePool(vSphereClient.client, rp.Reference())
-		rpName, err := rpObj.ObjectName(vSphereClient.ctx)
+		rpObj := object.NewResourcePool(v.client, rp.Reference())
+		rpName, err := rpObj.ObjectName(v.ctx)
 		if err != nil {
 			return nil, errors.Wrap(err, "Failed to get resource pool name")
 		}
 
-		adminOnly := templateMap[rpName].AdminOnly
+		template, _ := v.template(rpName)
+		adminOnly := template.AdminOnly
 
 		if !isAdmin && adminOnly {
 			continue
@@ -153,25 +132,25 @@ func (v *VSphereClient) vSphereGetPresetTemplates(isAdmin bool) ([]string, error
 	return templates, nil
 }
 
-func vSphereGetCustomTemplates() ([]gin.H, error) {
-	var templates []gin.H
+func (v *VSphereClient) vSphereGetCustomTemplates() ([]providers.CustomTemplate, error) {
+	var templates []providers.CustomTemplate
 
-	templateFolder, err := finder.Folder(vSphereClient.ctx, vCenterConfig.TemplateFolder)
+	templateFolder, err := v.finder.Folder(v.ctx, v.vCenterConfig.TemplateFolder)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to find templates folder")
 	}
 
-	folderChildren, err := templateFolder.Children(vSphereClient.ctx)
+	folderChildren, err := templateFolder.Children(v.ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to find template sub-folders")
 	}
 
-	pc := property.DefaultCollector(vSphereClient.client)
+	pc := property.DefaultCollector(v.client)
 	for _, subfolderRef := range folderChildren {
 		var subfolder []mo.Folder
 		switch subfolderRef.(type) {
 		case *object.Folder:
-			err := pc.Retrieve(vSphereClient.ctx, []types.ManagedObjectReference{subfolderRef.Reference()}, []string{"name", "childEntity"}, &subfolder)
+			err := pc.Retrieve(v.ctx, []types.ManagedObjectReference{subfolderRef.Reference()}, []string{"name", "childEntity"}, &subfolder)
 			if err != nil {
 				return nil, errors.Wrap(err, "Failed to retrieve templates from sub-folders")
 			}
@@ -182,7 +161,7 @@ func vSphereGetCustomTemplates() ([]gin.H, error) {
 
 			var vms []mo.VirtualMachine
 			for _, vmRef := range subfolder[0].ChildEntity {
-				err := pc.Retrieve(vSphereClient.ctx, []types.ManagedObjectReference{vmRef.Reference()}, []string{"name"}, &vms)
+				err := pc.Retrieve(v.ctx, []types.ManagedObjectReference{vmRef.Reference()}, []string{"name"}, &vms)
 				if err != nil {
 					return nil, errors.Wrap(err, "Failed to retrieve VM template")
 				}
@@ -192,8 +171,7 @@ func vSphereGetCustomTemplates() ([]gin.H, error) {
 			for _, vm := range vms {
 				vmNames = append(vmNames, vm.Name)
 			}
-			subfolderData := gin.H{"name": subfolder[0].Name, "vms": vmNames}
-			templates = append(templates, subfolderData)
+			templates = append(templates, providers.CustomTemplate{Name: subfolder[0].Name, VMs: vmNames})
 		default:
 			continue
 		}
@@ -201,10 +179,10 @@ func vSphereGetCustomTemplates() ([]gin.H, error) {
 	return templates, nil
 }
 
-func vSphereGetPods(owner string) ([]Pod, error) {
-	var pods []Pod
+func (v *VSphereClient) vSphereGetPods(owner string) ([]providers.Pod, error) {
+	var pods []providers.Pod
 
-	ownerPods, err := finder.ResourcePoolList(vSphereClient.ctx, fmt.Sprintf("*_%s", owner)) // hard coded based on our naming scheme
+	ownerPods, err := v.finder.ResourcePoolList(v.ctx, fmt.Sprintf("*_%s", owner)) // hard coded based on our naming scheme
 	if err != nil {
 		if _, ok := err.(*find.NotFoundError); ok {
 			return pods, nil
@@ -218,52 +196,76 @@ func vSphereGetPods(owner string) ([]Pod, error) {
 		refs = append(refs, podRef.Reference())
 	}
 
-	pc := property.DefaultCollector(vSphereClient.client)
+	pc := property.DefaultCollector(v.client)
 
 	var rps []mo.ResourcePool
-	err = pc.Retrieve(vSphereClient.ctx, refs, []string{"name", "config"}, &rps)
+	err = pc.Retrieve(v.ctx, refs, []string{"name", "config", "customValue"}, &rps)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to collect references for your pods")
 	}
 
+	// the name only narrows the search, other users' names can end in _<owner> too
 	for _, rp := range rps {
-		pods = append(pods, Pod{Name: rp.Name, ResourceGroup: rp.Config.Entity.Value, ServerGUID: vSphereClient.client.ServiceContent.About.InstanceUuid})
+		pod := providers.Pod{Name: rp.Name, ResourceGroup: rp.Reference().Value, ServerGUID: v.client.ServiceContent.About.InstanceUuid, Owner: v.podOwner(rp)}
+		if pod.OwnedBy(owner) {
+			pods = append(pods, pod)
+		}
 	}
 
 	return pods, nil
 }
 
-func (v *VSphereClient) vSphereTemplateClone(templateId string, username string) error {
-	err := v.vSpherePodLimit(username)
+// vSphereGetAllPods lists the pod resource pools of every user
+func (v *VSphereClient) vSphereGetAllPods() ([]providers.Pod, error) {
+	rps, err := v.podResourcePools()
 	if err != nil {
-		return err
+		return nil, errors.Wrap(err, "Failed to get pod list")
 	}
 
-	startPG := vCenterConfig.StartingPortGroup
-	endPG := vCenterConfig.EndingPortGroup
-
-	if templateMap[templateId].CompetitionPod {
-		startPG = vCenterConfig.CompetitionStartPortGroup
-		endPG = vCenterConfig.CompetitionEndPortGroup
+	pods := []providers.Pod{}
+	for _, rp := range rps {
+		pg, ok := providers.PodPortGroup(rp.Name)
+		if !ok || !v.inPortGroupRange(pg) {
+			continue
+		}
+		pods = append(pods, providers.Pod{Name: rp.Name, ResourceGroup: rp.Reference().Value, ServerGUID: v.client.ServiceContent.About.InstanceUuid, Owner: v.podOwner(rp)})
 	}
+	return pods, nil
+}
 
-	var nextAvailablePortGroup int
-	availablePortGroups.Mu.Lock()
-	for i := startPG; i < endPG; i++ {
-		if _, exists := availablePortGroups.Data[i]; !exists {
-			nextAvailablePortGroup = i
-			availablePortGroups.Data[i] = fmt.Sprintf("%v_%s", nextAvailablePortGroup, vCenterConfig.PortGroupSuffix)
-			break
-		}
+func (v *VSphereClient) vSphereTemplateClone(ctx context.Context, templateId string, username string) error {
+	template, ok := v.template(templateId)
+	if !ok {
+		return providers.NotFound("Template", templateId)
 	}
-	availablePortGroups.Mu.Unlock()
 
-	err = v.TemplateClone(templateId, username, nextAvailablePortGroup)
+	err := v.vSpherePodLimit(username)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	startPG := v.vCenterConfig.StartingPortGroup
+	endPG := v.vCenterConfig.EndingPortGroup
+
+	if template.CompetitionPod {
+		startPG = v.vCenterConfig.CompetitionStartPortGroup
+		endPG = v.vCenterConfig.CompetitionEndPortGroup
+	}
+
+	nextAvailablePortGroup := v.reservePortGroup(startPG, endPG)
+	if nextAvailablePortGroup == 0 {
+		providers.StartStep(ctx, providers.StepPortGroup)(providers.ErrNoPortGroups)
+		return providers.ErrNoPortGroups
+	}
+	defer v.cloneDone(nextAvailablePortGroup)
+
+	rollback := &providers.Rollback{}
+	rollback.Add(fmt.Sprintf("port group %d reservation", nextAvailablePortGroup), func(context.Context) error {
+		v.releasePortGroup(nextAvailablePortGroup)
+		return nil
+	})
+	err = v.TemplateClone(ctx, rollback, templateId, username, nextAvailablePortGroup)
+	return rollback.Fail(ctx, err)
 }
 
 func (v *VSphereClient) vSphereCustomClone(ctx context.Context, podName string, vmsToClone []string, nat bool, username string) error {
@@ -272,97 +274,81 @@ func (v *VSphereClient) vSphereCustomClone(ctx context.Context, podName string,
 		return err
 	}
 
-	var nextAvailablePortGroup int
-	availablePortGroups.Mu.Lock()
-	for i := vCenterConfig.StartingPortGroup; i < vCenterConfig.EndingPortGroup; i++ {
-		if _, exists := availablePortGroups.Data[i]; !exists {
-			nextAvailablePortGroup = i
-			availablePortGroups.Data[i] = fmt.Sprintf("%v_%s", nextAvailablePortGroup, vCenterConfig.PortGroupSuffix)
-			break
-		}
+	nextAvailablePortGroup := v.reservePortGroup(v.vCenterConfig.StartingPortGroup, v.vCenterConfig.EndingPortGroup)
+	if nextAvailablePortGroup == 0 {
+		providers.StartStep(ctx, providers.StepPortGroup)(providers.ErrNoPortGroups)
+		return providers.ErrNoPortGroups
 	}
-	availablePortGroups.Mu.Unlock()
+	defer v.cloneDone(nextAvailablePortGroup)
+
+	rollback := &providers.Rollback{}
+	rollback.Add(fmt.Sprintf("port group %d reservation", nextAvailablePortGroup), func(context.Context) error {
+		v.releasePortGroup(nextAvailablePortGroup)
+		return nil
+	})
+	err = v.CustomClone(ctx, rollback, podName, vmsToClone, nat, username, nextAvailablePortGroup)
+	return rollback.Fail(ctx, err)
+}
 
-	err = v.CustomClone(ctx, podName, vmsToClone, nat, username, nextAvailablePortGroup)
+// TemplateClone builds the pod of template sourceRP on portGroup, recording what it creates on rollback
+func (v *VSphereClient) TemplateClone(ctx context.Context, rollback *providers.Rollback, sourceRP, username string, portGroup int) error {
+	template, _ := v.template(sourceRP)
+	targetRP, pg, newFolder, err := v.InitializeClone(ctx, rollback, sourceRP, username, portGroup)
 	if err != nil {
+		log.Println(errors.Wrap(err, "Error initializing clone"))
 		return err
 	}
 
-	return nil
-}
-
-func (v *VSphereClient) TemplateClone(sourceRP, username string, portGroup int) error {
-	targetRP, pg, newFolder, err := InitializeClone(sourceRP, username, portGroup)
-
+	finish := providers.StartStep(ctx, providers.StepCloneVMs)
 	pgStr := strconv.Itoa(portGroup)
-	CloneVMs(templateMap[sourceRP].VMs, newFolder, targetRP.Reference(), datastore.Reference(), pg.Reference(), pgStr)
-
-	vmClones, err := newFolder.Children(vSphereClient.ctx)
+	err = v.CloneVMs(ctx, template.VMs, newFolder, targetRP.Reference(), v.datastore.Reference(), pg.Reference(), pgStr)
 	if err != nil {
-		log.Println(errors.Wrap(err, "Error getting children"))
+		finish(err)
+		log.Println(err)
 		return err
 	}
 
-	var vms []vm.VM
-	var router vm.VM
-	for _, v := range vmClones {
-		vmObj := object.NewVirtualMachine(vSphereClient.client, v.Reference())
-		vmName, err := vmObj.ObjectName(vSphereClient.ctx)
-		if err != nil {
-			log.Println(errors.Wrap(err, "Error getting VM name"))
-			return err
-		}
-
-		isRouter := strings.Contains(vmName, "PodRouter")
-		newVM := vm.VM{
-			Name:     vmName,
-			Ref:      v.Reference(),
-			Ctx:      &vSphereClient.ctx,
-			Client:   vSphereClient.client,
-			IsRouter: isRouter,
-		}
-
-		if isRouter {
-			router = newVM
-		}
-		vms = append(vms, newVM)
+	vms, router, err := v.podVMs(newFolder)
+	finish(err)
+	if err != nil {
+		return err
 	}
 
 	var routerPG *object.DistributedVirtualPortgroup
-	if templateMap[sourceRP].CompetitionPod {
-		routerPG = competitionPG
+	if template.CompetitionPod {
+		routerPG = v.competitionPG
 	} else {
-		routerPG = templateMap[sourceRP].WanPG
+		routerPG = template.WanPG
 	}
 
-	if !templateMap[sourceRP].NoRouter {
+	finish = providers.StartStep(ctx, providers.StepRouter)
+	if !template.NoRouter {
         fmt.Println("Powering on router")
         fmt.Println(router.String())
-        vmObj := object.NewVirtualMachine(vSphereClient.client, router.Ref.Reference())
-        task, err := vmObj.PowerOn(vSphereClient.ctx)
-        if err != nil {
-            return err
-        }
-        err = task.Wait(vSphereClient.ctx)
+		err := v.powerOnRouter(ctx, router)
 		if err != nil {
+			finish(err)
 			log.Println(errors.Wrap(err, "Error powering on router"))
 			return err
 		}
 
-		err = router.ConfigureRouterNetworks(routerPG, pg.(*object.DistributedVirtualPortgroup), dvsMo)
+		providers.Report(ctx, providers.Update{Step: providers.StepRouter, VM: router.Name, Message: "Configuring router networks"})
+		err = router.ConfigureRouterNetworks(routerPG, pg.(*object.DistributedVirtualPortgroup), v.dvsMo)
 		if err != nil {
+			finish(err)
 			log.Println(errors.Wrap(err, "Error configuring router networks"))
 			return err
 		}
 
-		if templateMap[sourceRP].Natted {
-			pgOctet, err := GetNatOctet(strconv.Itoa(portGroup))
+		if template.Natted {
+			pgOctet, err := v.GetNatOctet(strconv.Itoa(portGroup))
 			if err != nil {
+				finish(err)
 				return err
 			}
 
 			var networkID string
-			if templateMap[sourceRP].CompetitionPod {
+			if template.CompetitionPod {
 				octets := strings.Split(v.conf.CompetitionNetworkID, ".")
 				networkID = fmt.Sprintf("%s.%s", octets[0], octets[1])
 			} else {
@@ -371,71 +357,95 @@ func (v *VSphereClient) TemplateClone(sourceRP, username string, portGroup int)
 			}
 
 			program := types.GuestProgramSpec{
-				ProgramPath: vCenterConfig.RouterProgram,
-				Arguments:   fmt.Sprintf(vCenterConfig.RouterProgramArgs, pgOctet, networkID),
+				ProgramPath: v.vCenterConfig.RouterProgram,
+				Arguments:   fmt.Sprintf(v.vCenterConfig.RouterProgramArgs, pgOctet, networkID),
 			}
 
 			auth := types.NamePasswordAuthentication{
-				Username: vCenterConfig.RouterUsername,
-				Password: vCenterConfig.RouterPassword,
+				Username: v.vCenterConfig.RouterUsername,
+				Password: v.vCenterConfig.RouterPassword,
 			}
-			err = router.RunProgramOnVM(program, auth)
+			err = runRouterProgram(&router, program, auth, notifier(ctx, providers.StepRouter, router.Name))
 			if err != nil {
+				finish(err)
 				log.Println(errors.Wrap(err, "Error running program on router"))
 				return err
 			}
 		}
 	}
+	finish(nil)
 
-	wg := errgroup.Group{}
-	for _, vm := range vms {
-		wg.Go(func() error {
-			return vm.SetSnapshot("Base")
-		})
-	}
-
-	if err := wg.Wait(); err != nil {
-		return errors.Wrap(err, "Error setting snapshot")
+	err = v.snapshotAll(ctx, vms)
+	if err != nil {
+		return err
 	}
 
-	permission := types.Permission{
-		Principal: strings.Join([]string{v.conf.Domain, username}, "\\"),
-		RoleId:    cloneRole.RoleId,
-		Propagate: true,
-	}
-	AssignPermissionToObjects(&permission, []types.ManagedObjectReference{newFolder.Reference()})
+	return providers.RunStep(ctx, providers.StepPermissions, func() error {
+		permission := types.Permission{
+			Principal: strings.Join([]string{v.conf.Domain, username}, "\\"),
+			RoleId:    v.cloneRole.RoleId,
+			Propagate: true,
+		}
+		err := v.AssignPermissionToObjects(&permission, []types.ManagedObjectReference{newFolder.Reference()})
+		if err != nil {
+			return err
+		}
 
-	hiddenVMs := []vm.VM{}
-	for _, vm := range templateMap[sourceRP].VMs {
-		if vm.IsHidden {
-			hiddenVMs = append(hiddenVMs, vm)
+		hiddenVMs := []vm.VM{}
+		for _, vm := range template.VMs {
+			if vm.IsHidden {
+				hiddenVMs = append(hiddenVMs, vm)
+			}
 		}
-	}
 
-	v.HideVMs(hiddenVMs, username)
+		v.HideVMs(hiddenVMs, username)
+		return nil
+	})
+}
+
+// snapshotAll takes the Base snapshot every pod VM is reverted to
+func (v *VSphereClient) snapshotAll(ctx context.Context, vms []vm.VM) error {
+	return providers.RunStep(ctx, providers.StepSnapshots, func() error {
+		wg := errgroup.Group{}
+		for _, vm := range vms {
+			wg.Go(func() error {
+				reporter := taskProgress(ctx, providers.StepSnapshots, vm.Name)
+				err := vm.SetSnapshot("Base", reporter)
+				reporter.done("Snapshot Base taken", err)
+				return err
+			})
+		}
 
-	return nil
+		if err := wg.Wait(); err != nil {
+			return errors.Wrap(err, "Error setting snapshot")
+		}
+		return nil
+	})
 }
 
-func (v *VSphereClient) CustomClone(ctx context.Context, podName string, vmsToClone []string, natted bool, username string, portGroup int) error {
-    ctx, span := tracer.Start(ctx, "CustomClone")
+// CustomClone builds a pod of vmsToClone on portGroup, recording what it creates on rollback
+func (v *VSphereClient) CustomClone(ctx context.Context, rollback *providers.Rollback, podName string, vmsToClone []string, natted bool, username string, portGroup int) error {
+    ctx, span := v.tracer.Start(ctx, "CustomClone")
     defer span.End()
 
-	targetRP, pg, newFolder, err := InitializeClone(podName, username, portGroup)
+	targetRP, pg, newFolder, err := v.InitializeClone(ctx, rollback, podName, username, portGroup)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error initializing clone"))
 		return err
 	}
 
+	finish := providers.StartStep(ctx, providers.StepCloneVMs)
 	var vms []vm.VM
-	for _, v := range vmsToClone {
-		vmObj, err := finder.VirtualMachine(vSphereClient.ctx, v)
+	for _, name := range vmsToClone {
+		vmObj, err := v.finder.VirtualMachine(v.ctx, name)
 		if err != nil {
+			finish(err)
 			log.Println(errors.Wrap(err, "Error finding VM"))
 			return err
 		}
-		vmName, err := vmObj.ObjectName(vSphereClient.ctx)
+		vmName, err := vmObj.ObjectName(v.ctx)
 		if err != nil {
+			finish(err)
 			log.Println(errors.Wrap(err, "Error getting VM name"))
 			return err
 		}
@@ -443,44 +453,59 @@ func (v *VSphereClient) CustomClone(ctx context.Context, podName string, vmsToCl
 		newVM := vm.VM{
 			Name:     vmName,
 			Ref:      vmObj.Reference(),
-			Ctx:      &vSphereClient.ctx,
-			Client:   vSphereClient.client,
+			Ctx:      &v.ctx,
+			Client:   v.client,
 			IsRouter: strings.Contains(vmName, "PodRouter"),
 		}
 		vms = append(vms, newVM)
 	}
 
 	pgStr := strconv.Itoa(portGroup)
-	CloneVMsFromTemplates(ctx, vms, newFolder, targetRP.Reference(), datastore.Reference(), pg.Reference(), pgStr)
+	err = v.CloneVMsFromTemplates(ctx, vms, newFolder, targetRP.Reference(), v.datastore.Reference(), pg.Reference(), pgStr)
+	if err != nil {
+		finish(err)
+		log.Println(err)
+		return err
+	}
 
-	hasRouter := false
-	for _, vm := range vms {
-		if vm.IsRouter {
-			hasRouter = true
-			break
-		}
+	// the VMs from here on are the clones, the router among them when one of the templates was
+	vms, router, err := v.podVMs(newFolder)
+	finish(err)
+	if err != nil {
+		return err
 	}
 
-	router := vm.VM{}
-	if !hasRouter && natted {
-		router, err := CreateRouter(ctx, targetRP.Reference(), datastore.Reference(), newFolder, natted, podName)
+	if natted {
+		finish = providers.StartStep(ctx, providers.StepRouter)
+	}
+	if router.Name == "" && natted {
+		routerMo, err := v.CreateRouter(ctx, targetRP.Reference(), v.datastore.Reference(), newFolder, natted, podName)
 		if err != nil {
+			finish(err)
 			log.Println(errors.Wrap(err, "Error creating router"))
 			return err
 		}
-		newVM := vm.VM{
-			Name:     router.Name,
-			Ref:      router.Reference(),
-			Ctx:      &vSphereClient.ctx,
-			Client:   vSphereClient.client,
+		router = vm.VM{
+			Name:     routerMo.Name,
+			Ref:      routerMo.Reference(),
+			Ctx:      &v.ctx,
+			Client:   v.client,
 			IsRouter: true,
 		}
-		vms = append(vms, newVM)
+		vms = append(vms, router)
 	}
 
 	if natted {
-		pgOctet, err := GetNatOctet(strconv.Itoa(portGroup))
+		err = v.powerOnRouter(ctx, router)
+		if err != nil {
+			finish(err)
+			log.Println(errors.Wrap(err, "Error powering on router"))
+			return err
+		}
+
+		pgOctet, err := v.GetNatOctet(strconv.Itoa(portGroup))
 		if err != nil {
+			finish(err)
 			return err
 		}
 
@@ -488,149 +513,263 @@ func (v *VSphereClient) CustomClone(ctx context.Context, podName string, vmsToCl
 		networkID := fmt.Sprintf("%s.%s", octets[0], octets[1])
 
 		program := types.GuestProgramSpec{
-			ProgramPath: vCenterConfig.RouterProgram,
-			Arguments:   fmt.Sprintf(vCenterConfig.RouterProgramArgs, pgOctet, networkID),
+			ProgramPath: v.vCenterConfig.RouterProgram,
+			Arguments:   fmt.Sprintf(v.vCenterConfig.RouterProgramArgs, pgOctet, networkID),
 		}
 
 		auth := types.NamePasswordAuthentication{
-			Username: vCenterConfig.RouterUsername,
-			Password: vCenterConfig.RouterPassword,
+			Username: v.vCenterConfig.RouterUsername,
+			Password: v.vCenterConfig.RouterPassword,
 		}
 
-		err = router.RunProgramOnVM(program, auth)
+		err = runRouterProgram(&router, program, auth, notifier(ctx, providers.StepRouter, router.Name))
+		finish(err)
 		if err != nil {
 			log.Println(errors.Wrap(err, "Error running program on router"))
 			return err
 		}
 	}
 
-	wg := errgroup.Group{}
-	for _, vm := range vms {
-		wg.Go(func() error {
-			return vm.SetSnapshot("Base")
-		})
+	err = v.snapshotAll(ctx, vms)
+	if err != nil {
+		return err
 	}
 
-	if err := wg.Wait(); err != nil {
-		return errors.Wrap(err, "Error setting snapshot")
+	return providers.RunStep(ctx, providers.StepPermissions, func() error {
+		permission := types.Permission{
+			Principal: strings.Join([]string{v.conf.Domain, username}, "\\"),
+			RoleId:    v.customCloneRole.RoleId,
+			Propagate: true,
+		}
+		return v.AssignPermissionToObjects(&permission, []types.ManagedObjectReference{newFolder.Reference()})
+	})
+}
+
+// podVMs lists the VMs cloned into a pod's folder and the pod router among them, if any
+func (v *VSphereClient) podVMs(folder *object.Folder) ([]vm.VM, vm.VM, error) {
+	var vms []vm.VM
+	var router vm.VM
+
+	children, err := folder.Children(v.ctx)
+	if err != nil {
+		log.Println(errors.Wrap(err, "Error getting children"))
+		return nil, router, err
 	}
 
-	permission := types.Permission{
-		Principal: strings.Join([]string{v.conf.Domain, username}, "\\"),
-		RoleId:    customCloneRole.RoleId,
-		Propagate: true,
+	for _, child := range children {
+		vmObj := object.NewVirtualMachine(v.client, child.Reference())
+		vmName, err := vmObj.ObjectName(v.ctx)
+		if err != nil {
+			log.Println(errors.Wrap(err, "Error getting VM name"))
+			return nil, router, err
+		}
+
+		isRouter := strings.Contains(vmName, "PodRouter")
+		newVM := vm.VM{
+			Name:     vmName,
+			Ref:      child.Reference(),
+			Ctx:      &v.ctx,
+			Client:   v.client,
+			IsRouter: isRouter,
+		}
+
+		if isRouter {
+			router = newVM
+		}
+		vms = append(vms, newVM)
 	}
-	AssignPermissionToObjects(&permission, []types.ManagedObjectReference{newFolder.Reference()})
+	return vms, router, nil
+}
 
-	return nil
+// powerOnRouter powers on a pod router, which has to run before programs can be run on it
+func (v *VSphereClient) powerOnRouter(ctx context.Context, router vm.VM) error {
+	providers.Report(ctx, providers.Update{Step: providers.StepRouter, VM: router.Name, Message: "Powering on router"})
+	vmObj := object.NewVirtualMachine(v.client, router.Ref.Reference())
+	task, err := vmObj.PowerOn(v.ctx)
+	if err != nil {
+		return err
+	}
+	reporter := taskProgress(ctx, providers.StepRouter, router.Name)
+	_, err = task.WaitForResult(v.ctx, reporter)
+	reporter.done("Router powered on", err)
+	return err
 }
 
-func InitializeClone(podName, username string, portGroup int) (*types.ManagedObjectReference, object.NetworkReference, *object.Folder, error) {
+// InitializeClone creates the pod's resource pool, port group and folder, recording each on rollback
+func (v *VSphereClient) InitializeClone(ctx context.Context, rollback *providers.Rollback, podName, username string, portGroup int) (*types.ManagedObjectReference, object.NetworkReference, *object.Folder, error) {
 	strPortGroup := strconv.Itoa(int(portGroup))
-	pgName := strings.Join([]string{strPortGroup, vCenterConfig.PortGroupSuffix}, "_")
+	pgName := strings.Join([]string{strPortGroup, v.vCenterConfig.PortGroupSuffix}, "_")
 	podID := strings.Join([]string{strPortGroup, podName, username}, "_")
 
-	targetRP, err := CreateResourcePool(podID, templateMap[podName].CompetitionPod)
+	finish := providers.StartStep(ctx, providers.StepResourcePool)
+	template, _ := v.template(podName)
+	targetRP, err := v.CreateResourcePool(podID, template.CompetitionPod)
+	finish(err)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error creating resource pool"))
 		return &types.ManagedObjectReference{}, &object.Network{}, &object.Folder{}, err
 	}
+	rollback.Add("resource pool "+podID, func(ctx context.Context) error {
+		return v.DestroyResourcePool(ctx, object.NewResourcePool(v.client, targetRP))
+	})
+	if v.podOwnerKey != 0 {
+		err = v.customFieldsManager.Set(ctx, targetRP, v.podOwnerKey, username)
+		if err != nil {
+			log.Println(errors.Wrap(err, "Error recording pod owner"))
+			return &types.ManagedObjectReference{}, &object.Network{}, &object.Folder{}, err
+		}
+	}
 
-	pg, err := CreatePortGroup(pgName, portGroup)
+	finish = providers.StartStep(ctx, providers.StepPortGroup)
+	pg, err := v.CreatePortGroup(pgName, portGroup)
+	finish(err)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error creating portgroup"))
 		return &types.ManagedObjectReference{}, &object.Network{}, &object.Folder{}, err
 	}
+	rollback.Add("port group "+pgName, func(ctx context.Context) error {
+		return v.DestroyPortGroup(ctx, pg.Reference())
+	})
 
-	newFolder, err := CreateVMFolder(podID)
+	finish = providers.StartStep(ctx, providers.StepFolder)
+	newFolder, err := v.CreateVMFolder(podID)
+	finish(err)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error creating VM folder"))
 		return &types.ManagedObjectReference{}, &object.Network{}, &object.Folder{}, err
 	}
+	// destroying the folder takes the VMs cloned into it along
+	rollback.Add("folder "+podID, func(ctx context.Context) error {
+		return v.DestroyFolder(ctx, newFolder)
+	})
 	return &targetRP, pg, newFolder, nil
 }
 
-func DestroyResources(ctx context.Context, podId string) error {
-	resourcePool, err := GetResourcePool(podId)
+func (v *VSphereClient) DestroyResources(ctx context.Context, podId string) error {
+	resourcePool, err := v.GetResourcePool(podId)
 	if err != nil {
+		if _, ok := err.(*find.NotFoundError); ok {
+			return providers.NotFound("Pod", podId)
+		}
 		log.Println(errors.Wrap(err, "Error getting resource pool"))
 		return err
 	}
-	DestroyResourcePool(ctx, resourcePool)
+	// errors are logged by the Destroy functions, what is left is for an admin to clean up
+	v.DestroyResourcePool(ctx, resourcePool)
 
-	folder, err := finder.Folder(vSphereClient.ctx, podId)
+	folder, err := v.finder.Folder(v.ctx, podId)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error finding folder"))
 	} else {
-		DestroyFolder(ctx, folder)
+		v.DestroyFolder(ctx, folder)
 	}
 
-	pg, err := GetPortGroup(strings.Join([]string{strings.Split(podId, "_")[0], vCenterConfig.PortGroupSuffix}, "_"))
-	err = DestroyPortGroup(ctx, pg.Reference())
+	pg, err := v.GetPortGroup(strings.Join([]string{strings.Split(podId, "_")[0], v.vCenterConfig.PortGroupSuffix}, "_"))
+	if err == nil {
+		err = v.DestroyPortGroup(ctx, pg.Reference())
+	} else if _, ok := err.(*find.NotFoundError); ok {
+		err = nil
+	}
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error destroying portgroup"))
 		return err
 	}
 
-	availablePortGroups.Mu.Lock()
 	deleted_pg, _ := strconv.Atoi(strings.Split(podId, "_")[0])
-	delete(availablePortGroups.Data, deleted_pg)
-	availablePortGroups.Mu.Unlock()
+	v.releasePortGroup(deleted_pg)
 
 	return nil
 }
 
-func GetNatOctet(pg string) (int, error) {
+// reservePortGroup takes the first free port group in [start, end) for a clone, or returns 0 if none is free.
+// The port group counts as cloning until cloneDone is called.
+func (v *VSphereClient) reservePortGroup(start, end int) int {
+	v.availablePortGroups.Mu.Lock()
+	defer v.availablePortGroups.Mu.Unlock()
+	for i := start; i < end; i++ {
+		if _, exists := v.availablePortGroups.Data[i]; !exists {
+			v.availablePortGroups.Data[i] = v.portGroupName(i)
+			v.availablePortGroups.Cloning[i] = true
+			return i
+		}
+	}
+	return 0
+}
+
+// cloneDone marks the clone on port group pg as finished, whether it kept the port group or not
+func (v *VSphereClient) cloneDone(pg int) {
+	v.availablePortGroups.Mu.Lock()
+	delete(v.availablePortGroups.Cloning, pg)
+	v.availablePortGroups.Mu.Unlock()
+}
+
+// releasePortGroup lets the next clone take port group pg
+func (v *VSphereClient) releasePortGroup(pg int) {
+	v.availablePortGroups.Mu.Lock()
+	delete(v.availablePortGroups.Data, pg)
+	v.availablePortGroups.Mu.Unlock()
+}
+
+func (v *VSphereClient) GetNatOctet(pg string) (int, error) {
 	pgInt, err := strconv.Atoi(pg)
 	if err != nil {
 		return -1, errors.New("Port group is not a number")
 	}
 
 	var start int
-	if pgInt < vCenterConfig.CompetitionStartPortGroup {
-		if pgInt < vCenterConfig.StartingPortGroup || pgInt > vCenterConfig.EndingPortGroup || pgInt > vCenterConfig.StartingPortGroup+255 {
+	if pgInt < v.vCenterConfig.CompetitionStartPortGroup {
+		if pgInt < v.vCenterConfig.StartingPortGroup || pgInt > v.vCenterConfig.EndingPortGroup || pgInt > v.vCenterConfig.StartingPortGroup+255 {
 			return -1, errors.New("Port group out of range")
 		}
-		start = vCenterConfig.StartingPortGroup
+		start = v.vCenterConfig.StartingPortGroup
 	} else {
-		if pgInt < vCenterConfig.CompetitionStartPortGroup || pgInt > vCenterConfig.CompetitionEndPortGroup || pgInt > vCenterConfig.CompetitionStartPortGroup+255 {
+		if pgInt < v.vCenterConfig.CompetitionStartPortGroup || pgInt > v.vCenterConfig.CompetitionEndPortGroup || pgInt > v.vCenterConfig.CompetitionStartPortGroup+255 {
 			return -1, errors.New("Port group out of range")
 		}
-		start = vCenterConfig.CompetitionStartPortGroup
+		start = v.vCenterConfig.CompetitionStartPortGroup
 	}
 
 	return pgInt - start + 1, nil
 }
 
-func LoadTemplates(ctx context.Context) error {
-	rpList, err := GetChildResourcePools(vCenterConfig.PresetTemplateResourcePool)
+func (v *VSphereClient) LoadTemplates(ctx context.Context) error {
+	rpList, err := v.GetChildResourcePools(v.vCenterConfig.PresetTemplateResourcePool)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error getting child resource pools"))
 		return err
 	}
 
 	for _, rp := range rpList {
-		rpName, err := rp.ObjectName(vSphereClient.ctx)
+		rpName, err := rp.ObjectName(v.ctx)
 		if err != nil {
 			log.Println(errors.Wrap(err, "Error getting resource pool name"))
 			return err
 		}
-		template, err := LoadTemplate(ctx, rp, rpName)
+		template, err := v.LoadTemplate(ctx, rp, rpName)
 		if err != nil {
-			templateMap[rpName] = Template{}
             fmt.Println("Error loading template: ", rpName, err)
 			log.Println(errors.Wrap(err, "Error loading template"))
 		}
         fmt.Println("Loaded template: ", rpName)
         fmt.Println("Template: ", template)
-		templateMap[rpName] = template
+		v.templatesMu.Lock()
+		v.templateMap[rpName] = template
+		v.templatesMu.Unlock()
 	}
 
 	return nil
 }
 
-func LoadTemplate(ctx context.Context, rp *object.ResourcePool, name string) (Template, error) {
-	attrs, err := GetAllAttributes(rp.Reference())
+// template returns the loaded preset template called name. Clones read templates while they are refreshed.
+func (v *VSphereClient) template(name string) (Template, bool) {
+	v.templatesMu.RLock()
+	defer v.templatesMu.RUnlock()
+	template, ok := v.templateMap[name]
+	return template, ok
+}
+
+func (v *VSphereClient) LoadTemplate(ctx context.Context, rp *object.ResourcePool, name string) (Template, error) {
+	attrs, err := v.GetAllAttributes(rp.Reference())
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error getting attributes"))
 		return Template{}, err
@@ -640,7 +779,7 @@ func LoadTemplate(ctx context.Context, rp *object.ResourcePool, name string) (Te
 	noRouter := false
 	competitionPod := false
 	adminOnly := false
-	pg := wanPG
+	pg := v.wanPG
 	for key, value := range attrs {
 		switch key {
 		case "goclone.template.natted":
@@ -662,7 +801,7 @@ func LoadTemplate(ctx context.Context, rp *object.ResourcePool, name string) (Te
 		}
 	}
 
-	vms, err := GetVMsInResourcePool(rp.Reference())
+	vms, err := v.GetVMsInResourcePool(rp.Reference())
 	if err != nil {
         fmt.Println("Error getting VMs in resource pool: ", err)
 		log.Println(errors.Wrap(err, "Error getting VMs in resource pool"))
@@ -679,7 +818,7 @@ func LoadTemplate(ctx context.Context, rp *object.ResourcePool, name string) (Te
 				return false
 			}
 		}) {
-			router, err = CreateRouter(ctx, rp.Reference(), datastore.Reference(), templateFolder, natted, name)
+			router, err = v.CreateRouter(ctx, rp.Reference(), v.datastore.Reference(), v.templateFolder, natted, name)
 			vms = append(vms, *router)
 		}
 	}
@@ -690,9 +829,9 @@ func LoadTemplate(ctx context.Context, rp *object.ResourcePool, name string) (Te
 		return Template{}, err
 	}
 
-	for _, v := range vms {
-		vmObj := object.NewVirtualMachine(vSphereClient.client, v.Reference())
-		vmName, err := vmObj.ObjectName(vSphereClient.ctx)
+	for _, vmMo := range vms {
+		vmObj := object.NewVirtualMachine(v.client, vmMo.Reference())
+		vmName, err := vmObj.ObjectName(v.ctx)
 		if err != nil {
 			fmt.Println(errors.Wrap(err, "Error getting VM name"))
 			return Template{}, err
@@ -701,7 +840,7 @@ func LoadTemplate(ctx context.Context, rp *object.ResourcePool, name string) (Te
 		username := ""
 		password := ""
 		isHidden := ""
-		attrs, err := GetAllAttributes(v.Reference())
+		attrs, err := v.GetAllAttributes(vmMo.Reference())
 		for key, value := range attrs {
 			switch key {
 			case "goclone.vm.username":
@@ -714,14 +853,14 @@ func LoadTemplate(ctx context.Context, rp *object.ResourcePool, name string) (Te
 		}
 		newVM := vm.VM{
 			Name:     vmName,
-			Ref:      v.Reference(),
-			Ctx:      &vSphereClient.ctx,
-			Client:   vSphereClient.client,
+			Ref:      vmMo.Reference(),
+			Ctx:      &v.ctx,
+			Client:   v.client,
 			Username: username,
 			Password: password,
 			IsRouter: strings.Contains(vmName, "PodRouter"),
 			IsHidden: strings.Contains(strings.ToLower(isHidden), "true"),
-			GuestOS:  v.Config.GuestFullName,
+			GuestOS:  vmMo.Config.GuestFullName,
 		}
 		vmList = append(vmList, newVM)
 	}
@@ -729,8 +868,8 @@ func LoadTemplate(ctx context.Context, rp *object.ResourcePool, name string) (Te
 	wg := errgroup.Group{}
 	for _, vm := range vmList {
 		wg.Go(func() error {
-			vmObj := object.NewVirtualMachine(vSphereClient.client, vm.Ref.Reference())
-			if snap, _ := vmObj.FindSnapshot(vSphereClient.ctx, "SnapshotForCloning"); snap != nil {
+			vmObj := object.NewVirtualMachine(v.client, vm.Ref.Reference())
+			if snap, _ := vmObj.FindSnapshot(v.ctx, "SnapshotForCloning"); snap != nil {
 				err = vm.RemoveSnapshot("SnapshotForCloning")
 				if err != nil {
 					return err
@@ -767,37 +906,35 @@ func LoadTemplate(ctx context.Context, rp *object.ResourcePool, name string) (Te
 	return template, nil
 }
 
-func bulkDeletePods(ctx context.Context, filters []string) ([]string, error) {
-    ctx, span := tracer.Start(ctx, "bulkDeletePods")
+func (v *VSphereClient) bulkDeletePods(ctx context.Context, filter providers.PodFilter) ([]string, error) {
+    ctx, span := v.tracer.Start(ctx, "bulkDeletePods")
     defer span.End()
 
-    pods, err := GetAllPods()
+    pods, err := v.GetAllPods()
     if err != nil {
         return nil, errors.Wrap(err, "Failed to get all pods")
     }
+    var mu sync.Mutex
     failed := []string{}
     wg := errgroup.Group{}
     for _, pod := range pods {
-        podName, err := pod.ObjectName(vSphereClient.ctx)
+        podName, err := pod.ObjectName(v.ctx)
         if err != nil {
             failed = append(failed, podName)
             continue
         }
 
-        for _, f := range filters {
-            if f == "" {
-                continue
-            }
-            if strings.Contains(podName, f) {
-                wg.Go(func() error {
-                    err := DestroyResources(ctx, podName)
-                    if err != nil {
-                        failed = append(failed, podName)
-                        return err
-                    }
-                    return nil
-                })
-            }
+        if filter.Matches(podName) {
+            wg.Go(func() error {
+                err := v.DestroyResources(ctx, podName)
+                if err != nil {
+                    mu.Lock()
+                    failed = append(failed, podName)
+                    mu.Unlock()
+                    return err
+                }
+                return nil
+            })
         }
     }
 
@@ -808,17 +945,18 @@ func bulkDeletePods(ctx context.Context, filters []string) ([]string, error) {
     return failed, nil
 }
 
-func bulkRevertPods(filters []string, snapshot string) ([]string, error) {
-    pods, err := GetPodsMatchingFilter(filters)
+func (v *VSphereClient) bulkRevertPods(filters []string, snapshot string) ([]string, error) {
+    pods, err := v.GetPodsMatchingFilter(filters)
     if err != nil {
         return []string{}, errors.Wrap(err, "Error getting pods matching filter")
     }
 
-    vms, err := GetVMsOfPods(pods)
+    vms, err := v.GetVMsOfPods(pods)
     if err != nil {
         return []string{}, errors.Wrap(err, "Error getting VMs of pods")
     }
 
+    var mu sync.Mutex
     failed := []string{}
     wg := errgroup.Group{}
     for _, vm := range vms {
@@ -829,7 +967,9 @@ func bulkRevertPods(filters []string, snapshot string) ([]string, error) {
             }
             err := vm.RevertSnapshot(snapshot)
             if err != nil {
+                mu.Lock()
                 failed = append(failed, vm.Name)
+                mu.Unlock()
                 return err
             }
             return nil
@@ -843,35 +983,33 @@ func bulkRevertPods(filters []string, snapshot string) ([]string, error) {
     return failed, nil
 }
 
-func bulkPowerPods(filter []string, state bool) ([]string, error) {
-    pods, err := GetPodsMatchingFilter(filter)
+func (v *VSphereClient) bulkPowerPods(filter []string, state bool) ([]string, error) {
+    pods, err := v.GetPodsMatchingFilter(filter)
     if err != nil {
         return []string{}, errors.Wrap(err, "Error getting pods matching filter")
     }
 
-    vms, err := GetVMsOfPods(pods)
+    vms, err := v.GetVMsOfPods(pods)
     if err != nil {
         return []string{}, errors.Wrap(err, "Error getting VMs of pods")
     }
 
+    var mu sync.Mutex
     failed := []string{}
     wg := errgroup.Group{}
     for _, vm := range vms {
         wg.Go(func() error {
-            var task *object.Task
+            // PowerOn and PowerOff wait for the task themselves
+            var err error
             if state {
                 err = vm.PowerOn()
             } else {
                 err = vm.PowerOff()
             }
             if err != nil {
+                mu.Lock()
                 failed = append(failed, vm.Name)
-                return err
-            }
-
-            err = task.Wait(vSphereClient.ctx)
-            if err != nil {
-                log.Println(errors.Wrap(err, "Error waiting for task"))
+                mu.Unlock()
                 return err
             }
             return nil
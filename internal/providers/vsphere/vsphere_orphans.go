@@ -0,0 +1,244 @@
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+
+	"goclone/internal/providers"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+var _ providers.OrphanCollector = (*VSphereClient)(nil)
+
+func (v *VSphereClient) inPortGroupRange(pg int) bool {
+	return (pg >= v.vCenterConfig.StartingPortGroup && pg < v.vCenterConfig.EndingPortGroup) ||
+		(pg >= v.vCenterConfig.CompetitionStartPortGroup && pg < v.vCenterConfig.CompetitionEndPortGroup)
+}
+
+func (v *VSphereClient) portGroupName(pg int) string {
+	return fmt.Sprintf("%v_%s", pg, v.vCenterConfig.PortGroupSuffix)
+}
+
+// FindOrphans cross-checks the pod port groups, the folders under the destination folder and the pod
+// resource pools. Port groups without a resource pool, empty folders and resource pools without VMs
+// are reported. The port groups in use are synced back into availablePortGroups on the way.
+func (v *VSphereClient) FindOrphans(ctx context.Context) ([]providers.Orphan, error) {
+	_, span := v.tracer.Start(ctx, "FindOrphans")
+	defer span.End()
+
+	// port groups reserved while the inventory is read belong to clones that just started
+	v.availablePortGroups.Mu.Lock()
+	reserved := maps.Clone(v.availablePortGroups.Data)
+	v.availablePortGroups.Mu.Unlock()
+
+	orphans := []providers.Orphan{}
+	taken := map[int]bool{}
+	pods := map[int]bool{}
+
+	pools, err := v.podResourcePools()
+	if err != nil {
+		return nil, err
+	}
+	for _, rp := range pools {
+		pg, ok := providers.PodPortGroup(rp.Name)
+		if !ok || !v.inPortGroupRange(pg) {
+			continue
+		}
+		taken[pg] = true
+		pods[pg] = true
+		if len(rp.Vm) == 0 {
+			orphans = append(orphans, providers.Orphan{Kind: providers.OrphanResourcePool, Name: rp.Name, PortGroup: pg, Reason: "Resource pool has no VMs"})
+		}
+	}
+
+	folders, err := v.podFolders()
+	if err != nil {
+		return nil, err
+	}
+	for _, folder := range folders {
+		pg, ok := providers.PodPortGroup(folder.Name)
+		if !ok || !v.inPortGroupRange(pg) {
+			continue
+		}
+		taken[pg] = true
+		if len(folder.ChildEntity) == 0 {
+			orphans = append(orphans, providers.Orphan{Kind: providers.OrphanFolder, Name: folder.Name, PortGroup: pg, Reason: "Folder is empty"})
+		}
+	}
+
+	networks, err := v.podNetworks()
+	if err != nil {
+		return nil, err
+	}
+	for _, network := range networks {
+		pg, ok := v.podNetworkPortGroup(network.Name)
+		if !ok {
+			continue
+		}
+		taken[pg] = true
+		if !pods[pg] {
+			orphans = append(orphans, providers.Orphan{Kind: providers.OrphanPortGroup, Name: network.Name, PortGroup: pg, Reason: "Port group has no pod"})
+		}
+	}
+
+	v.availablePortGroups.Mu.Lock()
+	busy := maps.Clone(v.availablePortGroups.Cloning)
+	for pg := range v.availablePortGroups.Data {
+		if _, ok := reserved[pg]; !ok {
+			busy[pg] = true
+		}
+	}
+	for pg := range v.availablePortGroups.Data {
+		if !taken[pg] && !busy[pg] {
+			delete(v.availablePortGroups.Data, pg)
+		}
+	}
+	for pg := range taken {
+		v.availablePortGroups.Data[pg] = v.portGroupName(pg)
+	}
+	v.availablePortGroups.Mu.Unlock()
+
+	found := orphans[:0]
+	for _, orphan := range orphans {
+		if !busy[orphan.PortGroup] {
+			found = append(found, orphan)
+		}
+	}
+	log.Printf("Found %d orphaned resources", len(found))
+	return found, nil
+}
+
+// RemoveOrphan destroys a resource FindOrphans reported, unless a clone took its port group since
+func (v *VSphereClient) RemoveOrphan(ctx context.Context, orphan providers.Orphan) error {
+	v.availablePortGroups.Mu.Lock()
+	cloning := v.availablePortGroups.Cloning[orphan.PortGroup]
+	v.availablePortGroups.Mu.Unlock()
+	if cloning {
+		return fmt.Errorf("Port group %d is being cloned to", orphan.PortGroup)
+	}
+
+	switch orphan.Kind {
+	case providers.OrphanPortGroup:
+		pg, err := v.GetPortGroup(orphan.Name)
+		if err != nil {
+			return err
+		}
+		return v.DestroyPortGroup(ctx, pg.Reference())
+	case providers.OrphanFolder:
+		folder, err := v.destinationFolderChild(orphan.Name)
+		if err != nil {
+			return err
+		}
+		return v.DestroyFolder(ctx, folder)
+	case providers.OrphanResourcePool:
+		rp, err := v.GetResourcePool(orphan.Name)
+		if err != nil {
+			return err
+		}
+		return v.DestroyResourcePool(ctx, rp)
+	}
+	return fmt.Errorf("Unknown resource kind %s", orphan.Kind)
+}
+
+// podNetworkPortGroup returns the port group of a pod network named <pg>_<suffix>
+func (v *VSphereClient) podNetworkPortGroup(name string) (int, bool) {
+	var pg int
+	_, err := fmt.Sscanf(name, "%d_", &pg)
+	if err != nil || name != v.portGroupName(pg) || !v.inPortGroupRange(pg) {
+		return 0, false
+	}
+	return pg, true
+}
+
+// podNetworks lists the port groups ending in the pod port group suffix
+func (v *VSphereClient) podNetworks() ([]mo.DistributedVirtualPortgroup, error) {
+	podNetworks, err := v.finder.NetworkList(v.ctx, "*_"+v.vCenterConfig.PortGroupSuffix)
+	if err != nil {
+		if _, ok := err.(*find.NotFoundError); ok {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "Failed to list networks")
+	}
+
+	var refs []types.ManagedObjectReference
+	for _, pgRef := range podNetworks {
+		refs = append(refs, pgRef.Reference())
+	}
+
+	var pgs []mo.DistributedVirtualPortgroup
+	err = property.DefaultCollector(v.client).Retrieve(v.ctx, refs, []string{"name"}, &pgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get references for Virtual Port Groups")
+	}
+	return pgs, nil
+}
+
+// podResourcePools lists the resource pools in the target and competition resource pools with their VMs and attributes
+func (v *VSphereClient) podResourcePools() ([]mo.ResourcePool, error) {
+	pods, err := v.GetAllPods()
+	if err != nil {
+		return nil, err
+	}
+	if len(pods) == 0 {
+		return nil, nil
+	}
+
+	var refs []types.ManagedObjectReference
+	for _, pod := range pods {
+		refs = append(refs, pod.Reference())
+	}
+
+	var rps []mo.ResourcePool
+	err = property.DefaultCollector(v.client).Retrieve(v.ctx, refs, []string{"name", "vm", "customValue"}, &rps)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to collect pod resource pools")
+	}
+	return rps, nil
+}
+
+// podFolders lists the folders under the destination folder with what they hold
+func (v *VSphereClient) podFolders() ([]mo.Folder, error) {
+	children, err := v.destinationFolder.Children(v.ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list pod folders")
+	}
+
+	var refs []types.ManagedObjectReference
+	for _, child := range children {
+		if _, ok := child.(*object.Folder); ok {
+			refs = append(refs, child.Reference())
+		}
+	}
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	var folders []mo.Folder
+	err = property.DefaultCollector(v.client).Retrieve(v.ctx, refs, []string{"name", "childEntity"}, &folders)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to collect pod folders")
+	}
+	return folders, nil
+}
+
+// destinationFolderChild finds the folder named name under the destination folder
+func (v *VSphereClient) destinationFolderChild(name string) (*object.Folder, error) {
+	folders, err := v.podFolders()
+	if err != nil {
+		return nil, err
+	}
+	for _, folder := range folders {
+		if folder.Name == name {
+			return object.NewFolder(v.client, folder.Reference()), nil
+		}
+	}
+	return nil, providers.NotFound("Folder", name)
+}
@@ -0,0 +1,63 @@
+package vsphere
+
+import (
+	"context"
+	"sync"
+
+	"goclone/internal/providers"
+
+	"github.com/vmware/govmomi/vim25/progress"
+)
+
+// taskReporter reports how far along vmName's task is while step runs, then that it is done
+type taskReporter struct {
+	ctx    context.Context
+	step   string
+	vmName string
+	// drained is done once the reports sent by vSphere have all been passed on
+	drained sync.WaitGroup
+}
+
+// taskProgress returns the reporter of vmName's task in step. vSphere sends a report whenever
+// the task's progress changes, repeats are left out.
+func taskProgress(ctx context.Context, step, vmName string) *taskReporter {
+	return &taskReporter{ctx: ctx, step: step, vmName: vmName}
+}
+
+// Sink implements progress.Sinker. The channel is closed by the task once it is done.
+func (r *taskReporter) Sink() chan<- progress.Report {
+	// reports of a running task are dropped while the channel is full
+	ch := make(chan progress.Report, 8)
+	r.drained.Add(1)
+	go func() {
+		defer r.drained.Done()
+		last := -1
+		for report := range ch {
+			percent := int(report.Percentage())
+			if report.Error() != nil || percent <= last {
+				continue
+			}
+			last = percent
+			providers.Report(r.ctx, providers.Update{Step: r.step, VM: r.vmName, Percent: percent})
+		}
+	}()
+	return ch
+}
+
+// done reports the task as done with message, or as failed with err. It waits for the reports
+// still being passed on, so none of them comes after.
+func (r *taskReporter) done(message string, err error) {
+	r.drained.Wait()
+	if err != nil {
+		providers.Report(r.ctx, providers.Update{Step: r.step, VM: r.vmName, Error: err.Error()})
+		return
+	}
+	providers.Report(r.ctx, providers.Update{Step: r.step, VM: r.vmName, Percent: 100, Message: message})
+}
+
+// notifier reports what RunProgramOnVM is waiting for on vmName
+func notifier(ctx context.Context, step, vmName string) func(message string) {
+	return func(message string) {
+		providers.Report(ctx, providers.Update{Step: step, VM: vmName, Message: message})
+	}
+}
@@ -0,0 +1,60 @@
+package vsphere
+
+import (
+	"context"
+
+	"goclone/internal/providers"
+)
+
+var _ providers.Backend = (*VSphereClient)(nil)
+
+func (v *VSphereClient) ServerGUID() string {
+	return v.client.ServiceContent.About.InstanceUuid
+}
+
+func (v *VSphereClient) ListPods(ctx context.Context, owner string) ([]providers.Pod, error) {
+	return v.vSphereGetPods(owner)
+}
+
+func (v *VSphereClient) ListAllPods(ctx context.Context) ([]providers.Pod, error) {
+	return v.vSphereGetAllPods()
+}
+
+func (v *VSphereClient) DeletePod(ctx context.Context, req providers.DeletePodRequest) error {
+	return v.DestroyResources(ctx, req.PodID)
+}
+
+func (v *VSphereClient) ListPresetTemplates(ctx context.Context, isAdmin bool) ([]string, error) {
+	return v.vSphereGetPresetTemplates(isAdmin)
+}
+
+func (v *VSphereClient) ListCustomTemplates(ctx context.Context) ([]providers.CustomTemplate, error) {
+	return v.vSphereGetCustomTemplates()
+}
+
+func (v *VSphereClient) CloneTemplate(ctx context.Context, req providers.TemplateCloneRequest) error {
+	return v.vSphereTemplateClone(ctx, req.Template, req.Username)
+}
+
+func (v *VSphereClient) CloneCustom(ctx context.Context, req providers.CustomCloneRequest) error {
+	return v.vSphereCustomClone(ctx, req.Name, req.VMs, req.Natted, req.Username)
+}
+
+func (v *VSphereClient) RefreshTemplates(ctx context.Context) error {
+	return v.LoadTemplates(ctx)
+}
+
+func (v *VSphereClient) BulkDelete(ctx context.Context, filter providers.PodFilter) (providers.BulkResult, error) {
+	failed, err := v.bulkDeletePods(ctx, filter)
+	return providers.BulkResult{Failed: failed}, err
+}
+
+func (v *VSphereClient) BulkRevert(ctx context.Context, filter providers.PodFilter, snapshot string) (providers.BulkResult, error) {
+	failed, err := v.bulkRevertPods(filter, snapshot)
+	return providers.BulkResult{Failed: failed}, err
+}
+
+func (v *VSphereClient) BulkPower(ctx context.Context, filter providers.PodFilter, powerOn bool) (providers.BulkResult, error) {
+	failed, err := v.bulkPowerPods(filter, powerOn)
+	return providers.BulkResult{Failed: failed}, err
+}
@@ -7,6 +7,7 @@ import (
 	"goclone/internal/config"
 	"log"
 	"net/url"
+	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/vmware/govmomi"
@@ -17,29 +18,29 @@ import (
 	"github.com/vmware/govmomi/vim25/soap"
 	"github.com/vmware/govmomi/vim25/types"
 	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"golang.org/x/sync/errgroup"
 )
 
+// VSphereClient holds the connection and inventory objects for a single vCenter,
+// so several vCenters can be served side by side.
 type VSphereClient struct {
 	client *vim25.Client
 	ctx    context.Context
-    conf *config.Provider
-    authMgr auth.AuthManager    
-}
+	conf   *config.Provider
+	tracer trace.Tracer
 
-var (
-	authMgr        *auth.AuthManager
-	mainConfig    = &config.Config{}
-	templateMap   = map[string]Template{}
-	vCenterConfig config.VCenter
-    tracer trace.Tracer
-)
+	vCenterConfig       config.VCenter
+	// templatesMu guards templateMap, refreshes write it while clones read it
+	templatesMu         sync.RWMutex
+	templateMap         map[string]Template
+	availablePortGroups *RWPortGroupMap
 
-var (
 	authManager             *object.AuthorizationManager
 	cloneRole               *types.AuthorizationRole
 	customCloneRole         *types.AuthorizationRole
 	customFieldsManager     *object.CustomFieldsManager
+	podOwnerKey             int32
 	datastore               *object.Datastore
 	destinationFolder       *object.Folder
 	dvsMo                   mo.DistributedVirtualSwitch
@@ -47,20 +48,17 @@ var (
 	noAccessRole            *types.AuthorizationRole
 	targetResourcePool      *object.ResourcePool
 	templateFolder          *object.Folder
-	vSphereClient           *VSphereClient
 	wanPG                   *object.DistributedVirtualPortgroup
 	competitionPG           *object.DistributedVirtualPortgroup
 	competitionResourcePool *object.ResourcePool
-)
+}
 
 func NewVSphereProvider(conf *config.Config, authMgr *auth.AuthManager) *VSphereClient {
 	// setup vSphere client
-    tracer = conf.Core.Tracer
-
-    fmt.Println("Setting up vSphere Provider")
+	fmt.Println("Setting up vSphere Provider")
 	u, err := soap.ParseURL(conf.Provider.URL)
 	if err != nil {
-        fmt.Println("Error parsing vCenter URL")
+		fmt.Println("Error parsing vCenter URL")
 		log.Fatalln(errors.Wrap(err, "Error parsing vCenter URL"))
 	}
 
@@ -68,113 +66,128 @@ func NewVSphereProvider(conf *config.Config, authMgr *auth.AuthManager) *VSphere
 	ctx := context.Background()
 	client, err := govmomi.NewClient(ctx, u, true)
 	if err != nil {
-        fmt.Println("Error creating vSphere client")
+		fmt.Println("Error creating vSphere client")
 		log.Fatalln(errors.Wrap(err, "Error creating vSphere client"))
 	}
 
-	vSphereClient = &VSphereClient{
+	tracer := conf.Core.Tracer
+	if tracer == nil {
+		tracer = noop.NewTracerProvider().Tracer("goclone")
+	}
+
+	v := &VSphereClient{
 		client: client.Client,
 		ctx:    context.Background(),
-        conf:   &conf.Provider,
-	}
+		conf:   &conf.Provider,
+		tracer: tracer,
 
-    vCenterConfig = conf.Provider.VCenter
+		vCenterConfig: conf.Provider.VCenter,
+		templateMap:   map[string]Template{},
+		availablePortGroups: &RWPortGroupMap{
+			Data:    make(map[int]string),
+			Cloning: make(map[int]bool),
+		},
+	}
 
-	InitializeGovmomi()
-	err = vSphereLoadTakenPortGroups()
+	v.InitializeGovmomi()
+	err = v.vSphereLoadTakenPortGroups()
 	if err != nil {
 		log.Fatalln(errors.Wrap(err, "Error finding taken port groups"))
 	}
 
-    eg := errgroup.Group{}
-    eg.Go(func() error {
-        return LoadTemplates(ctx)  
-    })
+	eg := errgroup.Group{}
+	eg.Go(func() error {
+		return v.LoadTemplates(ctx)
+	})
 
-    if err := eg.Wait(); err != nil {
-        fmt.Println("Error loading templates", err)
-    }
+	if err := eg.Wait(); err != nil {
+		fmt.Println("Error loading templates", err)
+	}
 
-	go refreshSession()
+	go v.refreshSession()
 
-	return vSphereClient
+	return v
 }
 
-func InitializeGovmomi() {
-	finder = find.NewFinder(vSphereClient.client, true)
+func (v *VSphereClient) InitializeGovmomi() {
+	v.finder = find.NewFinder(v.client, true)
 
-	dc, err := finder.Datacenter(vSphereClient.ctx, vCenterConfig.Datacenter)
+	dc, err := v.finder.Datacenter(v.ctx, v.vCenterConfig.Datacenter)
 	if err != nil {
 		log.Fatalln(errors.Wrap(err, "Error finding datacenter"))
 	}
 
-	finder.SetDatacenter(dc)
+	v.finder.SetDatacenter(dc)
 
-	datastore, err = finder.Datastore(vSphereClient.ctx, vCenterConfig.Datastore)
+	v.datastore, err = v.finder.Datastore(v.ctx, v.vCenterConfig.Datastore)
 	if err != nil {
 		log.Fatalln(errors.Wrap(err, "Error finding datastore"))
 	}
 
-	dswitch, err := finder.Network(vSphereClient.ctx, vCenterConfig.MainDistributedSwitch)
+	dswitch, err := v.finder.Network(v.ctx, v.vCenterConfig.MainDistributedSwitch)
 	if err != nil {
 		log.Fatalln(errors.Wrap(err, "Error finding distributed switch"))
 	}
 
-	dvs := object.NewDistributedVirtualSwitch(vSphereClient.client, dswitch.Reference())
-	err = dvs.Properties(vSphereClient.ctx, dvs.Reference(), []string{"uuid"}, &dvsMo)
+	dvs := object.NewDistributedVirtualSwitch(v.client, dswitch.Reference())
+	err = dvs.Properties(v.ctx, dvs.Reference(), []string{"uuid"}, &v.dvsMo)
 	if err != nil {
 		log.Fatalln(errors.Wrap(err, "Error getting distributed switch properties"))
 	}
 
-	templateFolder, err = finder.Folder(vSphereClient.ctx, vCenterConfig.TemplateFolder)
+	v.templateFolder, err = v.finder.Folder(v.ctx, v.vCenterConfig.TemplateFolder)
 	if err != nil {
 		log.Fatalln(errors.Wrap(err, "Error finding template folder"))
 	}
 
-	destinationFolder, err = finder.Folder(vSphereClient.ctx, vCenterConfig.DestinationFolder)
+	v.destinationFolder, err = v.finder.Folder(v.ctx, v.vCenterConfig.DestinationFolder)
 	if err != nil {
 		log.Fatalln(errors.Wrap(err, "Error finding destination folder"))
 	}
 
-	targetResourcePool, err = finder.ResourcePool(vSphereClient.ctx, vCenterConfig.TargetResourcePool)
+	v.targetResourcePool, err = v.finder.ResourcePool(v.ctx, v.vCenterConfig.TargetResourcePool)
 	if err != nil {
 		log.Fatalln(errors.Wrap(err, "Error finding target resource pool"))
 	}
 
-	competitionResourcePool, err = finder.ResourcePool(vSphereClient.ctx, vCenterConfig.CompetitionResourcePool)
+	v.competitionResourcePool, err = v.finder.ResourcePool(v.ctx, v.vCenterConfig.CompetitionResourcePool)
 	if err != nil {
 		log.Fatalln(errors.Wrap(err, "Error finding competition resource pool"))
 	}
 
-	pg, err := finder.Network(vSphereClient.ctx, vCenterConfig.DefaultWanPortGroup)
+	pg, err := v.finder.Network(v.ctx, v.vCenterConfig.DefaultWanPortGroup)
 	if err != nil {
 		log.Fatalln(errors.Wrap(err, "Error finding WAN port group"))
 	}
-	wanPG = object.NewDistributedVirtualPortgroup(vSphereClient.client, pg.Reference())
+	v.wanPG = object.NewDistributedVirtualPortgroup(v.client, pg.Reference())
 
-	compPG, err := finder.Network(vSphereClient.ctx, vCenterConfig.CompetitionWanPortGroup)
+	compPG, err := v.finder.Network(v.ctx, v.vCenterConfig.CompetitionWanPortGroup)
 	if err != nil {
 		log.Fatalln(errors.Wrap(err, "Error finding competition WAN port group"))
 	}
-	competitionPG = object.NewDistributedVirtualPortgroup(vSphereClient.client, compPG.Reference())
+	v.competitionPG = object.NewDistributedVirtualPortgroup(v.client, compPG.Reference())
 
-	customFieldsManager = object.NewCustomFieldsManager(vSphereClient.client)
+	v.customFieldsManager = object.NewCustomFieldsManager(v.client)
+	v.podOwnerKey, err = v.podOwnerField()
+	if err != nil {
+		log.Println(errors.Wrap(err, "Error setting up pod owner attribute, pod owners are read from pod names"))
+	}
 
-	authManager = object.NewAuthorizationManager(vSphereClient.client)
-	roles, err := authManager.RoleList(vSphereClient.ctx)
+	v.authManager = object.NewAuthorizationManager(v.client)
+	roles, err := v.authManager.RoleList(v.ctx)
 	if err != nil {
 		log.Fatalln(errors.Wrap(err, "Error listing roles"))
 	}
 
 	for _, role := range roles {
-		if role.Name == vCenterConfig.CloneRole {
-			cloneRole = &role
+		if role.Name == v.vCenterConfig.CloneRole {
+			v.cloneRole = &role
 		}
-		if role.Name == vCenterConfig.CustomCloneRole {
-			customCloneRole = &role
+		if role.Name == v.vCenterConfig.CustomCloneRole {
+			v.customCloneRole = &role
 		}
 		if role.Name == "NoAccess" {
-			noAccessRole = &role
+			v.noAccessRole = &role
 		}
 	}
 }
@@ -0,0 +1,329 @@
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"goclone/internal/config"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Names of the objects vcsim creates in its default VPX inventory
+const (
+	simDatacenter = "DC0"
+	simDatastore  = "LocalDS_0"
+	simDVS        = "DVS0"
+	simCluster    = "DC0_C0"
+	simNetwork    = "DC0_DVPG0"
+	simTemplate   = "SimTemplate"
+)
+
+// StartSimulator starts an in-process vCenter simulator (vcsim), seeds it with
+// the inventory InitializeGovmomi and LoadTemplates expect and points
+// conf.Provider at it. The returned function stops the simulator.
+// vcsim keeps its inventory in package state, so only one simulator can run per process.
+func StartSimulator(conf *config.Config) (func(), error) {
+	fmt.Println("Starting vCenter simulator")
+	vc := &conf.Provider.VCenter
+	applySimulatorDefaults(vc)
+
+	model := simulator.VPX()
+	model.Autostart = false
+	model.Machine = 0
+	err := model.Create()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error creating simulator model")
+	}
+
+	server := model.Service.NewServer()
+	stop := func() {
+		server.Close()
+		model.Remove()
+	}
+
+	ctx := context.Background()
+	client, err := govmomi.NewClient(ctx, server.URL, true)
+	if err != nil {
+		stop()
+		return nil, errors.Wrap(err, "Error connecting to simulator")
+	}
+
+	err = seedSimulator(ctx, client.Client, *vc)
+	if err != nil {
+		stop()
+		return nil, errors.Wrap(err, "Error seeding simulator")
+	}
+
+	password, _ := server.URL.User.Password()
+	conf.Provider.URL = server.URL.String()
+	conf.Provider.Username = server.URL.User.Username()
+	conf.Provider.Password = password
+	if conf.Provider.MaxPodLimit == 0 {
+		conf.Provider.MaxPodLimit = 5
+	}
+	if conf.Provider.DefaultNetworkID == "" {
+		conf.Provider.DefaultNetworkID = "172.16.0.0/16"
+	}
+	if conf.Provider.CompetitionNetworkID == "" {
+		conf.Provider.CompetitionNetworkID = "172.26.0.0/16"
+	}
+
+	fmt.Println("vCenter simulator listening on", server.URL.Host)
+	return stop, nil
+}
+
+func applySimulatorDefaults(vc *config.VCenter) {
+	setDefault := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+	setDefaultInt := func(field *int, value int) {
+		if *field == 0 {
+			*field = value
+		}
+	}
+
+	setDefault(&vc.Datacenter, simDatacenter)
+	setDefault(&vc.Datastore, simDatastore)
+	setDefault(&vc.MainDistributedSwitch, simDVS)
+	setDefault(&vc.TemplateFolder, "Templates")
+	setDefault(&vc.DestinationFolder, "Goclone")
+	setDefault(&vc.TargetResourcePool, "Pods")
+	setDefault(&vc.CompetitionResourcePool, "CompetitionPods")
+	setDefault(&vc.PresetTemplateResourcePool, "PresetTemplates")
+	setDefault(&vc.DefaultWanPortGroup, "WAN")
+	setDefault(&vc.CompetitionWanPortGroup, "CompetitionWAN")
+	setDefault(&vc.PortGroupSuffix, "PodNetwork")
+	setDefault(&vc.CloneRole, "GocloneUsers")
+	setDefault(&vc.CustomCloneRole, "GocloneUsersCustomPod")
+	setDefault(&vc.RouterPath, "VyOS_PodRouter")
+	setDefault(&vc.NattedRouterPath, "1:1NAT_VyOS_PodRouter")
+	setDefault(&vc.RouterProgram, "/usr/bin/sed")
+	setDefault(&vc.RouterProgramArgs, "-i -e 's/{{THIRD_OCTET}}/%v/g;s/{{NETWORK_ID}}/%v/g' /config/scripts/vyos-postconfig-bootup.script")
+	setDefaultInt(&vc.StartingPortGroup, 1801)
+	setDefaultInt(&vc.EndingPortGroup, 2000)
+	setDefaultInt(&vc.CompetitionStartPortGroup, 2001)
+	setDefaultInt(&vc.CompetitionEndPortGroup, 2200)
+}
+
+func seedSimulator(ctx context.Context, client *vim25.Client, vc config.VCenter) error {
+	f := find.NewFinder(client, true)
+
+	dc, err := f.Datacenter(ctx, simDatacenter)
+	if err != nil {
+		return errors.Wrap(err, "Error finding simulator datacenter")
+	}
+	f.SetDatacenter(dc)
+
+	ds, err := f.Datastore(ctx, simDatastore)
+	if err != nil {
+		return errors.Wrap(err, "Error finding simulator datastore")
+	}
+
+	dvs, err := f.Network(ctx, simDVS)
+	if err != nil {
+		return errors.Wrap(err, "Error finding simulator distributed switch")
+	}
+
+	renames := []struct {
+		obj  object.Common
+		name string
+	}{
+		{dc.Common, vc.Datacenter},
+		{ds.Common, vc.Datastore},
+		{object.NewCommon(client, dvs.Reference()), vc.MainDistributedSwitch},
+	}
+	for _, r := range renames {
+		err = renameObject(ctx, r.obj, r.name)
+		if err != nil {
+			return err
+		}
+	}
+
+	dcFolders, err := dc.Folders(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Error getting datacenter folders")
+	}
+
+	templateFolder, err := createFolderPath(ctx, dcFolders.VmFolder, vc.Datacenter, vc.TemplateFolder)
+	if err != nil {
+		return err
+	}
+	_, err = createFolderPath(ctx, dcFolders.VmFolder, vc.Datacenter, vc.DestinationFolder)
+	if err != nil {
+		return err
+	}
+
+	rootPool, err := f.ResourcePool(ctx, path.Join(simCluster, "Resources"))
+	if err != nil {
+		return errors.Wrap(err, "Error finding simulator cluster resource pool")
+	}
+
+	pools := map[string]*object.ResourcePool{}
+	for _, name := range []string{vc.TargetResourcePool, vc.CompetitionResourcePool, vc.PresetTemplateResourcePool} {
+		pools[name], err = rootPool.Create(ctx, path.Base(name), types.DefaultResourceConfigSpec())
+		if err != nil {
+			return errors.Wrapf(err, "Error creating resource pool %s", name)
+		}
+	}
+
+	dvsObj := object.NewDistributedVirtualSwitch(client, dvs.Reference())
+	for i, name := range []string{vc.DefaultWanPortGroup, vc.CompetitionWanPortGroup} {
+		spec := types.DVPortgroupConfigSpec{
+			Name:     name,
+			Type:     string(types.DistributedVirtualPortgroupPortgroupTypeEarlyBinding),
+			NumPorts: 128,
+			DefaultPortConfig: &types.VMwareDVSPortSetting{
+				Vlan: &types.VmwareDistributedVirtualSwitchVlanIdSpec{VlanId: int32(40 + i)},
+			},
+		}
+		task, err := dvsObj.AddPortgroup(ctx, []types.DVPortgroupConfigSpec{spec})
+		if err != nil {
+			return errors.Wrapf(err, "Error adding port group %s", name)
+		}
+		if err = task.Wait(ctx); err != nil {
+			return errors.Wrapf(err, "Error adding port group %s", name)
+		}
+	}
+
+	fields := object.NewCustomFieldsManager(client)
+	fieldKeys := map[string]int32{}
+	for name, moType := range map[string]string{
+		"goclone.template.natted":         "ResourcePool",
+		"goclone.template.noRouter":       "ResourcePool",
+		"goclone.template.competitionPod": "ResourcePool",
+		"goclone.template.adminOnly":      "ResourcePool",
+		"goclone.vm.username":             "VirtualMachine",
+		"goclone.vm.password":             "VirtualMachine",
+		"goclone.vm.isHidden":             "VirtualMachine",
+	} {
+		def, err := fields.Add(ctx, name, moType, nil, nil)
+		if err != nil {
+			return errors.Wrapf(err, "Error adding custom attribute %s", name)
+		}
+		fieldKeys[name] = def.Key
+	}
+
+	roles := object.NewAuthorizationManager(client)
+	for _, name := range []string{vc.CloneRole, vc.CustomCloneRole} {
+		_, err = roles.AddRole(ctx, name, []string{"VirtualMachine.Interact.PowerOn", "VirtualMachine.Interact.PowerOff", "VirtualMachine.State.RevertToSnapshot"})
+		if err != nil {
+			return errors.Wrapf(err, "Error adding role %s", name)
+		}
+	}
+
+	// Router templates live at the root of the template folder so they are not listed as custom templates
+	for _, name := range []string{vc.RouterPath, vc.NattedRouterPath} {
+		err = createSimulatorVM(ctx, templateFolder, rootPool, path.Base(name), vc.Datastore, 2)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A preset template with a single VM; LoadTemplates adds the router
+	templatePool, err := pools[vc.PresetTemplateResourcePool].Create(ctx, simTemplate, types.DefaultResourceConfigSpec())
+	if err != nil {
+		return errors.Wrap(err, "Error creating template resource pool")
+	}
+	err = fields.Set(ctx, templatePool.Reference(), fieldKeys["goclone.template.adminOnly"], "false")
+	if err != nil {
+		return errors.Wrap(err, "Error setting template attributes")
+	}
+	err = createSimulatorVM(ctx, templateFolder, templatePool, simTemplate+"-Server", vc.Datastore, 1)
+	if err != nil {
+		return err
+	}
+
+	// A custom template folder
+	customFolder, err := templateFolder.CreateFolder(ctx, "Linux")
+	if err != nil {
+		return errors.Wrap(err, "Error creating custom template folder")
+	}
+	return createSimulatorVM(ctx, customFolder, rootPool, "Ubuntu", vc.Datastore, 1)
+}
+
+func renameObject(ctx context.Context, obj object.Common, name string) error {
+	current, err := obj.ObjectName(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Error getting object name")
+	}
+	if current == name {
+		return nil
+	}
+
+	task, err := obj.Rename(ctx, name)
+	if err != nil {
+		return errors.Wrapf(err, "Error renaming %s", current)
+	}
+	return task.Wait(ctx)
+}
+
+// createFolderPath creates every folder in an inventory path such as /<datacenter>/vm/a/b below the datacenter VM folder
+func createFolderPath(ctx context.Context, vmFolder *object.Folder, datacenter, folderPath string) (*object.Folder, error) {
+	folderPath = strings.TrimPrefix(folderPath, "/"+datacenter+"/vm")
+	folder := vmFolder
+	for _, name := range strings.Split(strings.Trim(folderPath, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		child, err := folder.CreateFolder(ctx, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error creating folder %s", name)
+		}
+		folder = child
+	}
+	return folder, nil
+}
+
+// createSimulatorVM creates a powered-off VM with the given number of NICs labelled the way ConfigureVMNetwork expects
+func createSimulatorVM(ctx context.Context, folder *object.Folder, pool *object.ResourcePool, name, datastore string, nics int) error {
+	spec := types.VirtualMachineConfigSpec{
+		Name:    name,
+		GuestId: string(types.VirtualMachineGuestOsIdentifierOtherGuest),
+		Files: &types.VirtualMachineFileInfo{
+			VmPathName: fmt.Sprintf("[%s]", datastore),
+		},
+	}
+
+	for i := 1; i <= nics; i++ {
+		nic := &types.VirtualVmxnet3{
+			VirtualVmxnet: types.VirtualVmxnet{
+				VirtualEthernetCard: types.VirtualEthernetCard{
+					VirtualDevice: types.VirtualDevice{
+						Key: int32(-i),
+						DeviceInfo: &types.Description{
+							Label:   fmt.Sprintf("Network adapter %d", i),
+							Summary: simNetwork,
+						},
+						Backing: &types.VirtualEthernetCardNetworkBackingInfo{
+							VirtualDeviceDeviceBackingInfo: types.VirtualDeviceDeviceBackingInfo{DeviceName: simNetwork},
+						},
+					},
+				},
+			},
+		}
+		spec.DeviceChange = append(spec.DeviceChange, &types.VirtualDeviceConfigSpec{
+			Operation: types.VirtualDeviceConfigSpecOperationAdd,
+			Device:    nic,
+		})
+	}
+
+	task, err := folder.CreateVM(ctx, spec, pool, nil)
+	if err != nil {
+		return errors.Wrapf(err, "Error creating VM %s", name)
+	}
+	if err = task.Wait(ctx); err != nil {
+		return errors.Wrapf(err, "Error creating VM %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"goclone/internal/config"
+	"goclone/internal/providers"
+	"goclone/internal/providers/vsphere/vm"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recorder keeps the updates of a clone
+type recorder struct {
+	mu      sync.Mutex
+	updates []providers.Update
+}
+
+func (r *recorder) StepStarted(step string)             {}
+func (r *recorder) StepFinished(step string, err error) {}
+func (r *recorder) StepUpdated(update providers.Update) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updates = append(r.updates, update)
+}
+
+// done lists the VMs whose task in step completed
+func (r *recorder) done(step string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var vms []string
+	for _, update := range r.updates {
+		if update.Step == step && update.Percent == 100 && update.Error == "" {
+			vms = append(vms, update.VM)
+		}
+	}
+	return vms
+}
+
+// takenPortGroups lists the port groups clones cannot take, in order
+func takenPortGroups(client *VSphereClient) []int {
+	client.availablePortGroups.Mu.Lock()
+	defer client.availablePortGroups.Mu.Unlock()
+	var taken []int
+	for pg := range client.availablePortGroups.Data {
+		taken = append(taken, pg)
+	}
+	slices.Sort(taken)
+	return taken
+}
+
+// percent is a progress report of a running task
+type percent float32
+
+func (p percent) Percentage() float32 { return float32(p) }
+func (p percent) Detail() string      { return "" }
+func (p percent) Error() error        { return nil }
+
+func TestTaskProgressFinishesLast(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		rec := &recorder{}
+		reporter := taskProgress(providers.WithProgress(context.Background(), rec), providers.StepCloneVMs, "vm")
+		ch := reporter.Sink()
+		ch <- percent(40)
+		ch <- percent(90)
+		close(ch)
+		reporter.done("Cloned", nil)
+
+		rec.mu.Lock()
+		updates := rec.updates
+		rec.mu.Unlock()
+		if len(updates) != 3 || updates[2].Percent != 100 {
+			t.Fatalf("expected the task's reports before it is done, got %+v", updates)
+		}
+	}
+}
+
+func TestSimulatorTemplateClone(t *testing.T) {
+	conf := &config.Config{
+		Core: config.Core{Tracer: noop.NewTracerProvider().Tracer("goclone")},
+		Provider: config.Provider{
+			MaxPodLimit: 1,
+			Domain:      "goclone.local",
+			VCenter:     config.VCenter{Simulator: true},
+		},
+	}
+
+	stop, err := StartSimulator(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	client := NewVSphereProvider(conf, nil)
+	ctx := context.Background()
+
+	templates, err := client.vSphereGetPresetTemplates(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(templates, simTemplate) {
+		t.Fatalf("expected %s in preset templates, got %v", simTemplate, templates)
+	}
+	if len(client.templateMap[simTemplate].VMs) != 2 {
+		t.Errorf("expected the template VM and a router, got %v", client.templateMap[simTemplate].VMs)
+	}
+
+	custom, err := client.vSphereGetCustomTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(custom) != 1 || custom[0].Name != "Linux" {
+		t.Errorf("unexpected custom templates %v", custom)
+	}
+
+	rec := &recorder{}
+	err = client.vSphereTemplateClone(providers.WithProgress(context.Background(), rec), simTemplate, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cloned := rec.done(providers.StepCloneVMs); len(cloned) != 2 {
+		t.Errorf("expected both VM clones to report completion, got %v", cloned)
+	}
+	if snapshots := rec.done(providers.StepSnapshots); len(snapshots) != 2 {
+		t.Errorf("expected both snapshots to report completion, got %v", snapshots)
+	}
+
+	pods, err := client.vSphereGetPods("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 1 || pods[0].Name != "1801_SimTemplate_alice" {
+		t.Fatalf("unexpected pods %+v", pods)
+	}
+	if pods[0].Owner != "alice" {
+		t.Errorf("expected the owner to be recorded, got %+v", pods[0])
+	}
+	if all, err := client.vSphereGetAllPods(); err != nil || len(all) != 1 || all[0] != pods[0] {
+		t.Errorf("expected alice's pod among all pods, got %+v %v", all, err)
+	}
+
+	if _, err := client.finder.Network(ctx, "1801_PodNetwork"); err != nil {
+		t.Errorf("pod port group was not created: %v", err)
+	}
+
+	podPools, err := client.GetPodsMatchingFilter([]string{"_alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vms, err := client.GetVMsOfPods(podPools)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vms) != 2 {
+		t.Errorf("expected 2 cloned VMs, got %d", len(vms))
+	}
+	for _, vm := range vms {
+		if !strings.HasPrefix(vm.Name, "1801-") {
+			t.Errorf("clone %s is missing the port group prefix", vm.Name)
+		}
+	}
+
+	err = client.vSphereTemplateClone(context.Background(), simTemplate, "alice")
+	if err == nil || err.Error() != "Max pod limit reached" {
+		t.Errorf("expected pod limit error, got %v", err)
+	}
+
+	err = client.DestroyResources(ctx, pods[0].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pods, err = client.vSphereGetPods("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 0 {
+		t.Errorf("pod was not destroyed: %+v", pods)
+	}
+
+	// owners are matched exactly, not by the end of the pod name
+	otherRP, err := client.CreateResourcePool("1852_Web_x_alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.customFieldsManager.Set(ctx, otherRP, client.podOwnerKey, "x_alice"); err != nil {
+		t.Fatal(err)
+	}
+	if owned, err := client.vSphereGetPods("alice"); err != nil || len(owned) != 0 {
+		t.Errorf("alice sees x_alice's pods: %+v %v", owned, err)
+	}
+
+	client.availablePortGroups.Mu.Lock()
+	_, taken := client.availablePortGroups.Data[1801]
+	client.availablePortGroups.Mu.Unlock()
+	if taken {
+		t.Errorf("port group 1801 was not released")
+	}
+
+	// a clone failing partway tears down what it had already created
+	err = client.vSphereCustomClone(ctx, "pod", []string{"Missing"}, false, "bob")
+	var cloneErr *providers.CloneError
+	if !errors.As(err, &cloneErr) || len(cloneErr.Cleanup) != 0 {
+		t.Fatalf("expected a clean rollback, got %v", err)
+	}
+	if pods, err := client.vSphereGetPods("bob"); err != nil || len(pods) != 0 {
+		t.Errorf("resource pool was left behind: %+v, %v", pods, err)
+	}
+	if _, err := client.finder.Network(ctx, "1801_PodNetwork"); err == nil {
+		t.Error("pod port group was left behind")
+	}
+	if _, err := client.finder.Folder(ctx, "1801_pod_bob"); err == nil {
+		t.Error("pod folder was left behind")
+	}
+	client.availablePortGroups.Mu.Lock()
+	_, taken = client.availablePortGroups.Data[1801]
+	client.availablePortGroups.Mu.Unlock()
+	if taken {
+		t.Errorf("port group 1801 was not released")
+	}
+}
+
+func TestSimulatorNattedCustomClone(t *testing.T) {
+	// without a tracer configured, spans go to a noop one
+	conf := &config.Config{
+		Provider: config.Provider{
+			MaxPodLimit: 1,
+			Domain:      "goclone.local",
+			VCenter:     config.VCenter{Simulator: true},
+		},
+	}
+
+	stop, err := StartSimulator(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	// vcsim cannot run programs in guests, the router program is recorded instead
+	var ran []string
+	defer func(run func(*vm.VM, types.GuestProgramSpec, types.NamePasswordAuthentication, func(string)) error) {
+		runRouterProgram = run
+	}(runRouterProgram)
+	runRouterProgram = func(router *vm.VM, program types.GuestProgramSpec, auth types.NamePasswordAuthentication, notify func(string)) error {
+		ran = append(ran, router.Name+" "+program.Arguments)
+		return nil
+	}
+
+	client := NewVSphereProvider(conf, nil)
+	ctx := context.Background()
+
+	err = client.vSphereCustomClone(ctx, "pod", []string{"Ubuntu"}, true, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 1 || !strings.HasPrefix(ran[0], "pod-Natted-PodRouter -i -e 's/{{THIRD_OCTET}}/1/g") {
+		t.Errorf("expected the router program to run on the new router, got %v", ran)
+	}
+
+	pods, err := client.vSphereGetPods("bob")
+	if err != nil || len(pods) != 1 {
+		t.Fatalf("expected bob's pod, got %+v %v", pods, err)
+	}
+	podPools, err := client.GetPodsMatchingFilter([]string{"_bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vms, err := client.GetVMsOfPods(podPools)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, vm := range vms {
+		names = append(names, vm.Name)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"1801-Ubuntu", "pod-Natted-PodRouter"}) {
+		t.Errorf("unexpected pod VMs %v", names)
+	}
+}
+
+func TestSimulatorOrphans(t *testing.T) {
+	conf := &config.Config{
+		Core: config.Core{Tracer: noop.NewTracerProvider().Tracer("goclone")},
+		Provider: config.Provider{
+			MaxPodLimit: 1,
+			Domain:      "goclone.local",
+			VCenter:     config.VCenter{Simulator: true},
+		},
+	}
+
+	stop, err := StartSimulator(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	client := NewVSphereProvider(conf, nil)
+	ctx := context.Background()
+
+	err = client.vSphereTemplateClone(ctx, simTemplate, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what failed clones and manual deletes leave behind
+	if _, err := client.CreatePortGroup("1850_PodNetwork", 1850); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CreateVMFolder("1851_Web_bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CreateResourcePool("1852_Web_bob", false); err != nil {
+		t.Fatal(err)
+	}
+	// a clone that is still running, and a reservation whose port group is gone
+	if _, err := client.CreatePortGroup("1853_PodNetwork", 1853); err != nil {
+		t.Fatal(err)
+	}
+	client.availablePortGroups.Mu.Lock()
+	client.availablePortGroups.Cloning[1853] = true
+	client.availablePortGroups.Data[1899] = "1899_PodNetwork"
+	client.availablePortGroups.Mu.Unlock()
+
+	found, err := client.FindOrphans(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, orphan := range found {
+		names = append(names, orphan.Kind+" "+orphan.Name)
+	}
+	slices.Sort(names)
+	expected := []string{"folder 1851_Web_bob", "portGroup 1850_PodNetwork", "resourcePool 1852_Web_bob"}
+	if !slices.Equal(names, expected) {
+		t.Errorf("expected orphans %v, got %v", expected, names)
+	}
+
+	if taken := takenPortGroups(client); !slices.Equal(taken, []int{1801, 1850, 1851, 1852, 1853}) {
+		t.Errorf("unexpected port groups after the sync %v", taken)
+	}
+
+	for _, orphan := range found {
+		if err := client.RemoveOrphan(ctx, orphan); err != nil {
+			t.Errorf("failed to remove %s: %v", orphan.Name, err)
+		}
+	}
+	client.cloneDone(1853)
+
+	found, err = client.FindOrphans(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Name != "1853_PodNetwork" {
+		t.Errorf("expected only the finished clone's port group left, got %+v", found)
+	}
+	if taken := takenPortGroups(client); !slices.Equal(taken, []int{1801, 1853}) {
+		t.Errorf("removed port groups were not released, got %v", taken)
+	}
+}
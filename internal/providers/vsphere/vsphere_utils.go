@@ -3,6 +3,7 @@ package vsphere
 import (
 	"context"
 	"fmt"
+	"goclone/internal/providers"
 	"goclone/internal/providers/vsphere/vm"
 	"log"
 	"strings"
@@ -14,10 +15,11 @@ import (
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
 	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
 )
 
-func CreatePortGroup(name string, vlanID int) (object.NetworkReference, error) {
-	dvsObj := object.NewDistributedVirtualSwitch(vSphereClient.client, dvsMo.Reference())
+func (v *VSphereClient) CreatePortGroup(name string, vlanID int) (object.NetworkReference, error) {
+	dvsObj := object.NewDistributedVirtualSwitch(v.client, v.dvsMo.Reference())
 	spec := types.DVPortgroupConfigSpec{
 		Name:     name,
 		Type:     string(types.DistributedVirtualPortgroupPortgroupTypeEarlyBinding),
@@ -29,19 +31,19 @@ func CreatePortGroup(name string, vlanID int) (object.NetworkReference, error) {
 		},
 	}
 
-	task, err := dvsObj.AddPortgroup(vSphereClient.ctx, []types.DVPortgroupConfigSpec{spec})
+	task, err := dvsObj.AddPortgroup(v.ctx, []types.DVPortgroupConfigSpec{spec})
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error adding portgroup"))
 		return object.Network{}, err
 	}
 
-	err = task.Wait(vSphereClient.ctx)
+	err = task.Wait(v.ctx)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error waiting for task"))
 		return object.Network{}, err
 	}
 
-	pgReference, err := finder.Network(vSphereClient.ctx, name)
+	pgReference, err := v.finder.Network(v.ctx, name)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error finding portgroup"))
 		return object.Network{}, err
@@ -50,8 +52,8 @@ func CreatePortGroup(name string, vlanID int) (object.NetworkReference, error) {
 	return pgReference, nil
 }
 
-func GetPortGroup(name string) (object.NetworkReference, error) {
-	pg, err := finder.Network(vSphereClient.ctx, name)
+func (v *VSphereClient) GetPortGroup(name string) (object.NetworkReference, error) {
+	pg, err := v.finder.Network(v.ctx, name)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error finding portgroup"))
 		return object.Network{}, err
@@ -60,7 +62,7 @@ func GetPortGroup(name string) (object.NetworkReference, error) {
 	return pg, nil
 }
 
-func CreateResourcePool(name string, compPod bool) (types.ManagedObjectReference, error) {
+func (v *VSphereClient) CreateResourcePool(name string, compPod bool) (types.ManagedObjectReference, error) {
 	rpSpec := types.ResourceConfigSpec{
 		CpuAllocation: types.ResourceAllocationInfo{
 			Shares:                &types.SharesInfo{Level: types.SharesLevelNormal},
@@ -76,27 +78,28 @@ func CreateResourcePool(name string, compPod bool) (types.ManagedObjectReference
 		},
 	}
 
-	rp, err := finder.ResourcePool(vSphereClient.ctx, name)
+	rp, err := v.finder.ResourcePool(v.ctx, name)
 	if err == nil {
 		log.Println("Resource pool already exists")
 		return rp.Reference(), nil
 	}
 
-	rpDest := targetResourcePool
+	rpDest := v.targetResourcePool
 	if compPod {
-		rpDest = competitionResourcePool
+		rpDest = v.competitionResourcePool
 	}
 
-	child, err := rpDest.Create(vSphereClient.ctx, name, rpSpec)
+	child, err := rpDest.Create(v.ctx, name, rpSpec)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error creating resource pool"))
+		return types.ManagedObjectReference{}, err
 	}
 
 	return child.Reference(), nil
 }
 
-func GetResourcePool(name string) (*object.ResourcePool, error) {
-	rpObj, err := finder.ResourcePool(vSphereClient.ctx, name)
+func (v *VSphereClient) GetResourcePool(name string) (*object.ResourcePool, error) {
+	rpObj, err := v.finder.ResourcePool(v.ctx, name)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error getting resource pool"))
 		return &object.ResourcePool{}, err
@@ -105,26 +108,27 @@ func GetResourcePool(name string) (*object.ResourcePool, error) {
 	return rpObj, nil
 }
 
-func CreateVMFolder(name string) (*object.Folder, error) {
-	newFolder, err := destinationFolder.CreateFolder(vSphereClient.ctx, name)
+func (v *VSphereClient) CreateVMFolder(name string) (*object.Folder, error) {
+	newFolder, err := v.destinationFolder.CreateFolder(v.ctx, name)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Failed to create folder"))
+		return nil, err
 	}
 
 	return newFolder, nil
 }
 
-func GetVMsInResourcePool(rp types.ManagedObjectReference) ([]mo.VirtualMachine, error) {
+func (v *VSphereClient) GetVMsInResourcePool(rp types.ManagedObjectReference) ([]mo.VirtualMachine, error) {
 	rpData := mo.ResourcePool{}
-	pc := property.DefaultCollector(vSphereClient.client)
-	err := pc.Retrieve(vSphereClient.ctx, []types.ManagedObjectReference{rp}, []string{"vm"}, &rpData)
+	pc := property.DefaultCollector(v.client)
+	err := pc.Retrieve(v.ctx, []types.ManagedObjectReference{rp}, []string{"vm"}, &rpData)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Failed to retrieve VMs from resource pool"))
 		return nil, err
 	}
 
 	var vms []mo.VirtualMachine
-	err = pc.Retrieve(vSphereClient.ctx, rpData.Vm, []string{"config", "name", "customValue"}, &vms)
+	err = pc.Retrieve(v.ctx, rpData.Vm, []string{"config", "name", "customValue"}, &vms)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Failed to get references for Virtual Machines"))
 		return nil, err
@@ -146,18 +150,18 @@ func (v *VSphereClient) HideVM(wg *sync.WaitGroup, vm *mo.VirtualMachine, userna
 	defer wg.Done()
 	permission := types.Permission{
 		Principal: strings.Join([]string{v.conf.Domain, username}, "\\"),
-		RoleId:    noAccessRole.RoleId,
+		RoleId:    v.noAccessRole.RoleId,
 		Propagate: true,
 	}
-	err := AssignPermissionToObjects(&permission, []types.ManagedObjectReference{vm.Reference()})
+	err := v.AssignPermissionToObjects(&permission, []types.ManagedObjectReference{vm.Reference()})
 	if err != nil {
 		log.Println(errors.Wrap(err, "Failed to assign permission to VM"))
 	}
 }
 
-func GetSnapshotRef(vm vm.VM, name string) types.ManagedObjectReference {
-	vmObj := object.NewVirtualMachine(vSphereClient.client, vm.Ref.Reference())
-	snapshot, err := vmObj.FindSnapshot(vSphereClient.ctx, name)
+func (v *VSphereClient) GetSnapshotRef(vm vm.VM, name string) types.ManagedObjectReference {
+	vmObj := object.NewVirtualMachine(v.client, vm.Ref.Reference())
+	snapshot, err := vmObj.FindSnapshot(v.ctx, name)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Failed to find snapshot"))
 		return types.ManagedObjectReference{}
@@ -166,17 +170,18 @@ func GetSnapshotRef(vm vm.VM, name string) types.ManagedObjectReference {
 	return snapshot.Reference()
 }
 
-func CloneVMs(vms []vm.VM, folder *object.Folder, resourcePool, ds, pg types.ManagedObjectReference, pgNum string) {
-	var wg sync.WaitGroup
+// CloneVMs clones vms into the pod at once, returning the first clone that failed
+func (v *VSphereClient) CloneVMs(ctx context.Context, vms []vm.VM, folder *object.Folder, resourcePool, ds, pg types.ManagedObjectReference, pgNum string) error {
+	wg := errgroup.Group{}
 	for _, vm := range vms {
         fmt.Println("Cloning VM: ", vm.Name)
-		configSpec, err := vm.ConfigureVMNetwork(&pg, dvsMo)
+		configSpec, err := vm.ConfigureVMNetwork(&pg, v.dvsMo)
 		if err != nil {
             fmt.Println("Failed to configure VM network: ", err)
 			log.Println(errors.Wrap(err, "Failed to configure VM network"))
 		}
 
-		snapshotRef := GetSnapshotRef(vm, "SnapshotForCloning")
+		snapshotRef := v.GetSnapshotRef(vm, "SnapshotForCloning")
 		spec := types.VirtualMachineCloneSpec{
 			Snapshot: &snapshotRef,
 			Location: types.VirtualMachineRelocateSpec{
@@ -189,21 +194,27 @@ func CloneVMs(vms []vm.VM, folder *object.Folder, resourcePool, ds, pg types.Man
 
 		vm.Name = strings.Join([]string{pgNum, vm.Name}, "-")
 
-		folderObj := object.NewFolder(vSphereClient.client, folder.Reference())
-		wg.Add(1)
-		go vm.CloneVM(&wg, &spec, folderObj)
+		folderObj := object.NewFolder(v.client, folder.Reference())
+		wg.Go(func() error {
+			reporter := taskProgress(ctx, providers.StepCloneVMs, vm.Name)
+			err := vm.CloneVM(&spec, folderObj, reporter)
+			reporter.done("Cloned", err)
+			if err != nil {
+				return errors.Wrapf(err, "Error cloning %s", vm.Name)
+			}
+			return nil
+		})
 	}
-	wg.Wait()
+	return wg.Wait()
 }
 
-func CloneVMsFromTemplates(ctx context.Context, templates []vm.VM, folder *object.Folder, resourcePool, ds, pg types.ManagedObjectReference, pgNum string) {
-
-	var wg sync.WaitGroup
+// CloneVMsFromTemplates clones templates into the pod one after the other, stopping at the first that fails
+func (v *VSphereClient) CloneVMsFromTemplates(ctx context.Context, templates []vm.VM, folder *object.Folder, resourcePool, ds, pg types.ManagedObjectReference, pgNum string) error {
 	for _, template := range templates {
-        _, span := tracer.Start(ctx, "CloneVMsFromTemplates")
+        _, span := v.tracer.Start(ctx, "CloneVMsFromTemplates")
         defer span.End()
 
-		configSpec, err := template.ConfigureVMNetwork(&pg, dvsMo)
+		configSpec, err := template.ConfigureVMNetwork(&pg, v.dvsMo)
 		if err != nil {
 			log.Println(errors.Wrap(err, "Failed to configure VM network"))
 		}
@@ -219,28 +230,32 @@ func CloneVMsFromTemplates(ctx context.Context, templates []vm.VM, folder *objec
 		template.Name = strings.Join([]string{pgNum, template.Name}, "-")
         span.SetAttributes(attribute.String("vm-name", template.Name))
 
-		folderObj := object.NewFolder(vSphereClient.client, folder.Reference())
-		wg.Add(1)
-		template.CloneVM(&wg, &spec, folderObj)
+		folderObj := object.NewFolder(v.client, folder.Reference())
+		reporter := taskProgress(ctx, providers.StepCloneVMs, template.Name)
+		err = template.CloneVM(&spec, folderObj, reporter)
+		reporter.done("Cloned", err)
+		if err != nil {
+			return errors.Wrapf(err, "Error cloning %s", template.Name)
+		}
 	}
-	wg.Wait()
+	return nil
 }
 
-func CreateRouter(ctx context.Context, srcRP, ds types.ManagedObjectReference, folder *object.Folder, natted bool, rpName string) (*mo.VirtualMachine, error) {
-    ctx, span := tracer.Start(ctx, "CreateRouter")
+func (v *VSphereClient) CreateRouter(ctx context.Context, srcRP, ds types.ManagedObjectReference, folder *object.Folder, natted bool, rpName string) (*mo.VirtualMachine, error) {
+    ctx, span := v.tracer.Start(ctx, "CreateRouter")
     defer span.End()
 
 	var templateName, cloneName string
 
 	if natted {
-		templateName = vCenterConfig.NattedRouterPath
+		templateName = v.vCenterConfig.NattedRouterPath
 		cloneName = strings.Join([]string{rpName, "Natted-PodRouter"}, "-")
 	} else {
-		templateName = vCenterConfig.RouterPath
+		templateName = v.vCenterConfig.RouterPath
 		cloneName = strings.Join([]string{rpName, "PodRouter"}, "-")
 	}
 
-	template, err := finder.VirtualMachine(vSphereClient.ctx, templateName)
+	template, err := v.finder.VirtualMachine(v.ctx, templateName)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error finding template"))
 		return &mo.VirtualMachine{}, err
@@ -253,27 +268,29 @@ func CreateRouter(ctx context.Context, srcRP, ds types.ManagedObjectReference, f
 		},
 	}
 
-	task, err := template.Clone(vSphereClient.ctx, folder, cloneName, cloneSpec)
+	task, err := template.Clone(v.ctx, folder, cloneName, cloneSpec)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error cloning template"))
 		return &mo.VirtualMachine{}, err
 	}
 
-	err = task.Wait(vSphereClient.ctx)
+	reporter := taskProgress(ctx, providers.StepRouter, cloneName)
+	_, err = task.WaitForResult(v.ctx, reporter)
+	reporter.done("Router cloned", err)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error waiting for task"))
 		return &mo.VirtualMachine{}, err
 	}
 
-	routerObj, err := finder.VirtualMachine(vSphereClient.ctx, cloneName)
+	routerObj, err := v.finder.VirtualMachine(v.ctx, cloneName)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error finding router"))
 		return &mo.VirtualMachine{}, err
 	}
 
 	routerMo := mo.VirtualMachine{}
-	pc := property.DefaultCollector(vSphereClient.client)
-	err = pc.Retrieve(vSphereClient.ctx, []types.ManagedObjectReference{routerObj.Reference()}, []string{"name"}, &routerMo)
+	pc := property.DefaultCollector(v.client)
+	err = pc.Retrieve(v.ctx, []types.ManagedObjectReference{routerObj.Reference()}, []string{"name", "config"}, &routerMo)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error retrieving router"))
 		return &mo.VirtualMachine{}, err
@@ -282,16 +299,16 @@ func CreateRouter(ctx context.Context, srcRP, ds types.ManagedObjectReference, f
 	return &routerMo, nil
 }
 
-func GetRouter(srcRPRef types.ManagedObjectReference) (*mo.VirtualMachine, error) {
-	router, err := finder.VirtualMachine(vSphereClient.ctx, "PodRouter")
+func (v *VSphereClient) GetRouter(srcRPRef types.ManagedObjectReference) (*mo.VirtualMachine, error) {
+	router, err := v.finder.VirtualMachine(v.ctx, "PodRouter")
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error finding router"))
 		return &mo.VirtualMachine{}, err
 	}
 
 	routerMo := mo.VirtualMachine{}
-	pc := property.DefaultCollector(vSphereClient.client)
-	err = pc.Retrieve(vSphereClient.ctx, []types.ManagedObjectReference{router.Reference()}, []string{"name"}, &routerMo)
+	pc := property.DefaultCollector(v.client)
+	err = pc.Retrieve(v.ctx, []types.ManagedObjectReference{router.Reference()}, []string{"name"}, &routerMo)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error retrieving router"))
 		return &mo.VirtualMachine{}, err
@@ -300,74 +317,76 @@ func GetRouter(srcRPRef types.ManagedObjectReference) (*mo.VirtualMachine, error
 	return &routerMo, nil
 }
 
-func DestroyFolder(ctx context.Context, folderObj *object.Folder) {
-    _, span := tracer.Start(ctx, "DestroyFolder")
+// DestroyFolder powers off the VMs in the folder and destroys it along with them
+func (v *VSphereClient) DestroyFolder(ctx context.Context, folderObj *object.Folder) error {
+    _, span := v.tracer.Start(ctx, "DestroyFolder")
     defer span.End()
 
-	vms, err := folderObj.Children(vSphereClient.ctx)
+	vms, err := folderObj.Children(v.ctx)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error getting children"))
 	}
 
 	for _, vm := range vms {
-		task, err := vm.(*object.VirtualMachine).PowerOff(vSphereClient.ctx)
-		if err != nil {
-			log.Println(errors.Wrap(err, "Error destroying VM"))
+		vmObj, ok := vm.(*object.VirtualMachine)
+		if !ok {
+			continue
+		}
+		// VMs that are already off refuse to power off, which is fine
+		task, err := vmObj.PowerOff(v.ctx)
+		if err == nil {
+			err = task.Wait(v.ctx)
 		}
-
-		err = task.Wait(vSphereClient.ctx)
 		if err != nil {
-			log.Println(errors.Wrap(err, "Error waiting for task"))
+			log.Println(errors.Wrap(err, "Error powering off VM"))
 		}
 	}
 
-	task, err := folderObj.Destroy(vSphereClient.ctx)
-	if err != nil {
-		log.Println(errors.Wrap(err, "Error destroying folder"))
+	task, err := folderObj.Destroy(v.ctx)
+	if err == nil {
+		err = task.Wait(v.ctx)
 	}
-
-	err = task.Wait(vSphereClient.ctx)
 	if err != nil {
-		log.Println(errors.Wrap(err, "Error waiting for task"))
+		log.Println(errors.Wrap(err, "Error destroying folder"))
+		return err
 	}
+	return nil
 }
 
-func DestroyResourcePool(ctx context.Context, rpObj *object.ResourcePool) {
-    _, span := tracer.Start(ctx, "DestroyResourcePool")
+func (v *VSphereClient) DestroyResourcePool(ctx context.Context, rpObj *object.ResourcePool) error {
+    _, span := v.tracer.Start(ctx, "DestroyResourcePool")
     defer span.End()
 
-	task, err := rpObj.Destroy(vSphereClient.ctx)
-	if err != nil {
-		log.Println(errors.Wrap(err, "Error destroying resource pool"))
+	task, err := rpObj.Destroy(v.ctx)
+	if err == nil {
+		err = task.Wait(v.ctx)
 	}
-
-	err = task.Wait(vSphereClient.ctx)
 	if err != nil {
-		log.Println(errors.Wrap(err, "Error waiting for task"))
+		log.Println(errors.Wrap(err, "Error destroying resource pool"))
+		return err
 	}
+	return nil
 }
 
-func DestroyPortGroup(ctx context.Context, pg types.ManagedObjectReference) error {
-    _, span := tracer.Start(ctx, "DestroyPortGroup")
+func (v *VSphereClient) DestroyPortGroup(ctx context.Context, pg types.ManagedObjectReference) error {
+    _, span := v.tracer.Start(ctx, "DestroyPortGroup")
     defer span.End()
 
-	pgObj := object.NewNetwork(vSphereClient.client, pg.Reference())
-	task, err := pgObj.Destroy(vSphereClient.ctx)
-	if err != nil {
-		log.Println(errors.Wrap(err, "Error destroying port group"))
+	pgObj := object.NewNetwork(v.client, pg.Reference())
+	task, err := pgObj.Destroy(v.ctx)
+	if err == nil {
+		err = task.Wait(v.ctx)
 	}
-
-	err = task.Wait(vSphereClient.ctx)
 	if err != nil {
-		log.Println(errors.Wrap(err, "Error waiting for task"))
+		log.Println(errors.Wrap(err, "Error destroying port group"))
+		return err
 	}
-
 	return nil
 }
 
-func AssignPermissionToObjects(permission *types.Permission, object []types.ManagedObjectReference) error {
+func (v *VSphereClient) AssignPermissionToObjects(permission *types.Permission, object []types.ManagedObjectReference) error {
 	for _, obj := range object {
-		err := authManager.SetEntityPermissions(vSphereClient.ctx, obj, []types.Permission{*permission})
+		err := v.authManager.SetEntityPermissions(v.ctx, obj, []types.Permission{*permission})
 		if err != nil {
 			log.Println(errors.Wrap(err, "Error setting entity permissions"))
 			return err
@@ -377,16 +396,16 @@ func AssignPermissionToObjects(permission *types.Permission, object []types.Mana
 	return nil
 }
 
-func GetChildResourcePools(resourcePool string) ([]*object.ResourcePool, error) {
-	templateParentPool, err := finder.ResourcePool(vSphereClient.ctx, resourcePool)
+func (v *VSphereClient) GetChildResourcePools(resourcePool string) ([]*object.ResourcePool, error) {
+	templateParentPool, err := v.finder.ResourcePool(v.ctx, resourcePool)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error getting resource pool list"))
 		return nil, err
 	}
 
 	rpData := mo.ResourcePool{}
-	poolObj := object.NewResourcePool(vSphereClient.client, templateParentPool.Reference())
-	err = poolObj.Properties(vSphereClient.ctx, templateParentPool.Reference(), []string{"resourcePool"}, &rpData)
+	poolObj := object.NewResourcePool(v.client, templateParentPool.Reference())
+	err = poolObj.Properties(v.ctx, templateParentPool.Reference(), []string{"resourcePool"}, &rpData)
 	if err != nil {
 		log.Println(errors.Wrap(err, "Error getting resource pool children"))
 		return nil, err
@@ -394,20 +413,20 @@ func GetChildResourcePools(resourcePool string) ([]*object.ResourcePool, error)
 
 	var rpList []*object.ResourcePool
 	for _, rp := range rpData.ResourcePool {
-		rpObj := object.NewResourcePool(vSphereClient.client, rp.Reference())
+		rpObj := object.NewResourcePool(v.client, rp.Reference())
 		rpList = append(rpList, rpObj)
 	}
 
 	return rpList, nil
 }
 
-func GetAllPods() ([]*object.ResourcePool, error) {
-	kaminoPods, err := GetChildResourcePools(vCenterConfig.TargetResourcePool)
+func (v *VSphereClient) GetAllPods() ([]*object.ResourcePool, error) {
+	kaminoPods, err := v.GetChildResourcePools(v.vCenterConfig.TargetResourcePool)
 	if err != nil {
 		return []*object.ResourcePool{}, errors.Wrap(err, "Error getting Kamino pods")
 	}
 
-	competitionPods, err := GetChildResourcePools(vCenterConfig.CompetitionResourcePool)
+	competitionPods, err := v.GetChildResourcePools(v.vCenterConfig.CompetitionResourcePool)
 	if err != nil {
 		return []*object.ResourcePool{}, errors.Wrap(err, "Error getting Competition pods")
 	}
@@ -416,63 +435,56 @@ func GetAllPods() ([]*object.ResourcePool, error) {
 	return pods, nil
 }
 
-func GetPodsMatchingFilter(filter []string) ([]*object.ResourcePool, error) {
-	pods, err := GetAllPods()
+func (v *VSphereClient) GetPodsMatchingFilter(filter []string) ([]*object.ResourcePool, error) {
+	pods, err := v.GetAllPods()
 	if err != nil {
 		return []*object.ResourcePool{}, err
 	}
 
 	var filteredPods []*object.ResourcePool
 	for _, pod := range pods {
-		podName, err := pod.ObjectName(vSphereClient.ctx)
+		podName, err := pod.ObjectName(v.ctx)
 		if err != nil {
 			return []*object.ResourcePool{}, errors.Wrap(err, "Error getting pod name")
 		}
 
-		// This is mostly copy pasted from bulkDelete, can probalby make a helper or something...
-		for _, f := range filter {
-			if f == "" {
-				continue
-			}
-			if !strings.Contains(podName, f) {
-				continue
-			}
+		if providers.PodFilter(filter).Matches(podName) {
 			filteredPods = append(filteredPods, pod)
 		}
 	}
 	return filteredPods, nil
 }
 
-func GetVMsOfPods(pods []*object.ResourcePool) ([]vm.VM, error) {
+func (v *VSphereClient) GetVMsOfPods(pods []*object.ResourcePool) ([]vm.VM, error) {
 	var vms []vm.VM
 
 	for _, pod := range pods {
-		podName, err := pod.ObjectName(vSphereClient.ctx)
+		podName, err := pod.ObjectName(v.ctx)
 		if err != nil {
 			return []vm.VM{}, errors.Wrap(err, "Error getting pod name")
 		}
 
-		folder, err := finder.Folder(vSphereClient.ctx, podName)
+		folder, err := v.finder.Folder(v.ctx, podName)
 		if err != nil {
 			log.Println(errors.Wrap(err, "Error finding folder"))
 			return []vm.VM{}, err
 		}
 
-		vmList, err := folder.Children(vSphereClient.ctx)
+		vmList, err := folder.Children(v.ctx)
 		if err != nil {
 			log.Println(errors.Wrap(err, "Error getting children"))
 			return []vm.VM{}, err
 		}
-		for _, v := range vmList {
-			vmObj := object.NewVirtualMachine(vSphereClient.client, v.Reference())
-			vmName, err := vmObj.ObjectName(vSphereClient.ctx)
+		for _, child := range vmList {
+			vmObj := object.NewVirtualMachine(v.client, child.Reference())
+			vmName, err := vmObj.ObjectName(v.ctx)
 			if err != nil {
 				log.Println(errors.Wrap(err, "Error getting VM name"))
 				return []vm.VM{}, err
 			}
 			newVM := vm.VM{
 				Name: vmName,
-				Ref:  v,
+				Ref:  child,
 			}
 			vms = append(vms, newVM)
 		}
@@ -480,15 +492,46 @@ func GetVMsOfPods(pods []*object.ResourcePool) ([]vm.VM, error) {
 	return vms, nil
 }
 
-func GetAttribute(ref types.ManagedObjectReference, key string) (string, error) {
-	keyID, err := customFieldsManager.FindKey(vSphereClient.ctx, key)
+// podOwnerAttribute is the resource pool attribute naming the user a pod was cloned for
+const podOwnerAttribute = "goclone.pod.owner"
+
+// podOwnerField returns the key of the pod owner attribute, adding it to vCenter if it is missing
+func (v *VSphereClient) podOwnerField() (int32, error) {
+	key, err := v.customFieldsManager.FindKey(v.ctx, podOwnerAttribute)
+	if err == nil {
+		return key, nil
+	}
+	if err != object.ErrKeyNameNotFound {
+		return 0, errors.Wrap(err, "Error getting attribute key ID")
+	}
+
+	def, err := v.customFieldsManager.Add(v.ctx, podOwnerAttribute, "ResourcePool", nil, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "Error adding attribute")
+	}
+	return def.Key, nil
+}
+
+// podOwner returns the owner recorded on a pod resource pool retrieved with its customValue,
+// falling back to the pod name for pools cloned before owners were recorded
+func (v *VSphereClient) podOwner(rp mo.ResourcePool) string {
+	for _, value := range rp.CustomValue {
+		if value, ok := value.(*types.CustomFieldStringValue); ok && v.podOwnerKey != 0 && value.Key == v.podOwnerKey {
+			return value.Value
+		}
+	}
+	return providers.PodOwner(rp.Name)
+}
+
+func (v *VSphereClient) GetAttribute(ref types.ManagedObjectReference, key string) (string, error) {
+	keyID, err := v.customFieldsManager.FindKey(v.ctx, key)
 	if err != nil {
 		return "", errors.Wrap(err, "Error getting attribute key ID")
 	}
 
 	target := mo.ManagedEntity{}
-	pc := property.DefaultCollector(vSphereClient.client)
-	err = pc.Retrieve(vSphereClient.ctx, []types.ManagedObjectReference{ref}, []string{"customValue"}, &target)
+	pc := property.DefaultCollector(v.client)
+	err = pc.Retrieve(v.ctx, []types.ManagedObjectReference{ref}, []string{"customValue"}, &target)
 	if err != nil {
 		return "", errors.Wrap(err, "Error retrieving object")
 	}
@@ -502,15 +545,15 @@ func GetAttribute(ref types.ManagedObjectReference, key string) (string, error)
 	return "", errors.New("Attribute not found")
 }
 
-func GetAllAttributes(ref types.ManagedObjectReference) (map[string]string, error) {
+func (v *VSphereClient) GetAllAttributes(ref types.ManagedObjectReference) (map[string]string, error) {
 	target := mo.ManagedEntity{}
-	pc := property.DefaultCollector(vSphereClient.client)
-	err := pc.Retrieve(vSphereClient.ctx, []types.ManagedObjectReference{ref}, []string{"customValue"}, &target)
+	pc := property.DefaultCollector(v.client)
+	err := pc.Retrieve(v.ctx, []types.ManagedObjectReference{ref}, []string{"customValue"}, &target)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error retrieving object")
 	}
 
-	attrList, err := customFieldsManager.Field(vSphereClient.ctx)
+	attrList, err := v.customFieldsManager.Field(v.ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error getting custom fields")
 	}